@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// maxStatusLineSize is the number of bytes of a response searched for its
+// status line.
+const maxStatusLineSize = 256
+
+// statusRecorder writes a HTTP response as it is, recording its status code,
+// parsed from the status line while it is copied.
+type statusRecorder struct {
+	io.Writer
+
+	line   []byte
+	done   bool
+	status int
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if !s.done {
+		s.record(p)
+	}
+
+	return s.Writer.Write(p)
+}
+
+// record appends p to the status line until it is complete, parsing the status
+// code, as in "HTTP/1.1 200 OK", from it.
+func (s *statusRecorder) record(p []byte) {
+	if i := bytes.IndexByte(p, '\n'); i >= 0 {
+		p = p[:i]
+		s.done = true
+	}
+
+	if n := maxStatusLineSize - len(s.line); len(p) > n {
+		p = p[:n]
+		s.done = true
+	}
+
+	s.line = append(s.line, p...)
+
+	if !s.done {
+		return
+	}
+
+	fields := bytes.Fields(s.line)
+	if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("HTTP/")) {
+		return
+	}
+
+	if status, err := strconv.Atoi(string(fields[1])); err == nil {
+		s.status = status
+	}
+
+	s.line = nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		status int
+	}{
+		{name: "single write", writes: []string{"HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"}, status: http.StatusCreated},
+		{name: "split status line", writes: []string{"HTTP/1.1 4", "04 Not Found\r", "\nContent-Length: 0\r\n\r\n"}, status: http.StatusNotFound},
+		{name: "without reason", writes: []string{"HTTP/1.0 204\r\n\r\n"}, status: http.StatusNoContent},
+		{name: "incomplete status line", writes: []string{"HTTP/1.1 200 OK"}},
+		{name: "not a response", writes: []string{"SSH-2.0-OpenSSH\r\n"}},
+		{name: "invalid status", writes: []string{"HTTP/1.1 OK\r\n\r\n"}},
+		{name: "status line too long", writes: []string{"HTTP/1.1 200 " + strings.Repeat("OK", maxStatusLineSize) + "\r\n\r\n"}, status: http.StatusOK},
+		{name: "garbage too long", writes: []string{strings.Repeat("x", maxStatusLineSize), "HTTP/1.1 200 OK\r\n\r\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			s := &statusRecorder{Writer: &out}
+
+			for _, p := range tt.writes {
+				n, err := s.Write([]byte(p))
+				if err != nil || n != len(p) {
+					t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(p))
+				}
+			}
+
+			if s.status != tt.status {
+				t.Errorf("status = %d, want %d", s.status, tt.status)
+			}
+
+			if want := strings.Join(tt.writes, ""); out.String() != want {
+				t.Errorf("written %q, want the response as it is, %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestHTTPTunnelAccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "secret body") // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.Atoi(backendPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		service string
+		want    []string
+	}{
+		{name: "disabled"},
+		{name: "forwarded", enabled: true, want: []string{"HTTP tunnel request", "method=POST", "path=/hello", "status=201", "namespace=dev"}},
+		{name: "refused", enabled: true, service: "missing", want: []string{"HTTP tunnel request", "method=POST", "path=/hello", "status=404"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogSync(t)
+
+			p := newTestHTTPProxy(t, &ConfigOptions{
+				ForwardedHTTPAddress:     "127.0.0.1:1",
+				ForwardedHTTPPort:        port,
+				ForwardedHTTPScheme:      "http",
+				ForwardedHTTPDialTimeout: 1,
+				HTTPTunnelAccessLog:      tt.enabled,
+			})
+
+			front := httptest.NewServer(p)
+			defer front.Close()
+
+			req, err := http.NewRequest(http.MethodPost, front.URL, strings.NewReader("secret request"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/hello")
+			req.Header.Set("X-Namespace", "dev")
+			if tt.service != "" {
+				req.Header.Set("X-Service", tt.service)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			io.Copy(io.Discard, res.Body) // nolint:errcheck
+			res.Body.Close()
+
+			// The request is logged once the handler returns, which could be
+			// after the response is read from the hijacked connection.
+			deadline := time.Now().Add(5 * time.Second)
+			for len(tt.want) > 0 && !strings.Contains(buf.String(), "HTTP tunnel request") && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			logs := buf.String()
+			if len(tt.want) == 0 && strings.Contains(logs, "HTTP tunnel request") {
+				t.Errorf("logged the request with the access log disabled:\n%s", logs)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(logs, want) {
+					t.Errorf("access log misses %q:\n%s", want, logs)
+				}
+			}
+
+			for _, secret := range []string{"secret request", "secret body"} {
+				if strings.Contains(logs, secret) {
+					t.Errorf("access log contains the body %q:\n%s", secret, logs)
+				}
+			}
+		})
+	}
+}
+
+func TestLogAccessDuration(t *testing.T) {
+	buf := captureLog(t)
+	fake := setFakeClock(t)
+
+	p := newTestHTTPProxy(t, &ConfigOptions{ForwardedHTTPAddress: "127.0.0.1:80"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Path", "/status")
+
+	start := fake.now
+	fake.now = fake.now.Add(1500 * time.Millisecond)
+
+	status := http.StatusOK
+	p.logAccess(r, start, &status)
+
+	for _, want := range []string{"duration=1.5s", "status=200", "path=/status", "method=GET"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("access log misses %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -1,46 +1,294 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
-	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
-	"github.com/brycedjohnson/shellhub-agent/pkg/sysinfo"
 	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/backoff"
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
 	"github.com/brycedjohnson/shellhub-agent/pkg/models"
 	"github.com/brycedjohnson/shellhub-agent/pkg/revdial"
+	"github.com/brycedjohnson/shellhub-agent/pkg/sysinfo"
+	"github.com/brycedjohnson/shellhub-agent/pkg/tunnel"
+	"github.com/brycedjohnson/shellhub-agent/pkg/webhook"
+	"github.com/brycedjohnson/shellhub-agent/server"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// failoverRetryCount is the number of times a failed request is retried
+	// before switching to the next server, when there are many of them.
+	failoverRetryCount = 3
+
+	// defaultAuthorizationInterval is the interval used to refresh the
+	// authorization when the token expiration time cannot be determined.
+	defaultAuthorizationInterval = 10 * time.Minute
+
+	// minAuthorizationInterval avoids refreshing the authorization in a tight
+	// loop when the token is already expired or about to expire.
+	minAuthorizationInterval = time.Minute
+
+	// maxDeauthorizedInterval caps the interval between the authorization
+	// attempts of a device whose authorization was rejected.
+	maxDeauthorizedInterval = time.Hour
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrNotAuthorized = errors.New("device is not authorized")
 )
 
 type Agent struct {
-	opts          *ConfigOptions
-	pubKey        *rsa.PublicKey
-	Identity      *models.DeviceIdentity
-	Info          *models.DeviceInfo
+	opts     *ConfigOptions
+	pubKey   *rsa.PublicKey
+	Identity *models.DeviceIdentity
+	Info     *models.DeviceInfo
+	hostname string
+
+	// mu guards the server in use and the device authorization on it, as
+	// they change when switching to another server while being read by the
+	// other goroutines. They are read through currentClient, currentAddress,
+	// currentServerInfo and currentAuth.
+	mu            sync.RWMutex
 	authData      *models.DeviceAuthResponse
 	cli           client.Client
 	serverInfo    *models.Info
 	serverAddress *url.URL
-	sessions      []string
+	tlsConfig     *tls.Config
+	proxy         func(*http.Request) (*url.URL, error)
+	localAddr     *net.TCPAddr
+	resolver      *net.Resolver
+	hosts         client.HostOverrides
+	headers       http.Header
+	servers       []*url.URL
+	server        int
+
+	// connMu serializes the switches to another server and the
+	// authorizations, so an authorization is never mixed up with another
+	// server.
+	connMu    sync.Mutex
+	health    health
+	startedAt time.Time
+
+	// rtt collects the round-trip times to the server measured by the
+	// heartbeats.
+	rtt rttStats
+
+	// events delivers the lifecycle events to the event webhook, nil when
+	// it is not set.
+	events *webhook.Sender
+
+	// logFile is the log file, when the logs are written to a file, reopened
+	// on SIGHUP.
+	logFile *logFile
+
+	// deauthorized is set when the server rejected the device authorization,
+	// the following attempts being backed off with authBackoff.
+	deauthorized bool
+	authBackoff  *backoff.Backoff
+
+	// connected is closed when the agent connects to the server for the
+	// first time.
+	connected     chan struct{}
+	connectedOnce sync.Once
+
+	// connectionHooks queues the connection hooks to run, started on the
+	// first one.
+	connectionHooks     chan connectionHook
+	connectionHooksOnce sync.Once
+
+	// onServerChange is called after switching to another server.
+	onServerChange func()
+
+	// sessions returns the ids of the active sessions, and closeSession
+	// closes one of them. They are nil until the SSH server is started.
+	sessions     func() []string
+	closeSession func(id string)
 }
 
 func NewAgent(opts *ConfigOptions) (*Agent, error) {
-	a := &Agent{}
+	var servers []*url.URL
+
+	for _, address := range strings.Split(opts.ServerAddress, ",") {
+		serverAddress, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, serverAddress)
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := proxyFunc(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	serverAddress, err := url.Parse(opts.ServerAddress)
+	localAddr, err := sourceAddr(opts)
 	if err != nil {
 		return nil, err
 	}
 
+	resolver, err := dnsResolver(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := hostOverrides(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := extraHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Agent{
+		opts:      opts,
+		servers:   servers,
+		tlsConfig: tlsConfig,
+		proxy:     proxy,
+		localAddr: localAddr,
+		resolver:  resolver,
+		hosts:     hosts,
+		headers:   headers,
+		connected: make(chan struct{}),
+		startedAt: clock.Now(),
+
+		authBackoff: backoff.New(minAuthorizationInterval, maxDeauthorizedInterval),
+	}
+
+	// Start with the server that worked last time, when there are many.
+	if err := a.useServer(a.lastServer()); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// useServer makes the agent communicate with the server at the index i of the
+// server addresses, wrapping around the list. The server in use is kept when
+// its client can't be created.
+func (a *Agent) useServer(i int) error {
+	server := i % len(a.servers)
+	serverAddress := a.servers[server]
+
+	opts := []client.Opt{
+		client.WithURL(serverAddress),
+		client.WithProxy(a.proxy),
+		client.WithUserAgent(userAgent(a.opts)),
+		client.WithHandshakeTimeout(time.Duration(a.opts.HandshakeTimeout) * time.Second),
+	}
+
+	if a.tlsConfig != nil {
+		opts = append(opts, client.WithTLSConfig(a.tlsConfig))
+	}
+
+	if a.localAddr != nil {
+		opts = append(opts, client.WithLocalAddr(a.localAddr))
+	}
+
+	if a.headers != nil {
+		opts = append(opts, client.WithHeaders(a.headers))
+	}
+
+	if a.resolver != nil {
+		opts = append(opts, client.WithResolver(a.resolver))
+	}
+
+	if a.hosts != nil {
+		opts = append(opts, client.WithHostOverrides(a.hosts))
+	}
+
+	if a.opts.TCPKeepAlive {
+		opts = append(opts, client.WithTCPKeepAlive(tcpKeepAlive(a.opts)))
+	}
+
+	if a.opts.SessionCompression {
+		opts = append(opts, client.WithCompression(true))
+	}
+
+	// The requests are retried forever by default, which would never let the
+	// agent switch to another server.
+	if len(a.servers) > 1 {
+		opts = append(opts, client.WithRetryCount(failoverRetryCount))
+	}
+
+	cli, err := client.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.server = server
 	a.serverAddress = serverAddress
+	a.cli = cli
+
+	return nil
+}
+
+// currentClient returns the client of the server in use.
+func (a *Agent) currentClient() client.Client {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.cli
+}
+
+// currentAddress returns the address of the server in use.
+func (a *Agent) currentAddress() *url.URL {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.serverAddress
+}
+
+// currentServerInfo returns the information of the server in use, nil until
+// it is probed.
+func (a *Agent) currentServerInfo() *models.Info {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.serverInfo
+}
 
-	return &Agent{
-		opts: opts,
-		cli:  client.NewClient(client.WithURL(serverAddress)),
-	}, nil
+// currentAuth returns the device authorization on the server in use, nil when
+// the device is not authorized on it.
+func (a *Agent) currentAuth() *models.DeviceAuthResponse {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.authData
+}
+
+// setAuth sets the device authorization on the server in use.
+func (a *Agent) setAuth(authData *models.DeviceAuthResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.authData = authData
 }
 
 // initialize initializes agent.
@@ -53,6 +301,10 @@ func (a *Agent) initialize() error {
 		return errors.Wrap(err, "failed to load device info")
 	}
 
+	a.hostname = preferredHostname(a.opts, runSourceCommand, func(err error, source string) {
+		log.WithError(err).WithField("source", source).Warn("Failed to get the preferred hostname, falling back to the next source")
+	})
+
 	if err := a.generatePrivateKey(); err != nil {
 		return errors.Wrap(err, "failed to generate private key")
 	}
@@ -61,10 +313,37 @@ func (a *Agent) initialize() error {
 		return errors.Wrap(err, "failed to read public key")
 	}
 
+	var err error
+
+	// Try each server once, starting from the current one.
+	for range a.servers {
+		if err = a.connectServer(); err == nil {
+			return nil
+		}
+
+		if len(a.servers) > 1 {
+			a.logger().WithError(err).WithField("server_address", a.currentAddress().String()).Warn("Failed to connect to server")
+
+			if err := a.useServer(a.server + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return err
+}
+
+// connectServer probes the current server information and authorizes the
+// device on it.
+func (a *Agent) connectServer() error {
 	if err := a.probeServerInfo(); err != nil {
 		return errors.Wrap(err, "failed to probe server info")
 	}
 
+	if err := a.checkClockSkew(); err != nil {
+		return errors.Wrap(err, "failed to check the clock skew")
+	}
+
 	if err := a.authorize(); err != nil {
 		return errors.Wrap(err, "failed to authorize device")
 	}
@@ -72,30 +351,87 @@ func (a *Agent) initialize() error {
 	return nil
 }
 
+// failover switches to the next server, authorizing the device on it.
+func (a *Agent) failover() {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+
+	if err := a.useServer(a.server + 1); err != nil {
+		a.logger().WithError(err).Warn("Failed to switch to the next server")
+
+		return
+	}
+
+	// The token of the previous server is not valid on the next one.
+	a.setAuth(nil)
+
+	a.logger().WithField("server_address", a.currentAddress().String()).Info("Switching to the next server")
+
+	if err := a.connectServer(); err != nil {
+		a.logger().WithError(err).WithField("server_address", a.currentAddress().String()).Warn("Failed to connect to server")
+
+		return
+	}
+
+	if a.onServerChange != nil {
+		a.onServerChange()
+	}
+}
+
+// generatePrivateKey generates the device private key when it is missing and
+// the generation is enabled.
 func (a *Agent) generatePrivateKey() error {
 	if _, err := os.Stat(a.opts.PrivateKey); os.IsNotExist(err) {
-		err := keygen.GeneratePrivateKey(a.opts.PrivateKey)
+		if !a.opts.GenerateKeyIfMissing {
+			return errors.Wrap(ErrKeyNotFound, a.opts.PrivateKey)
+		}
+
+		passphrase, err := privateKeyPassphrase(a.opts)
 		if err != nil {
 			return err
 		}
+
+		if err := keygen.GenerateEncryptedPrivateKey(a.opts.PrivateKey, passphrase); err != nil {
+			return err
+		}
+
+		log.WithField("path", a.opts.PrivateKey).Info("Generated a new device private key")
 	}
 
 	return nil
 }
 
 func (a *Agent) readPublicKey() error {
-	key, err := keygen.ReadPublicKey(a.opts.PrivateKey)
-	a.pubKey = key
+	passphrase, err := privateKeyPassphrase(a.opts)
+	if err != nil {
+		return err
+	}
 
-	return err
+	key, err := keygen.ReadPrivateKey(a.opts.PrivateKey, passphrase)
+	if os.IsNotExist(err) {
+		return errors.Wrap(ErrKeyNotFound, a.opts.PrivateKey)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	a.pubKey = &key.PublicKey
+
+	return nil
 }
 
 // generateDeviceIdentity generates device identity.
 func (a *Agent) generateDeviceIdentity() error {
 	log.Info("generateDeviceIdentity")
 
-	// priorize identity from env
-	if id := a.opts.PreferredIdentity; id != "" {
+	id, err := preferredIdentity(a.opts, runSourceCommand)
+	if err != nil {
+		log.WithError(err).WithField("command", a.opts.PreferredIdentityCommand).Warn("Failed to run the identity command, falling back to the preferred identity")
+	}
+
+	// priorize identity from the command or env
+	if id != "" {
 		a.Identity = &models.DeviceIdentity{
 			MAC: id,
 		}
@@ -131,34 +467,442 @@ func (a *Agent) loadDeviceInfo() error {
 		Platform:   AgentPlatform,
 	}
 
+	if a.opts.ReportFacts {
+		a.Info.Facts = a.collectFacts()
+	}
+
 	return nil
 }
 
-// probeServerInfo probe server information.
+// collectFacts collects the device facts on a best-effort basis, omitting the
+// ones which fail to be collected.
+func (a *Agent) collectFacts() *models.DeviceFacts {
+	facts := &models.DeviceFacts{
+		CPUCount: runtime.NumCPU(),
+	}
+
+	omit := func(fact string, err error) {
+		log.WithError(err).WithField("fact", fact).Debug("Failed to collect the device fact, omitting it")
+	}
+
+	var err error
+
+	if facts.KernelVersion, err = sysinfo.KernelVersion(); err != nil {
+		omit("kernel_version", err)
+	}
+
+	if facts.MemTotal, err = sysinfo.MemTotal(); err != nil {
+		omit("mem_total", err)
+	}
+
+	if facts.CPUModel, err = sysinfo.CPUModel(); err != nil {
+		omit("cpu_model", err)
+	}
+
+	if iface, err := sysinfo.PrimaryInterface(); err != nil {
+		omit("mac", err)
+	} else {
+		facts.MAC = iface.HardwareAddr.String()
+	}
+
+	return facts
+}
+
+// probeServerInfo probe server information, failing with ErrProbeTimeout when
+// the server does not answer within the probe timeout.
 func (a *Agent) probeServerInfo() error {
-	info, err := a.cli.GetInfo(AgentVersion)
+	ctx, cancel := a.probeContext()
+	defer cancel()
+
+	info, err := a.currentClient().GetInfoContext(ctx, AgentVersion)
+
+	a.mu.Lock()
 	a.serverInfo = info
+	a.mu.Unlock()
 
-	return err
+	return a.probeError(ctx, err)
+}
+
+// probeContext returns the context bounding a probe of the server to the probe
+// timeout.
+func (a *Agent) probeContext() (context.Context, context.CancelFunc) {
+	if a.opts.ProbeTimeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(a.opts.ProbeTimeout)*time.Second)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// probeError returns the error of a probe of the server, ErrProbeTimeout when
+// its context expired.
+func (a *Agent) probeError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: no answer within %d seconds", ErrProbeTimeout, a.opts.ProbeTimeout)
+	}
+
+	return requestError(err)
 }
 
 // authorize send auth request to the server.
 func (a *Agent) authorize() error {
-	authData, err := a.cli.AuthDevice(&models.DeviceAuthRequest{
+	req := &models.DeviceAuthRequest{
 		Info: a.Info,
 		DeviceAuth: &models.DeviceAuth{
-			Hostname:  a.opts.PreferredHostname,
+			Hostname:  a.hostname,
 			Identity:  a.Identity,
 			TenantID:  a.opts.TenantID,
 			PublicKey: string(keygen.EncodePublicKeyToPem(a.pubKey)),
 		},
-	})
+	}
 
-	a.authData = authData
+	// The server keeps the active sessions of the device it knows about.
+	if a.sessions != nil {
+		req.Sessions = a.sessions()
+	}
 
-	return err
+	ctx, cancel := a.handshakeContext()
+	defer cancel()
+
+	authData, err := a.currentClient().AuthDeviceContext(ctx, req)
+	if err != nil {
+		err = a.handshakeError(ctx, err)
+		if !retryable(err) {
+			a.event(webhook.Event{Type: webhook.AuthFailed, Server: a.currentAddress().String(), Error: err.Error()})
+		}
+
+		return err
+	}
+
+	a.setAuth(authData)
+	a.health.setAuthorized(true)
+
+	return nil
+}
+
+// refreshAuthorization refreshes the device authorization, reporting whether it
+// succeeded. The current authorization is kept when it fails. When the server
+// rejects it, the agent enters the deauthorized state, where the following
+// attempts are backed off until one succeeds.
+func (a *Agent) refreshAuthorization() bool {
+	a.connMu.Lock()
+	err := a.authorize()
+	a.connMu.Unlock()
+
+	if err == nil {
+		if a.deauthorized {
+			a.logger().Info("Device authorization restored")
+		}
+
+		a.deauthorized = false
+		a.authBackoff.Reset()
+
+		return true
+	}
+
+	if retryable(err) {
+		a.logger().WithError(err).WithField("retryable", true).Warn("Failed to refresh the device authorization")
+
+		return false
+	}
+
+	a.deauthorized = true
+	a.health.setAuthorized(false)
+
+	a.logger().WithError(err).WithFields(log.Fields{
+		"retryable": false,
+		"hint":      initErrorHint(err),
+	}).Error("Device authorization rejected by the server, retrying less often")
+
+	return false
 }
 
 func (a *Agent) newReverseListener() (*revdial.Listener, error) {
-	return a.cli.NewReverseListener(a.authData.Token)
+	a.mu.RLock()
+	cli, authData := a.cli, a.authData
+	a.mu.RUnlock()
+
+	if authData == nil {
+		return nil, ErrNotAuthorized
+	}
+
+	ctx, cancel := a.handshakeContext()
+	defer cancel()
+
+	listener, err := cli.NewReverseListenerContext(ctx, authData.Token)
+	if err != nil {
+		return nil, a.handshakeError(ctx, err)
+	}
+
+	return listener, nil
+}
+
+// handshakeContext returns the context bounding the device authorization,
+// retries included, and the connection of the reverse listener to the
+// handshake timeout.
+func (a *Agent) handshakeContext() (context.Context, context.CancelFunc) {
+	if a.opts.HandshakeTimeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(a.opts.HandshakeTimeout)*time.Second)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// handshakeError returns the error of a handshake with the server,
+// ErrHandshakeTimeout when its context expired or, as both share the handshake
+// timeout, when the dialer timed out first.
+func (a *Agent) handshakeError(ctx context.Context, err error) error {
+	var netErr net.Error
+	if err != nil && (errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: no answer within %d seconds", ErrHandshakeTimeout, a.opts.HandshakeTimeout)
+	}
+
+	return requestError(err)
+}
+
+// authorizationInterval returns how long to wait before refreshing the
+// authorization, which is at 80% of the remaining lifetime of the current
+// token, or the backoff delay in the deauthorized state.
+func (a *Agent) authorizationInterval() time.Duration {
+	if a.deauthorized {
+		return a.authBackoff.Next()
+	}
+
+	authData := a.currentAuth()
+	if authData == nil {
+		return defaultAuthorizationInterval
+	}
+
+	expiration, err := tokenExpiration(authData.Token)
+	if err != nil {
+		log.WithError(err).Debug("Failed to read the token expiration time")
+
+		return defaultAuthorizationInterval
+	}
+
+	interval := expiration.Sub(clock.Now()) * 8 / 10
+	if interval < minAuthorizationInterval {
+		return minAuthorizationInterval
+	}
+
+	return interval
+}
+
+// tokenExpiration returns the time defined by the exp claim of a JWT token.
+// The token signature is not verified.
+func tokenExpiration(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	var claims struct {
+		ExpiresAt *int64 `json:"exp"`
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	if claims.ExpiresAt == nil {
+		return time.Time{}, errors.Wrap(ErrInvalidToken, "missing exp claim")
+	}
+
+	return time.Unix(*claims.ExpiresAt, 0), nil
+}
+
+// listen keeps the reverse listener connected to the server, serving the tunnel
+// over it, until ctx is done.
+func (a *Agent) listen(ctx context.Context, tun *tunnel.Tunnel) {
+	b := backoff.New(
+		time.Duration(a.opts.ReconnectBackoffBase)*time.Second,
+		time.Duration(a.opts.ReconnectBackoffMax)*time.Second,
+	)
+
+	failures := newFailureLogger(log.StandardLogger(), time.Duration(a.opts.ReconnectLogInterval)*time.Second)
+
+	// connected is the address of the server the agent was last connected
+	// to.
+	var connected string
+
+	for ctx.Err() == nil {
+		listener, err := a.newReverseListener()
+		if err != nil {
+			delay := b.Next()
+
+			fields := a.logFields()
+			fields["server_address"] = a.currentAddress().String()
+			fields["retry_in"] = delay
+
+			failures.failure(err, fields, "Failed to connect to server")
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+
+			// Stick with the current server while it works, switching to the
+			// next one only when it fails.
+			if len(a.servers) > 1 && ctx.Err() == nil {
+				a.failover()
+			}
+
+			continue
+		}
+
+		b.Reset()
+		a.health.setConnected(true)
+		a.connectedOnce.Do(func() {
+			close(a.connected)
+		})
+
+		address := a.currentAddress().String()
+
+		a.logger().WithFields(log.Fields{
+			"hostname":        a.currentAuth().Name,
+			"server_address":  address,
+			"ssh_server":      a.currentServerInfo().Endpoints.SSH,
+			"sshid":           a.sshid(),
+			"failed_attempts": failures.success(),
+		}).Info("Server connection established")
+
+		a.keepSessions(connected)
+		connected = address
+
+		a.saveState()
+		a.event(webhook.Event{Type: webhook.Connected, Server: address})
+		a.connectionHook(connectEvent)
+
+		done := make(chan struct{})
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+			case <-done:
+			}
+		}()
+
+		if a.opts.HeartbeatInterval > 0 {
+			listener.OnRoundTrip(a.rtt.record)
+
+			go func() {
+				err := listener.Heartbeat(
+					time.Duration(a.opts.HeartbeatInterval)*time.Second,
+					time.Duration(a.opts.HeartbeatTimeout)*time.Second,
+					a.opts.HeartbeatMaxFailures,
+				)
+				if err != nil {
+					a.logger().WithError(err).WithField("server_address", address).Warn("Server connection stopped responding, reconnecting")
+				}
+			}()
+		}
+
+		if err := tun.Listen(listener); err != nil {
+			a.logger().WithError(err).Debug("Server connection closed")
+		}
+
+		close(done)
+		a.health.setConnected(false)
+		a.event(webhook.Event{Type: webhook.Disconnected, Server: address})
+		a.connectionHook(disconnectEvent)
+	}
+}
+
+// sessionsSurvive reports whether the active sessions survive a reconnection
+// from the previous server to the current one. Each session has its own
+// connection to the server, independent of the listener one, and is reported
+// to the server on authorization, so it survives a reconnection to the same
+// server. It cannot be moved to another server.
+func sessionsSurvive(previous, current string) bool {
+	return previous == "" || previous == current
+}
+
+// keepSessions keeps the active sessions once reconnected to the server, when
+// they survive the reconnection from the previous server, and closes them
+// otherwise.
+func (a *Agent) keepSessions(previous string) {
+	if a.sessions == nil {
+		return
+	}
+
+	ids := a.sessions()
+	if len(ids) == 0 {
+		return
+	}
+
+	current := a.currentAddress().String()
+
+	if sessionsSurvive(previous, current) {
+		a.logger().WithField("sessions", len(ids)).Info("Keeping the active sessions after reconnecting")
+
+		return
+	}
+
+	a.logger().WithFields(log.Fields{
+		"sessions":        len(ids),
+		"previous_server": previous,
+		"server_address":  current,
+	}).Warn("Closing the active sessions as they cannot be moved to another server")
+
+	for _, id := range ids {
+		a.closeSession(id)
+	}
+}
+
+// event sends the lifecycle event to the event webhook, when set, tagged with
+// the tenant, device and namespace.
+func (a *Agent) event(event webhook.Event) {
+	if a.events == nil {
+		return
+	}
+
+	event.TenantID = a.opts.TenantID
+
+	if authData := a.currentAuth(); authData != nil {
+		event.Device = authData.Name
+		event.Namespace = authData.Namespace
+	}
+
+	a.events.Send(event)
+}
+
+// logFields returns the fields identifying the tenant and namespace of the
+// device, shared by the logs of the connection lifecycle.
+func (a *Agent) logFields() log.Fields {
+	return server.LogFields(a.opts.TenantID, a.currentAuth())
+}
+
+// logger returns the logger of the connection lifecycle, carrying the tenant
+// and namespace fields.
+func (a *Agent) logger() *log.Entry {
+	return log.WithFields(a.logFields())
+}
+
+// sshid returns the SSHID used to reach the device through the server.
+func (a *Agent) sshid() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return formatSSHID(a.authData.Namespace, a.authData.Name, a.serverInfo.Endpoints.SSH)
+}
+
+// formatSSHID returns the SSHID of the device named name in the namespace,
+// reached through the server SSH endpoint, whose port is left out.
+func formatSSHID(namespace, name, sshEndpoint string) string {
+	// Splitting on the last colon keeps the IPv6 endpoints whole.
+	host := sshEndpoint
+	if h, _, err := net.SplitHostPort(sshEndpoint); err == nil {
+		host = h
+	}
+
+	return strings.NewReplacer(
+		"{namespace}", namespace,
+		"{tenantName}", name,
+		"{sshEndpoint}", host,
+	).Replace("{namespace}.{tenantName}@{sshEndpoint}")
 }
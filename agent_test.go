@@ -0,0 +1,826 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	"github.com/brycedjohnson/shellhub-agent/pkg/sysinfo"
+	"github.com/brycedjohnson/shellhub-agent/pkg/tunnel"
+	"github.com/brycedjohnson/shellhub-agent/server"
+)
+
+// newFakeServer starts a server answering the server information and device
+// authorization requests as the ShellHub server does.
+func newFakeServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/info":
+			json.NewEncoder(w).Encode(&models.Info{ // nolint:errcheck
+				Version:   "1.0.0",
+				Endpoints: models.Endpoints{API: name + ":80", SSH: name + ":22"},
+			})
+		case "/api/devices/auth":
+			json.NewEncoder(w).Encode(&models.DeviceAuthResponse{ // nolint:errcheck
+				UID:       "uid",
+				Token:     "token",
+				Name:      "device",
+				Namespace: name,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestFailoverDuringAuthorizationRefresh(t *testing.T) {
+	first := newFakeServer(t, "first")
+	second := newFakeServer(t, "second")
+
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress: first.URL + "," + second.URL,
+		TenantID:      "tenant",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+
+	if err := agent.connectServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	serv := server.NewServer(agent.currentClient(), agent.currentAuth(), "", 0, "")
+	agent.onServerChange = func() {
+		serv.SetAPI(agent.currentClient(), agent.currentAuth())
+		serv.SetDeviceName(agent.currentAuth().Name)
+	}
+
+	const iterations = 20
+
+	var wg sync.WaitGroup
+
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < iterations; i++ {
+			agent.failover()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < iterations; i++ {
+			if agent.refreshAuthorization() {
+				serv.SetAPI(agent.currentClient(), agent.currentAuth())
+			}
+
+			agent.authorizationInterval()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < iterations; i++ {
+			agent.logger()
+			agent.currentAddress()
+			agent.connectionHookEnv(connectEvent)
+			agent.newReverseListener() // nolint:errcheck
+		}
+	}()
+
+	wg.Wait()
+
+	// The authorization is always the one of the server in use.
+	auth, info := agent.currentAuth(), agent.currentServerInfo()
+	if auth == nil || info == nil {
+		t.Fatal("agent is not authorized after the failovers")
+	}
+
+	if want := info.Endpoints.SSH; auth.Namespace+":22" != want {
+		t.Errorf("authorization namespace = %q, want the one of %q", auth.Namespace, want)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	stop := make(chan struct{})
+
+	// The server answers the probe, then accepts the connections of the
+	// handshake but never answers them.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/info" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&models.Info{Version: "1.0.0"}) // nolint:errcheck
+
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress:    srv.URL,
+		TenantID:         "tenant",
+		HandshakeTimeout: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+
+	tests := []struct {
+		name      string
+		handshake func() error
+	}{
+		{name: "authorization", handshake: agent.connectServer},
+		{name: "reverse listener", handshake: func() error {
+			agent.setAuth(&models.DeviceAuthResponse{Token: "token"})
+
+			_, err := agent.newReverseListener()
+
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+
+			err := tt.handshake()
+			if !errors.Is(err, ErrHandshakeTimeout) {
+				t.Fatalf("error = %v, want %v", err, ErrHandshakeTimeout)
+			}
+
+			if !retryable(err) {
+				t.Errorf("retryable(%v) = false", err)
+			}
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("handshake gave up after %v, want about 1s", elapsed)
+			}
+		})
+	}
+}
+
+func TestNewAgentClientError(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		err   error
+	}{
+		{name: "valid keep-alive", count: 6},
+		{name: "invalid keep-alive", count: 0, err: client.ErrInvalidKeepAlive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(&ConfigOptions{
+				ServerAddress:        "http://localhost",
+				TCPKeepAlive:         true,
+				TCPKeepAliveIdle:     60,
+				TCPKeepAliveInterval: 10,
+				TCPKeepAliveCount:    tt.count,
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("NewAgent() error = %v, want %v", err, tt.err)
+			}
+
+			if err == nil && agent.currentClient() == nil {
+				t.Error("NewAgent() has no client")
+			}
+		})
+	}
+}
+
+// fakeClock is a clock set by the tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func setFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+
+	fake := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	backend := clock.DefaultBackend
+	clock.DefaultBackend = fake
+
+	t.Cleanup(func() {
+		clock.DefaultBackend = backend
+	})
+
+	return fake
+}
+
+// testToken returns an unsigned JWT token with the claims.
+func testToken(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + "." + encode([]byte("signature"))
+}
+
+func TestTokenExpiration(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+		err   bool
+	}{
+		{name: "exp claim", token: testToken(`{"exp":1672531200}`), want: time.Unix(1672531200, 0)},
+		{name: "missing exp claim", token: testToken(`{"sub":"device"}`), err: true},
+		{name: "invalid exp claim", token: testToken(`{"exp":"tomorrow"}`), err: true},
+		{name: "invalid payload", token: testToken(`not json`), err: true},
+		{name: "invalid encoding", token: "a.!!!.c", err: true},
+		{name: "missing parts", token: "a.b", err: true},
+		{name: "empty", token: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tokenExpiration(tt.token)
+			if (err != nil) != tt.err {
+				t.Fatalf("tokenExpiration() error = %v, want error %v", err, tt.err)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("tokenExpiration() error = %v, want %v", err, ErrInvalidToken)
+			}
+
+			if !got.Equal(tt.want) {
+				t.Errorf("tokenExpiration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizationInterval(t *testing.T) {
+	fake := setFakeClock(t)
+
+	expiresIn := func(d time.Duration) string {
+		return testToken(`{"exp":` + strconv.FormatInt(fake.now.Add(d).Unix(), 10) + `}`)
+	}
+
+	tests := []struct {
+		name     string
+		authData *models.DeviceAuthResponse
+		want     time.Duration
+	}{
+		{name: "not authorized", want: defaultAuthorizationInterval},
+		{name: "invalid token", authData: &models.DeviceAuthResponse{Token: "token"}, want: defaultAuthorizationInterval},
+		{name: "80% of the lifetime", authData: &models.DeviceAuthResponse{Token: expiresIn(time.Hour)}, want: 48 * time.Minute},
+		{name: "short lifetime", authData: &models.DeviceAuthResponse{Token: expiresIn(30 * time.Second)}, want: minAuthorizationInterval},
+		{name: "expired", authData: &models.DeviceAuthResponse{Token: expiresIn(-time.Hour)}, want: minAuthorizationInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			agent.authData = tt.authData
+
+			if got := agent.authorizationInterval(); got != tt.want {
+				t.Errorf("authorizationInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizationIntervalDeauthorized(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.deauthorized = true
+
+	// Once deauthorized, the refreshes back off from the minimum interval,
+	// whatever the token lifetime.
+	for i := 0; i < 4; i++ {
+		want := minAuthorizationInterval << i
+
+		if got := agent.authorizationInterval(); got < want/2 || got > want {
+			t.Errorf("authorizationInterval() = %v, want between %v and %v", got, want/2, want)
+		}
+	}
+}
+
+func TestListenStopsOnCancel(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress:        "http://localhost",
+		ReconnectBackoffBase: 60,
+		ReconnectBackoffMax:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	// Not being authorized, the agent waits for the backoff delay before
+	// connecting again, which the cancellation interrupts.
+	go func() {
+		defer close(done)
+
+		agent.listen(ctx, tunnel.NewTunnel())
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listen() did not return once cancelled")
+	}
+}
+
+// blockingConn is a connection whose Close blocks until unblock is closed.
+type blockingConn struct {
+	net.Conn
+	unblock chan struct{}
+}
+
+func (c *blockingConn) Close() error {
+	<-c.unblock
+
+	return c.Conn.Close()
+}
+
+func TestShutdown(t *testing.T) {
+	serv := server.NewServer(nil, nil, "", 0, "")
+
+	var peers []net.Conn
+
+	for _, id := range []string{"first", "second"} {
+		conn, peer := net.Pipe()
+		peers = append(peers, peer)
+
+		if err := serv.AddSession(id, conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shutdown(serv, tunnel.NewTunnel(), 5*time.Second)
+
+	if ids := serv.ListSessionIDs(); len(ids) != 0 {
+		t.Errorf("sessions %v still active after the shutdown", ids)
+	}
+
+	for _, peer := range peers {
+		if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("session connection read error = %v, want %v", err, io.EOF)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	serv := server.NewServer(nil, nil, "", 0, "")
+
+	conn, _ := net.Pipe()
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	if err := serv.AddSession("stuck", &blockingConn{conn, unblock}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	shutdown(serv, tunnel.NewTunnel(), 100*time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("shutdown() returned after %v, want about the timeout", elapsed)
+	}
+}
+
+func TestFormatSSHID(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{name: "host", endpoint: "cloud.shellhub.io", want: "namespace.device@cloud.shellhub.io"},
+		{name: "host and port", endpoint: "cloud.shellhub.io:22", want: "namespace.device@cloud.shellhub.io"},
+		{name: "ipv4 and port", endpoint: "192.0.2.1:2222", want: "namespace.device@192.0.2.1"},
+		{name: "ipv6 and port", endpoint: "[2001:db8::1]:22", want: "namespace.device@2001:db8::1"},
+		{name: "empty", endpoint: "", want: "namespace.device@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSSHID("namespace", "device", tt.endpoint); got != tt.want {
+				t.Errorf("formatSSHID(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgentSSHID(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.authData = &models.DeviceAuthResponse{Namespace: "namespace", Name: "device"}
+	agent.serverInfo = &models.Info{Endpoints: models.Endpoints{SSH: "cloud.shellhub.io:22"}}
+
+	if got := agent.sshid(); got != "namespace.device@cloud.shellhub.io" {
+		t.Errorf("sshid() = %q, want namespace.device@cloud.shellhub.io", got)
+	}
+}
+
+func TestAgentLogger(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := agent.logger().Data; data["tenant_id"] != "tenant" || data["namespace"] != nil {
+		t.Errorf("logger() fields = %v before the authorization, want only the tenant id", data)
+	}
+
+	agent.authData = &models.DeviceAuthResponse{Namespace: "namespace"}
+
+	if data := agent.logger().Data; data["tenant_id"] != "tenant" || data["namespace"] != "namespace" {
+		t.Errorf("logger() fields = %v after the authorization, want the tenant id and the namespace", data)
+	}
+}
+
+func TestGeneratePrivateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		generate bool
+		err      error
+	}{
+		{name: "enabled", generate: true},
+		{name: "disabled", err: ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privateKey := filepath.Join(t.TempDir(), "shellhub.key")
+
+			agent, err := NewAgent(&ConfigOptions{
+				ServerAddress:        "http://localhost",
+				TenantID:             "tenant",
+				PrivateKey:           privateKey,
+				GenerateKeyIfMissing: tt.generate,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := agent.generatePrivateKey(); !errors.Is(err, tt.err) {
+				t.Fatalf("generatePrivateKey() = %v, want %v", err, tt.err)
+			}
+
+			_, err = os.Stat(privateKey)
+			if generated := err == nil; generated != tt.generate {
+				t.Errorf("private key generated = %v, want %v", generated, tt.generate)
+			}
+
+			if tt.generate {
+				// An existing key is kept.
+				before, _ := os.ReadFile(privateKey)
+
+				if err := agent.generatePrivateKey(); err != nil {
+					t.Fatal(err)
+				}
+
+				if after, _ := os.ReadFile(privateKey); string(after) != string(before) {
+					t.Error("the existing private key was replaced")
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshAuthorization(t *testing.T) {
+	var status int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := int(atomic.LoadInt32(&status)); code != http.StatusOK {
+			w.WriteHeader(code)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.DeviceAuthResponse{UID: "uid", Token: "token", Name: "device"}) // nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant", HandshakeTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+
+	// The steps run in order, each one starting from the state the previous
+	// one left.
+	steps := []struct {
+		name         string
+		status       int
+		refreshed    bool
+		deauthorized bool
+	}{
+		{name: "authorized", status: http.StatusOK, refreshed: true},
+		{name: "transient failure", status: http.StatusServiceUnavailable},
+		{name: "rejected", status: http.StatusForbidden, deauthorized: true},
+		{name: "still rejected", status: http.StatusUnauthorized, deauthorized: true},
+		{name: "restored", status: http.StatusOK, refreshed: true},
+	}
+
+	for _, step := range steps {
+		atomic.StoreInt32(&status, int32(step.status))
+
+		if got := agent.refreshAuthorization(); got != step.refreshed {
+			t.Errorf("%s: refreshAuthorization() = %v, want %v", step.name, got, step.refreshed)
+		}
+
+		if agent.deauthorized != step.deauthorized || agent.health.isAuthorized() == step.deauthorized {
+			t.Errorf("%s: deauthorized = %v, authorized = %v, want deauthorized %v", step.name, agent.deauthorized, agent.health.isAuthorized(), step.deauthorized)
+		}
+
+		// The current authorization is kept on failures.
+		if auth := agent.currentAuth(); auth == nil || auth.Name != "device" {
+			t.Errorf("%s: authorization = %+v, want the one of the last success", step.name, auth)
+		}
+	}
+}
+
+func TestLoadDeviceInfoFacts(t *testing.T) {
+	dir := t.TempDir()
+
+	for filename, content := range map[*string]string{
+		&sysinfo.DefaultKernelReleaseFilename: "6.1.0-13-amd64\n",
+		&sysinfo.DefaultMemInfoFilename:       "MemTotal:       2048 kB\n",
+		// The CPU model is missing, so it is omitted.
+		&sysinfo.DefaultCPUInfoFilename: "processor\t: 0\n",
+	} {
+		path := filepath.Join(dir, filepath.Base(*filename))
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		defer func(filename *string, previous string) { *filename = previous }(filename, *filename)
+		*filename = path
+	}
+
+	tests := []struct {
+		name   string
+		report bool
+	}{
+		{name: "reported", report: true},
+		{name: "not reported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant", ReportFacts: tt.report})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := agent.loadDeviceInfo(); err != nil {
+				t.Fatal(err)
+			}
+
+			facts := agent.Info.Facts
+			if !tt.report {
+				if facts != nil {
+					t.Errorf("facts = %+v, want them not reported", facts)
+				}
+
+				return
+			}
+
+			if facts == nil || facts.KernelVersion != "6.1.0-13-amd64" || facts.MemTotal != 2048*1024 || facts.CPUModel != "" || facts.CPUCount != runtime.NumCPU() {
+				t.Errorf("facts = %+v, want the collected ones, without the CPU model", facts)
+			}
+		})
+	}
+}
+
+func TestProbeServerInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		err     error
+	}{
+		{
+			name: "answered",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(&models.Info{Version: "1.0.0"}) // nolint:errcheck
+			},
+		},
+		{
+			name: "server errors retried until the timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			err: ErrProbeTimeout,
+		},
+		{
+			name: "no answer",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			err: ErrProbeTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant", ProbeTimeout: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+
+			err = agent.probeServerInfo()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("probeServerInfo() = %v, want %v", err, tt.err)
+			}
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("probeServerInfo() took %v, want it bounded by the probe timeout", elapsed)
+			}
+
+			if info := agent.currentServerInfo(); (info != nil) != (tt.err == nil) {
+				t.Errorf("server info = %+v, want it set only when answered", info)
+			}
+		})
+	}
+}
+
+func TestSessionsSurvive(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		want     bool
+	}{
+		{name: "first connection", current: "https://first.shellhub.io", want: true},
+		{name: "same server", previous: "https://first.shellhub.io", current: "https://first.shellhub.io", want: true},
+		{name: "other server", previous: "https://first.shellhub.io", current: "https://second.shellhub.io"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionsSurvive(tt.previous, tt.current); got != tt.want {
+				t.Errorf("sessionsSurvive(%q, %q) = %v, want %v", tt.previous, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		sessions []string
+		closed   []string
+	}{
+		{name: "first connection", sessions: []string{"a", "b"}},
+		{name: "same server", previous: "https://first.shellhub.io", sessions: []string{"a", "b"}},
+		{name: "other server", previous: "https://second.shellhub.io", sessions: []string{"a", "b"}, closed: []string{"a", "b"}},
+		{name: "no sessions", previous: "https://second.shellhub.io"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := NewAgent(&ConfigOptions{ServerAddress: "https://first.shellhub.io", TenantID: "tenant"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var closed []string
+
+			agent.sessions = func() []string { return tt.sessions }
+			agent.closeSession = func(id string) { closed = append(closed, id) }
+
+			agent.keepSessions(tt.previous)
+
+			if !reflect.DeepEqual(closed, tt.closed) {
+				t.Errorf("closed sessions = %v, want %v", closed, tt.closed)
+			}
+		})
+	}
+}
+
+func TestKeepSessionsWithoutServer(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "https://first.shellhub.io", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The SSH server is not started yet.
+	agent.keepSessions("https://second.shellhub.io")
+}
+
+func TestAuthorizeReportsSessions(t *testing.T) {
+	requests := make(chan models.DeviceAuthRequest, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.DeviceAuthRequest
+		json.NewDecoder(r.Body).Decode(&req) // nolint:errcheck
+		requests <- req
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.DeviceAuthResponse{UID: "uid", Token: "token", Name: "device"}) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+	agent.sessions = func() []string { return []string{"a", "b"} }
+
+	if err := agent.authorize(); err != nil {
+		t.Fatal(err)
+	}
+
+	if req := <-requests; !reflect.DeepEqual(req.Sessions, []string{"a", "b"}) {
+		t.Errorf("authorization request sessions = %v, want the active ones", req.Sessions)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadLoginBanner returns the login banner set in the configuration, either
+// the text itself or, when it is an absolute path, the content of the file.
+func loadLoginBanner(opts *ConfigOptions) (string, error) {
+	if !filepath.IsAbs(opts.LoginBanner) {
+		return opts.LoginBanner, nil
+	}
+
+	data, err := os.ReadFile(opts.LoginBanner)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// loginBanner returns the banner with the {hostname}, {sshid} and {version}
+// placeholders replaced by the device name, its SSHID and the agent version,
+// its lines ending with CRLF, as written to a terminal.
+func (a *Agent) loginBanner(banner string) string {
+	if banner == "" {
+		return ""
+	}
+
+	replacer := strings.NewReplacer(
+		"{hostname}", a.currentAuth().Name,
+		"{sshid}", a.sshid(),
+		"{version}", AgentVersion,
+	)
+
+	lines := strings.Split(strings.TrimRight(replacer.Replace(banner), "\r\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
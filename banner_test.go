@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+func TestLoadLoginBanner(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "banner")
+	if err := os.WriteFile(file, []byte("Authorized use only\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		banner string
+		want   string
+		err    error
+	}{
+		{name: "unset"},
+		{name: "text", banner: "Authorized use only", want: "Authorized use only"},
+		{name: "relative path as text", banner: "banner", want: "banner"},
+		{name: "file", banner: file, want: "Authorized use only\n"},
+		{name: "missing file", banner: filepath.Join(dir, "missing"), err: os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadLoginBanner(&ConfigOptions{LoginBanner: tt.banner})
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("loadLoginBanner() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoginBanner(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.authData = &models.DeviceAuthResponse{Namespace: "namespace", Name: "device"}
+	agent.serverInfo = &models.Info{Endpoints: models.Endpoints{SSH: "cloud.shellhub.io:22"}}
+
+	tests := []struct {
+		name   string
+		banner string
+		want   string
+	}{
+		{name: "empty"},
+		{name: "single line", banner: "Authorized use only", want: "Authorized use only\r\n"},
+		{name: "lines", banner: "Authorized use only\nActivity is logged\n\n", want: "Authorized use only\r\nActivity is logged\r\n"},
+		{name: "CRLF lines", banner: "Authorized use only\r\nActivity is logged\r\n", want: "Authorized use only\r\nActivity is logged\r\n"},
+		{
+			name:   "placeholders",
+			banner: "Welcome to {hostname} ({sshid}), agent {version}",
+			want:   "Welcome to device (namespace.device@cloud.shellhub.io), agent " + AgentVersion + "\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agent.loginBanner(tt.banner); got != tt.want {
+				t.Errorf("loginBanner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCircuits bounds the number of device services tracked by the breaker, as
+// their addresses come from the request headers.
+const maxCircuits = 1024
+
+// circuitBreaker tracks the consecutive dial failures of the device services.
+// Once a service fails threshold times in a row, its circuit opens and the
+// requests to it are refused for the cooldown. Then, a single request probes
+// the service, closing the circuit when it succeeds and opening it again
+// otherwise. A zero threshold disables the breaker.
+//
+// At most maxCircuits services are tracked, the ones which failed last the
+// longest time ago being forgotten first.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the breaker state of a device service.
+type circuit struct {
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+	probing     bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// allow reports whether a request can be forwarded to the service at address,
+// its circuit being either closed or half-open with no probe in progress.
+func (b *circuitBreaker) allow(address string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[address]
+	if !ok || c.failures < b.threshold {
+		return true
+	}
+
+	if c.probing || b.now().Sub(c.openedAt) < b.cooldown {
+		return false
+	}
+
+	c.probing = true
+
+	return true
+}
+
+// success closes the circuit of the service at address.
+func (b *circuitBreaker) success(address string) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.circuits, address)
+}
+
+// failure counts a dial failure of the service at address, opening its circuit
+// once the threshold is reached or when the probe failed.
+func (b *circuitBreaker) failure(address string) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	c, ok := b.circuits[address]
+	if !ok {
+		if len(b.circuits) >= maxCircuits {
+			b.evict(now)
+		}
+
+		c = &circuit{}
+		b.circuits[address] = c
+	}
+
+	c.failures++
+	c.lastFailure = now
+	c.probing = false
+
+	if c.failures >= b.threshold {
+		c.openedAt = now
+	}
+}
+
+// evict forgets the services which failed last more than the cooldown ago, or,
+// when there is none, the one which failed last the longest time ago, making
+// room for another service. It must be called with the lock held.
+func (b *circuitBreaker) evict(now time.Time) {
+	var (
+		oldest      string
+		oldestFound bool
+	)
+
+	for address, c := range b.circuits {
+		if now.Sub(c.lastFailure) >= b.cooldown && !c.probing {
+			delete(b.circuits, address)
+
+			continue
+		}
+
+		if !oldestFound || c.lastFailure.Before(b.circuits[oldest].lastFailure) {
+			oldest, oldestFound = address, true
+		}
+	}
+
+	if len(b.circuits) >= maxCircuits {
+		delete(b.circuits, oldest)
+	}
+}
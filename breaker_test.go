@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const address = "http://127.0.0.1:8080"
+
+	// Each step happens at the time offset from the start, and either
+	// reports a dial result or checks whether a request is allowed.
+	type step struct {
+		at      time.Duration
+		failure bool
+		success bool
+		allow   bool
+	}
+
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{failure: true}, {failure: true}, {failure: true},
+				{allow: true},
+			},
+		},
+		{
+			name:      "closed below the threshold",
+			threshold: 3,
+			steps: []step{
+				{failure: true}, {failure: true},
+				{allow: true},
+			},
+		},
+		{
+			name:      "open at the threshold",
+			threshold: 3,
+			steps: []step{
+				{failure: true}, {failure: true}, {failure: true},
+				{allow: false},
+				{at: 29 * time.Second, allow: false},
+			},
+		},
+		{
+			name:      "success resets the failures",
+			threshold: 3,
+			steps: []step{
+				{failure: true}, {failure: true}, {success: true},
+				{failure: true}, {failure: true},
+				{allow: true},
+			},
+		},
+		{
+			name:      "half-open after the cooldown allows a single probe",
+			threshold: 2,
+			steps: []step{
+				{failure: true}, {failure: true},
+				{at: 30 * time.Second, allow: true},
+				{at: 30 * time.Second, allow: false},
+			},
+		},
+		{
+			name:      "successful probe closes",
+			threshold: 2,
+			steps: []step{
+				{failure: true}, {failure: true},
+				{at: 30 * time.Second, allow: true},
+				{at: 30 * time.Second, success: true},
+				{at: 30 * time.Second, allow: true},
+				{at: 30 * time.Second, allow: true},
+			},
+		},
+		{
+			name:      "failed probe opens again",
+			threshold: 2,
+			steps: []step{
+				{failure: true}, {failure: true},
+				{at: 30 * time.Second, allow: true},
+				{at: 31 * time.Second, failure: true},
+				{at: 31 * time.Second, allow: false},
+				{at: 60 * time.Second, allow: false},
+				{at: 61 * time.Second, allow: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			now := start
+
+			b := newCircuitBreaker(tt.threshold, 30*time.Second)
+			b.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = start.Add(s.at)
+
+				switch {
+				case s.failure:
+					b.failure(address)
+				case s.success:
+					b.success(address)
+				default:
+					if allowed := b.allow(address); allowed != s.allow {
+						t.Fatalf("step %d: allow() at %v = %v, want %v", i, s.at, allowed, s.allow)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerBound(t *testing.T) {
+	const open = "http://127.0.0.1:8080"
+
+	start := time.Now()
+	now := start
+
+	b := newCircuitBreaker(1, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.failure(open)
+
+	// The failing addresses sent by a client never grow the breaker past
+	// its bound.
+	for i := 0; i < 2*maxCircuits; i++ {
+		now = start.Add(time.Duration(i) * time.Millisecond)
+
+		b.failure("http://127.0.0.1:" + strconv.Itoa(10000+i))
+
+		if len(b.circuits) > maxCircuits {
+			t.Fatalf("breaker tracks %d services, want at most %d", len(b.circuits), maxCircuits)
+		}
+	}
+
+	// The service which failed last the longest time ago was forgotten.
+	if !b.allow(open) {
+		t.Errorf("allow(%q) = false after it was forgotten", open)
+	}
+
+	// Once the cooldown elapsed, the services are forgotten at once.
+	now = start.Add(time.Hour)
+	b.failure("http://127.0.0.1:1")
+
+	if len(b.circuits) != 1 {
+		t.Errorf("breaker tracks %d services after the cooldown, want 1", len(b.circuits))
+	}
+}
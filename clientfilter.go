@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidClientCIDR = errors.New("invalid client CIDR")
+	ErrClientDenied      = errors.New("client address is denied")
+	ErrClientNotAllowed  = errors.New("client address is not allowed")
+	ErrNoClientAddress   = errors.New("client address is unknown")
+)
+
+// clientFilter restricts the client addresses, as conveyed by the server, which
+// may open sessions on the device. The denied networks take precedence over
+// the allowed ones, and any address is allowed when there is none.
+type clientFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+func newClientFilter(allowed, denied []string) (*clientFilter, error) {
+	f := new(clientFilter)
+
+	var err error
+
+	if f.allowed, err = parseNetworks(allowed); err != nil {
+		return nil, err
+	}
+
+	if f.denied, err = parseNetworks(denied); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// parseNetworks parses the CIDRs, a single IP address being a network of its
+// own.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidClientCIDR, entry)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// enabled reports whether the filter restricts any client.
+func (f *clientFilter) enabled() bool {
+	return len(f.allowed) > 0 || len(f.denied) > 0
+}
+
+// check returns an error when the client at ip may not open a session. An
+// unknown address is only allowed when there is no allow list.
+func (f *clientFilter) check(ip net.IP) error {
+	if ip == nil {
+		if len(f.allowed) > 0 {
+			return ErrNoClientAddress
+		}
+
+		return nil
+	}
+
+	if containsIP(f.denied, ip) {
+		return ErrClientDenied
+	}
+
+	if len(f.allowed) > 0 && !containsIP(f.allowed, ip) {
+		return ErrClientNotAllowed
+	}
+
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the address of the client opening a session, as conveyed by
+// the server in the X-Real-IP header or, without it, as the first address of
+// the X-Forwarded-For header. It returns nil when the address is unknown.
+func clientIP(r *http.Request) net.IP {
+	value := r.Header.Get("X-Real-IP")
+	if value == "" {
+		value, _, _ = strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+	}
+
+	return net.ParseIP(strings.TrimSpace(value))
+}
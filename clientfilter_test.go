@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewClientFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		enabled bool
+		err     error
+	}{
+		{name: "empty"},
+		{name: "blank entries", allowed: []string{" ", ""}},
+		{name: "cidr", allowed: []string{"10.0.0.0/8"}, enabled: true},
+		{name: "ip", denied: []string{"203.0.113.1"}, enabled: true},
+		{name: "ipv6", allowed: []string{"2001:db8::/32", "::1"}, enabled: true},
+		{name: "surrounding spaces", allowed: []string{" 10.0.0.0/8 "}, enabled: true},
+		{name: "invalid allowed", allowed: []string{"10.0.0.0/33"}, err: ErrInvalidClientCIDR},
+		{name: "invalid denied", denied: []string{"not an address"}, err: ErrInvalidClientCIDR},
+		{name: "hostname", allowed: []string{"example.com"}, err: ErrInvalidClientCIDR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newClientFilter(tt.allowed, tt.denied)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("newClientFilter(%q, %q) error = %v, want %v", tt.allowed, tt.denied, err, tt.err)
+			}
+
+			if err == nil && f.enabled() != tt.enabled {
+				t.Errorf("enabled() = %v, want %v", f.enabled(), tt.enabled)
+			}
+		})
+	}
+}
+
+func TestClientFilterCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		ip      string
+		err     error
+	}{
+		{name: "no lists", ip: "203.0.113.1"},
+		{name: "no lists unknown address", ip: ""},
+		{name: "allowed", allowed: []string{"10.0.0.0/8"}, ip: "10.1.2.3"},
+		{name: "not allowed", allowed: []string{"10.0.0.0/8"}, ip: "11.0.0.1", err: ErrClientNotAllowed},
+		{name: "unknown address with an allow list", allowed: []string{"10.0.0.0/8"}, ip: "", err: ErrNoClientAddress},
+		{name: "unknown address with a deny list", denied: []string{"10.0.0.0/8"}, ip: ""},
+		{name: "denied", denied: []string{"203.0.113.0/24"}, ip: "203.0.113.7", err: ErrClientDenied},
+		{name: "not denied", denied: []string{"203.0.113.0/24"}, ip: "198.51.100.7"},
+		{name: "deny before allow", allowed: []string{"10.0.0.0/8"}, denied: []string{"10.0.0.1"}, ip: "10.0.0.1", err: ErrClientDenied},
+		{name: "allowed next to a denied address", allowed: []string{"10.0.0.0/8"}, denied: []string{"10.0.0.1"}, ip: "10.0.0.2"},
+		{name: "single allowed ip", allowed: []string{"203.0.113.1"}, ip: "203.0.113.1"},
+		{name: "ipv4 mapped ipv6", allowed: []string{"203.0.113.1"}, ip: "::ffff:203.0.113.1"},
+		{name: "ipv6 allowed", allowed: []string{"2001:db8::/32"}, ip: "2001:db8::1"},
+		{name: "ipv6 not allowed", allowed: []string{"2001:db8::/32"}, ip: "2001:db9::1", err: ErrClientNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newClientFilter(tt.allowed, tt.denied)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := f.check(net.ParseIP(tt.ip)); err != tt.err {
+				t.Errorf("check(%q) = %v, want %v", tt.ip, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "unknown", header: http.Header{}},
+		{name: "real ip", header: http.Header{"X-Real-Ip": {"203.0.113.1"}}, want: "203.0.113.1"},
+		{name: "real ip with spaces", header: http.Header{"X-Real-Ip": {" 203.0.113.1 "}}, want: "203.0.113.1"},
+		{name: "forwarded for", header: http.Header{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}}, want: "203.0.113.1"},
+		{
+			name:   "real ip before forwarded for",
+			header: http.Header{"X-Real-Ip": {"203.0.113.1"}, "X-Forwarded-For": {"198.51.100.1"}},
+			want:   "203.0.113.1",
+		},
+		{name: "ipv6", header: http.Header{"X-Real-Ip": {"2001:db8::1"}}, want: "2001:db8::1"},
+		{name: "invalid", header: http.Header{"X-Real-Ip": {"forged"}}},
+		{name: "with port", header: http.Header{"X-Real-Ip": {"203.0.113.1:4242"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+
+			ip := clientIP(r)
+
+			if got := ip.String(); (ip == nil && tt.want != "") || (ip != nil && got != tt.want) {
+				t.Errorf("clientIP() = %v, want %q", ip, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrClockSkew = errors.New("clock skew is too large")
+
+// clockSkew returns how far the local time is ahead of the server time,
+// negative when it is behind.
+func clockSkew(server, local time.Time) time.Duration {
+	return local.Sub(server)
+}
+
+// exceedsClockSkew reports whether the skew, in either direction, is larger
+// than max. A zero max allows any skew.
+func exceedsClockSkew(skew, max time.Duration) bool {
+	if max <= 0 {
+		return false
+	}
+
+	return skew > max || skew < -max
+}
+
+// checkClockSkew compares the local time with the server one, as sent by the
+// last server response, warning when they are too far apart, as the server
+// would then reject the authorization tokens. In strict mode, it also fails.
+func (a *Agent) checkClockSkew() error {
+	serverDate := a.currentClient().ServerDate()
+	if serverDate.IsZero() {
+		return nil
+	}
+
+	local := clock.Now()
+	skew := clockSkew(serverDate, local)
+
+	if !exceedsClockSkew(skew, time.Duration(a.opts.MaxClockSkew)*time.Second) {
+		log.WithField("clock_skew", skew).Debug("Clock skew within the allowed range")
+
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"clock_skew":     skew.Round(time.Second),
+		"max_clock_skew": time.Duration(a.opts.MaxClockSkew) * time.Second,
+		"server_time":    serverDate,
+		"local_time":     local,
+	}).Warn("The device clock is far off the server clock, the authorization may fail; synchronize the device clock, e.g. with NTP")
+
+	if a.opts.StrictClockSkew {
+		return ErrClockSkew
+	}
+
+	return nil
+}
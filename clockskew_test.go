@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExceedsClockSkew(t *testing.T) {
+	tests := []struct {
+		name string
+		skew time.Duration
+		max  time.Duration
+		want bool
+	}{
+		{name: "none", skew: 0, max: 30 * time.Second, want: false},
+		{name: "ahead within", skew: 30 * time.Second, max: 30 * time.Second, want: false},
+		{name: "behind within", skew: -30 * time.Second, max: 30 * time.Second, want: false},
+		{name: "ahead", skew: 31 * time.Second, max: 30 * time.Second, want: true},
+		{name: "behind", skew: -31 * time.Second, max: 30 * time.Second, want: true},
+		{name: "unlimited", skew: 24 * time.Hour, max: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exceedsClockSkew(tt.skew, tt.max); got != tt.want {
+				t.Errorf("exceedsClockSkew(%s, %s) = %v, want %v", tt.skew, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+// serverDate is the time sent by the test servers in the Date header.
+var serverDate = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// serverDateHandler answers the server info request with serverDate as the
+// server time.
+func serverDateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Date", serverDate.Format(http.TimeFormat))
+	serverInfoHandler(w, r)
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serverDateHandler))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		skew   time.Duration
+		max    int
+		strict bool
+		err    error
+	}{
+		{name: "within", skew: 10 * time.Second, max: 30, strict: true},
+		{name: "ahead", skew: time.Minute, max: 30, strict: true, err: ErrClockSkew},
+		{name: "behind", skew: -time.Minute, max: 30, strict: true, err: ErrClockSkew},
+		{name: "not strict", skew: time.Hour, max: 30},
+		{name: "unlimited", skew: time.Hour, max: 0, strict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			fake := setFakeClock(t)
+
+			agent, err := NewAgent(&ConfigOptions{
+				ServerAddress:   server.URL,
+				TenantID:        "tenant",
+				MaxClockSkew:    tt.max,
+				StrictClockSkew: tt.strict,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The skew is unknown until the server answers a request.
+			if err := agent.checkClockSkew(); err != nil {
+				t.Fatalf("checkClockSkew() = %v before any request, want nil", err)
+			}
+
+			if _, err := agent.currentClient().GetInfo(AgentVersion); err != nil {
+				t.Fatal(err)
+			}
+
+			fake.now = serverDate.Add(tt.skew)
+
+			if err := agent.checkClockSkew(); !errors.Is(err, tt.err) {
+				t.Errorf("checkClockSkew() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDoctorClockSkew(t *testing.T) {
+	fake := setFakeClock(t)
+	fake.now = serverDate.Add(time.Hour)
+
+	_, report := doctorReport(newTestDoctor(t, http.HandlerFunc(serverDateHandler), "127.0.0.1:80"))
+
+	for _, line := range report {
+		if strings.HasPrefix(line, "[FAIL] clock skew: "+ErrClockSkew.Error()) {
+			return
+		}
+	}
+
+	t.Errorf("doctor report does not fail the clock skew check:\n%s", strings.Join(report, "\n"))
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+	"github.com/brycedjohnson/shellhub-agent/pkg/osauth"
+	"github.com/brycedjohnson/shellhub-agent/pkg/sftp"
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+	"github.com/brycedjohnson/shellhub-agent/server"
+	"github.com/kelseyhightower/envconfig"
+	shellwords "github.com/mattn/go-shellwords"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrSingleUserAsRoot     = errors.New("single-user mode cannot be enabled when running as root")
+	ErrMultiUserAsNonRoot   = errors.New("multi-user mode requires running as root")
+	ErrInvalidServerAddress = errors.New("invalid server address")
+	ErrUnsupportedScheme    = errors.New("unsupported server address scheme")
+	ErrEmptyTenantID        = errors.New("tenant id is empty")
+	ErrUnknownConfigKey     = errors.New("unknown configuration key")
+	ErrSFTPRootNotDir       = errors.New("sftp root is not a directory")
+	ErrSFTPUserAsNonRoot    = errors.New("sftp user can only be set when running as root")
+	ErrUnsupportedHash      = errors.New("unsupported password hash algorithm")
+	ErrInvalidJitter        = errors.New("keep alive jitter must be between 0 and 1")
+	ErrNotExecutable        = errors.New("file is not executable")
+	ErrInvalidHeartbeat     = errors.New("heartbeat timeout and max failures must be positive when the heartbeat is enabled")
+	ErrInvalidLockout       = errors.New("password lockout window and cooldown must be positive when the lockout is enabled")
+)
+
+// loadConfigOptions loads the agent configuration from the system environment.
+// When configFile is not empty, the values from the file are used for the
+// options not set in the environment.
+func loadConfigOptions(configFile string) (*ConfigOptions, error) {
+	if configFile != "" {
+		if err := loadConfigFile(configFile); err != nil {
+			return nil, fmt.Errorf("failed to load config file %s: %w", configFile, err)
+		}
+	}
+
+	opts := ConfigOptions{}
+
+	// Process unprefixed env vars for backward compatibility
+	envconfig.Process("", &opts) // nolint:errcheck
+
+	if err := envconfig.Process("shellhub", &opts); err != nil {
+		return nil, err
+	}
+
+	return &opts, nil
+}
+
+// configFileEnv holds the environment variables exported from the config file,
+// which are replaced when the file is loaded again.
+var configFileEnv = make(map[string]bool)
+
+// loadConfigFile reads a YAML file mapping the configuration keys to their
+// values, e.g. "server_address: https://cloud.shellhub.io", and exports them to
+// the environment unless already set, so the environment takes precedence.
+// Keys are either the environment variable names, with or without the
+// SHELLHUB_ prefix, or the ConfigOptions field names, case insensitive.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	for env := range configFileEnv {
+		os.Unsetenv(env) // nolint:errcheck
+		delete(configFileEnv, env)
+	}
+
+	keys := make(map[string]string)
+
+	t := reflect.TypeOf(ConfigOptions{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("envconfig")
+
+		keys[strings.ToLower(key)] = key
+		keys[strings.ToLower(field.Name)] = key
+	}
+
+	for name, value := range values {
+		key, ok := keys[strings.TrimPrefix(strings.ToLower(name), "shellhub_")]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownConfigKey, name)
+		}
+
+		env := strings.ToUpper(key)
+		if _, ok := os.LookupEnv("SHELLHUB_" + env); ok {
+			continue
+		}
+
+		if _, ok := os.LookupEnv(env); ok {
+			continue
+		}
+
+		if err := os.Setenv("SHELLHUB_"+env, configValue(value)); err != nil {
+			return err
+		}
+
+		configFileEnv["SHELLHUB_"+env] = true
+	}
+
+	return nil
+}
+
+// configValue formats a value from the config file as expected by envconfig,
+// joining lists with commas and maps as comma-separated key:value pairs.
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, configValue(item))
+		}
+
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		items := make([]string, 0, len(v))
+		for key, item := range v {
+			items = append(items, key+":"+configValue(item))
+		}
+
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// normalizeServerAddress parses the server address, returning its canonical
+// form: a http or https URL, the websocket schemes being converted to their
+// HTTP counterparts, without trailing slashes.
+func normalizeServerAddress(address string) (string, error) {
+	address = strings.TrimSpace(address)
+
+	// Without the separator, "host:port" would be parsed as a "host" scheme.
+	if !strings.Contains(address, "://") {
+		return "", fmt.Errorf("%w: missing scheme in %q", ErrInvalidServerAddress, address)
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidServerAddress, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "ws":
+		u.Scheme = "http"
+	case "https", "wss":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("%w: missing host in %q", ErrInvalidServerAddress, address)
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	return u.String(), nil
+}
+
+// normalizeServerAddresses normalizes each address of a comma-separated list of
+// server addresses, returning the list in its canonical form.
+func normalizeServerAddresses(addresses string) (string, error) {
+	list := strings.Split(addresses, ",")
+	for i, address := range list {
+		normalized, err := normalizeServerAddress(address)
+		if err != nil {
+			return "", err
+		}
+
+		list[i] = normalized
+	}
+
+	return strings.Join(list, ","), nil
+}
+
+// checkUserMode checks that the single-user mode is only enabled when running
+// as non-root user, unless explicitly allowed as root, and the multi-user mode
+// only when running as root.
+func checkUserMode(opts *ConfigOptions) error {
+	if os.Geteuid() == 0 && opts.SingleUserPassword != "" && !opts.AllowRootSingleUser {
+		return ErrSingleUserAsRoot
+	}
+
+	if os.Geteuid() != 0 && opts.SingleUserPassword == "" {
+		return ErrMultiUserAsNonRoot
+	}
+
+	return nil
+}
+
+// configCheck is a named validation performed over the agent configuration.
+type configCheck struct {
+	name  string
+	check func(opts *ConfigOptions) error
+}
+
+// privateKeyPassphrase returns the passphrase of the private key, read from the
+// passphrase file, without its trailing newline, when set. Empty means the key
+// is not encrypted.
+func privateKeyPassphrase(opts *ConfigOptions) ([]byte, error) {
+	if opts.PrivateKeyPassphraseFile == "" {
+		return []byte(opts.PrivateKeyPassphrase), nil
+	}
+
+	data, err := os.ReadFile(opts.PrivateKeyPassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// checkPrivateKey checks that the private key is a valid key, decrypted with
+// its passphrase when encrypted, or that it is missing and will be generated.
+func checkPrivateKey(opts *ConfigOptions) error {
+	passphrase, err := privateKeyPassphrase(opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(opts.PrivateKey); os.IsNotExist(err) {
+		if opts.GenerateKeyIfMissing {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, opts.PrivateKey)
+	}
+
+	_, err = keygen.ReadPrivateKey(opts.PrivateKey, passphrase)
+
+	return err
+}
+
+// checkPasswordLockout checks that the password lockout, when enabled, has a
+// window and a cooldown.
+func checkPasswordLockout(opts *ConfigOptions) error {
+	if opts.PasswordLockoutThreshold > 0 && (opts.PasswordLockoutWindow <= 0 || opts.PasswordLockoutCooldown <= 0) {
+		return ErrInvalidLockout
+	}
+
+	return nil
+}
+
+// updatePublicKey returns the public key verifying the agent updates: the
+// configured one, or the one built into the agent. It is nil when there is none.
+func updatePublicKey(opts *ConfigOptions) (ed25519.PublicKey, error) {
+	key := opts.UpdatePublicKey
+	if key == "" {
+		key = UpdatePublicKey
+	}
+
+	if key == "" {
+		return nil, nil
+	}
+
+	return updater.ParsePublicKey(key)
+}
+
+// checkUpdate checks that the updates, when enabled, are downloaded over HTTPS
+// and verified with a valid public key.
+func checkUpdate(opts *ConfigOptions) error {
+	if opts.UpdateURL == "" {
+		return nil
+	}
+
+	if err := updater.CheckURL(opts.UpdateURL); err != nil {
+		return err
+	}
+
+	key, err := updatePublicKey(opts)
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		return updater.ErrMissingPublicKey
+	}
+
+	return nil
+}
+
+// checkExtraPassword checks that the extra password, when set, is hashed with a
+// supported algorithm.
+func checkExtraPassword(opts *ConfigOptions) error {
+	if opts.ExtraPassword != "" && !osauth.IsPasswordHashSupported(opts.ExtraPassword) {
+		return ErrUnsupportedHash
+	}
+
+	return nil
+}
+
+// checkKeepAliveJitter checks that the keep alive jitter is a fraction between 0
+// and 1.
+func checkKeepAliveJitter(opts *ConfigOptions) error {
+	if opts.KeepAliveJitter < 0 || opts.KeepAliveJitter > 1 {
+		return ErrInvalidJitter
+	}
+
+	return nil
+}
+
+// parseSingleUserShell splits the single-user shell into the program path and
+// its arguments, as a shell would.
+func parseSingleUserShell(shell string) ([]string, error) {
+	args, err := shellwords.Parse(shell)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	return args, nil
+}
+
+// checkSingleUserShell checks that the single-user shell, when set, is an
+// executable file.
+func checkSingleUserShell(opts *ConfigOptions) error {
+	if opts.SingleUserShell == "" {
+		return nil
+	}
+
+	args, err := parseSingleUserShell(opts.SingleUserShell)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	return checkExecutable(args[0])
+}
+
+// checkExecutable checks that the file at path is an executable file.
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() || fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%w: %s", ErrNotExecutable, path)
+	}
+
+	return nil
+}
+
+// checkSessionHooks checks that the session hooks, when set, are executable
+// files.
+func checkSessionHooks(opts *ConfigOptions) error {
+	for _, hook := range []string{opts.OnSessionStart, opts.OnSessionEnd} {
+		if hook == "" {
+			continue
+		}
+
+		if err := checkExecutable(hook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkHeartbeat checks that the heartbeat, when enabled, has a timeout and
+// a failure threshold.
+func checkHeartbeat(opts *ConfigOptions) error {
+	if opts.HeartbeatInterval > 0 && (opts.HeartbeatTimeout <= 0 || opts.HeartbeatMaxFailures <= 0) {
+		return ErrInvalidHeartbeat
+	}
+
+	return nil
+}
+
+// tcpKeepAlive returns the TCP keep-alive settings of the connections to the
+// server.
+func tcpKeepAlive(opts *ConfigOptions) client.KeepAlive {
+	return client.KeepAlive{
+		Idle:     time.Duration(opts.TCPKeepAliveIdle) * time.Second,
+		Interval: time.Duration(opts.TCPKeepAliveInterval) * time.Second,
+		Count:    opts.TCPKeepAliveCount,
+	}
+}
+
+// checkTCPKeepAlive checks the TCP keep-alive settings, when it is enabled.
+func checkTCPKeepAlive(opts *ConfigOptions) error {
+	if !opts.TCPKeepAlive {
+		return nil
+	}
+
+	return tcpKeepAlive(opts).Check()
+}
+
+// checkSFTPRoot checks that the SFTP root, when set, is an existing directory.
+func checkSFTPRoot(opts *ConfigOptions) error {
+	if opts.SFTPRoot == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(opts.SFTPRoot)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return ErrSFTPRootNotDir
+	}
+
+	return nil
+}
+
+// checkSFTPUser checks that the SFTP user, when set, is an existing user and
+// group, which the agent can switch to only when running as root.
+func checkSFTPUser(opts *ConfigOptions) error {
+	if opts.SFTPUser == "" {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return ErrSFTPUserAsNonRoot
+	}
+
+	_, err := server.LookupCredential(opts.SFTPUser)
+
+	return err
+}
+
+// sessionLimits returns the limits applied to the processes spawned for the
+// sessions.
+func sessionLimits(opts *ConfigOptions) server.SessionLimits {
+	return server.SessionLimits{
+		Nice:      opts.SessionNice,
+		Cgroup:    opts.SessionCgroup,
+		CPUMax:    opts.SessionCPUMax,
+		MemoryMax: int64(opts.SessionMemoryMax) * 1024 * 1024,
+	}
+}
+
+// sshAlgorithms returns the algorithms the SSH server negotiates.
+func sshAlgorithms(opts *ConfigOptions) server.Algorithms {
+	return server.Algorithms{
+		KeyExchanges: opts.SSHKexAlgorithms,
+		Ciphers:      opts.SSHCiphers,
+		MACs:         opts.SSHMACs,
+	}
+}
+
+// configChecks lists the validations performed by the config validate command.
+var configChecks = []configCheck{
+	{
+		name: "server address",
+		check: func(opts *ConfigOptions) error {
+			_, err := normalizeServerAddresses(opts.ServerAddress)
+
+			return err
+		},
+	},
+	{
+		name:  "private key",
+		check: checkPrivateKey,
+	},
+	{
+		name: "tenant id",
+		check: func(opts *ConfigOptions) error {
+			if opts.TenantID == "" {
+				return ErrEmptyTenantID
+			}
+
+			return nil
+		},
+	},
+	{
+		name:  "user mode",
+		check: checkUserMode,
+	},
+	{
+		name:  "extra password",
+		check: checkExtraPassword,
+	},
+	{
+		name: "session limits",
+		check: func(opts *ConfigOptions) error {
+			return server.CheckSessionLimits(sessionLimits(opts))
+		},
+	},
+	{
+		name:  "password lockout",
+		check: checkPasswordLockout,
+	},
+	{
+		name:  "update",
+		check: checkUpdate,
+	},
+	{
+		name:  "keep alive jitter",
+		check: checkKeepAliveJitter,
+	},
+	{
+		name: "tls certificates",
+		check: func(opts *ConfigOptions) error {
+			_, err := newTLSConfig(opts)
+
+			return err
+		},
+	},
+	{
+		name: "proxy",
+		check: func(opts *ConfigOptions) error {
+			_, err := proxyFunc(opts)
+
+			return err
+		},
+	},
+	{
+		name: "source address",
+		check: func(opts *ConfigOptions) error {
+			_, err := sourceAddr(opts)
+
+			return err
+		},
+	},
+	{
+		name: "dns server",
+		check: func(opts *ConfigOptions) error {
+			_, err := dnsResolver(opts)
+
+			return err
+		},
+	},
+	{
+		name: "host overrides",
+		check: func(opts *ConfigOptions) error {
+			_, err := hostOverrides(opts)
+
+			return err
+		},
+	},
+	{
+		name: "extra headers",
+		check: func(opts *ConfigOptions) error {
+			_, err := extraHeaders(opts)
+
+			return err
+		},
+	},
+	{
+		name: "http tunnel address",
+		check: func(opts *ConfigOptions) error {
+			_, _, err := forwardedHTTPAddress(opts)
+
+			return err
+		},
+	},
+	{
+		name: "http tunnel services",
+		check: func(opts *ConfigOptions) error {
+			_, err := httpServices(opts)
+
+			return err
+		},
+	},
+	{
+		name:  "single-user shell",
+		check: checkSingleUserShell,
+	},
+	{
+		name:  "session hooks",
+		check: checkSessionHooks,
+	},
+	{
+		name: "login banner",
+		check: func(opts *ConfigOptions) error {
+			_, err := loadLoginBanner(opts)
+
+			return err
+		},
+	},
+	{
+		name:  "connection hooks",
+		check: checkConnectionHooks,
+	},
+	{
+		name:  "heartbeat",
+		check: checkHeartbeat,
+	},
+	{
+		name:  "watchdog",
+		check: checkWatchdog,
+	},
+	{
+		name:  "tcp keep-alive",
+		check: checkTCPKeepAlive,
+	},
+	{
+		name:  "sftp root",
+		check: checkSFTPRoot,
+	},
+	{
+		name: "ssh algorithms",
+		check: func(opts *ConfigOptions) error {
+			return server.CheckAlgorithms(sshAlgorithms(opts))
+		},
+	},
+	{
+		name:  "sftp user",
+		check: checkSFTPUser,
+	},
+	{
+		name: "sftp tuning",
+		check: func(opts *ConfigOptions) error {
+			return sftp.CheckTuning(opts.SFTPMaxInFlight, opts.SFTPPacketSize)
+		},
+	},
+	{
+		name: "port forwarding allow list",
+		check: func(opts *ConfigOptions) error {
+			_, err := server.ParseForwardRules(opts.PortForwardingAllowList)
+
+			return err
+		},
+	},
+	{
+		name: "client cidrs",
+		check: func(opts *ConfigOptions) error {
+			_, err := newClientFilter(opts.AllowedClientCIDRs, opts.DeniedClientCIDRs)
+
+			return err
+		},
+	},
+}
+
+// validateConfig runs the configuration checks, writing a report to w. It
+// returns false when any check has failed.
+func validateConfig(w io.Writer, opts *ConfigOptions) bool {
+	ok := true
+
+	for _, c := range configChecks {
+		if err := c.check(opts); err != nil {
+			ok = false
+
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", c.name, err)
+
+			if hint := initErrorHint(err); hint != "" {
+				fmt.Fprintf(w, "       hint: %s\n", hint)
+			}
+
+			continue
+		}
+
+		fmt.Fprintf(w, "[PASS] %s\n", c.name)
+	}
+
+	return ok
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+)
+
+func TestNormalizeServerAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		err     error
+	}{
+		{name: "canonical", address: "https://cloud.shellhub.io", want: "https://cloud.shellhub.io"},
+		{name: "trailing slash", address: "http://localhost/", want: "http://localhost"},
+		{name: "trailing slashes", address: "http://localhost:8080///", want: "http://localhost:8080"},
+		{name: "path", address: "https://example.com/shellhub/", want: "https://example.com/shellhub"},
+		{name: "surrounding spaces", address: "  https://example.com  ", want: "https://example.com"},
+		{name: "upper case", address: "HTTPS://Example.COM", want: "https://example.com"},
+		{name: "websocket", address: "ws://example.com", want: "http://example.com"},
+		{name: "secure websocket", address: "wss://example.com/", want: "https://example.com"},
+		{name: "schemeless", address: "example.com", err: ErrInvalidServerAddress},
+		{name: "schemeless with port", address: "example.com:443", err: ErrInvalidServerAddress},
+		{name: "unsupported scheme", address: "ftp://example.com", err: ErrUnsupportedScheme},
+		{name: "missing host", address: "https:///path", err: ErrInvalidServerAddress},
+		{name: "empty", address: "", err: ErrInvalidServerAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeServerAddress(tt.address)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("normalizeServerAddress(%q) error = %v, want %v", tt.address, err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("normalizeServerAddress(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeServerAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses string
+		want      string
+		err       error
+	}{
+		{name: "single", addresses: "https://example.com/", want: "https://example.com"},
+		{name: "list", addresses: "https://a.example.com/, wss://b.example.com", want: "https://a.example.com,https://b.example.com"},
+		{name: "schemeless entry", addresses: "https://a.example.com,b.example.com", err: ErrInvalidServerAddress},
+		{name: "empty entry", addresses: "https://a.example.com,,https://b.example.com", err: ErrInvalidServerAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeServerAddresses(tt.addresses)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("normalizeServerAddresses(%q) error = %v, want %v", tt.addresses, err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("normalizeServerAddresses(%q) = %q, want %q", tt.addresses, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSFTPUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the SFTP user can only be set when running as root")
+	}
+
+	tests := []struct {
+		name string
+		user string
+		err  bool
+	}{
+		{name: "unset", user: ""},
+		{name: "name", user: "root"},
+		{name: "name and group", user: "root:root"},
+		{name: "ids", user: "0:0"},
+		{name: "unknown user", user: "shellhub-missing-user", err: true},
+		{name: "unknown group", user: "root:shellhub-missing-group", err: true},
+		{name: "missing name", user: ":root", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSFTPUser(&ConfigOptions{SFTPUser: tt.user})
+			if (err != nil) != tt.err {
+				t.Errorf("checkSFTPUser(%q) error = %v, want error %v", tt.user, err, tt.err)
+			}
+		})
+	}
+}
+
+// newTestConfigOptions returns the configuration loaded from the environment,
+// with its defaults, for a multi-user agent using a generated private key.
+func newTestConfigOptions(t *testing.T) *ConfigOptions {
+	t.Helper()
+
+	privateKey := filepath.Join(t.TempDir(), "shellhub.key")
+	if err := keygen.GeneratePrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SHELLHUB_SERVER_ADDRESS", "https://cloud.shellhub.io")
+	t.Setenv("SHELLHUB_PRIVATE_KEY", privateKey)
+	t.Setenv("SHELLHUB_TENANT_ID", "tenant")
+
+	opts, err := loadConfigOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return opts
+}
+
+func TestValidateConfig(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the multi-user mode requires running as root")
+	}
+
+	opts := newTestConfigOptions(t)
+
+	var out bytes.Buffer
+	if !validateConfig(&out, opts) {
+		t.Fatalf("validateConfig() = false, want true, report:\n%s", out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(configChecks) {
+		t.Errorf("report has %d lines, want one per check, %d", len(lines), len(configChecks))
+	}
+
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "[PASS] ") {
+			t.Errorf("report line %q, want a passed check", line)
+		}
+	}
+
+	opts.ServerAddress = "cloud.shellhub.io"
+	opts.TenantID = ""
+	opts.PrivateKey = filepath.Join(t.TempDir(), "missing.key")
+	opts.GenerateKeyIfMissing = false
+
+	out.Reset()
+	if validateConfig(&out, opts) {
+		t.Fatalf("validateConfig() = true with an invalid configuration, report:\n%s", out.String())
+	}
+
+	for _, want := range []string{
+		"[FAIL] server address: ",
+		"[FAIL] tenant id: " + ErrEmptyTenantID.Error(),
+		"[FAIL] private key: " + ErrKeyNotFound.Error(),
+		"       hint: " + initErrorHint(ErrKeyNotFound) + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report misses %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCheckUserMode(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the test runs the checks as root")
+	}
+
+	tests := []struct {
+		name string
+		opts ConfigOptions
+		err  error
+	}{
+		{name: "multi-user", opts: ConfigOptions{}},
+		{name: "single-user", opts: ConfigOptions{SingleUserPassword: "hash"}, err: ErrSingleUserAsRoot},
+		{name: "single-user allowed as root", opts: ConfigOptions{SingleUserPassword: "hash", AllowRootSingleUser: true}},
+		{name: "multi-user allowed as root", opts: ConfigOptions{AllowRootSingleUser: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkUserMode(&tt.opts); err != tt.err {
+				t.Errorf("checkUserMode() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAllowRootSingleUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the test runs the checks as root")
+	}
+
+	t.Setenv("SHELLHUB_SIMPLE_USER_PASSWORD", "hash")
+
+	opts := newTestConfigOptions(t)
+	if opts.AllowRootSingleUser {
+		t.Fatal("AllowRootSingleUser = true, want false by default")
+	}
+
+	if err := checkUserMode(opts); err != ErrSingleUserAsRoot {
+		t.Errorf("checkUserMode() error = %v, want %v", err, ErrSingleUserAsRoot)
+	}
+
+	t.Setenv("SHELLHUB_ALLOW_ROOT_SINGLE_USER", "true")
+
+	opts = newTestConfigOptions(t)
+	if !opts.AllowRootSingleUser {
+		t.Fatal("AllowRootSingleUser = false, want true from SHELLHUB_ALLOW_ROOT_SINGLE_USER")
+	}
+
+	if err := checkUserMode(opts); err != nil {
+		t.Errorf("checkUserMode() error = %v, want nil", err)
+	}
+}
+
+// writeTestConfigFile writes the YAML configuration file, returning its path.
+// The environment variables exported from it are removed once the test is
+// over.
+func writeTestConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		for env := range configFileEnv {
+			os.Unsetenv(env) // nolint:errcheck
+			delete(configFileEnv, env)
+		}
+	})
+
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	privateKey := filepath.Join(t.TempDir(), "shellhub.key")
+
+	path := writeTestConfigFile(t, `
+server_address: https://file.example.com
+SHELLHUB_TENANT_ID: file-tenant
+PrivateKey: `+privateKey+`
+keepalive_interval: 45
+allowed_commands:
+  - uptime
+  - df -h
+`)
+
+	// The environment takes precedence over the file.
+	t.Setenv("SHELLHUB_TENANT_ID", "env-tenant")
+
+	opts, err := loadConfigOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.ServerAddress != "https://file.example.com" {
+		t.Errorf("ServerAddress = %q, want the file value", opts.ServerAddress)
+	}
+
+	if opts.TenantID != "env-tenant" {
+		t.Errorf("TenantID = %q, want the environment value", opts.TenantID)
+	}
+
+	if opts.PrivateKey != privateKey {
+		t.Errorf("PrivateKey = %q, want %q, set by field name", opts.PrivateKey, privateKey)
+	}
+
+	if opts.KeepAliveInterval != 45 {
+		t.Errorf("KeepAliveInterval = %d, want 45", opts.KeepAliveInterval)
+	}
+
+	if len(opts.AllowedCommands) != 2 || opts.AllowedCommands[0] != "uptime" || opts.AllowedCommands[1] != "df -h" {
+		t.Errorf("AllowedCommands = %q, want the file list", opts.AllowedCommands)
+	}
+}
+
+func TestLoadConfigFileReload(t *testing.T) {
+	first := writeTestConfigFile(t, "server_address: https://first.example.com\ntenant_id: tenant\nprivate_key: /tmp/key\nkeepalive_interval: 45\n")
+	second := writeTestConfigFile(t, "server_address: https://second.example.com\ntenant_id: tenant\nprivate_key: /tmp/key\n")
+
+	if _, err := loadConfigOptions(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values of the previous file are dropped once it is loaded again.
+	opts, err := loadConfigOptions(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.ServerAddress != "https://second.example.com" {
+		t.Errorf("ServerAddress = %q, want the second file value", opts.ServerAddress)
+	}
+
+	if opts.KeepAliveInterval != 30 {
+		t.Errorf("KeepAliveInterval = %d, want the default 30", opts.KeepAliveInterval)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     error
+	}{
+		{name: "unknown key", content: "server_adress: https://example.com\n", err: ErrUnknownConfigKey},
+		{name: "invalid yaml", content: "server_address: [\n"},
+		{name: "not a mapping", content: "- server_address\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfigFile(writeTestConfigFile(t, tt.content))
+			if err == nil {
+				t.Fatal("loadConfigFile() succeeded, want an error")
+			}
+
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("loadConfigFile() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("loadConfigFile() error = %v for a missing file, want not exist", err)
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "nil", value: nil, want: ""},
+		{name: "string", value: "value", want: "value"},
+		{name: "int", value: 42, want: "42"},
+		{name: "bool", value: true, want: "true"},
+		{name: "list", value: []interface{}{"a", 1, true}, want: "a,1,true"},
+		{name: "map", value: map[string]interface{}{"key": "value"}, want: "key:value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configValue(tt.value); got != tt.want {
+				t.Errorf("configValue(%v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckExtraPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{name: "unset", password: ""},
+		{name: "sha512", password: "$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."},
+		{name: "bsd1", password: "$1$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1"},
+		{name: "plain text", password: "secret", err: ErrUnsupportedHash},
+		{name: "unknown prefix", password: "$9$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1", err: ErrUnsupportedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkExtraPassword(&ConfigOptions{ExtraPassword: tt.password}); err != tt.err {
+				t.Errorf("checkExtraPassword(%q) = %v, want %v", tt.password, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckKeepAliveJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter float64
+		err    error
+	}{
+		{name: "none", jitter: 0},
+		{name: "fraction", jitter: 0.2},
+		{name: "one", jitter: 1},
+		{name: "negative", jitter: -0.1, err: ErrInvalidJitter},
+		{name: "above one", jitter: 1.5, err: ErrInvalidJitter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkKeepAliveJitter(&ConfigOptions{KeepAliveJitter: tt.jitter}); err != tt.err {
+				t.Errorf("checkKeepAliveJitter(%v) = %v, want %v", tt.jitter, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckHeartbeat(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    int
+		timeout     int
+		maxFailures int
+		err         error
+	}{
+		{name: "enabled", interval: 30, timeout: 15, maxFailures: 1},
+		{name: "disabled", interval: 0, timeout: 0, maxFailures: 0},
+		{name: "no timeout", interval: 30, timeout: 0, maxFailures: 1, err: ErrInvalidHeartbeat},
+		{name: "negative timeout", interval: 30, timeout: -1, maxFailures: 1, err: ErrInvalidHeartbeat},
+		{name: "many failures", interval: 30, timeout: 15, maxFailures: 3},
+		{name: "no failures", interval: 30, timeout: 15, maxFailures: 0, err: ErrInvalidHeartbeat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkHeartbeat(&ConfigOptions{
+				HeartbeatInterval:    tt.interval,
+				HeartbeatTimeout:     tt.timeout,
+				HeartbeatMaxFailures: tt.maxFailures,
+			})
+			if err != tt.err {
+				t.Errorf("checkHeartbeat() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseSingleUserShell(t *testing.T) {
+	tests := []struct {
+		name  string
+		shell string
+		want  []string
+		err   bool
+	}{
+		{name: "empty", shell: "", want: nil},
+		{name: "spaces", shell: "  ", want: nil},
+		{name: "program", shell: "/usr/bin/menu", want: []string{"/usr/bin/menu"}},
+		{name: "arguments", shell: "/usr/bin/menu --restricted -v", want: []string{"/usr/bin/menu", "--restricted", "-v"}},
+		{name: "quoted argument", shell: `/usr/bin/menu --title "Device menu"`, want: []string{"/usr/bin/menu", "--title", "Device menu"}},
+		{name: "unbalanced quote", shell: `/usr/bin/menu "title`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseSingleUserShell(tt.shell)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseSingleUserShell(%q) error = %v, want error %v", tt.shell, err, tt.err)
+			}
+
+			if strings.Join(args, "|") != strings.Join(tt.want, "|") || len(args) != len(tt.want) {
+				t.Errorf("parseSingleUserShell(%q) = %q, want %q", tt.shell, args, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSingleUserShell(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "menu")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	notExecutable := filepath.Join(dir, "menu.txt")
+	if err := os.WriteFile(notExecutable, []byte("menu"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		shell string
+		err   error
+		fail  bool
+	}{
+		{name: "unset", shell: ""},
+		{name: "executable", shell: script + " --restricted"},
+		{name: "not executable", shell: notExecutable, err: ErrNotExecutable},
+		{name: "directory", shell: dir, err: ErrNotExecutable},
+		{name: "missing", shell: filepath.Join(dir, "missing"), fail: true},
+		{name: "unbalanced quote", shell: script + ` "title`, fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSingleUserShell(&ConfigOptions{SingleUserShell: tt.shell})
+
+			switch {
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Errorf("checkSingleUserShell(%q) = %v, want %v", tt.shell, err, tt.err)
+			case tt.fail && err == nil:
+				t.Errorf("checkSingleUserShell(%q) = nil, want an error", tt.shell)
+			case tt.err == nil && !tt.fail && err != nil:
+				t.Errorf("checkSingleUserShell(%q) = %v, want nil", tt.shell, err)
+			}
+		})
+	}
+}
+
+func TestCheckPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.key")
+	if err := keygen.GeneratePrivateKey(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.key")
+	if err := os.WriteFile(invalid, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := filepath.Join(dir, "encrypted.key")
+	if err := keygen.GenerateEncryptedPrivateKey(encrypted, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(dir, "missing.key")
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		generate   bool
+		err        error
+	}{
+		{name: "valid", key: valid},
+		{name: "missing and generated", key: missing, generate: true},
+		{name: "missing", key: missing, err: ErrKeyNotFound},
+		{name: "invalid", key: invalid, err: keygen.ErrPemDecode},
+		{name: "encrypted", key: encrypted, passphrase: "passphrase"},
+		{name: "encrypted without passphrase", key: encrypted, err: keygen.ErrPassphraseRequired},
+		{name: "encrypted with wrong passphrase", key: encrypted, passphrase: "wrong", err: keygen.ErrWrongPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPrivateKey(&ConfigOptions{PrivateKey: tt.key, PrivateKeyPassphrase: tt.passphrase, GenerateKeyIfMissing: tt.generate})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("checkPrivateKey() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyPassphrase(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(file, []byte("from file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts *ConfigOptions
+		want string
+		err  error
+	}{
+		{name: "none", opts: &ConfigOptions{}},
+		{name: "option", opts: &ConfigOptions{PrivateKeyPassphrase: "from option"}, want: "from option"},
+		{name: "file without line ending", opts: &ConfigOptions{PrivateKeyPassphrase: "from option", PrivateKeyPassphraseFile: file}, want: "from file"},
+		{name: "missing file", opts: &ConfigOptions{PrivateKeyPassphraseFile: filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := privateKeyPassphrase(tt.opts)
+			if !errors.Is(err, tt.err) || string(got) != tt.want {
+				t.Errorf("privateKeyPassphrase() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Connection state transitions notified to the connection hooks.
+const (
+	connectEvent    = "connect"
+	disconnectEvent = "disconnect"
+)
+
+// connectionHookQueueSize is the number of connection hooks pending to run
+// before the new ones are dropped.
+const connectionHookQueueSize = 16
+
+// connectionHook is a connection hook run, with its environment.
+type connectionHook struct {
+	event string
+	path  string
+	env   []string
+}
+
+// checkConnectionHooks checks that the connection hooks, when set, are
+// executable files.
+func checkConnectionHooks(opts *ConfigOptions) error {
+	for _, hook := range []string{opts.OnConnect, opts.OnDisconnect} {
+		if hook == "" {
+			continue
+		}
+
+		if err := checkExecutable(hook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// connectionHookEnv returns the environment passing the connection metadata to
+// the connection hooks.
+func (a *Agent) connectionHookEnv(event string) []string {
+	a.mu.RLock()
+	serverAddress, authData, serverInfo := a.serverAddress, a.authData, a.serverInfo
+	a.mu.RUnlock()
+
+	env := []string{
+		"SHELLHUB_CONNECTION_EVENT=" + event,
+		"SHELLHUB_SERVER_ADDRESS=" + serverAddress.String(),
+		"SHELLHUB_TENANT_ID=" + a.opts.TenantID,
+	}
+
+	if authData != nil {
+		env = append(env,
+			"SHELLHUB_DEVICE_NAME="+authData.Name,
+			"SHELLHUB_NAMESPACE="+authData.Namespace,
+		)
+	}
+
+	if authData != nil && serverInfo != nil {
+		env = append(env,
+			"SHELLHUB_SSHID="+formatSSHID(authData.Namespace, authData.Name, serverInfo.Endpoints.SSH),
+			"SHELLHUB_API_ENDPOINT="+serverInfo.Endpoints.API,
+			"SHELLHUB_SSH_ENDPOINT="+serverInfo.Endpoints.SSH,
+		)
+	}
+
+	return env
+}
+
+// connectionHook queues the hook of the connection state transition, when set.
+// The hooks run in background, one at a time and in order, so they never
+// delay the connection. When too many hooks are pending, the hook is dropped.
+func (a *Agent) connectionHook(event string) {
+	path := a.opts.OnConnect
+	if event == disconnectEvent {
+		path = a.opts.OnDisconnect
+	}
+
+	if path == "" {
+		return
+	}
+
+	a.connectionHooksOnce.Do(func() {
+		a.connectionHooks = make(chan connectionHook, connectionHookQueueSize)
+
+		go func() {
+			for hook := range a.connectionHooks {
+				a.runConnectionHook(hook)
+			}
+		}()
+	})
+
+	select {
+	case a.connectionHooks <- connectionHook{event: event, path: path, env: a.connectionHookEnv(event)}:
+	default:
+		a.logger().WithFields(log.Fields{
+			"event": event,
+			"hook":  path,
+		}).Warn("Too many connection hooks pending, dropping it")
+	}
+}
+
+// runConnectionHook runs the connection hook, killing it after the hook
+// timeout. A failing hook is only logged, never affecting the connection.
+func (a *Agent) runConnectionHook(hook connectionHook) {
+	ctx := context.Background()
+	if a.opts.SessionHookTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(a.opts.SessionHookTimeout)*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, hook.path) // nolint:gosec
+	cmd.Env = append(os.Environ(), hook.env...)
+
+	logger := a.logger().WithFields(log.Fields{
+		"event": hook.event,
+		"hook":  hook.path,
+	})
+
+	// The output is discarded, as waiting for it would block on the children
+	// the hook may leave running in background.
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		logger.WithError(err).Warn("Connection hook failed")
+
+		return
+	}
+
+	logger.Debug("Connection hook run")
+}
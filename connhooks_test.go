@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// writeTestConnectionHook writes an executable shell script running the
+// commands to dir, returning its path.
+func writeTestConnectionHook(t *testing.T, dir, name, commands string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+commands+"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// waitForFile waits for the file to have the content, failing the test after a
+// while.
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && string(data) == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%s = %q, want %q", filepath.Base(path), data, want)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent use, capturing the logs written
+// in background.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// captureLogSync is captureLog for the logs written in background.
+func captureLogSync(t *testing.T) *syncBuffer {
+	t.Helper()
+
+	captureLog(t)
+
+	var buf syncBuffer
+	log.SetOutput(&buf)
+
+	return &buf
+}
+
+func TestCheckConnectionHooks(t *testing.T) {
+	dir := t.TempDir()
+
+	hook := writeTestConnectionHook(t, dir, "hook", "true")
+
+	notExecutable := filepath.Join(dir, "hook.txt")
+	if err := os.WriteFile(notExecutable, []byte("true"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts ConfigOptions
+		err  error
+	}{
+		{name: "unset"},
+		{name: "executable", opts: ConfigOptions{OnConnect: hook, OnDisconnect: hook}},
+		{name: "not executable", opts: ConfigOptions{OnDisconnect: notExecutable}, err: ErrNotExecutable},
+		{name: "missing", opts: ConfigOptions{OnConnect: filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkConnectionHooks(&tt.opts); !errors.Is(err, tt.err) {
+				t.Errorf("checkConnectionHooks() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConnectionHookEnv(t *testing.T) {
+	srv := newFakeServer(t, "first")
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := agent.connectionHookEnv(disconnectEvent)
+
+	want := []string{
+		"SHELLHUB_CONNECTION_EVENT=disconnect",
+		"SHELLHUB_SERVER_ADDRESS=" + srv.URL,
+		"SHELLHUB_TENANT_ID=tenant",
+	}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("connectionHookEnv() = %q before connecting, want %q", env, want)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+
+	if err := agent.connectServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	env = agent.connectionHookEnv(connectEvent)
+
+	want = []string{
+		"SHELLHUB_API_ENDPOINT=first:80",
+		"SHELLHUB_CONNECTION_EVENT=connect",
+		"SHELLHUB_DEVICE_NAME=device",
+		"SHELLHUB_NAMESPACE=first",
+		"SHELLHUB_SERVER_ADDRESS=" + srv.URL,
+		"SHELLHUB_SSHID=first.device@first",
+		"SHELLHUB_SSH_ENDPOINT=first:22",
+		"SHELLHUB_TENANT_ID=tenant",
+	}
+
+	sort.Strings(env)
+
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("connectionHookEnv() = %q once connected, want %q", env, want)
+	}
+}
+
+func TestConnectionHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "events")
+
+	hook := writeTestConnectionHook(t, dir, "hook", `echo "$SHELLHUB_CONNECTION_EVENT $SHELLHUB_TENANT_ID" >> `+out)
+
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress: "http://localhost",
+		TenantID:      "tenant",
+		OnConnect:     hook,
+		OnDisconnect:  hook,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hooks run in order.
+	agent.connectionHook(connectEvent)
+	agent.connectionHook(disconnectEvent)
+	agent.connectionHook(connectEvent)
+
+	waitForFile(t, out, "connect tenant\ndisconnect tenant\nconnect tenant\n")
+}
+
+func TestConnectionHookUnset(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.connectionHook(connectEvent)
+
+	if agent.connectionHooks != nil {
+		t.Error("connection hooks started without any hook set")
+	}
+}
+
+func TestConnectionHookTimeout(t *testing.T) {
+	buf := captureLogSync(t)
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant", SessionHookTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := writeTestConnectionHook(t, t.TempDir(), "hook", "exec sleep 30")
+
+	start := time.Now()
+
+	agent.runConnectionHook(connectionHook{event: connectEvent, path: hook})
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("hook ran for %v, want it killed after the timeout", elapsed)
+	}
+
+	if !strings.Contains(buf.String(), "Connection hook failed") || !strings.Contains(buf.String(), "context deadline exceeded") {
+		t.Errorf("log = %q, want the hook timeout logged", buf.String())
+	}
+}
+
+func TestConnectionHookQueueFull(t *testing.T) {
+	buf := captureLogSync(t)
+
+	dir := t.TempDir()
+	started, release, runs := filepath.Join(dir, "started"), filepath.Join(dir, "release"), filepath.Join(dir, "runs")
+
+	// The hook blocks until released, so the queue fills up.
+	hook := writeTestConnectionHook(t, dir, "hook", "touch "+started+"; while [ ! -e "+release+" ]; do sleep 0.01; done; echo >> "+runs)
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant", OnConnect: hook})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.connectionHook(connectEvent)
+	waitForFile(t, started, "")
+
+	// The running hook is out of the queue, which holds the next ones until
+	// it is full.
+	for i := 0; i < connectionHookQueueSize+1; i++ {
+		agent.connectionHook(connectEvent)
+	}
+
+	if got := strings.Count(buf.String(), "Too many connection hooks pending, dropping it"); got != 1 {
+		t.Errorf("dropped hooks = %d, want 1 once the queue is full:\n%s", got, buf.String())
+	}
+
+	if err := os.WriteFile(release, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForFile(t, runs, strings.Repeat("\n", connectionHookQueueSize+1))
+}
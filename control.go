@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/control"
+	"github.com/brycedjohnson/shellhub-agent/server"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrControlSocketDisabled = errors.New("control socket is disabled, set SHELLHUB_CONTROL_SOCKET or use the --socket flag")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrMissingSessionID      = errors.New("missing session id")
+	ErrInvalidMaintenance    = errors.New("maintenance mode must be on or off")
+)
+
+// agentStatus is the result of the status control command.
+type agentStatus struct {
+	Version       string    `json:"version"`
+	ServerAddress string    `json:"server_address"`
+	Authorized    bool      `json:"authorized"`
+	Connected     bool      `json:"connected"`
+	Sessions      int       `json:"sessions"`
+	Maintenance   bool      `json:"maintenance"`
+	StartedAt     time.Time `json:"started_at"`
+	// Uptime is the time since the agent started, in seconds.
+	Uptime int64 `json:"uptime"`
+	// LastAuthorizedAt is the time of the last successful authorization,
+	// omitted when there was none.
+	LastAuthorizedAt *time.Time `json:"last_authorized_at,omitempty"`
+	// Reconnects is the number of connections established to the server
+	// after the first one.
+	Reconnects int64 `json:"reconnects"`
+	// RTT summarizes the round-trip times to the server measured by the
+	// heartbeats, omitted when none was measured.
+	RTT         *rttSummary `json:"rtt,omitempty"`
+	Transferred struct {
+		SessionsReceived int64 `json:"sessions_received"`
+		SessionsSent     int64 `json:"sessions_sent"`
+		HTTPReceived     int64 `json:"http_received"`
+		HTTPSent         int64 `json:"http_sent"`
+	} `json:"transferred_bytes"`
+}
+
+// newControlServer creates the control server handling the commands sent to
+// the running agent:
+//
+//	sessions     lists the active sessions
+//	close <id>   closes the session identified by id
+//	status       shows the agent connection state
+//	reload       reloads the configuration options that can be changed at runtime
+//	maintenance [on|off]
+//	             shows, enables or disables the maintenance mode
+func newControlServer(agent *Agent, serv *server.Server, proxy *httpProxy, configFile string) *control.Server {
+	c := control.NewServer()
+
+	c.Handle("sessions", func(args []string) (interface{}, error) {
+		return serv.ListSessions(), nil
+	})
+
+	c.Handle("close", func(args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, ErrMissingSessionID
+		}
+
+		if _, ok := serv.GetSession(args[0]); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, args[0])
+		}
+
+		serv.CloseSession(args[0])
+
+		log.WithField("id", args[0]).Info("Session closed through the control socket")
+
+		return args[0], nil
+	})
+
+	c.Handle("status", func(args []string) (interface{}, error) {
+		return newAgentStatus(agent, serv, proxy), nil
+	})
+
+	c.Handle("reload", func(args []string) (interface{}, error) {
+		if err := agent.reload(configFile, serv); err != nil {
+			return nil, err
+		}
+
+		return agent.opts.LogLevel, nil
+	})
+
+	c.Handle("maintenance", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return serv.Maintenance(), nil
+		}
+
+		switch args[0] {
+		case "on":
+			setMaintenance(serv, true)
+		case "off":
+			setMaintenance(serv, false)
+		default:
+			return nil, ErrInvalidMaintenance
+		}
+
+		return serv.Maintenance(), nil
+	})
+
+	return c
+}
+
+// newAgentStatus returns the current state of the running agent.
+func newAgentStatus(agent *Agent, serv *server.Server, proxy *httpProxy) *agentStatus {
+	status := &agentStatus{
+		Version:       AgentVersion,
+		ServerAddress: agent.currentAddress().String(),
+		Authorized:    agent.health.isAuthorized(),
+		Connected:     agent.health.isConnected(),
+		Sessions:      len(serv.ListSessionIDs()),
+		Maintenance:   serv.Maintenance(),
+		StartedAt:     agent.startedAt,
+		Uptime:        int64(clock.Now().Sub(agent.startedAt).Seconds()),
+		Reconnects:    agent.health.reconnects(),
+	}
+
+	if at := agent.health.lastAuthorizedAt(); !at.IsZero() {
+		status.LastAuthorizedAt = &at
+	}
+
+	if rtt := agent.rtt.summary(); rtt.Samples > 0 {
+		status.RTT = &rtt
+	}
+
+	status.Transferred.SessionsReceived, status.Transferred.SessionsSent = serv.TransferredBytes()
+	status.Transferred.HTTPReceived, status.Transferred.HTTPSent = proxy.transferredBytes()
+
+	return status
+}
+
+// setMaintenance enables or disables the maintenance mode of the server.
+func setMaintenance(serv *server.Server, enabled bool) {
+	serv.SetMaintenance(enabled)
+
+	if enabled {
+		log.Info("Maintenance mode enabled, refusing new sessions")
+
+		return
+	}
+
+	log.Info("Maintenance mode disabled, accepting new sessions")
+}
+
+// toggleMaintenanceOnSignal toggles the maintenance mode whenever the agent
+// receives SIGUSR1.
+func toggleMaintenanceOnSignal(serv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for range signals {
+		setMaintenance(serv, !serv.Maintenance())
+	}
+}
+
+// serveControl serves the control commands on the unix socket at path, in
+// background. The returned listener must be closed to stop serving.
+func serveControl(path string, c *control.Server) (net.Listener, error) {
+	listener, err := control.Listen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := c.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.WithError(err).WithField("path", path).Error("Failed to serve the control socket")
+		}
+	}()
+
+	return listener, nil
+}
+
+// controlSocketPath returns the path of the control socket, from the flag when
+// set or from the agent configuration.
+func controlSocketPath(flag, configFile string) (string, error) {
+	if flag != "" {
+		return flag, nil
+	}
+
+	opts, err := loadConfigOptions(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.ControlSocket == "" {
+		return "", ErrControlSocketDisabled
+	}
+
+	return opts.ControlSocket, nil
+}
+
+// printSessions writes the sessions returned by the sessions command to w as a
+// table.
+func printSessions(w io.Writer, result json.RawMessage) error {
+	var sessions []server.SessionInfo
+	if err := json.Unmarshal(result, &sessions); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTARTED\tDURATION\tREMOTE\tRECEIVED\tSENT")
+
+	for _, session := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\n",
+			session.ID,
+			session.StartedAt.Format(time.RFC3339),
+			time.Since(session.StartedAt).Round(time.Second),
+			session.RemoteAddr,
+			session.BytesReceived,
+			session.BytesSent,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// runControlCommand sends a command to the running agent, printing its result.
+func runControlCommand(socket, configFile string, args []string) {
+	path, err := controlSocketPath(socket, configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	result, err := control.Send(path, strings.Join(args, " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println(out.String())
+}
+
+// runSessionsCommand lists the sessions of the running agent.
+func runSessionsCommand(socket, configFile string) {
+	path, err := controlSocketPath(socket, configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	result, err := control.Send(path, "sessions")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := printSessions(os.Stdout, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/server"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestControlSocketPath(t *testing.T) {
+	newTestConfigOptions(t)
+
+	tests := []struct {
+		name   string
+		flag   string
+		config string
+		path   string
+		err    error
+	}{
+		{name: "flag", flag: "/run/flag.sock", config: "/run/config.sock", path: "/run/flag.sock"},
+		{name: "config", config: "/run/config.sock", path: "/run/config.sock"},
+		{name: "disabled", err: ErrControlSocketDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SHELLHUB_CONTROL_SOCKET", tt.config)
+
+			path, err := controlSocketPath(tt.flag, "")
+			if path != tt.path || err != tt.err {
+				t.Errorf("controlSocketPath(%q) = %q, %v, want %q, %v", tt.flag, path, err, tt.path, tt.err)
+			}
+		})
+	}
+}
+
+func TestControlSessions(t *testing.T) {
+	serv := server.NewServer(nil, nil, "", 0, "")
+
+	for _, id := range []string{"first", "second"} {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+
+		if err := serv.AddSession(id, conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := newControlServer(nil, serv, nil, "").Exec("sessions")
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+
+	var out bytes.Buffer
+	if err := printSessions(&out, res.Result); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("sessions table = %q, want a header and 2 sessions", out.String())
+	}
+
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "ID STARTED DURATION REMOTE RECEIVED SENT" {
+		t.Errorf("header = %q", lines[0])
+	}
+
+	ids := []string{strings.Fields(lines[1])[0], strings.Fields(lines[2])[0]}
+	sort.Strings(ids)
+
+	if ids[0] != "first" || ids[1] != "second" {
+		t.Errorf("sessions = %v, want [first second]", ids)
+	}
+}
+
+func TestPrintSessionsInvalidResult(t *testing.T) {
+	if err := printSessions(&bytes.Buffer{}, json.RawMessage(`{"id":"a"}`)); err == nil {
+		t.Error("printSessions accepted a result that is not a list of sessions")
+	}
+}
+
+func TestControlClose(t *testing.T) {
+	serv := server.NewServer(nil, nil, "", 0, "")
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	if err := serv.AddSession("id", conn); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newControlServer(nil, serv, nil, "")
+
+	tests := []struct {
+		name   string
+		line   string
+		result string
+		err    string
+	}{
+		{name: "missing id", line: "close", err: ErrMissingSessionID.Error()},
+		{name: "unknown session", line: "close other", err: "session not found: other"},
+		{name: "session", line: "close id", result: `"id"`},
+		{name: "closed session", line: "close id", err: "session not found: id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := c.Exec(tt.line)
+
+			if string(res.Result) != tt.result || res.Error != tt.err {
+				t.Errorf("Exec(%q) = {%s %q}, want {%s %q}", tt.line, res.Result, res.Error, tt.result, tt.err)
+			}
+		})
+	}
+
+	if _, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Error("the session connection is still open")
+	}
+}
+
+func TestControlStatus(t *testing.T) {
+	fake := setFakeClock(t)
+
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress: "http://localhost",
+		TenantID:      "tenant",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.health.setAuthorized(true)
+
+	serv := server.NewServer(nil, nil, "", 0, "")
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	if err := serv.AddSession("id", conn); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     "127.0.0.1:80",
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	fake.now = fake.now.Add(90 * time.Second)
+
+	res := newControlServer(agent, serv, proxy, "").Exec("status")
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+
+	var status agentStatus
+	if err := json.Unmarshal(res.Result, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Version != AgentVersion || status.ServerAddress != "http://localhost" {
+		t.Errorf("status version, server address = %q, %q", status.Version, status.ServerAddress)
+	}
+
+	if !status.Authorized || status.Connected || status.Sessions != 1 || status.Uptime != 90 {
+		t.Errorf("status = %+v, want authorized, disconnected, 1 session and 90s uptime", status)
+	}
+
+	if status.LastAuthorizedAt == nil || !status.LastAuthorizedAt.Equal(agent.startedAt) || status.Reconnects != 0 {
+		t.Errorf("status last authorization, reconnects = %v, %d, want %v and 0", status.LastAuthorizedAt, status.Reconnects, agent.startedAt)
+	}
+
+	if status.RTT != nil {
+		t.Errorf("status round-trip time = %+v, want none before a heartbeat is answered", status.RTT)
+	}
+
+	agent.rtt.record(20 * time.Millisecond)
+	agent.rtt.record(40 * time.Millisecond)
+
+	res = newControlServer(agent, serv, proxy, "").Exec("status")
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+
+	status = agentStatus{}
+	if err := json.Unmarshal(res.Result, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (rttSummary{Samples: 2, Min: 20, Avg: 30, Max: 40}); status.RTT == nil || *status.RTT != want {
+		t.Errorf("status round-trip time = %+v, want %+v", status.RTT, want)
+	}
+}
+
+func TestControlReload(t *testing.T) {
+	level, formatter := log.GetLevel(), log.StandardLogger().Formatter
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		log.SetFormatter(formatter)
+	})
+
+	opts := newTestConfigOptions(t)
+
+	agent, err := NewAgent(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newControlServer(agent, nil, nil, "")
+
+	t.Setenv("SHELLHUB_LOG_LEVEL", "debug")
+
+	if res := c.Exec("reload"); res.Error != "" || string(res.Result) != `"debug"` {
+		t.Errorf("reload = {%s %q}, want the reloaded level debug", res.Result, res.Error)
+	}
+
+	if log.GetLevel() != log.DebugLevel {
+		t.Errorf("log level = %s, want debug", log.GetLevel())
+	}
+
+	t.Setenv("SHELLHUB_LOG_LEVEL", "invalid")
+
+	if res := c.Exec("reload"); res.Error == "" {
+		t.Errorf("reload = %s, want an invalid level error", res.Result)
+	}
+}
+
+func TestControlMaintenance(t *testing.T) {
+	captureLog(t)
+
+	serv := server.NewServer(nil, nil, "", 0, "")
+	c := newControlServer(nil, serv, nil, "")
+
+	steps := []struct {
+		line   string
+		result string
+		err    string
+	}{
+		{line: "maintenance", result: "false"},
+		{line: "maintenance on", result: "true"},
+		{line: "maintenance", result: "true"},
+		{line: "maintenance invalid", err: ErrInvalidMaintenance.Error()},
+		{line: "maintenance", result: "true"},
+		{line: "maintenance off", result: "false"},
+	}
+
+	for _, step := range steps {
+		res := c.Exec(step.line)
+
+		if string(res.Result) != step.result || res.Error != step.err {
+			t.Errorf("Exec(%q) = {%s %q}, want {%s %q}", step.line, res.Result, res.Error, step.result, step.err)
+		}
+	}
+}
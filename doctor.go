@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+// doctorTimeout is the maximum time each network check of the doctor command
+// waits for.
+const doctorTimeout = 10 * time.Second
+
+var (
+	ErrCheckSkipped     = errors.New("skipped")
+	ErrNoServerDate     = errors.New("server response has no Date header")
+	ErrUnexpectedStatus = errors.New("unexpected response status")
+)
+
+// doctorCheck is a named diagnostic performed by the doctor command, with a
+// hint on how to fix it when it fails.
+type doctorCheck struct {
+	name  string
+	hint  string
+	check func() error
+}
+
+// doctor diagnoses the connectivity of the agent with the server. The checks
+// run in order, the later ones relying on the information gathered by the
+// former ones.
+type doctor struct {
+	opts      *ConfigOptions
+	servers   []*url.URL
+	tlsConfig *tls.Config
+	proxy     func(*http.Request) (*url.URL, error)
+
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	dial       func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Gathered from the first server answering the info request.
+	serverInfo *models.Info
+	serverDate time.Time
+}
+
+func newDoctor(opts *ConfigOptions, agent *Agent) *doctor {
+	dialer := &net.Dialer{Timeout: doctorTimeout, Resolver: agent.resolver}
+	if agent.localAddr != nil {
+		dialer.LocalAddr = agent.localAddr
+	}
+
+	resolver := net.DefaultResolver
+	if agent.resolver != nil {
+		resolver = agent.resolver
+	}
+
+	// The host overrides are applied as the agent does, so the diagnostics
+	// reflect its connections.
+	return &doctor{
+		opts:      opts,
+		servers:   agent.servers,
+		tlsConfig: agent.tlsConfig,
+		proxy:     agent.proxy,
+		lookupHost: func(ctx context.Context, host string) ([]string, error) {
+			if ip, ok := agent.hosts[host]; ok {
+				return []string{ip}, nil
+			}
+
+			return resolver.LookupHost(ctx, host)
+		},
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, agent.hosts.Address(address))
+		},
+	}
+}
+
+// checks returns the diagnostics to run.
+func (d *doctor) checks() []doctorCheck {
+	checks := []doctorCheck{
+		{
+			name:  "private key",
+			hint:  fmt.Sprintf("the agent generates a key at %s when missing; otherwise check that it is a readable PEM encoded RSA key", d.opts.PrivateKey),
+			check: func() error { return checkPrivateKey(d.opts) },
+		},
+	}
+
+	for _, server := range d.servers {
+		server := server
+
+		checks = append(checks,
+			doctorCheck{
+				name:  "dns resolution of " + server.Hostname(),
+				hint:  "check the server address and the DNS configuration of the device",
+				check: func() error { return d.checkDNS(server.Hostname()) },
+			},
+			doctorCheck{
+				name:  "connection to " + server.Host,
+				hint:  "check that a firewall is not blocking the outbound connections, or configure a proxy",
+				check: func() error { return d.checkReachable(server.Scheme, serverHostPort(server)) },
+			},
+			doctorCheck{
+				name:  "server info from " + server.String(),
+				hint:  "check that the server address points to a ShellHub server",
+				check: func() error { return d.checkServerInfo(server) },
+			},
+		)
+	}
+
+	checks = append(checks,
+		doctorCheck{
+			name:  "clock skew",
+			hint:  "synchronize the device clock, e.g. with NTP, as the authorization tokens are time bound",
+			check: d.checkClockSkew,
+		},
+		doctorCheck{
+			name:  "ssh endpoint",
+			hint:  "check that a firewall is not blocking the connections to the server SSH endpoint",
+			check: func() error { return d.checkEndpoint(func(e models.Endpoints) string { return e.SSH }, "22") },
+		},
+		doctorCheck{
+			name:  "api endpoint",
+			hint:  "check that a firewall is not blocking the connections to the server API endpoint",
+			check: func() error { return d.checkEndpoint(func(e models.Endpoints) string { return e.API }, "443") },
+		},
+		doctorCheck{
+			name:  "http tunnel target " + d.opts.ForwardedHTTPAddress,
+			hint:  "start the device HTTP service or set SHELLHUB_FORWARDED_HTTP_ADDRESS to the address it listens on",
+			check: d.checkHTTPTarget,
+		},
+	)
+
+	return checks
+}
+
+// checkDNS checks that host resolves to an address. IP addresses are accepted
+// as they are.
+func (d *doctor) checkDNS(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	addrs, err := d.lookupHost(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address found for %s", host)
+	}
+
+	return nil
+}
+
+// checkReachable checks that a TCP connection to address can be established,
+// completing the TLS handshake when the scheme is https. The connection is
+// made directly, even when a proxy is configured, from the source address.
+func (d *doctor) checkReachable(scheme, address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	conn, err := d.dial(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if scheme != "https" {
+		return nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if d.tlsConfig != nil {
+		config = d.tlsConfig.Clone()
+	}
+
+	config.ServerName, _, _ = net.SplitHostPort(address)
+
+	return tls.Client(conn, config).HandshakeContext(ctx)
+}
+
+// checkServerInfo requests the server information, keeping it, and the server
+// time, for the following checks.
+func (d *doctor) checkServerInfo(server *url.URL) error {
+	client := &http.Client{
+		Timeout: doctorTimeout,
+		Transport: &http.Transport{
+			Proxy:           d.proxy,
+			TLSClientConfig: d.tlsConfig,
+			DialContext:     d.dial,
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.String()+"/info?agent_version="+url.QueryEscape(AgentVersion), nil) // nolint:noctx
+	if err != nil {
+		return err
+	}
+
+	if header, err := extraHeaders(d.opts); err == nil {
+		for name, values := range header {
+			req.Header[name] = values
+		}
+	}
+
+	req.Header.Set("User-Agent", userAgent(d.opts))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+	}
+
+	var info models.Info
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return err
+	}
+
+	if d.serverInfo == nil {
+		d.serverInfo = &info
+
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			d.serverDate = date
+		}
+	}
+
+	return nil
+}
+
+// checkClockSkew checks that the device clock is close to the server one.
+func (d *doctor) checkClockSkew() error {
+	if d.serverInfo == nil {
+		return fmt.Errorf("%w: no server info", ErrCheckSkipped)
+	}
+
+	if d.serverDate.IsZero() {
+		return ErrNoServerDate
+	}
+
+	if skew := clockSkew(d.serverDate, clock.Now()); exceedsClockSkew(skew, time.Duration(d.opts.MaxClockSkew)*time.Second) {
+		return fmt.Errorf("%w: the device clock is %s off the server one", ErrClockSkew, skew.Round(time.Second))
+	}
+
+	return nil
+}
+
+// checkEndpoint checks that the server endpoint returned by endpoint is
+// reachable, using defaultPort when the endpoint has no port.
+func (d *doctor) checkEndpoint(endpoint func(models.Endpoints) string, defaultPort string) error {
+	if d.serverInfo == nil {
+		return fmt.Errorf("%w: no server info", ErrCheckSkipped)
+	}
+
+	address := endpoint(d.serverInfo.Endpoints)
+	if address == "" {
+		return fmt.Errorf("%w: endpoint not advertised by the server", ErrCheckSkipped)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+
+	return d.checkReachable("tcp", address)
+}
+
+// checkHTTPTarget checks that a service listens on the device address the HTTP
+// tunnels are forwarded to by default. The connection is made from any local
+// address, as the HTTP tunnels do.
+func (d *doctor) checkHTTPTarget() error {
+	host, port, err := forwardedHTTPAddress(d.opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{Timeout: doctorTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// run runs the checks, writing a report to w. It returns false when any check
+// has failed.
+func (d *doctor) run(w io.Writer) bool {
+	ok := true
+
+	for _, c := range d.checks() {
+		err := c.check()
+
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "[PASS] %s\n", c.name)
+		case errors.Is(err, ErrCheckSkipped):
+			fmt.Fprintf(w, "[SKIP] %s: %s\n", c.name, err)
+		default:
+			ok = false
+
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", c.name, err)
+			fmt.Fprintf(w, "       hint: %s\n", c.hint)
+		}
+	}
+
+	return ok
+}
+
+// serverHostPort returns the host and port of the server address, the port
+// defaulting to the one of the scheme.
+func serverHostPort(server *url.URL) string {
+	if server.Port() != "" {
+		return server.Host
+	}
+
+	if server.Scheme == "https" {
+		return net.JoinHostPort(server.Hostname(), "443")
+	}
+
+	return net.JoinHostPort(server.Hostname(), "80")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+// newTestDoctor returns a doctor checking the agent connectivity with the
+// server served by handler, the HTTP tunnels being forwarded to target.
+func newTestDoctor(t *testing.T, handler http.Handler, target string) *doctor {
+	t.Helper()
+
+	clearProxyEnv(t)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	opts := newTestConfigOptions(t)
+	opts.ServerAddress = server.URL
+	opts.ForwardedHTTPAddress = target
+
+	agent, err := NewAgent(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newDoctor(opts, agent)
+}
+
+// serverInfoHandler answers the server info request, advertising the address
+// of the server as its endpoints.
+func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/info" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	json.NewEncoder(w).Encode(&models.Info{ // nolint:errcheck
+		Version:   "v0.0.0",
+		Endpoints: models.Endpoints{API: r.Host, SSH: r.Host},
+	})
+}
+
+// doctorReport runs the doctor, returning whether it passed and the lines of
+// its report.
+func doctorReport(d *doctor) (bool, []string) {
+	var out bytes.Buffer
+
+	ok := d.run(&out)
+
+	return ok, strings.Split(strings.TrimSpace(out.String()), "\n")
+}
+
+func TestDoctor(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer target.Close()
+
+	ok, report := doctorReport(newTestDoctor(t, http.HandlerFunc(serverInfoHandler), target.Addr().String()))
+
+	if !ok {
+		t.Errorf("doctor failed:\n%s", strings.Join(report, "\n"))
+	}
+
+	// The private key, the three server checks and the four checks relying
+	// on the server info.
+	if len(report) != 8 {
+		t.Fatalf("doctor report = %d lines, want 8:\n%s", len(report), strings.Join(report, "\n"))
+	}
+
+	for _, line := range report {
+		if !strings.HasPrefix(line, "[PASS] ") {
+			t.Errorf("check %q did not pass", line)
+		}
+	}
+}
+
+func TestDoctorFailures(t *testing.T) {
+	// A closed listener gives an address nothing listens on.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	ok, report := doctorReport(newTestDoctor(t, handler, target.Addr().String()))
+
+	if ok {
+		t.Errorf("doctor passed with a failing server:\n%s", strings.Join(report, "\n"))
+	}
+
+	want := []string{
+		"[PASS] private key",
+		"[PASS] dns resolution of 127.0.0.1",
+		"[PASS] connection to ",
+		"[FAIL] server info from ",
+		"       hint: check that the server address points to a ShellHub server",
+		"[SKIP] clock skew: skipped: no server info",
+		"[SKIP] ssh endpoint: skipped: no server info",
+		"[SKIP] api endpoint: skipped: no server info",
+		"[FAIL] http tunnel target " + target.Addr().String(),
+		"       hint: start the device HTTP service",
+	}
+
+	if len(report) != len(want) {
+		t.Fatalf("doctor report = %d lines, want %d:\n%s", len(report), len(want), strings.Join(report, "\n"))
+	}
+
+	for i, prefix := range want {
+		if !strings.HasPrefix(report[i], prefix) {
+			t.Errorf("report line %d = %q, want the prefix %q", i, report[i], prefix)
+		}
+	}
+}
+
+func TestServerHostPort(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{server: "https://cloud.shellhub.io", want: "cloud.shellhub.io:443"},
+		{server: "http://shellhub.example.com", want: "shellhub.example.com:80"},
+		{server: "https://shellhub.example.com:8443", want: "shellhub.example.com:8443"},
+		{server: "http://[::1]", want: "[::1]:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			u, err := url.Parse(tt.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := serverHostPort(u); got != tt.want {
+				t.Errorf("serverHostPort(%s) = %q, want %q", tt.server, got, tt.want)
+			}
+		})
+	}
+}
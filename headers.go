@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidExtraHeader  = errors.New("invalid extra header, expected name=value")
+	ErrReservedExtraHeader = errors.New("extra header is set by the agent")
+)
+
+// reservedHeaders are the headers the agent sets itself on the requests to the
+// server, which cannot be overridden.
+var reservedHeaders = map[string]bool{
+	"Authorization": true,
+	"Host":          true,
+	"User-Agent":    true,
+}
+
+// sensitiveHeaderWords are the words, in lower case, of the header names
+// whose values are hidden in the logs and the agent information.
+var sensitiveHeaderWords = []string{"auth", "key", "token", "secret", "cookie", "password", "signature"}
+
+// extraHeaders returns the extra headers set in the configuration, as
+// name=value pairs, sent on the requests to the server.
+func extraHeaders(opts *ConfigOptions) (http.Header, error) {
+	if len(opts.ExtraHeaders) == 0 {
+		return nil, nil
+	}
+
+	header := make(http.Header)
+
+	for _, pair := range opts.ExtraHeaders {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+
+		if !ok || name == "" || strings.ContainsAny(name, " \t:") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExtraHeader, pair)
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("%w: %s", ErrReservedExtraHeader, name)
+		}
+
+		header.Add(name, strings.TrimSpace(value))
+	}
+
+	return header, nil
+}
+
+// sensitiveHeader reports whether the value of the header must be hidden.
+func sensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactedHeaders returns the extra headers, as name=value pairs, hiding the
+// values of the sensitive ones.
+func redactedHeaders(pairs []string) []string {
+	redacted := make([]string, 0, len(pairs))
+
+	for _, pair := range pairs {
+		name, _, ok := strings.Cut(pair, "=")
+		if ok && sensitiveHeader(strings.TrimSpace(name)) {
+			pair = name + "=" + redactedValue
+		}
+
+		redacted = append(redacted, pair)
+	}
+
+	return redacted
+}
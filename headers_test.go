@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestExtraHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []string
+		want  http.Header
+		err   error
+	}{
+		{name: "none"},
+		{
+			name:  "canonicalized and trimmed",
+			pairs: []string{"x-api-key = secret ", "X-Route=eu", "x-route=us"},
+			want:  http.Header{"X-Api-Key": {"secret"}, "X-Route": {"eu", "us"}},
+		},
+		{name: "value with equal sign", pairs: []string{"X-Token=a=b"}, want: http.Header{"X-Token": {"a=b"}}},
+		{name: "empty value", pairs: []string{"X-Empty="}, want: http.Header{"X-Empty": {""}}},
+		{name: "missing value", pairs: []string{"X-Route"}, err: ErrInvalidExtraHeader},
+		{name: "missing name", pairs: []string{"=eu"}, err: ErrInvalidExtraHeader},
+		{name: "name with space", pairs: []string{"X Route=eu"}, err: ErrInvalidExtraHeader},
+		{name: "name with colon", pairs: []string{"X-Route:eu=us"}, err: ErrInvalidExtraHeader},
+		{name: "authorization", pairs: []string{"authorization=Bearer token"}, err: ErrReservedExtraHeader},
+		{name: "user agent", pairs: []string{"User-Agent=curl"}, err: ErrReservedExtraHeader},
+		{name: "host", pairs: []string{"Host=shellhub.io"}, err: ErrReservedExtraHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extraHeaders(&ConfigOptions{ExtraHeaders: tt.pairs})
+			if !errors.Is(err, tt.err) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extraHeaders() = %v, %v, want %v, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestSensitiveHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "X-Api-Key", want: true},
+		{name: "X-Auth-Token", want: true},
+		{name: "Proxy-Authorization", want: true},
+		{name: "Cookie", want: true},
+		{name: "X-Client-Secret", want: true},
+		{name: "X-Signature", want: true},
+		{name: "X-Route"},
+		{name: "X-Tenant"},
+	}
+
+	for _, tt := range tests {
+		if got := sensitiveHeader(tt.name); got != tt.want {
+			t.Errorf("sensitiveHeader(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactedHeaders(t *testing.T) {
+	got := redactedHeaders([]string{"X-Api-Key=secret", "X-Route=eu", "invalid"})
+
+	want := []string{"X-Api-Key=" + redactedValue, "X-Route=eu", "invalid"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactedHeaders() = %v, want %v", got, want)
+	}
+}
+
+func TestDoctorExtraHeaders(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+	)
+
+	d := newTestDoctor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+
+		serverInfoHandler(w, r)
+	}), "127.0.0.1:1")
+	d.opts.ExtraHeaders = []string{"X-Api-Key=secret", "X-Route=eu"}
+
+	doctorReport(d)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(headers) == 0 {
+		t.Fatal("doctor did not request the server")
+	}
+
+	for _, header := range headers {
+		if header.Get("X-Api-Key") != "secret" || header.Get("X-Route") != "eu" {
+			t.Errorf("doctor request headers = %v, want the extra headers", header)
+		}
+	}
+}
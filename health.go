@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+)
+
+// health tracks the agent state reported by the health check endpoints and
+// the status control command.
+type health struct {
+	authorized int32
+	connected  int32
+
+	// lastAuthorized is the time, in Unix nanoseconds, of the last successful
+	// authorization, zero when there was none.
+	lastAuthorized int64
+	// connections is the number of connections established to the server.
+	connections int64
+
+	// status holds the func() *agentStatus returning the agent status served
+	// by /status, unset until the SSH server is created.
+	status atomic.Value
+}
+
+func (h *health) setAuthorized(authorized bool) {
+	atomic.StoreInt32(&h.authorized, boolToInt32(authorized))
+
+	if authorized {
+		atomic.StoreInt64(&h.lastAuthorized, clock.Now().UnixNano())
+	}
+}
+
+func (h *health) setConnected(connected bool) {
+	atomic.StoreInt32(&h.connected, boolToInt32(connected))
+
+	if connected {
+		atomic.AddInt64(&h.connections, 1)
+	}
+}
+
+// lastAuthorizedAt returns the time of the last successful authorization, or
+// the zero time when there was none.
+func (h *health) lastAuthorizedAt() time.Time {
+	nsec := atomic.LoadInt64(&h.lastAuthorized)
+	if nsec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nsec)
+}
+
+// reconnects returns the number of connections established to the server
+// after the first one.
+func (h *health) reconnects() int64 {
+	if n := atomic.LoadInt64(&h.connections); n > 1 {
+		return n - 1
+	}
+
+	return 0
+}
+
+// setStatus sets the function returning the agent status served by /status.
+func (h *health) setStatus(status func() *agentStatus) {
+	h.status.Store(status)
+}
+
+func (h *health) isAuthorized() bool {
+	return atomic.LoadInt32(&h.authorized) == 1
+}
+
+func (h *health) isConnected() bool {
+	return atomic.LoadInt32(&h.connected) == 1
+}
+
+// ready reports whether the agent has been authorized and has an active
+// reverse listener.
+func (h *health) ready() bool {
+	return h.isAuthorized() && h.isConnected()
+}
+
+// handler serves /healthz, which succeeds whenever the process is up, /readyz,
+// which succeeds only when the agent is ready, and /status, which returns the
+// agent status, including the transferred bytes and the round-trip times to the
+// server, as JSON.
+func (h *health) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status, ok := h.status.Load().(func() *agentStatus)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status()) // nolint:errcheck
+	})
+
+	return mux
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
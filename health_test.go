@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/server"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorized bool
+		connected  bool
+		ready      int
+	}{
+		{name: "starting", ready: http.StatusServiceUnavailable},
+		{name: "authorized", authorized: true, ready: http.StatusServiceUnavailable},
+		{name: "connected", connected: true, ready: http.StatusServiceUnavailable},
+		{name: "ready", authorized: true, connected: true, ready: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := new(health)
+			h.setAuthorized(tt.authorized)
+			h.setConnected(tt.connected)
+
+			for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": tt.ready} {
+				rec := httptest.NewRecorder()
+				h.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+				if rec.Code != want {
+					t.Errorf("GET %s status = %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthReadyTransitions(t *testing.T) {
+	h := new(health)
+	handler := h.handler()
+
+	readyz := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		return rec.Code
+	}
+
+	steps := []struct {
+		name   string
+		update func()
+		want   int
+	}{
+		{name: "authorized", update: func() { h.setAuthorized(true) }, want: http.StatusServiceUnavailable},
+		{name: "connected", update: func() { h.setConnected(true) }, want: http.StatusOK},
+		{name: "disconnected", update: func() { h.setConnected(false) }, want: http.StatusServiceUnavailable},
+		{name: "reconnected", update: func() { h.setConnected(true) }, want: http.StatusOK},
+		{name: "deauthorized", update: func() { h.setAuthorized(false) }, want: http.StatusServiceUnavailable},
+	}
+
+	for _, step := range steps {
+		step.update()
+
+		if got := readyz(); got != step.want {
+			t.Errorf("%s: GET /readyz status = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestHealthReconnects(t *testing.T) {
+	h := new(health)
+
+	if got := h.reconnects(); got != 0 {
+		t.Errorf("reconnects() = %d before connecting, want 0", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.setConnected(true)
+		h.setConnected(false)
+	}
+
+	if got := h.reconnects(); got != 2 {
+		t.Errorf("reconnects() = %d after three connections, want 2", got)
+	}
+}
+
+func TestHealthStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello") // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress: "http://localhost",
+		TenantID:      "tenant",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serv := server.NewServer(nil, nil, "", 0, "")
+	proxy := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     backend.Listener.Addr().String(),
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	h := agent.health.handler()
+
+	// The status is unavailable until the SSH server is created.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /status status = %d before the server is created, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	agent.health.setStatus(func() *agentStatus {
+		return newAgentStatus(agent, serv, proxy)
+	})
+
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Close = true
+	req.Header.Set("X-Path", "/")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(io.Discard, res.Body) // nolint:errcheck
+	res.Body.Close()
+
+	agent.rtt.record(10 * time.Millisecond)
+	agent.rtt.record(30 * time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var status agentStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.ServerAddress != "http://localhost" {
+		t.Errorf("server address = %q, want %q", status.ServerAddress, "http://localhost")
+	}
+
+	if status.Transferred.HTTPReceived == 0 || status.Transferred.HTTPSent == 0 {
+		t.Errorf("HTTP transferred bytes = %d received, %d sent, want both counted",
+			status.Transferred.HTTPReceived, status.Transferred.HTTPSent)
+	}
+
+	want := rttSummary{Samples: 2, Min: 10, Avg: 20, Max: 30}
+	if status.RTT == nil || *status.RTT != want {
+		t.Errorf("rtt = %+v, want %+v", status.RTT, want)
+	}
+}
+
+func TestHealthLastAuthorizedAt(t *testing.T) {
+	fake := setFakeClock(t)
+
+	h := new(health)
+
+	if at := h.lastAuthorizedAt(); !at.IsZero() {
+		t.Errorf("lastAuthorizedAt() = %v before any authorization, want the zero time", at)
+	}
+
+	first := fake.now
+	h.setAuthorized(true)
+
+	fake.now = fake.now.Add(time.Minute)
+
+	// A failed authorization keeps the time of the last successful one.
+	h.setAuthorized(false)
+
+	if at := h.lastAuthorizedAt(); !at.Equal(first) {
+		t.Errorf("lastAuthorizedAt() = %v, want %v", at, first)
+	}
+
+	h.setAuthorized(true)
+
+	if at := h.lastAuthorizedAt(); !at.Equal(fake.now) {
+		t.Errorf("lastAuthorizedAt() = %v, want %v", at, fake.now)
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/iocount"
+	"github.com/brycedjohnson/shellhub-agent/pkg/ratelimit"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidForwardedAddress = errors.New("invalid forwarded address")
+	ErrInvalidForwardedPort    = errors.New("invalid forwarded port")
+	ErrInvalidForwardedScheme  = errors.New("invalid forwarded scheme")
+	ErrForwardedHostDenied     = errors.New("forwarded host is not allowed")
+	ErrForwardedHostFailing    = errors.New("forwarded host keeps failing to accept connections")
+	ErrRequestHeaderTooLarge   = errors.New("request header too large")
+	ErrRequestBodyTooLarge     = errors.New("request body too large")
+	ErrInvalidHTTPService      = errors.New("invalid http service, expected name=[scheme://]host:port")
+	ErrUnknownHTTPService      = errors.New("unknown http service")
+)
+
+// httpService is a device HTTP service a request can be forwarded to by name,
+// with the X-Service header.
+type httpService struct {
+	scheme string
+	host   string
+	port   int
+}
+
+// httpProxy forwards the HTTP requests received through the tunnel to a HTTP
+// service running on the device.
+type httpProxy struct {
+	opts *ConfigOptions
+
+	// host and port are the address of the device service the requests are
+	// forwarded to by default.
+	host string
+	port int
+
+	// allowedHosts and allowedNetworks are the hostnames and networks a
+	// request can be forwarded to through the X-Host header.
+	allowedHosts    map[string]bool
+	allowedNetworks []*net.IPNet
+
+	// services are the device services a request can be forwarded to by
+	// name, through the X-Service header.
+	services map[string]httpService
+
+	// limiter returns the bandwidth limiter of a request, nil meaning
+	// unlimited.
+	limiter func() *ratelimit.Limiter
+
+	// breaker refuses the requests to the device services failing to accept
+	// connections.
+	breaker *circuitBreaker
+
+	// transferred counts the bytes exchanged with the device services over
+	// all the requests.
+	transferred iocount.Counters
+}
+
+func newHTTPProxy(opts *ConfigOptions, limiter func() *ratelimit.Limiter) (*httpProxy, error) {
+	host, port, err := forwardedHTTPAddress(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := httpServices(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &httpProxy{
+		opts:         opts,
+		host:         host,
+		port:         port,
+		allowedHosts: make(map[string]bool),
+		services:     services,
+		limiter:      limiter,
+		breaker: newCircuitBreaker(
+			opts.ForwardedHTTPBreakerThreshold,
+			time.Duration(opts.ForwardedHTTPBreakerCooldown)*time.Second,
+		),
+	}
+
+	for _, entry := range opts.ForwardedHTTPHosts {
+		entry = strings.TrimSpace(entry)
+
+		if ip := net.ParseIP(entry); ip != nil {
+			entry = ip.String()
+		}
+
+		if !strings.Contains(entry, "/") {
+			p.allowedHosts[strings.ToLower(entry)] = true
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		p.allowedNetworks = append(p.allowedNetworks, network)
+	}
+
+	return p, nil
+}
+
+// forwardedHTTPAddress returns the host and port of the device service the
+// requests are forwarded to by default, the ForwardedHTTPPort option, when set,
+// overriding the port of the address.
+func forwardedHTTPAddress(opts *ConfigOptions) (string, int, error) {
+	host, portValue, err := net.SplitHostPort(opts.ForwardedHTTPAddress)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidForwardedAddress, err)
+	}
+
+	if host == "" {
+		return "", 0, fmt.Errorf("%w: missing host in %q", ErrInvalidForwardedAddress, opts.ForwardedHTTPAddress)
+	}
+
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("%w: invalid port in %q", ErrInvalidForwardedAddress, opts.ForwardedHTTPAddress)
+	}
+
+	if opts.ForwardedHTTPPort != 0 {
+		port = opts.ForwardedHTTPPort
+	}
+
+	return host, port, nil
+}
+
+// httpServices returns the named device services set in the configuration, as
+// name=[scheme://]host:port entries, the scheme defaulting to the configured
+// one.
+func httpServices(opts *ConfigOptions) (map[string]httpService, error) {
+	services := make(map[string]httpService)
+
+	for _, entry := range opts.ForwardedHTTPServices {
+		name, address, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHTTPService, entry)
+		}
+
+		service := httpService{scheme: opts.ForwardedHTTPScheme}
+
+		if scheme, rest, ok := strings.Cut(address, "://"); ok {
+			service.scheme = scheme
+			address = rest
+		}
+
+		host, portValue, err := net.SplitHostPort(address)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHTTPService, entry)
+		}
+
+		port, err := strconv.Atoi(portValue)
+		if err != nil || port < 1 || port > 65535 || (service.scheme != "http" && service.scheme != "https") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHTTPService, entry)
+		}
+
+		service.host = host
+		service.port = port
+		services[name] = service
+	}
+
+	return services, nil
+}
+
+// forwardedHost returns the host the request must be forwarded to. Without the
+// X-Host header, requests are forwarded to the configured address. Otherwise,
+// the host must be a hostname or an IP address within the allowed list.
+func (p *httpProxy) forwardedHost(r *http.Request) (string, error) {
+	host := r.Header.Get("X-Host")
+	if host == "" {
+		return p.host, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range p.allowedNetworks {
+			if network.Contains(ip) {
+				return ip.String(), nil
+			}
+		}
+
+		host = ip.String()
+	}
+
+	if !p.allowedHosts[strings.ToLower(host)] {
+		return "", ErrForwardedHostDenied
+	}
+
+	return host, nil
+}
+
+// forwardedPort returns the device port the request must be forwarded to. The
+// X-Port header, when present, overrides the configured default.
+func (p *httpProxy) forwardedPort(r *http.Request) (int, error) {
+	port := p.port
+
+	if header := r.Header.Get("X-Port"); header != "" {
+		value, err := strconv.Atoi(header)
+		if err != nil {
+			return 0, ErrInvalidForwardedPort
+		}
+
+		port = value
+	}
+
+	if port < 1 || port > 65535 {
+		return 0, ErrInvalidForwardedPort
+	}
+
+	return port, nil
+}
+
+// forwardedScheme returns the scheme used to reach the device service. The
+// X-Scheme header, when present, overrides the configured default.
+func (p *httpProxy) forwardedScheme(r *http.Request) (string, error) {
+	scheme := p.opts.ForwardedHTTPScheme
+
+	if header := r.Header.Get("X-Scheme"); header != "" {
+		scheme = header
+	}
+
+	switch scheme {
+	case "http", "https":
+		return scheme, nil
+	default:
+		return "", ErrInvalidForwardedScheme
+	}
+}
+
+// dial connects to the device service, establishing a TLS connection when the
+// scheme is https. It fails when the connection takes longer than the dial
+// timeout.
+func (p *httpProxy) dial(scheme, host string, port int) (net.Conn, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: time.Duration(p.opts.ForwardedHTTPDialTimeout) * time.Second}
+
+	if scheme == "https" {
+		serverName := host
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			serverName = "localhost"
+		}
+
+		return tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ // nolint:gosec
+			ServerName:         serverName,
+			InsecureSkipVerify: p.opts.ForwardedHTTPSInsecure,
+		})
+	}
+
+	return dialer.Dial("tcp", address)
+}
+
+// transferredBytes returns the total number of bytes received from and sent to
+// the clients over all the requests.
+func (p *httpProxy) transferredBytes() (received, sent int64) {
+	return p.transferred.Written(), p.transferred.Read()
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// status is the status code of the response, zero when it is unknown,
+	// logged in the access log.
+	var status int
+
+	if p.opts.HTTPTunnelAccessLog {
+		defer p.logAccess(r, clock.Now(), &status)
+	}
+
+	replyError := func(err error, msg string, code int) {
+		log.WithError(err).WithFields(log.Fields{
+			"remote":    r.RemoteAddr,
+			"namespace": r.Header.Get("X-Namespace"),
+			"path":      r.Header.Get("X-Path"),
+			"version":   AgentVersion,
+		}).Error(msg)
+
+		status = code
+		http.Error(w, msg, code)
+	}
+
+	if max := int64(p.opts.ForwardedHTTPMaxHeaderSize) * 1024; max > 0 && headerSize(r.Header) > max {
+		replyError(ErrRequestHeaderTooLarge, "request header too large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	var body *limitedBody
+
+	if max := int64(p.opts.ForwardedHTTPMaxBodySize) * 1024 * 1024; max > 0 {
+		if r.ContentLength > max {
+			replyError(ErrRequestBodyTooLarge, "request body too large", http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		// The length of a chunked body is only known once it is read.
+		body = &limitedBody{ReadCloser: r.Body, remaining: max}
+		r.Body = body
+	}
+
+	var (
+		scheme, host string
+		port         int
+		err          error
+	)
+
+	// A named service is reached as configured, ignoring the headers
+	// overriding the default service address.
+	if name := r.Header.Get("X-Service"); name != "" {
+		service, ok := p.services[name]
+		if !ok {
+			replyError(fmt.Errorf("%w: %s", ErrUnknownHTTPService, name), "unknown service to forward the request to", http.StatusNotFound)
+
+			return
+		}
+
+		scheme, host, port = service.scheme, service.host, service.port
+	} else {
+		if port, err = p.forwardedPort(r); err != nil {
+			replyError(err, "invalid port to forward the request to", http.StatusBadRequest)
+
+			return
+		}
+
+		if scheme, err = p.forwardedScheme(r); err != nil {
+			replyError(err, "invalid scheme to forward the request to", http.StatusBadRequest)
+
+			return
+		}
+
+		if host, err = p.forwardedHost(r); err != nil {
+			replyError(err, "host to forward the request to is not allowed", http.StatusForbidden)
+
+			return
+		}
+	}
+
+	address := scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+	if !p.breaker.allow(address) {
+		log.WithError(ErrForwardedHostFailing).WithFields(log.Fields{
+			"remote":    r.RemoteAddr,
+			"namespace": r.Header.Get("X-Namespace"),
+			"address":   address,
+		}).Debug("Refused HTTP tunnel request as the HTTP server on device keeps failing")
+
+		status = http.StatusServiceUnavailable
+		http.Error(w, "HTTP server on device is unavailable", status)
+
+		return
+	}
+
+	in, err := p.dial(scheme, host, port)
+	if err != nil {
+		p.breaker.failure(address)
+
+		replyError(err, "failed to connect to HTTP server on device", http.StatusGatewayTimeout)
+
+		return
+	}
+
+	p.breaker.success(address)
+
+	defer in.Close()
+
+	// The bytes written to the device service are received from the client,
+	// and the ones read from it are sent to the client.
+	counters := new(iocount.Counters)
+	in = iocount.NewConn(in, counters, &p.transferred)
+
+	defer func() {
+		log.WithFields(log.Fields{
+			"remote":         r.RemoteAddr,
+			"namespace":      r.Header.Get("X-Namespace"),
+			"bytes_received": counters.Written(),
+			"bytes_sent":     counters.Read(),
+		}).Debug("HTTP tunnel request finished")
+	}()
+
+	url, err := r.URL.Parse(r.Header.Get("X-Path"))
+	if err != nil {
+		replyError(err, "failed to parse URL", http.StatusInternalServerError)
+
+		return
+	}
+
+	r.URL = url
+	r.URL.Scheme = scheme
+
+	if p.opts.ForwardedHTTPHeaders {
+		setForwardedHeaders(r)
+	}
+
+	if err := r.Write(in); err != nil {
+		if body != nil && body.exceeded {
+			replyError(ErrRequestBodyTooLarge, "request body too large", http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		replyError(err, "failed to write request to the server on device", http.StatusInternalServerError)
+
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		replyError(nil, "webserver doesn't support hijacking", http.StatusInternalServerError)
+
+		return
+	}
+
+	out, buf, err := hj.Hijack()
+	if err != nil {
+		replyError(err, "failed to hijack connection", http.StatusInternalServerError)
+
+		return
+	}
+
+	defer out.Close()
+
+	limiter := p.limiter()
+
+	// After a WebSocket upgrade, the client keeps sending data to the device
+	// service over the same connection.
+	if isWebSocketUpgrade(r) {
+		go func() {
+			if _, err := io.Copy(ratelimit.NewWriter(in, limiter), buf); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"remote":    r.RemoteAddr,
+					"namespace": r.Header.Get("X-Namespace"),
+					"path":      r.Header.Get("X-Path"),
+					"version":   AgentVersion,
+				}).Debug("failed to copy WebSocket data from client to device service")
+			}
+
+			in.Close()
+		}()
+	}
+
+	response := &statusRecorder{Writer: ratelimit.NewWriter(out, limiter)}
+
+	if _, err := io.Copy(response, in); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"remote":    r.RemoteAddr,
+			"namespace": r.Header.Get("X-Namespace"),
+			"path":      r.Header.Get("X-Path"),
+			"version":   AgentVersion,
+		}).Error("failed to copy response from device service to client")
+	}
+
+	status = response.status
+}
+
+// logAccess writes the access log line of the request, started at start and
+// answered with the status code, never logging the request or response body.
+func (p *httpProxy) logAccess(r *http.Request, start time.Time, status *int) {
+	log.WithFields(log.Fields{
+		"remote":    r.RemoteAddr,
+		"namespace": r.Header.Get("X-Namespace"),
+		"method":    r.Method,
+		"path":      r.Header.Get("X-Path"),
+		"status":    *status,
+		"duration":  clock.Now().Sub(start),
+	}).Info("HTTP tunnel request")
+}
+
+// headerSize returns the size of the header as sent on the wire.
+func headerSize(header http.Header) int64 {
+	var size int64
+
+	for key, values := range header {
+		for _, value := range values {
+			size += int64(len(key) + len(": ") + len(value) + len("\r\n"))
+		}
+	}
+
+	return size
+}
+
+// limitedBody is a request body failing with ErrRequestBodyTooLarge once more
+// than remaining bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrRequestBodyTooLarge
+	}
+
+	// Reading one byte more than the limit tells whether it is exceeded.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n, b.remaining, b.exceeded = int(b.remaining), 0, true
+
+		return n, ErrRequestBodyTooLarge
+	}
+
+	b.remaining -= int64(n)
+
+	return n, err
+}
+
+// httpTunnelHandler returns the handler of the HTTP tunnel requests, forwarding
+// them to the device services through proxy unless the HTTP tunnel is disabled.
+func httpTunnelHandler(opts *ConfigOptions, proxy *httpProxy) http.HandlerFunc {
+	if opts.DisableHTTPTunnel {
+		return refuseHTTPTunnel
+	}
+
+	return proxy.ServeHTTP
+}
+
+// refuseHTTPTunnel replies to the HTTP tunnel requests when the HTTP tunnel is
+// disabled.
+func refuseHTTPTunnel(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"remote":    r.RemoteAddr,
+		"namespace": r.Header.Get("X-Namespace"),
+		"path":      r.Header.Get("X-Path"),
+	}).Debug("Refused HTTP tunnel request as the HTTP tunnel is disabled")
+
+	http.Error(w, "HTTP tunnel is disabled on this device", http.StatusForbidden)
+}
+
+// setForwardedHeaders sets the headers telling the device service the address of
+// the client, as conveyed by the server, and the scheme of its request, as
+// passed along by the server in the X-Scheme header. The X-Real-IP,
+// X-Forwarded-For and X-Forwarded-Proto headers are always overwritten, so they
+// can't be forged by the client.
+func setForwardedHeaders(r *http.Request) {
+	ip := clientIP(r)
+
+	r.Header.Del("X-Real-IP")
+	r.Header.Del("X-Forwarded-For")
+
+	if ip != nil {
+		r.Header.Set("X-Real-IP", ip.String())
+		r.Header.Set("X-Forwarded-For", ip.String())
+	}
+
+	proto := "http"
+	if strings.EqualFold(r.Header.Get("X-Scheme"), "https") || r.TLS != nil {
+		proto = "https"
+	}
+
+	r.Header.Set("X-Forwarded-Proto", proto)
+}
+
+// isWebSocketUpgrade reports whether the request asks to upgrade the connection
+// to the WebSocket protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
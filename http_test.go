@@ -0,0 +1,1005 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/ratelimit"
+)
+
+func newTestHTTPProxy(t *testing.T, opts *ConfigOptions) *httpProxy {
+	t.Helper()
+
+	p, err := newHTTPProxy(opts, func() *ratelimit.Limiter { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestForwardedPort(t *testing.T) {
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress: "127.0.0.1:80",
+		ForwardedHTTPScheme:  "http",
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+		err    error
+	}{
+		{name: "default", want: 80},
+		{name: "header", header: "8080", want: 8080},
+		{name: "lowest", header: "1", want: 1},
+		{name: "highest", header: "65535", want: 65535},
+		{name: "zero", header: "0", err: ErrInvalidForwardedPort},
+		{name: "negative", header: "-1", err: ErrInvalidForwardedPort},
+		{name: "out of range", header: "65536", err: ErrInvalidForwardedPort},
+		{name: "not a number", header: "http", err: ErrInvalidForwardedPort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Port", tt.header)
+			}
+
+			got, err := p.forwardedPort(r)
+			if err != tt.err || got != tt.want {
+				t.Errorf("forwardedPort() = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress: "127.0.0.1:80",
+		ForwardedHTTPScheme:  "http",
+		ForwardedHTTPHosts:   []string{"Printer.lan", " 192.168.1.10 ", "10.0.0.0/8", "2001:db8::/32", "0:0:0:0:0:0:0:1"},
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		err    error
+	}{
+		{name: "default", want: "127.0.0.1"},
+		{name: "hostname", header: "printer.lan", want: "printer.lan"},
+		{name: "hostname case", header: "PRINTER.LAN", want: "PRINTER.LAN"},
+		{name: "ip", header: "192.168.1.10", want: "192.168.1.10"},
+		{name: "network", header: "10.1.2.3", want: "10.1.2.3"},
+		{name: "ipv6 network", header: "2001:db8::1", want: "2001:db8::1"},
+		{name: "ipv6 normalized", header: "::1", want: "::1"},
+		{name: "unlisted hostname", header: "router.lan", err: ErrForwardedHostDenied},
+		{name: "unlisted ip", header: "192.168.1.11", err: ErrForwardedHostDenied},
+		{name: "outside network", header: "11.0.0.1", err: ErrForwardedHostDenied},
+		{name: "loopback", header: "127.0.0.1", err: ErrForwardedHostDenied},
+		{name: "localhost", header: "localhost", err: ErrForwardedHostDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Host", tt.header)
+			}
+
+			got, err := p.forwardedHost(r)
+			if err != tt.err || got != tt.want {
+				t.Errorf("forwardedHost() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewHTTPProxyInvalidHosts(t *testing.T) {
+	_, err := newHTTPProxy(&ConfigOptions{
+		ForwardedHTTPAddress: "127.0.0.1:80",
+		ForwardedHTTPScheme:  "http",
+		ForwardedHTTPHosts:   []string{"10.0.0.0/33"},
+	}, func() *ratelimit.Limiter { return nil })
+	if err == nil {
+		t.Error("newHTTPProxy() succeeded with an invalid network")
+	}
+}
+
+func TestHTTPServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		want     map[string]httpService
+		err      error
+	}{
+		{name: "unset", want: map[string]httpService{}},
+		{
+			name:     "default scheme",
+			services: []string{"camera=127.0.0.1:8081"},
+			want:     map[string]httpService{"camera": {scheme: "http", host: "127.0.0.1", port: 8081}},
+		},
+		{
+			name:     "scheme",
+			services: []string{"camera=127.0.0.1:8081", " config=https://[::1]:8443"},
+			want: map[string]httpService{
+				"camera": {scheme: "http", host: "127.0.0.1", port: 8081},
+				"config": {scheme: "https", host: "::1", port: 8443},
+			},
+		},
+		{name: "missing name", services: []string{"=127.0.0.1:8081"}, err: ErrInvalidHTTPService},
+		{name: "missing address", services: []string{"camera"}, err: ErrInvalidHTTPService},
+		{name: "missing port", services: []string{"camera=127.0.0.1"}, err: ErrInvalidHTTPService},
+		{name: "missing host", services: []string{"camera=:8081"}, err: ErrInvalidHTTPService},
+		{name: "invalid port", services: []string{"camera=127.0.0.1:65536"}, err: ErrInvalidHTTPService},
+		{name: "invalid scheme", services: []string{"camera=ftp://127.0.0.1:21"}, err: ErrInvalidHTTPService},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := httpServices(&ConfigOptions{ForwardedHTTPScheme: "http", ForwardedHTTPServices: tt.services})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("httpServices() error = %v, want %v", err, tt.err)
+			}
+
+			if tt.err != nil {
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("httpServices() = %v, want %v", got, tt.want)
+			}
+
+			for name, service := range tt.want {
+				if got[name] != service {
+					t.Errorf("httpServices()[%q] = %+v, want %+v", name, got[name], service)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPProxyXService(t *testing.T) {
+	newBackend := func(name string) int {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name) // nolint:errcheck
+		}))
+		t.Cleanup(backend.Close)
+
+		_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		port, err := strconv.Atoi(backendPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return port
+	}
+
+	defaultPort := newBackend("default")
+	cameraPort := newBackend("camera")
+
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     "127.0.0.1:1",
+		ForwardedHTTPPort:        defaultPort,
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+		ForwardedHTTPServices:    []string{"camera=127.0.0.1:" + strconv.Itoa(cameraPort)},
+	})
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{name: "default", status: http.StatusOK, body: "default"},
+		{name: "service", headers: map[string]string{"X-Service": "camera"}, status: http.StatusOK, body: "camera"},
+		{
+			name:    "service ignoring the overrides",
+			headers: map[string]string{"X-Service": "camera", "X-Host": "192.0.2.1", "X-Port": "0"},
+			status:  http.StatusOK,
+			body:    "camera",
+		},
+		{name: "unknown service", headers: map[string]string{"X-Service": "printer"}, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/")
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+
+			if tt.status == http.StatusOK && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestNewHTTPProxyInvalidServices(t *testing.T) {
+	_, err := newHTTPProxy(&ConfigOptions{
+		ForwardedHTTPAddress:  "127.0.0.1:80",
+		ForwardedHTTPScheme:   "http",
+		ForwardedHTTPServices: []string{"camera"},
+	}, func() *ratelimit.Limiter { return nil })
+	if !errors.Is(err, ErrInvalidHTTPService) {
+		t.Errorf("newHTTPProxy() error = %v, want %v", err, ErrInvalidHTTPService)
+	}
+}
+
+func TestHTTPProxyXHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path) // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := strconv.Atoi(backendPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     "127.0.0.1:1",
+		ForwardedHTTPPort:        port,
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+		ForwardedHTTPHosts:       []string{"127.0.0.0/8"},
+	})
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	tests := []struct {
+		name   string
+		host   string
+		status int
+	}{
+		{name: "default", status: http.StatusOK},
+		{name: "allowed", host: "127.0.0.1", status: http.StatusOK},
+		{name: "disallowed", host: "192.0.2.1", status: http.StatusForbidden},
+		{name: "disallowed hostname", host: "example.com", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/hello")
+			if tt.host != "" {
+				req.Header.Set("X-Host", tt.host)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+
+			if tt.status == http.StatusOK && string(body) != "/hello" {
+				t.Errorf("body = %q, want %q", body, "/hello")
+			}
+		})
+	}
+}
+
+func TestForwardedHTTPAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		port    int
+		host    string
+		want    int
+		err     bool
+	}{
+		{name: "address", address: "127.0.0.1:80", host: "127.0.0.1", want: 80},
+		{name: "port override", address: "127.0.0.1:80", port: 8080, host: "127.0.0.1", want: 8080},
+		{name: "hostname", address: "localhost:443", host: "localhost", want: 443},
+		{name: "ipv6", address: "[::1]:8080", host: "::1", want: 8080},
+		{name: "ipv6 port override", address: "[fd00::1]:80", port: 8080, host: "fd00::1", want: 8080},
+		{name: "highest port", address: "127.0.0.1:65535", host: "127.0.0.1", want: 65535},
+		{name: "missing port", address: "127.0.0.1", err: true},
+		{name: "unbracketed ipv6", address: "::1:80", err: true},
+		{name: "port zero", address: "127.0.0.1:0", err: true},
+		{name: "port out of range", address: "127.0.0.1:65536", err: true},
+		{name: "missing host", address: ":80", err: true},
+		{name: "invalid port", address: "127.0.0.1:http", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, err := forwardedHTTPAddress(&ConfigOptions{
+				ForwardedHTTPAddress: tt.address,
+				ForwardedHTTPPort:    tt.port,
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("forwardedHTTPAddress() error = %v, want error %v", err, tt.err)
+			}
+
+			if host != tt.host || port != tt.want {
+				t.Errorf("forwardedHTTPAddress() = %q, %d, want %q, %d", host, port, tt.host, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyXPort(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path) // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the default port: only the requests with the X-Port
+	// header reach the backend.
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     "127.0.0.1:1",
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	tests := []struct {
+		name   string
+		port   string
+		status int
+		body   string
+	}{
+		{name: "header", port: backendPort, status: http.StatusOK, body: "/hello"},
+		{name: "out of range", port: strconv.Itoa(65536), status: http.StatusBadRequest},
+		{name: "not a number", port: "http", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/hello")
+			req.Header.Set("X-Port", tt.port)
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+
+			if tt.body != "" && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		realIP    string
+		forwarded string
+		proto     string
+	}{
+		{
+			name: "unknown client",
+			header: http.Header{
+				"X-Forwarded-For":   {"forged"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			proto: "http",
+		},
+		{
+			name: "real ip",
+			header: http.Header{
+				"X-Real-Ip": {"203.0.113.1"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "http",
+		},
+		{
+			name: "forwarded for",
+			header: http.Header{
+				"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "http",
+		},
+		{
+			name: "forged forwarded for",
+			header: http.Header{
+				"X-Real-Ip":       {"203.0.113.1"},
+				"X-Forwarded-For": {"10.0.0.1"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "http",
+		},
+		{
+			name: "invalid real ip",
+			header: http.Header{
+				"X-Real-Ip": {"forged"},
+			},
+			proto: "http",
+		},
+		{
+			name: "https",
+			header: http.Header{
+				"X-Real-Ip": {"203.0.113.1"},
+				"X-Scheme":  {"https"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "https",
+		},
+		{
+			name: "http",
+			header: http.Header{
+				"X-Real-Ip": {"203.0.113.1"},
+				"X-Scheme":  {"http"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "http",
+		},
+		{
+			name: "forged proto",
+			header: http.Header{
+				"X-Real-Ip":         {"203.0.113.1"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			realIP:    "203.0.113.1",
+			forwarded: "203.0.113.1",
+			proto:     "http",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "127.0.0.1:4242"
+			r.Header = tt.header
+
+			setForwardedHeaders(r)
+
+			if got := r.Header.Get("X-Real-IP"); got != tt.realIP {
+				t.Errorf("X-Real-IP = %q, want %q", got, tt.realIP)
+			}
+
+			if got := r.Header.Get("X-Forwarded-For"); got != tt.forwarded {
+				t.Errorf("X-Forwarded-For = %q, want %q", got, tt.forwarded)
+			}
+
+			if got := r.Header.Get("X-Forwarded-Proto"); got != tt.proto {
+				t.Errorf("X-Forwarded-Proto = %q, want %q", got, tt.proto)
+			}
+		})
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int64
+	}{
+		{name: "empty", header: http.Header{}, want: 0},
+		{name: "single", header: http.Header{"X-Path": {"/"}}, want: int64(len("X-Path: /\r\n"))},
+		{
+			name:   "repeated",
+			header: http.Header{"Accept": {"a", "bc"}},
+			want:   int64(len("Accept: a\r\n") + len("Accept: bc\r\n")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerSize(tt.header); got != tt.want {
+				t.Errorf("headerSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		limit int64
+		want  string
+		err   error
+	}{
+		{name: "under", body: "hello", limit: 10, want: "hello"},
+		{name: "exact", body: "hello", limit: 5, want: "hello"},
+		{name: "over", body: "hello", limit: 4, want: "hell", err: ErrRequestBodyTooLarge},
+		{name: "empty", body: "", limit: 0, want: ""},
+		{name: "zero", body: "h", limit: 0, want: "", err: ErrRequestBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &limitedBody{ReadCloser: io.NopCloser(strings.NewReader(tt.body)), remaining: tt.limit}
+
+			got, err := io.ReadAll(body)
+			if !errors.Is(err, tt.err) || string(got) != tt.want {
+				t.Errorf("ReadAll() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+
+			if body.exceeded != (tt.err != nil) {
+				t.Errorf("exceeded = %v, want %v", body.exceeded, tt.err != nil)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyRequestLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, strconv.Itoa(len(body))) // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:       backend.Listener.Addr().String(),
+		ForwardedHTTPScheme:        "http",
+		ForwardedHTTPDialTimeout:   1,
+		ForwardedHTTPMaxHeaderSize: 1,
+		ForwardedHTTPMaxBodySize:   1,
+	})
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name    string
+		body    int
+		chunked bool
+		header  int
+		status  int
+	}{
+		{name: "normal", body: 1024, status: http.StatusOK},
+		{name: "body at the limit", body: mb, status: http.StatusOK},
+		{name: "chunked", body: 1024, chunked: true, status: http.StatusOK},
+		{name: "body too large", body: mb + 1, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked body too large", body: 2 * mb, chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "header too large", header: 2048, status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(make([]byte, tt.body))
+			if tt.chunked {
+				// Hiding the length of the body makes it sent chunked.
+				body = io.MultiReader(body)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, front.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/")
+			if tt.header > 0 {
+				req.Header.Set("X-Padding", strings.Repeat("a", tt.header))
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			got, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+
+			if tt.status == http.StatusOK && string(got) != strconv.Itoa(tt.body) {
+				t.Errorf("backend received %s bytes, want %d", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestForwardedScheme(t *testing.T) {
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress: "127.0.0.1:80",
+		ForwardedHTTPScheme:  "http",
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		err    error
+	}{
+		{name: "default", want: "http"},
+		{name: "http", header: "http", want: "http"},
+		{name: "https", header: "https", want: "https"},
+		{name: "unsupported", header: "ftp", err: ErrInvalidForwardedScheme},
+		{name: "upper case", header: "HTTPS", err: ErrInvalidForwardedScheme},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Scheme", tt.header)
+			}
+
+			got, err := p.forwardedScheme(r)
+			if err != tt.err || got != tt.want {
+				t.Errorf("forwardedScheme() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyHTTPS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path) // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name     string
+		insecure bool
+		status   int
+	}{
+		{name: "insecure", insecure: true, status: http.StatusOK},
+		{name: "untrusted certificate", insecure: false, status: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestHTTPProxy(t, &ConfigOptions{
+				ForwardedHTTPAddress:     backend.Listener.Addr().String(),
+				ForwardedHTTPScheme:      "https",
+				ForwardedHTTPSInsecure:   tt.insecure,
+				ForwardedHTTPDialTimeout: 1,
+			})
+
+			front := httptest.NewServer(p)
+			defer front.Close()
+
+			req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Close = true
+			req.Header.Set("X-Path", "/hello")
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+
+			if tt.status == http.StatusOK && string(body) != "/hello" {
+				t.Errorf("body = %q, want %q", body, "/hello")
+			}
+		})
+	}
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		upgrade    string
+		connection []string
+		want       bool
+	}{
+		{name: "upgrade", upgrade: "websocket", connection: []string{"Upgrade"}, want: true},
+		{name: "case insensitive", upgrade: "WebSocket", connection: []string{"upgrade"}, want: true},
+		{name: "token list", upgrade: "websocket", connection: []string{"keep-alive, Upgrade"}, want: true},
+		{name: "repeated header", upgrade: "websocket", connection: []string{"keep-alive", "Upgrade"}, want: true},
+		{name: "missing connection", upgrade: "websocket"},
+		{name: "keep-alive", upgrade: "websocket", connection: []string{"keep-alive"}},
+		{name: "other protocol", upgrade: "h2c", connection: []string{"Upgrade"}},
+		{name: "plain request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.upgrade != "" {
+				r.Header.Set("Upgrade", tt.upgrade)
+			}
+
+			for _, value := range tt.connection {
+				r.Header.Add("Connection", value)
+			}
+
+			if got := isWebSocketUpgrade(r); got != tt.want {
+				t.Errorf("isWebSocketUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyWebSocket(t *testing.T) {
+	// The backend accepts the upgrade and echoes the data it receives.
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n") // nolint:errcheck
+
+		io.Copy(conn, buf) // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     backend.Listener.Addr().String(),
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: device\r\nX-Path: /socket\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n") // nolint:errcheck
+
+	reader := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// The data sent after the upgrade reaches the backend and back.
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint:errcheck
+
+	echo := make([]byte, len("ping"))
+	if _, err := io.ReadFull(reader, echo); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(echo) != "ping" {
+		t.Errorf("echo = %q, want %q", echo, "ping")
+	}
+}
+
+func TestHTTPProxyDialTimeout(t *testing.T) {
+	// The backend never answers the TLS handshake, which the dial timeout
+	// covers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	p := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     listener.Addr().String(),
+		ForwardedHTTPScheme:      "https",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Path", "/")
+
+	start := time.Now()
+	p.ServeHTTP(rec, r)
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("request answered after %v, want after the 1s dial timeout", elapsed)
+	}
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestHTTPProxyForwardedAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{name: "ipv4", network: "tcp4", address: "127.0.0.1:0"},
+		{name: "ipv6", network: "tcp6", address: "[::1]:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen(tt.network, tt.address)
+			if err != nil {
+				t.Skipf("%s loopback unavailable: %v", tt.name, err)
+			}
+
+			backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "backend") // nolint:errcheck
+			}))
+			backend.Listener.Close()
+			backend.Listener = listener
+			backend.Start()
+			defer backend.Close()
+
+			front := httptest.NewServer(newTestHTTPProxy(t, &ConfigOptions{
+				ForwardedHTTPAddress:     listener.Addr().String(),
+				ForwardedHTTPScheme:      "http",
+				ForwardedHTTPDialTimeout: 1,
+			}))
+			defer front.Close()
+
+			res, err := http.Get(front.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != http.StatusOK || string(body) != "backend" {
+				t.Errorf("GET = %d %q, want the backend response", res.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestHTTPTunnelHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "backend") // nolint:errcheck
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		disable bool
+		status  int
+		body    string
+	}{
+		{name: "enabled", status: http.StatusOK, body: "backend"},
+		{name: "disabled", disable: true, status: http.StatusForbidden, body: "HTTP tunnel is disabled on this device\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &ConfigOptions{
+				ForwardedHTTPAddress:     backend.Listener.Addr().String(),
+				ForwardedHTTPScheme:      "http",
+				ForwardedHTTPDialTimeout: 1,
+				DisableHTTPTunnel:        tt.disable,
+			}
+
+			front := httptest.NewServer(httpTunnelHandler(opts, newTestHTTPProxy(t, opts)))
+			defer front.Close()
+
+			res, err := http.Get(front.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.status || string(body) != tt.body {
+				t.Errorf("GET = %d %q, want %d %q", res.StatusCode, body, tt.status, tt.body)
+			}
+		})
+	}
+}
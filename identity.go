@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sourceCommandTimeout is the maximum time a command computing a device
+// attribute, such as its identity or hostname, can run.
+const sourceCommandTimeout = 30 * time.Second
+
+var (
+	ErrEmptyCommandOutput = errors.New("command output is empty")
+	ErrInvalidHostname    = errors.New("invalid hostname")
+)
+
+// hostnameRegexp matches the hostnames as defined by the RFC 1123, which the
+// server expects.
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,62}(\.[a-zA-Z0-9][a-zA-Z0-9-]{0,62})*$`)
+
+// validHostname reports whether hostname is a valid RFC 1123 hostname.
+func validHostname(hostname string) bool {
+	return len(hostname) <= 253 && hostnameRegexp.MatchString(hostname)
+}
+
+// runSourceCommand runs the command with the system shell, returning its
+// standard output trimmed of the surrounding white spaces.
+func runSourceCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sourceCommandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command) // nolint:gosec
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return "", err
+	}
+
+	value := strings.TrimSpace(string(output))
+	if value == "" {
+		return "", ErrEmptyCommandOutput
+	}
+
+	return value, nil
+}
+
+// preferredIdentity returns the device preferred identity, either the output of
+// the identity command or, when it fails or is not set, the static preferred
+// identity. Empty means the identity is the MAC address of the primary network
+// interface.
+func preferredIdentity(opts *ConfigOptions, run func(string) (string, error)) (string, error) {
+	if opts.PreferredIdentityCommand == "" {
+		return opts.PreferredIdentity, nil
+	}
+
+	id, err := run(opts.PreferredIdentityCommand)
+	if err != nil {
+		return opts.PreferredIdentity, err
+	}
+
+	return id, nil
+}
+
+// preferredHostname returns the device preferred hostname. Without a hostname
+// command, it is the static preferred hostname, empty meaning the server names
+// the device. Otherwise, it is the first valid hostname among the command
+// output, the static preferred hostname and the OS hostname, the errors met
+// being reported to warn.
+func preferredHostname(opts *ConfigOptions, run func(string) (string, error), warn func(err error, source string)) string {
+	if opts.PreferredHostnameCommand == "" {
+		return opts.PreferredHostname
+	}
+
+	hostname, err := run(opts.PreferredHostnameCommand)
+	if err == nil && !validHostname(hostname) {
+		err = fmt.Errorf("%w: %q", ErrInvalidHostname, hostname)
+	}
+
+	if err == nil {
+		return hostname
+	}
+
+	warn(err, "command")
+
+	if opts.PreferredHostname != "" {
+		if validHostname(opts.PreferredHostname) {
+			return opts.PreferredHostname
+		}
+
+		warn(fmt.Errorf("%w: %q", ErrInvalidHostname, opts.PreferredHostname), "preferred hostname")
+	}
+
+	hostname, err = os.Hostname()
+	if err == nil && !validHostname(hostname) {
+		err = fmt.Errorf("%w: %q", ErrInvalidHostname, hostname)
+	}
+
+	if err != nil {
+		warn(err, "os")
+
+		return ""
+	}
+
+	return hostname
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunSourceCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		err     string
+	}{
+		{name: "output", command: "echo serial-1234", want: "serial-1234"},
+		{name: "surrounding spaces", command: `printf '  serial-1234 \n\n'`, want: "serial-1234"},
+		{name: "empty output", command: "true", err: ErrEmptyCommandOutput.Error()},
+		{name: "blank output", command: "echo '   '", err: ErrEmptyCommandOutput.Error()},
+		{name: "failure", command: "exit 3", err: "exit status 3"},
+		{name: "failure message", command: "echo 'no serial' >&2; exit 1", err: "exit status 1: no serial"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runSourceCommand(tt.command)
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("runSourceCommand(%q) error = %v, want %q", tt.command, err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("runSourceCommand(%q) = %q, %v, want %q", tt.command, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSourceCommand returns a command runner answering with output, or with
+// err when set, recording the command it ran.
+func fakeSourceCommand(output string, err error, ran *string) func(string) (string, error) {
+	return func(command string) (string, error) {
+		*ran = command
+
+		return output, err
+	}
+}
+
+func TestPreferredIdentity(t *testing.T) {
+	failure := errors.New("command failed")
+
+	tests := []struct {
+		name     string
+		identity string
+		command  string
+		output   string
+		err      error
+		want     string
+	}{
+		{name: "unset", want: ""},
+		{name: "static", identity: "static-id", want: "static-id"},
+		{name: "command", identity: "static-id", command: "cat /serial", output: "serial-1234", want: "serial-1234"},
+		{name: "failed command", identity: "static-id", command: "cat /serial", err: failure, want: "static-id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran string
+
+			opts := &ConfigOptions{PreferredIdentity: tt.identity, PreferredIdentityCommand: tt.command}
+
+			got, err := preferredIdentity(opts, fakeSourceCommand(tt.output, tt.err, &ran))
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("preferredIdentity() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+
+			if ran != tt.command {
+				t.Errorf("preferredIdentity() ran %q, want %q", ran, tt.command)
+			}
+		})
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{hostname: "device", want: true},
+		{hostname: "device-01", want: true},
+		{hostname: "1device", want: true},
+		{hostname: "device.example.com", want: true},
+		{hostname: strings.Repeat("a", 63), want: true},
+		{hostname: "", want: false},
+		{hostname: "-device", want: false},
+		{hostname: "device_01", want: false},
+		{hostname: "device name", want: false},
+		{hostname: "device.", want: false},
+		{hostname: strings.Repeat("a", 64), want: false},
+		{hostname: strings.Repeat("a.", 127) + "a", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			if got := validHostname(tt.hostname); got != tt.want {
+				t.Errorf("validHostname(%q) = %v, want %v", tt.hostname, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferredHostname(t *testing.T) {
+	osHostname, err := os.Hostname()
+	if err != nil || !validHostname(osHostname) {
+		t.Skipf("the OS hostname %q is not valid", osHostname)
+	}
+
+	failure := errors.New("command failed")
+
+	tests := []struct {
+		name     string
+		hostname string
+		command  string
+		output   string
+		err      error
+		want     string
+		warnings []string
+	}{
+		{name: "unset", want: ""},
+		{name: "static", hostname: "static", want: "static"},
+		{name: "command", hostname: "static", command: "hostname-cmd", output: "from-command", want: "from-command"},
+		{name: "failed command", hostname: "static", command: "hostname-cmd", err: failure, want: "static", warnings: []string{"command"}},
+		{name: "invalid command output", hostname: "static", command: "hostname-cmd", output: "not valid", want: "static", warnings: []string{"command"}},
+		{name: "os hostname", command: "hostname-cmd", err: failure, want: osHostname, warnings: []string{"command"}},
+		{name: "invalid static", hostname: "not_valid", command: "hostname-cmd", err: failure, want: osHostname, warnings: []string{"command", "preferred hostname"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				ran      string
+				warnings []string
+			)
+
+			opts := &ConfigOptions{PreferredHostname: tt.hostname, PreferredHostnameCommand: tt.command}
+
+			got := preferredHostname(opts, fakeSourceCommand(tt.output, tt.err, &ran), func(err error, source string) {
+				warnings = append(warnings, source)
+			})
+			if got != tt.want {
+				t.Errorf("preferredHostname() = %q, want %q", got, tt.want)
+			}
+
+			if strings.Join(warnings, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("preferredHostname() warned about %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+}
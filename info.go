@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/control"
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+// redactedConfigKeys are the configuration options whose values are hidden in
+// the agent information.
+var redactedConfigKeys = map[string]bool{
+	"private_key":            true,
+	"private_key_passphrase": true,
+	"simple_user_password":   true,
+	"extra_password":         true,
+	"event_webhook_secret":   true,
+}
+
+// redactedValue replaces the value of the redacted configuration options.
+const redactedValue = "[REDACTED]"
+
+// agentInfo describes the agent, its configuration and the server it connects
+// to, as shown by the info command.
+type agentInfo struct {
+	Version   string                 `json:"version"`
+	GoVersion string                 `json:"go_version"`
+	TenantID  string                 `json:"tenant_id"`
+	Config    map[string]interface{} `json:"config"`
+	Server    *models.Info           `json:"server"`
+	// Runtime is the state of the running agent, queried through its control
+	// socket, omitted when it cannot be reached.
+	Runtime *agentStatus `json:"runtime,omitempty"`
+}
+
+func newAgentInfo(opts *ConfigOptions, serverInfo *models.Info) *agentInfo {
+	return &agentInfo{
+		Version:   AgentVersion,
+		GoVersion: runtime.Version(),
+		TenantID:  opts.TenantID,
+		Config:    redactedConfig(opts),
+		Server:    serverInfo,
+	}
+}
+
+// runningAgentStatus queries the state of the running agent through the control
+// socket at path.
+func runningAgentStatus(path string) (*agentStatus, error) {
+	result, err := control.Send(path, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	var status agentStatus
+	if err := json.Unmarshal(result, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// redactedConfig returns the configuration options keyed by their envconfig
+// names, hiding the values of the secret ones.
+func redactedConfig(opts *ConfigOptions) map[string]interface{} {
+	config := make(map[string]interface{})
+
+	v := reflect.ValueOf(opts).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("envconfig")
+		value := v.Field(i).Interface()
+
+		if redactedConfigKeys[key] && !v.Field(i).IsZero() {
+			value = redactedValue
+		}
+
+		// The extra headers are only redacted when sensitive, as they may
+		// also be routing hints useful to troubleshoot.
+		if key == "extra_headers" {
+			value = redactedHeaders(opts.ExtraHeaders)
+		}
+
+		config[key] = value
+	}
+
+	return config
+}
+
+// writeJSON writes the agent information to w as a single JSON object.
+func (i *agentInfo) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(i)
+}
+
+// writeText writes the agent information to w in a human readable form.
+func (i *agentInfo) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Agent version: %s\n", i.Version)
+	fmt.Fprintf(w, "Go version: %s\n", i.GoVersion)
+	fmt.Fprintf(w, "Server address: %s\n", i.Config["server_address"])
+	fmt.Fprintf(w, "Tenant ID: %s\n", i.TenantID)
+
+	if i.Server != nil {
+		fmt.Fprintf(w, "Server version: %s\n", i.Server.Version)
+		fmt.Fprintf(w, "API endpoint: %s\n", i.Server.Endpoints.API)
+		fmt.Fprintf(w, "SSH endpoint: %s\n", i.Server.Endpoints.SSH)
+	}
+
+	if i.Runtime != nil {
+		fmt.Fprintf(w, "Uptime: %s\n", time.Duration(i.Runtime.Uptime)*time.Second)
+
+		if i.Runtime.LastAuthorizedAt != nil {
+			fmt.Fprintf(w, "Last authorization: %s (%s ago)\n",
+				i.Runtime.LastAuthorizedAt.Format(time.RFC3339),
+				clock.Now().Sub(*i.Runtime.LastAuthorizedAt).Round(time.Second),
+			)
+		} else {
+			fmt.Fprintln(w, "Last authorization: never")
+		}
+
+		fmt.Fprintf(w, "Active sessions: %d\n", i.Runtime.Sessions)
+		fmt.Fprintf(w, "Reconnects: %d\n", i.Runtime.Reconnects)
+
+		if rtt := i.Runtime.RTT; rtt != nil {
+			fmt.Fprintf(w, "Server round-trip time: min %.1f ms, avg %.1f ms, max %.1f ms\n", rtt.Min, rtt.Avg, rtt.Max)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/control"
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	"github.com/brycedjohnson/shellhub-agent/server"
+)
+
+func newTestAgentInfo() *agentInfo {
+	return newAgentInfo(&ConfigOptions{
+		ServerAddress:        "https://cloud.shellhub.io",
+		TenantID:             "tenant",
+		PrivateKey:           "/etc/shellhub.key",
+		PrivateKeyPassphrase: "passphrase",
+		SingleUserPassword:   "$2a$04$hash",
+		ExtraHeaders:         []string{"X-Api-Key=api-secret", "X-Route=eu"},
+		KeepAliveInterval:    30,
+	}, &models.Info{
+		Version:   "1.0.0",
+		Endpoints: models.Endpoints{API: "cloud.shellhub.io:443", SSH: "cloud.shellhub.io:22"},
+	})
+}
+
+func TestAgentInfoJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := newTestAgentInfo().writeJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var info struct {
+		Version   string                 `json:"version"`
+		GoVersion string                 `json:"go_version"`
+		TenantID  string                 `json:"tenant_id"`
+		Config    map[string]interface{} `json:"config"`
+		Server    *models.Info           `json:"server"`
+		Runtime   *json.RawMessage       `json:"runtime"`
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("writeJSON() wrote invalid JSON: %v\n%s", err, out.String())
+	}
+
+	if info.TenantID != "tenant" || info.GoVersion == "" {
+		t.Errorf("tenant id = %q, go version = %q, want tenant and the go version", info.TenantID, info.GoVersion)
+	}
+
+	if info.Server == nil || info.Server.Version != "1.0.0" || info.Server.Endpoints.SSH != "cloud.shellhub.io:22" {
+		t.Errorf("server = %+v, want the server information", info.Server)
+	}
+
+	if info.Runtime != nil {
+		t.Errorf("runtime = %s, want it omitted when the agent is not running", *info.Runtime)
+	}
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{key: "server_address", want: "https://cloud.shellhub.io"},
+		{key: "keepalive_interval", want: float64(30)},
+		{key: "private_key", want: redactedValue},
+		{key: "private_key_passphrase", want: redactedValue},
+		{key: "private_key_passphrase_file", want: ""},
+		{key: "simple_user_password", want: redactedValue},
+		{key: "extra_password", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := info.Config[tt.key]; got != tt.want {
+			t.Errorf("config %s = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+	// The extra headers are only redacted when sensitive.
+	headers := []interface{}{"X-Api-Key=" + redactedValue, "X-Route=eu"}
+	if got := info.Config["extra_headers"]; !reflect.DeepEqual(got, headers) {
+		t.Errorf("config extra_headers = %v, want %v", got, headers)
+	}
+
+	if strings.Contains(out.String(), "api-secret") {
+		t.Error("writeJSON() leaked a sensitive extra header")
+	}
+
+	if strings.Contains(out.String(), "$2a$04$hash") {
+		t.Error("writeJSON() leaked the single-user password")
+	}
+
+	if strings.Contains(out.String(), `"passphrase"`) {
+		t.Error("writeJSON() leaked the private key passphrase")
+	}
+}
+
+func TestAgentInfoText(t *testing.T) {
+	var out bytes.Buffer
+	newTestAgentInfo().writeText(&out)
+
+	for _, want := range []string{
+		"Server address: https://cloud.shellhub.io\n",
+		"Tenant ID: tenant\n",
+		"Server version: 1.0.0\n",
+		"SSH endpoint: cloud.shellhub.io:22\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeText() misses %q:\n%s", want, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "Uptime") {
+		t.Errorf("writeText() shows the runtime state when the agent is not running:\n%s", out.String())
+	}
+}
+
+func TestAgentInfoTextRuntime(t *testing.T) {
+	fake := setFakeClock(t)
+
+	authorizedAt := fake.now.Add(-5 * time.Minute)
+
+	tests := []struct {
+		name         string
+		authorizedAt *time.Time
+		want         []string
+	}{
+		{
+			name:         "authorized",
+			authorizedAt: &authorizedAt,
+			want: []string{
+				"Uptime: 1h30m0s\n",
+				"Last authorization: 2022-12-31T23:55:00Z (5m0s ago)\n",
+				"Active sessions: 2\n",
+				"Reconnects: 3\n",
+				"Server round-trip time: min 12.5 ms, avg 20.0 ms, max 31.2 ms\n",
+			},
+		},
+		{
+			name: "never authorized",
+			want: []string{"Last authorization: never\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newTestAgentInfo()
+			info.Runtime = &agentStatus{Uptime: 5400, LastAuthorizedAt: tt.authorizedAt, Sessions: 2, Reconnects: 3}
+			if tt.authorizedAt != nil {
+				info.Runtime.RTT = &rttSummary{Samples: 3, Min: 12.5, Avg: 20, Max: 31.25}
+			}
+
+			var out bytes.Buffer
+			info.writeText(&out)
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("writeText() misses %q:\n%s", want, out.String())
+				}
+			}
+
+			if tt.authorizedAt == nil && strings.Contains(out.String(), "round-trip time") {
+				t.Errorf("writeText() reports a round-trip time without any measured:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestRunningAgentStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	if _, err := runningAgentStatus(path); err == nil {
+		t.Error("runningAgentStatus() = nil error without a running agent")
+	}
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := newTestHTTPProxy(t, &ConfigOptions{
+		ForwardedHTTPAddress:     "127.0.0.1:80",
+		ForwardedHTTPScheme:      "http",
+		ForwardedHTTPDialTimeout: 1,
+	})
+
+	listener, err := control.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	go newControlServer(agent, server.NewServer(nil, nil, "", 0, ""), proxy, "").Serve(listener) // nolint:errcheck
+
+	status, err := runningAgentStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.ServerAddress != "http://localhost" || status.Version != AgentVersion {
+		t.Errorf("runningAgentStatus() = %+v, want the status of the running agent", status)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+)
+
+// Causes of the agent initialization failures.
+var (
+	ErrKeyNotFound       = errors.New("private key not found")
+	ErrServerUnreachable = errors.New("server is unreachable")
+	ErrServerError       = errors.New("server failed to handle the request")
+	ErrAuthRejected      = errors.New("server rejected the device authentication")
+	ErrTenantInvalid     = errors.New("tenant id does not match any namespace")
+	ErrProbeTimeout      = errors.New("server did not answer the probe in time")
+	ErrHandshakeTimeout  = errors.New("server did not complete the handshake in time")
+)
+
+// requestError maps the error of a request to the server to the typed error of
+// its cause: a network failure or the server answer status. Other errors are
+// returned as they are.
+func requestError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var status *client.StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrTenantInvalid, err)
+		case status.StatusCode >= http.StatusInternalServerError, status.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("%w: %s", ErrServerError, err)
+		default:
+			return fmt.Errorf("%w: %s", ErrAuthRejected, err)
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %s", ErrServerUnreachable, err)
+	}
+
+	return err
+}
+
+// retryable reports whether the failed request may succeed when retried: the
+// network failures and server errors are transient, while a rejected device
+// authentication or an unknown tenant are not.
+func retryable(err error) bool {
+	return !errors.Is(err, ErrAuthRejected) && !errors.Is(err, ErrTenantInvalid)
+}
+
+// initErrorHints are the remediation hints of the initialization failures.
+var initErrorHints = []struct {
+	err  error
+	hint string
+}{
+	{ErrKeyNotFound, "generate the key with the keygen command, or enable SHELLHUB_GENERATE_KEY_IF_MISSING"},
+	{keygen.ErrPassphraseRequired, "set the key passphrase in SHELLHUB_PRIVATE_KEY_PASSPHRASE or SHELLHUB_PRIVATE_KEY_PASSPHRASE_FILE"},
+	{keygen.ErrWrongPassphrase, "check the key passphrase set in SHELLHUB_PRIVATE_KEY_PASSPHRASE or SHELLHUB_PRIVATE_KEY_PASSPHRASE_FILE"},
+	{ErrServerUnreachable, "run the doctor command to diagnose the connectivity with the server"},
+	{ErrProbeTimeout, "the server is slow or unreachable, run the doctor command or raise SHELLHUB_PROBE_TIMEOUT"},
+	{ErrHandshakeTimeout, "the server is slow or unreachable, run the doctor command or raise SHELLHUB_HANDSHAKE_TIMEOUT"},
+	{ErrServerError, "the server may be temporarily unavailable, otherwise contact its administrator"},
+	{ErrAuthRejected, "check that the device is not removed or rejected in the namespace"},
+	{ErrTenantInvalid, "check that the tenant id is the one shown in the namespace settings"},
+	{ErrClockSkew, "synchronize the device clock, e.g. with NTP"},
+}
+
+// initErrorHint returns the remediation hint of the initialization failure, or
+// an empty string when its cause is unknown.
+func initErrorHint(err error) string {
+	for _, h := range initErrorHints {
+		if errors.Is(err, h.err) {
+			return h.hint
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+)
+
+func TestRequestError(t *testing.T) {
+	other := errors.New("other")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "none"},
+		{name: "not found", err: &client.StatusError{StatusCode: http.StatusNotFound}, want: ErrTenantInvalid},
+		{name: "unauthorized", err: &client.StatusError{StatusCode: http.StatusUnauthorized}, want: ErrAuthRejected},
+		{name: "forbidden", err: &client.StatusError{StatusCode: http.StatusForbidden}, want: ErrAuthRejected},
+		{name: "server error", err: &client.StatusError{StatusCode: http.StatusBadGateway}, want: ErrServerError},
+		{name: "rate limited", err: &client.StatusError{StatusCode: http.StatusTooManyRequests}, want: ErrServerError},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: ErrServerUnreachable},
+		{name: "other", err: other, want: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := requestError(tt.err); !errors.Is(err, tt.want) {
+				t.Errorf("requestError() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	status := func(code int) error {
+		return requestError(&client.StatusError{StatusCode: code})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: status(http.StatusInternalServerError), want: true},
+		{name: "rate limited", err: status(http.StatusTooManyRequests), want: true},
+		{name: "network", err: requestError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), want: true},
+		{name: "handshake timeout", err: ErrHandshakeTimeout, want: true},
+		{name: "rejected", err: status(http.StatusUnauthorized)},
+		{name: "unknown tenant", err: status(http.StatusNotFound)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitErrorHint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "key not found", err: ErrKeyNotFound, want: "generate the key with the keygen command, or enable SHELLHUB_GENERATE_KEY_IF_MISSING"},
+		{name: "wrapped", err: requestError(&client.StatusError{StatusCode: http.StatusNotFound}), want: "check that the tenant id is the one shown in the namespace settings"},
+		{name: "passphrase required", err: keygen.ErrPassphraseRequired, want: "set the key passphrase in SHELLHUB_PRIVATE_KEY_PASSPHRASE or SHELLHUB_PRIVATE_KEY_PASSPHRASE_FILE"},
+		{name: "probe timeout", err: fmt.Errorf("%w: no answer within 30 seconds", ErrProbeTimeout), want: "the server is slow or unreachable, run the doctor command or raise SHELLHUB_PROBE_TIMEOUT"},
+		{name: "unknown", err: errors.New("unknown")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := initErrorHint(tt.err); got != tt.want {
+				t.Errorf("initErrorHint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPublicKeyNotFound(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{
+		ServerAddress: "http://localhost",
+		TenantID:      "tenant",
+		PrivateKey:    filepath.Join(t.TempDir(), "missing.key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := agent.readPublicKey(); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("readPublicKey() = %v, want %v", err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var ErrKeyExists = errors.New("private key already exists")
+
+// generateKey generates a device private key at path, encrypted with passphrase
+// when it is not empty, writing its public key and fingerprint to w. An
+// existing key is only replaced when force is set, the new key being written
+// aside and renamed over it.
+func generateKey(w io.Writer, path string, force bool, passphrase []byte) error {
+	_, err := os.Stat(path)
+
+	switch {
+	case os.IsNotExist(err):
+		if err := keygen.GenerateEncryptedPrivateKey(path, passphrase); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !force:
+		return fmt.Errorf("%w: %s, use --force to overwrite it", ErrKeyExists, path)
+	default:
+		tmp := path + ".new"
+
+		os.Remove(tmp) // nolint:errcheck
+
+		if err := keygen.GenerateEncryptedPrivateKey(tmp, passphrase); err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp) // nolint:errcheck
+
+			return err
+		}
+	}
+
+	// The key is read back as the agent does, so it is known to be usable.
+	key, err := keygen.ReadPrivateKey(path, passphrase)
+	if err != nil {
+		return err
+	}
+
+	pub, err := gossh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Private key written to %s\n", path)
+	fmt.Fprintf(w, "Fingerprint: %s\n", gossh.FingerprintSHA256(pub))
+	fmt.Fprintf(w, "%s", keygen.EncodePublicKeyToPem(&key.PublicKey))
+
+	return nil
+}
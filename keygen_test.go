@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+)
+
+func TestGenerateKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase []byte
+	}{
+		{name: "plain"},
+		{name: "encrypted", passphrase: []byte("passphrase")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "shellhub.key")
+
+			var out bytes.Buffer
+			if err := generateKey(&out, path, false, tt.passphrase); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := keygen.ReadPrivateKey(path, tt.passphrase); err != nil {
+				t.Errorf("ReadPrivateKey() = %v, want the generated key readable", err)
+			}
+
+			for _, want := range []string{"Private key written to " + path + "\n", "Fingerprint: SHA256:", "-----BEGIN RSA PUBLIC KEY-----"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("generateKey() output misses %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateKeyExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shellhub.key")
+
+	if err := keygen.GeneratePrivateKey(path); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := generateKey(&out, path, false, nil); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("generateKey() = %v without force, want %v", err, ErrKeyExists)
+	}
+
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, existing) {
+		t.Error("generateKey() replaced the existing key without force")
+	}
+
+	if err := generateKey(&out, path, true, nil); err != nil {
+		t.Fatalf("generateKey() = %v with force", err)
+	}
+
+	if data, _ := os.ReadFile(path); bytes.Equal(data, existing) {
+		t.Error("generateKey() kept the existing key with force")
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("replaced key = %v, %v, want it readable only by its owner", fi.Mode(), err)
+	}
+
+	if _, err := os.Stat(path + ".new"); !os.IsNotExist(err) {
+		t.Errorf("temporary key = %v, want it renamed over the existing key", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidLogFormat          = errors.New("invalid log format")
+	ErrInvalidLogTimestampFormat = errors.New("invalid log timestamp format")
+)
+
+// epochFormatter wraps a formatter to report the entry time as seconds since
+// the Unix epoch.
+type epochFormatter struct {
+	log.Formatter
+}
+
+func (f *epochFormatter) Format(entry *log.Entry) ([]byte, error) {
+	entry.Data[log.FieldKeyTime] = entry.Time.Unix()
+
+	return f.Formatter.Format(entry)
+}
+
+// newLogFormatter creates the log formatter for the given format, 'text' or
+// 'json', and timestamp format, 'rfc3339' or 'epoch'.
+func newLogFormatter(format, timestampFormat string) (log.Formatter, error) {
+	epoch := false
+
+	switch timestampFormat {
+	case "rfc3339":
+	case "epoch":
+		epoch = true
+	default:
+		return nil, ErrInvalidLogTimestampFormat
+	}
+
+	// With the timestamp disabled, its key is moved away, so the epoch time
+	// field is not renamed as clashing with it.
+	fieldMap := log.FieldMap{}
+	if epoch {
+		fieldMap[log.FieldKeyTime] = "timestamp"
+	}
+
+	var formatter log.Formatter
+
+	switch format {
+	case "text":
+		formatter = &log.TextFormatter{
+			FullTimestamp:    true,
+			TimestampFormat:  time.RFC3339,
+			DisableTimestamp: epoch,
+			FieldMap:         fieldMap,
+		}
+	case "json":
+		formatter = &log.JSONFormatter{
+			TimestampFormat:  time.RFC3339,
+			DisableTimestamp: epoch,
+			FieldMap:         fieldMap,
+		}
+	default:
+		return nil, ErrInvalidLogFormat
+	}
+
+	if epoch {
+		return &epochFormatter{formatter}, nil
+	}
+
+	return formatter, nil
+}
+
+// failureLogger collapses the logging of repeated identical failures. The first
+// failure with each error is logged as usual, while the following ones with an
+// error already seen since the last success are only summarized, with the
+// number of attempts, every interval. A zero interval logs every failure.
+type failureLogger struct {
+	logger   log.FieldLogger
+	interval time.Duration
+	now      func() time.Time
+
+	seen     map[string]bool
+	attempts int
+	logged   time.Time
+}
+
+func newFailureLogger(logger log.FieldLogger, interval time.Duration) *failureLogger {
+	return &failureLogger{
+		logger:   logger,
+		interval: interval,
+		now:      time.Now,
+		seen:     make(map[string]bool),
+	}
+}
+
+// failure logs a failed attempt with msg.
+func (l *failureLogger) failure(err error, fields log.Fields, msg string) {
+	l.attempts++
+
+	now := l.now()
+	entry := l.logger.WithError(err).WithFields(fields)
+
+	if l.interval <= 0 || !l.seen[err.Error()] {
+		l.seen[err.Error()] = true
+		l.logged = now
+
+		entry.Warn(msg)
+
+		return
+	}
+
+	if now.Sub(l.logged) < l.interval {
+		entry.Debug(msg)
+
+		return
+	}
+
+	l.logged = now
+
+	entry.WithField("attempts", l.attempts).Warn("Still failing: " + msg)
+}
+
+// success resets the failures, returning how many attempts have failed since
+// the last success.
+func (l *failureLogger) success() int {
+	attempts := l.attempts
+
+	l.seen = make(map[string]bool)
+	l.attempts = 0
+
+	return attempts
+}
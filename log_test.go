@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNewLogFormatter(t *testing.T) {
+	entry := &log.Entry{
+		Time:    time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   log.InfoLevel,
+		Message: "hello",
+		Data:    log.Fields{"sshid": "namespace.device@localhost"},
+	}
+
+	tests := []struct {
+		name            string
+		format          string
+		timestampFormat string
+		want            []string
+		err             error
+	}{
+		{
+			name:            "text",
+			format:          "text",
+			timestampFormat: "rfc3339",
+			want:            []string{`time="2023-01-02T03:04:05Z"`, `msg=hello`, `sshid=namespace.device@localhost`},
+		},
+		{
+			name:            "text epoch",
+			format:          "text",
+			timestampFormat: "epoch",
+			want:            []string{` time=1672628645`, `msg=hello`},
+		},
+		{
+			name:            "json",
+			format:          "json",
+			timestampFormat: "rfc3339",
+			want:            []string{`"time":"2023-01-02T03:04:05Z"`, `"msg":"hello"`, `"level":"info"`},
+		},
+		{
+			name:            "json epoch",
+			format:          "json",
+			timestampFormat: "epoch",
+			want:            []string{`"time":1672628645`, `"msg":"hello"`},
+		},
+		{name: "invalid format", format: "xml", timestampFormat: "rfc3339", err: ErrInvalidLogFormat},
+		{name: "invalid timestamp format", format: "json", timestampFormat: "unix", err: ErrInvalidLogTimestampFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatter, err := newLogFormatter(tt.format, tt.timestampFormat)
+			if err != tt.err {
+				t.Fatalf("newLogFormatter() error = %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			e := *entry
+			e.Data = log.Fields{}
+			for key, value := range entry.Data {
+				e.Data[key] = value
+			}
+
+			out, err := formatter.Format(&e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.format == "json" && !json.Valid(out) {
+				t.Errorf("Format() = %s, want valid JSON", out)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(" "+string(out), want) {
+					t.Errorf("Format() = %s, want it to contain %s", out, want)
+				}
+			}
+
+			if strings.Contains(string(out), "fields.") {
+				t.Errorf("Format() = %s, want no field renamed as clashing", out)
+			}
+		})
+	}
+}
+
+func TestFailureLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetLevel(log.DebugLevel)
+	logger.SetFormatter(&log.JSONFormatter{})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := newFailureLogger(logger, time.Minute)
+	l.now = func() time.Time { return now }
+
+	refused := errors.New("connection refused")
+	timeout := errors.New("timeout")
+
+	steps := []struct {
+		name     string
+		err      error
+		elapsed  time.Duration
+		level    string
+		msg      string
+		attempts float64
+	}{
+		{name: "first failure", err: refused, level: "warning", msg: "Failed to connect"},
+		{name: "repeated failure", err: refused, elapsed: 10 * time.Second, level: "debug", msg: "Failed to connect"},
+		{name: "other error", err: timeout, elapsed: 10 * time.Second, level: "warning", msg: "Failed to connect"},
+		{name: "repeated within interval", err: refused, elapsed: 30 * time.Second, level: "debug", msg: "Failed to connect"},
+		{name: "summary", err: refused, elapsed: 30 * time.Second, level: "warning", msg: "Still failing: Failed to connect", attempts: 5},
+		{name: "after summary", err: timeout, elapsed: time.Second, level: "debug", msg: "Failed to connect"},
+	}
+
+	for _, step := range steps {
+		buf.Reset()
+		now = now.Add(step.elapsed)
+
+		l.failure(step.err, log.Fields{"server_address": "https://cloud.shellhub.io"}, "Failed to connect")
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if entry["level"] != step.level || entry["msg"] != step.msg || entry["error"] != step.err.Error() {
+			t.Errorf("%s: logged %v, want %s %q", step.name, entry, step.level, step.msg)
+		}
+
+		if attempts, _ := entry["attempts"].(float64); attempts != step.attempts {
+			t.Errorf("%s: attempts = %v, want %v", step.name, entry["attempts"], step.attempts)
+		}
+	}
+
+	if attempts := l.success(); attempts != len(steps) {
+		t.Errorf("success() = %d, want %d", attempts, len(steps))
+	}
+
+	// The errors are logged again after a success.
+	buf.Reset()
+	l.failure(refused, nil, "Failed to connect")
+
+	if !strings.Contains(buf.String(), `"level":"warning"`) {
+		t.Errorf("failure after a success logged %q, want a warning", buf.String())
+	}
+}
+
+func TestFailureLoggerWithoutInterval(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := log.New()
+	logger.SetOutput(&buf)
+
+	l := newFailureLogger(logger, 0)
+
+	for i := 0; i < 3; i++ {
+		l.failure(errors.New("connection refused"), nil, "Failed to connect")
+	}
+
+	if count := strings.Count(buf.String(), "level=warning"); count != 3 {
+		t.Errorf("logged %d warnings, want 3:\n%s", count, buf.String())
+	}
+}
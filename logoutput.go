@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syslogTag is the tag of the agent logs sent to syslog.
+const syslogTag = "shellhub-agent"
+
+// setLogOutput directs the logs of logger to the output: 'stderr', 'stdout',
+// 'syslog' or a file path, rotating the file once it exceeds maxSize bytes
+// when maxSize is positive. It returns the log file, when the output is a
+// file, so it can be reopened.
+func setLogOutput(logger *log.Logger, output string, maxSize int64) (*logFile, error) {
+	switch output {
+	case "", "stderr":
+		logger.SetOutput(os.Stderr)
+	case "stdout":
+		logger.SetOutput(os.Stdout)
+	case "syslog":
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, syslogTag)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.SetOutput(io.Discard)
+		logger.AddHook(&syslogHook{writer: writer})
+	default:
+		file, err := openLogFile(output, maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.SetOutput(file)
+
+		return file, nil
+	}
+
+	return nil, nil
+}
+
+// syslogHook sends the log entries to syslog with the priority matching their
+// level.
+type syslogHook struct {
+	writer *syslog.Writer
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return h.writer.Crit(line)
+	case log.ErrorLevel:
+		return h.writer.Err(line)
+	case log.WarnLevel:
+		return h.writer.Warning(line)
+	case log.InfoLevel:
+		return h.writer.Info(line)
+	default:
+		return h.writer.Debug(line)
+	}
+}
+
+// logFile is a log file, appended to, which is rotated once it exceeds its
+// maximum size, keeping a single rotated file with the '.1' suffix.
+type logFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func openLogFile(path string, maxSize int64) (*logFile, error) {
+	f := &logFile{path: path, maxSize: maxSize}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *logFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+func (f *logFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// rotate renames the log file with the '.1' suffix, replacing the previously
+// rotated one, and opens a new log file.
+func (f *logFile) rotate() error {
+	f.file.Close()
+
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// Reopen closes and opens the log file again, e.g. once it has been moved by
+// an external log rotation.
+func (f *logFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.file.Close()
+
+	return f.open()
+}
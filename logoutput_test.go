@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSetLogOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	tests := []struct {
+		name   string
+		output string
+		want   interface{}
+	}{
+		{name: "default", want: os.Stderr},
+		{name: "stderr", output: "stderr", want: os.Stderr},
+		{name: "stdout", output: "stdout", want: os.Stdout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := log.New()
+
+			file, err := setLogOutput(logger, tt.output, 0)
+			if err != nil || file != nil {
+				t.Fatalf("setLogOutput() = %v, %v, want no log file", file, err)
+			}
+
+			if logger.Out != tt.want {
+				t.Errorf("log output = %v, want %v", logger.Out, tt.want)
+			}
+		})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		logger := log.New()
+		logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+
+		file, err := setLogOutput(logger, path, 0)
+		if err != nil || file == nil {
+			t.Fatalf("setLogOutput() = %v, %v, want the log file", file, err)
+		}
+
+		defer file.file.Close()
+
+		logger.Info("to the file")
+
+		if data, _ := os.ReadFile(path); !strings.Contains(string(data), "to the file") {
+			t.Errorf("log file = %q, want the log entry", data)
+		}
+	})
+
+	t.Run("file in a missing directory", func(t *testing.T) {
+		if _, err := setLogOutput(log.New(), filepath.Join(path, "missing", "agent.log"), 0); err == nil {
+			t.Error("setLogOutput() = nil error, want the log file failing to open")
+		}
+	})
+}
+
+func TestLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	file, err := openLogFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No two lines fit in the maximum size, so each write rotates the file,
+	// only the last rotated file being kept.
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: path, want: "third\n"},
+		{path: path + ".1", want: "second\n"},
+	}
+
+	for _, tt := range tests {
+		if data, err := os.ReadFile(tt.path); err != nil || string(data) != tt.want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(tt.path), data, err, tt.want)
+		}
+	}
+}
+
+func TestLogFileRotationOversizedEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	file, err := openLogFile(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.file.Close()
+
+	// An entry larger than the maximum size is written to an empty file
+	// rather than rotating it.
+	if _, err := file.Write([]byte("oversized\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("rotated file = %v, want the empty file not rotated", err)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.log")
+
+	file, err := openLogFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { file.file.Close() }()
+
+	if _, err := file.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An external log rotation moves the file away, then asks the agent to
+	// reopen it.
+	moved := filepath.Join(dir, "agent.log.old")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: moved, want: "before\n"},
+		{path: path, want: "after\n"},
+	}
+
+	for _, tt := range tests {
+		if data, err := os.ReadFile(tt.path); err != nil || string(data) != tt.want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(tt.path), data, err, tt.want)
+		}
+	}
+}
@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/brycedjohnson/shellhub-agent/pkg/tunnel"
+	"github.com/brycedjohnson/shellhub-agent/pkg/sftp"
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+	"github.com/brycedjohnson/shellhub-agent/pkg/webhook"
 	"github.com/brycedjohnson/shellhub-agent/server"
 
+	"github.com/brycedjohnson/shellhub-agent/pkg/backoff"
 	"github.com/brycedjohnson/shellhub-agent/pkg/loglevel"
+	"github.com/brycedjohnson/shellhub-agent/pkg/ratelimit"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -24,18 +33,44 @@ import (
 // main.AgentVersion=1.2.3"`).
 //
 // If set to `latest`, the auto-updating mechanism is disabled. This is intended
-// to be used during development only.
+// to be used during development only. See the UpdateURL configuration option.
 var AgentVersion string
 
+// UpdatePublicKey is the base64 encoded Ed25519 public key the agent update
+// binaries are signed with. It is injected using `-ldflags` as AgentVersion,
+// and can be overridden by the UpdatePublicKey configuration option.
+var UpdatePublicKey string
+
 // ConfigOptions provides the configuration for the agent service. The values are load from
 // the system environment and control multiple aspects of the service.
 type ConfigOptions struct {
-	// Set the ShellHub Cloud server address the agent will use to connect.
+	// Set the ShellHub Cloud server address the agent will use to connect. A
+	// comma-separated list of addresses can be set for failover: the agent
+	// connects to the first available server, in the listed order, and
+	// switches to the next one when it fails.
 	ServerAddress string `envconfig:"server_address" required:"true"`
 
 	// Specify the path to the device private key.
 	PrivateKey string `envconfig:"private_key" required:"true"`
 
+	// Generate a new private key at the private key path when it is missing,
+	// e.g. on the first boot. An existing key is never overwritten. When
+	// disabled, a missing key is an error. Default is true.
+	GenerateKeyIfMissing bool `envconfig:"generate_key_if_missing" default:"true"`
+
+	// Report the device hardware and OS facts, such as the kernel version,
+	// total RAM, CPU model and count and primary MAC address, to the server
+	// inventory on authorization. Default is true.
+	ReportFacts bool `envconfig:"report_facts" default:"true"`
+
+	// Set the passphrase of the private key, when it is encrypted. A generated
+	// key is encrypted with it.
+	PrivateKeyPassphrase string `envconfig:"private_key_passphrase"`
+
+	// Set the path to a file holding the passphrase of the private key, used
+	// instead of the passphrase set in the configuration.
+	PrivateKeyPassphraseFile string `envconfig:"private_key_passphrase_file"`
+
 	// Sets the account tenant id used during communication to associate the
 	// device to a specific tenant.
 	TenantID string `envconfig:"tenant_id" required:"true"`
@@ -45,36 +80,588 @@ type ConfigOptions struct {
 	// state. Default is 30 seconds.
 	KeepAliveInterval int `envconfig:"keepalive_interval" default:"30"`
 
+	// Enable the TCP keep-alive of the connections to the server, with the
+	// settings below instead of the Go defaults, so dead connections behind a
+	// NAT or firewall are dropped sooner. Default is false.
+	TCPKeepAlive bool `envconfig:"tcp_keepalive" default:"false"`
+
+	// Set the time, in seconds, a connection to the server stays idle before
+	// the first TCP keep-alive probe. Default is 60 seconds.
+	TCPKeepAliveIdle int `envconfig:"tcp_keepalive_idle" default:"60"`
+
+	// Set the time, in seconds, between the TCP keep-alive probes. Default is
+	// 10 seconds.
+	TCPKeepAliveInterval int `envconfig:"tcp_keepalive_interval" default:"10"`
+
+	// Set how many TCP keep-alive probes must be unanswered before the
+	// connection to the server is dropped. Default is 6.
+	TCPKeepAliveCount int `envconfig:"tcp_keepalive_count" default:"6"`
+
+	// Set the fraction of the keep alive interval by which each interval is
+	// randomized, in either direction, spreading the keep alive messages of
+	// devices started together. Must be between 0 and 1. Default is 0, which
+	// keeps the exact interval.
+	KeepAliveJitter float64 `envconfig:"keepalive_jitter" default:"0"`
+
 	// Set the device preferred hostname. This provides a hint to the server to
 	// use this as hostname if it is available.
 	PreferredHostname string `envconfig:"preferred_hostname"`
 
+	// Set the command, run with the system shell, whose output is used as the
+	// device preferred hostname. When it fails, or its output is not a valid
+	// hostname, the preferred hostname is used instead, falling back to the OS
+	// hostname.
+	PreferredHostnameCommand string `envconfig:"preferred_hostname_command"`
+
 	// Set the device preferred identity. This provides a hint to the server to
 	// use this identity if it is available.
 	PreferredIdentity string `envconfig:"preferred_identity" default:""`
 
+	// Set a suffix appended to the User-Agent of the requests to the server,
+	// which identifies the agent version and platform, e.g. to tag custom
+	// builds.
+	UserAgentSuffix string `envconfig:"user_agent_suffix"`
+
+	// Set the command, run with the system shell, whose output is used as the
+	// device preferred identity, e.g. to read a serial number from the
+	// hardware. When it fails, the preferred identity is used instead.
+	PreferredIdentityCommand string `envconfig:"preferred_identity_command"`
+
 	// Set password for single-user mode (without root privileges). If not provided,
 	// multi-user mode (with root privileges) is enabled by default.
 	// NOTE: The password hash could be generated by ```openssl passwd```.
 	SingleUserPassword string `envconfig:"simple_user_password"`
 
+	// Set how many failed password authentications of a client within the
+	// password lockout window lock it out. The clients are told apart by the
+	// address the server reports, the ones without it are never locked out.
+	// Default is 0, meaning no lockout.
+	PasswordLockoutThreshold int `envconfig:"password_lockout_threshold" default:"0"`
+
+	// Set the window, in seconds, the failed password authentications are
+	// counted over. Default is 300 seconds.
+	PasswordLockoutWindow int `envconfig:"password_lockout_window" default:"300"`
+
+	// Set the time, in seconds, a client is locked out for, doubling on each
+	// new lockout of the client, up to an hour. Default is 60 seconds.
+	PasswordLockoutCooldown int `envconfig:"password_lockout_cooldown" default:"60"`
+
+	// Allow the single-user mode when running as root, e.g. in containers
+	// running as root without OS accounts, where the sessions run as root.
+	// Default is false, which refuses to start.
+	AllowRootSingleUser bool `envconfig:"allow_root_single_user" default:"false"`
+
+	// Set the program, with its arguments, started for the interactive
+	// sessions in single-user mode instead of the user shell, such as a
+	// restricted menu, e.g. '/usr/bin/menu --restricted'.
+	SingleUserShell string `envconfig:"single_user_shell"`
+
 	// Log level to use. Valid values are 'info', 'warning', 'error', 'debug', and 'trace'.
 	LogLevel string `envconfig:"log_level" default:"info"`
-}
 
-// NewAgentServer creates a new agent server instance.
-func NewAgentServer() *Agent { // nolint:gocyclo
-	opts := ConfigOptions{}
+	// Log format to use. Valid values are 'text' and 'json'.
+	LogFormat string `envconfig:"log_format" default:"text"`
+
+	// Log timestamp format to use. Valid values are 'rfc3339' and 'epoch'.
+	LogTimestampFormat string `envconfig:"log_timestamp_format" default:"rfc3339"`
+
+	// Log output to use. Valid values are 'stderr', 'stdout', 'syslog' and a
+	// file path. A log file is appended to and reopened on SIGHUP, so it can
+	// be rotated by logrotate. Default is stderr.
+	LogOutput string `envconfig:"log_output" default:"stderr"`
+
+	// Set the maximum size, in megabytes, of the log file before it is
+	// rotated, keeping a single rotated file with the '.1' suffix. A value of
+	// 0 disables the rotation. Default is 0.
+	LogMaxSize int `envconfig:"log_max_size" default:"0"`
+
+	// Disable the HTTP tunnel, refusing the requests to reach the device HTTP
+	// services through the agent. SSH and SFTP are not affected.
+	DisableHTTPTunnel bool `envconfig:"disable_http_tunnel" default:"false"`
+
+	// Log the method, path, status and duration of each request through the
+	// HTTP tunnel, never its body, e.g. to debug a device web interface.
+	// Default is false.
+	HTTPTunnelAccessLog bool `envconfig:"http_tunnel_access_log" default:"false"`
+
+	// Set the address, as host:port, of the device HTTP service reached
+	// through the HTTP tunnel, e.g. '[::1]:8080' for a service bound to the
+	// IPv6 loopback. A request can override the host with the X-Host header,
+	// and the port with the X-Port header. Default is 127.0.0.1:80.
+	ForwardedHTTPAddress string `envconfig:"forwarded_http_address" default:"127.0.0.1:80"`
+
+	// Set the port of the device HTTP service reached through the HTTP tunnel,
+	// overriding the port of ForwardedHTTPAddress. Kept for compatibility.
+	ForwardedHTTPPort int `envconfig:"forwarded_http_port"`
+
+	// Set the scheme of the device HTTP service reached through the HTTP
+	// tunnel. Valid values are 'http' and 'https'. A request can override it
+	// with the X-Scheme header. Default is http.
+	ForwardedHTTPScheme string `envconfig:"forwarded_http_scheme" default:"http"`
+
+	// Skip the certificate verification of the device HTTPS service. This is
+	// intended for devices serving self-signed certificates.
+	ForwardedHTTPSInsecure bool `envconfig:"forwarded_https_insecure" default:"false"`
+
+	// Set the comma-separated list of hostnames, IP addresses and CIDRs other
+	// than the localhost that a request can be forwarded to with the X-Host
+	// header. If not provided, requests are only forwarded to the localhost.
+	ForwardedHTTPHosts []string `envconfig:"forwarded_http_hosts"`
+
+	// Set the comma-separated list of device HTTP services reached by name,
+	// through the X-Service header, as name=[scheme://]host:port entries,
+	// e.g. 'camera=127.0.0.1:8081,config=https://127.0.0.1:8443'. The scheme
+	// defaults to ForwardedHTTPScheme. Requests naming an unknown service are
+	// refused, while the ones naming none reach ForwardedHTTPAddress.
+	ForwardedHTTPServices []string `envconfig:"forwarded_http_services"`
+
+	// Add the X-Forwarded-For, X-Forwarded-Proto and X-Real-IP headers to the
+	// requests forwarded through the HTTP tunnel, so the device HTTP service
+	// knows the original client address. Default is true.
+	ForwardedHTTPHeaders bool `envconfig:"forwarded_http_headers" default:"true"`
+
+	// Set the maximum time, in seconds, to wait while connecting to the device
+	// HTTP service. Default is 5 seconds.
+	ForwardedHTTPDialTimeout int `envconfig:"forwarded_http_dial_timeout" default:"5"`
+
+	// Set the maximum size, in kilobytes, of the headers of a request
+	// forwarded through the HTTP tunnel. Larger requests are refused with the
+	// 413 status. A value of 0 disables the limit. Default is 64 kilobytes.
+	ForwardedHTTPMaxHeaderSize int `envconfig:"forwarded_http_max_header_size" default:"64"`
+
+	// Set the maximum size, in megabytes, of the body of a request forwarded
+	// through the HTTP tunnel. Larger requests are refused with the 413
+	// status. A value of 0 disables the limit. Default is 100 megabytes.
+	ForwardedHTTPMaxBodySize int `envconfig:"forwarded_http_max_body_size" default:"100"`
+
+	// Set the number of consecutive failures to connect to a device HTTP
+	// service after which the requests to it are refused, with the 503
+	// status, for the breaker cooldown. A value of 0 disables the breaker.
+	// Default is 5.
+	ForwardedHTTPBreakerThreshold int `envconfig:"forwarded_http_breaker_threshold" default:"5"`
+
+	// Set the time, in seconds, the requests to a failing device HTTP service
+	// are refused before a request probes it again. Default is 30 seconds.
+	ForwardedHTTPBreakerCooldown int `envconfig:"forwarded_http_breaker_cooldown" default:"30"`
+
+	// Set the initial interval, in seconds, to wait before reconnecting to the
+	// server after a failure. It doubles on each consecutive failure. Default
+	// is 1 second.
+	ReconnectBackoffBase int `envconfig:"reconnect_backoff_base" default:"1"`
+
+	// Set the maximum interval, in seconds, to wait before reconnecting to
+	// the server. Default is 300 seconds.
+	ReconnectBackoffMax int `envconfig:"reconnect_backoff_max" default:"300"`
+
+	// Set the path of the file where the agent keeps its state across
+	// restarts, such as the server it last connected to, tried first on the
+	// next start when many server addresses are set. Empty disables it.
+	StateFile string `envconfig:"state_file" default:"/var/lib/shellhub-agent/state.json"`
+
+	// Set the interval, in seconds, between the summaries of the repeated
+	// failures to reconnect to the server, which are otherwise only logged in
+	// debug level. Zero logs every failure. Default is 300 seconds.
+	ReconnectLogInterval int `envconfig:"reconnect_log_interval" default:"300"`
+
+	// Set the interval, in seconds, to check that the server connection is
+	// still responding, reconnecting when it is not. Zero disables the check.
+	// Default is 30 seconds.
+	HeartbeatInterval int `envconfig:"heartbeat_interval" default:"30"`
+
+	// Set the maximum time, in seconds, to wait for the server to answer a
+	// heartbeat. Default is 15 seconds.
+	HeartbeatTimeout int `envconfig:"heartbeat_timeout" default:"15"`
+
+	// Set the maximum time, in seconds, to wait for the server information,
+	// including the retries, when connecting to the server and in the info
+	// command. A value of 0 waits indefinitely. Default is 30 seconds, as the
+	// connection timeout to the server.
+	ProbeTimeout int `envconfig:"probe_timeout" default:"30"`
+
+	// Set the maximum time, in seconds, the device authorization, retries
+	// included, and the server connection handshake can take before failing
+	// and being retried with backoff, e.g. when the server address accepts
+	// connections but never answers. It also bounds each other request to the
+	// server. A value of 0 disables the timeout. Default is 30 seconds.
+	HandshakeTimeout int `envconfig:"handshake_timeout" default:"30"`
+
+	// Set how many heartbeats in a row must fail before reconnecting to the
+	// server. Default is 1.
+	HeartbeatMaxFailures int `envconfig:"heartbeat_max_failures" default:"1"`
+
+	// Set the interval, in seconds, at which the minimum, average and maximum
+	// round-trip times to the server, measured by the heartbeats, are logged.
+	// Zero disables the report. Default is 300 seconds.
+	RTTReportInterval int `envconfig:"rtt_report_interval" default:"300"`
+
+	// Set the path of the certificate presented to the server, for deployments
+	// requiring TLS client authentication. Requires ClientKeyFile.
+	ClientCertFile string `envconfig:"client_cert_file"`
+
+	// Set the path of the private key of the client certificate.
+	ClientKeyFile string `envconfig:"client_key_file"`
+
+	// Set the path of a PEM file with the CA certificates trusted to sign the
+	// server certificate, instead of the system ones.
+	CACertFile string `envconfig:"ca_cert_file"`
+
+	// Set the path of a directory with the CA certificates, in PEM files with
+	// the .pem, .crt or .cer extension, trusted to sign the server
+	// certificate, along with the ones of CACertFile.
+	CACertDir string `envconfig:"ca_cert_dir"`
+
+	// Trust the CA certificates of CACertFile and CACertDir in addition to
+	// the system ones, instead of replacing them, e.g. when the system trust
+	// store is outdated. Default is false.
+	CACertAppend bool `envconfig:"ca_cert_append" default:"false"`
+
+	// Set a comma-separated list of base64 encoded SHA-256 hashes of the
+	// certificates, or of their public keys, the server is expected to
+	// present. The connection is rejected when none of them matches. Empty
+	// disables the pinning, while a list holding no pin, e.g. ',', is an
+	// invalid configuration.
+	ServerCertPins string `envconfig:"server_cert_pins"`
+
+	// Set the maximum difference, in seconds, between the device and the
+	// server clocks before a warning is logged. Zero disables the check.
+	// Default is 30 seconds.
+	MaxClockSkew int `envconfig:"max_clock_skew" default:"30"`
+
+	// Refuse to connect to the server when the clock skew exceeds
+	// MaxClockSkew, instead of only logging a warning.
+	StrictClockSkew bool `envconfig:"strict_clock_skew" default:"false"`
+
+	// Set the proxy used to connect to the server, as an URL with the http or
+	// socks5 scheme and the credentials, if any, in the user info. When unset,
+	// the proxy is read from the HTTPS_PROXY, HTTP_PROXY, ALL_PROXY and
+	// NO_PROXY environment variables.
+	Proxy string `envconfig:"proxy"`
+
+	// Set the local IP address the connections to the server are made from,
+	// pinning them to the network it belongs to on multi-homed devices. It
+	// must be assigned to the device, and to the source interface when set.
+	SourceAddress string `envconfig:"source_address"`
+
+	// Set the network interface the connections to the server are made from,
+	// using its first address, IPv4 preferred, unless the source address is
+	// set.
+	SourceInterface string `envconfig:"source_interface"`
+
+	// Set the address, as ip or ip:port, of the DNS server resolving the
+	// server address, instead of the system resolver, e.g. on networks with a
+	// broken or captive DNS. Default port is 53.
+	DNSServer string `envconfig:"dns_server"`
+
+	// Set the comma-separated list of host:ip pairs, each host being resolved
+	// to the ip when connecting to the server, without any DNS query, e.g.
+	// 'shellhub.example.com:203.0.113.10'.
+	HostOverrides []string `envconfig:"host_overrides"`
+
+	// Set the comma-separated list of name=value headers added to the
+	// requests to the server, e.g. the API key or the routing hints required
+	// by a gateway or WAF in front of it. The values of the headers named
+	// after a credential, such as an API key or a token, are hidden in the
+	// logs.
+	ExtraHeaders []string `envconfig:"extra_headers"`
+
+	// Set the maximum time, in seconds, to wait for the sessions to be closed
+	// when the agent is stopped. Default is 10 seconds.
+	ShutdownTimeout int `envconfig:"shutdown_timeout" default:"10"`
+
+	// Set the address to serve the /healthz and /readyz health check
+	// endpoints, and the /status endpoint returning the agent status as JSON,
+	// e.g. 127.0.0.1:8080. If not provided, they are disabled.
+	HealthAddress string `envconfig:"health_address"`
+
+	// Set the path of the file touched while the agent is connected to the
+	// server, so an external supervisor can restart the agent when it gets
+	// stale. When run by systemd with WatchdogSec set, the systemd watchdog
+	// is notified as well. If not provided, no file is touched.
+	WatchdogFile string `envconfig:"watchdog_file"`
+
+	// Set the interval, in seconds, the watchdog file is touched at. Default
+	// is 10 seconds.
+	WatchdogInterval int `envconfig:"watchdog_interval" default:"10"`
+
+	// Set how many times the agent initialization is retried before giving
+	// up. Default is 3 times.
+	InitRetries int `envconfig:"init_retries" default:"3"`
+
+	// Set the initial interval, in seconds, between the agent initialization
+	// retries. It doubles on each retry. Default is 5 seconds.
+	InitRetryInterval int `envconfig:"init_retry_interval" default:"5"`
+
+	// Set the maximum number of concurrent sessions. New sessions are refused
+	// when the limit is reached. Default is 0, meaning unlimited.
+	MaxSessions int `envconfig:"max_sessions" default:"0"`
+
+	// Set the maximum number of new sessions per minute, all of which can be
+	// started at once. The excess sessions are refused, independently of the
+	// maximum number of concurrent sessions. Default is 0, meaning unlimited.
+	MaxSessionsPerMinute int `envconfig:"max_sessions_per_minute" default:"0"`
+
+	// Set the time, in seconds, after which a session with no data transferred
+	// is closed. Default is 0, meaning disabled.
+	IdleTimeout int `envconfig:"idle_timeout" default:"0"`
+
+	// Set the maximum time, in seconds, a session can last before it is
+	// closed, whether it is active or not. Default is 0, meaning unlimited.
+	MaxSessionDuration int `envconfig:"max_session_duration" default:"0"`
+
+	// Set the URL to download the agent binary from when updating it to the
+	// server version. The {version}, {os} and {arch} placeholders are
+	// replaced accordingly, and the base64 encoded Ed25519 signature of the
+	// binary is read from the same URL with the .sig suffix. The URL must use
+	// HTTPS. If not provided, the auto-updating mechanism is disabled.
+	UpdateURL string `envconfig:"update_url"`
+
+	// Set the base64 encoded Ed25519 public key verifying the signature of
+	// the downloaded agent binaries, instead of the one built into the agent.
+	// The updates are refused without a public key.
+	UpdatePublicKey string `envconfig:"update_public_key"`
+
+	// Set the interval, in seconds, to check for agent updates. Default is
+	// 3600 seconds.
+	UpdateCheckInterval int `envconfig:"update_check_interval" default:"3600"`
+
+	// Set the time, in seconds, for an updated agent to connect to the server
+	// before the update is rolled back to the previous agent binary, and the
+	// agent stopped to be started again by its supervisor, such as systemd.
+	// Default is 300 seconds.
+	UpdateRollbackTimeout int `envconfig:"update_rollback_timeout" default:"300"`
+
+	// Set the maximum time, in seconds, to wait for the active sessions to
+	// finish before updating the agent, as the update restarts it. When they
+	// are still active after it, the update is postponed to the next check,
+	// unless UpdateForce is set. Default is 600 seconds.
+	UpdateDrainTimeout int `envconfig:"update_drain_timeout" default:"600"`
+
+	// Update the agent once the drain timeout expires even if there are
+	// sessions still active, closing them.
+	UpdateForce bool `envconfig:"update_force" default:"false"`
+
+	// Set the update channel used to check for agent updates. Valid values are
+	// 'stable' and 'beta', which also receives pre-release versions. Default
+	// is stable.
+	UpdateChannel string `envconfig:"update_channel" default:"stable"`
+
+	// Set the maximum time, in seconds, a read on a session connection can
+	// block before the session is closed. As the device may not receive any
+	// data while a session is idle, it is disabled by default.
+	ConnReadTimeout int `envconfig:"conn_read_timeout" default:"0"`
+
+	// Set the maximum time, in seconds, a write on a session connection can
+	// block before the session is closed. If not provided, it defaults to
+	// three times the keep alive interval.
+	ConnWriteTimeout int `envconfig:"conn_write_timeout" default:"0"`
+
+	// Disable SFTP, rejecting the file transfers, e.g. to prevent the data
+	// exfiltration. The interactive SSH sessions are not affected. Default is
+	// false.
+	DisableSFTP bool `envconfig:"disable_sftp" default:"false"`
+
+	// Compress the sessions data with the WebSocket permessage-deflate
+	// extension, e.g. on low-bandwidth links, when the server supports it.
+	// Each session connection offers it to the server, falling back to
+	// uncompressed data otherwise. Default is false.
+	SessionCompression bool `envconfig:"session_compression" default:"false"`
+
+	// Set the directory SFTP sessions are confined to, which is seen by the
+	// clients as the root directory. If not provided, the whole file system is
+	// served.
+	SFTPRoot string `envconfig:"sftp_root"`
+
+	// Allow SFTP sessions only to download files and list directories,
+	// rejecting uploads, deletions and any other change to the file system.
+	SFTPReadOnly bool `envconfig:"sftp_read_only" default:"false"`
+
+	// Set how many reads and writes of a SFTP session are handled
+	// concurrently, which speeds up the transfers over high latency links,
+	// such as satellite or cellular ones. Valid values are between 1 and 8.
+	// Default is 1.
+	SFTPMaxInFlight int `envconfig:"sftp_max_in_flight" default:"1"`
+
+	// Set the maximum size, in bytes, of the data read by a single SFTP
+	// request. Valid values are between 4096 and 32768. Default is 32768.
+	SFTPPacketSize int `envconfig:"sftp_packet_size" default:"32768"`
+
+	// Set the user, as "user[:group]" names or ids, the SFTP server process
+	// runs as instead of the session user, e.g. an unprivileged user sharing
+	// a group with the served files. If not provided, the session user is
+	// used.
+	SFTPUser string `envconfig:"sftp_user"`
+
+	// Set the maximum throughput, in kilobits per second, of the data
+	// transferred through a session or a forwarded HTTP request. Default is
+	// 0, which means unlimited.
+	MaxBandwidthKbps int `envconfig:"max_bandwidth_kbps" default:"0"`
+
+	// Apply the bandwidth limit to the aggregate throughput of all sessions,
+	// instead of to each one.
+	MaxBandwidthShared bool `envconfig:"max_bandwidth_shared" default:"false"`
+
+	// Set the comma-separated list of destinations, in the host:port form,
+	// permitted for the SSH local port forwarding. The host can be a hostname
+	// pattern with wildcards, an IP address or a CIDR, and the port can be '*'
+	// to permit any port. If not provided, any destination is permitted.
+	PortForwardingAllowList []string `envconfig:"port_forwarding_allow_list"`
+
+	// Set the comma-separated list of IP addresses and CIDRs of the clients,
+	// as conveyed by the server, permitted to open sessions on the device.
+	// If not provided, any client is permitted.
+	AllowedClientCIDRs []string `envconfig:"allowed_client_cidrs"`
+
+	// Set the comma-separated list of IP addresses and CIDRs of the clients,
+	// as conveyed by the server, refused to open sessions on the device,
+	// taking precedence over AllowedClientCIDRs.
+	DeniedClientCIDRs []string `envconfig:"denied_client_cidrs"`
+
+	// Allow the SSH remote port forwarding, which lets users listen on a port
+	// of the device tunneled back to them. As it exposes the device to inbound
+	// connections, it is disabled by default.
+	AllowRemoteForwarding bool `envconfig:"allow_remote_forwarding" default:"false"`
+
+	// Record the input and output of the interactive sessions, in the
+	// asciinema format, for auditing.
+	SessionRecording bool `envconfig:"session_recording" default:"false"`
+
+	// Set the directory where the session recordings are written, named after
+	// the session id. Default is /var/lib/shellhub-agent/recordings.
+	SessionRecordingDir string `envconfig:"session_recording_dir" default:"/var/lib/shellhub-agent/recordings"`
+
+	// Set the maximum size, in megabytes, of a session recording. The rest of
+	// the session is not recorded once it is reached. Default is 10 megabytes.
+	SessionRecordingMaxSize int `envconfig:"session_recording_max_size" default:"10"`
+
+	// Set the number of days the session recordings are kept. Default is 30
+	// days.
+	SessionRecordingRetention int `envconfig:"session_recording_retention" default:"30"`
+
+	// Set the list of patterns, separated by commas or spaces, of the
+	// environment variables the clients can set for their sessions, e.g.
+	// 'LANG LC_* TERM'. The other variables are ignored. Default is LANG and
+	// LC_*.
+	AcceptEnv []string `envconfig:"accept_env" default:"LANG,LC_*"`
+
+	// Set the comma-separated list of patterns of the commands the clients can
+	// execute without a shell, e.g. 'reboot,journalctl *', where * matches any
+	// sequence of characters. The patterns are matched against the whole
+	// command line. Interactive sessions are not affected. Empty allows any
+	// command.
+	AllowedCommands []string `envconfig:"allowed_commands"`
+
+	// Set the nice value, from -20 to 19, of the shells and commands spawned
+	// for the sessions, so they cannot starve the device critical processes.
+	// Default is 0, leaving the niceness unchanged.
+	SessionNice int `envconfig:"session_nice" default:"0"`
+
+	// Set the path of the cgroup v2 the shells and commands spawned for the
+	// sessions are started in, e.g. '/sys/fs/cgroup/shellhub-sessions',
+	// created when missing. Requires Linux 5.7 or later. If not provided,
+	// they stay in the agent cgroup.
+	SessionCgroup string `envconfig:"session_cgroup"`
+
+	// Set the CPU time the processes of all the sessions can use together, in
+	// percent of a CPU, e.g. 50 for half a CPU. Requires SessionCgroup.
+	// Default is 0, meaning unlimited.
+	SessionCPUMax int `envconfig:"session_cpu_max" default:"0"`
+
+	// Set the memory, in megabytes, the processes of all the sessions can use
+	// together. Requires SessionCgroup. Default is 0, meaning unlimited.
+	SessionMemoryMax int `envconfig:"session_memory_max" default:"0"`
+
+	// Set the maximum time, in seconds, a command executed without a shell can
+	// run before its process group is killed and the session closed, with the
+	// exit status 124. Interactive sessions are not affected. Default is 0
+	// seconds, which means no timeout.
+	ExecTimeout int `envconfig:"exec_timeout" default:"0"`
+
+	// Set the executable run when a session starts, with the session metadata
+	// in the SHELLHUB_SESSION_* environment variables. The session waits for
+	// it to finish.
+	OnSessionStart string `envconfig:"on_session_start"`
+
+	// Set the executable run when a session ends, with the session metadata,
+	// including the transferred bytes, in the SHELLHUB_SESSION_* environment
+	// variables.
+	OnSessionEnd string `envconfig:"on_session_end"`
+
+	// Set the banner written to the interactive sessions before the shell
+	// starts, e.g. a compliance notice, either as text or as the absolute
+	// path of a file holding it. The {hostname}, {sshid} and {version}
+	// placeholders are replaced by the device name, its SSHID and the agent
+	// version. If not provided, no banner is written.
+	LoginBanner string `envconfig:"login_banner"`
+
+	// Set the executable run when the agent connects to the server, with the
+	// SSHID, the server endpoints and the device metadata in the SHELLHUB_*
+	// environment variables, e.g. to notify a local daemon that the device is
+	// reachable. The connection does not wait for it.
+	OnConnect string `envconfig:"on_connect"`
+
+	// Set the executable run when the agent disconnects from the server, with
+	// the same environment as OnConnect.
+	OnDisconnect string `envconfig:"on_disconnect"`
+
+	// Set the maximum time, in seconds, a session or connection hook can run
+	// before being killed. Default is 10 seconds.
+	SessionHookTimeout int `envconfig:"session_hook_timeout" default:"10"`
+
+	// Set the URL the lifecycle events, such as the sessions opening and
+	// closing, the server connections and the authorization failures, are
+	// posted to as JSON. If not provided, no event is sent.
+	EventWebhookURL string `envconfig:"event_webhook_url"`
+
+	// Set the secret signing the events posted to the event webhook with
+	// HMAC-SHA256, in the X-ShellHub-Signature header. If not provided, the
+	// events are not signed.
+	EventWebhookSecret string `envconfig:"event_webhook_secret"`
+
+	// Set the maximum number of events pending delivery to the event webhook.
+	// Once reached, the oldest events are dropped. Default is 100.
+	EventWebhookQueueSize int `envconfig:"event_webhook_queue_size" default:"100"`
+
+	// Set the comma-separated list, in order of preference, of the key
+	// exchange algorithms the SSH server negotiates. Default is a modern set
+	// based on elliptic curves and 2048 bits groups with SHA-256.
+	SSHKexAlgorithms []string `envconfig:"ssh_kex_algorithms" default:"curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,ecdh-sha2-nistp384,ecdh-sha2-nistp521,diffie-hellman-group14-sha256"`
+
+	// Set the comma-separated list, in order of preference, of the ciphers the
+	// SSH server negotiates. Default is a modern set of AEAD and CTR ciphers.
+	SSHCiphers []string `envconfig:"ssh_ciphers" default:"aes128-gcm@openssh.com,aes256-gcm@openssh.com,chacha20-poly1305@openssh.com,aes128-ctr,aes192-ctr,aes256-ctr"`
 
-	// Process unprefixed env vars for backward compatibility
-	envconfig.Process("", &opts) // nolint:errcheck
+	// Set the comma-separated list, in order of preference, of the MACs the
+	// SSH server negotiates. Default is a modern set of SHA-2 based MACs.
+	SSHMACs []string `envconfig:"ssh_macs" default:"hmac-sha2-512-etm@openssh.com,hmac-sha2-256-etm@openssh.com,hmac-sha2-256"`
 
-	if err := envconfig.Process("shellhub", &opts); err != nil {
+	// Set an additional password, hashed in the same format as the single-user
+	// password, which allows the users to authenticate independently of the
+	// operating system users.
+	ExtraPassword string `envconfig:"extra_password"`
+
+	// Set the path of the unix socket used to send commands to the running
+	// agent, such as listing and closing the active sessions. It is only
+	// accessible by the user running the agent. If not provided, the control
+	// socket is disabled.
+	ControlSocket string `envconfig:"control_socket"`
+}
+
+// NewAgentServer creates a new agent server instance.
+func NewAgentServer(configFile string) *Agent { // nolint:gocyclo
+	opts, err := loadConfigOptions(configFile)
+	if err != nil {
 		// show envconfig usage help users to run agent
-		envconfig.Usage("shellhub", &opts) // nolint:errcheck
+		envconfig.Usage("shellhub", &ConfigOptions{}) // nolint:errcheck
 		log.Fatal(err)
 	}
 
+	serverAddress, err := normalizeServerAddresses(opts.ServerAddress)
+	if err != nil {
+		log.WithError(err).WithField("server_address", opts.ServerAddress).Fatal("Invalid server address")
+	}
+
+	opts.ServerAddress = serverAddress
+
 	// Set the log level accordingly to the configuration.
 	level, err := log.ParseLevel(opts.LogLevel)
 	if err != nil {
@@ -83,23 +670,130 @@ func NewAgentServer() *Agent { // nolint:gocyclo
 	}
 	log.SetLevel(level)
 
-	if os.Geteuid() == 0 && opts.SingleUserPassword != "" {
-		log.Error("ShellHub agent cannot run as root when single-user mode is enabled.")
-		log.Error("To disable single-user mode unset SHELLHUB_SINGLE_USER_PASSWORD env.")
+	formatter, err := newLogFormatter(opts.LogFormat, opts.LogTimestampFormat)
+	if err != nil {
+		log.WithError(err).Error("Invalid log format has been provided.")
 		os.Exit(1)
 	}
+	log.SetFormatter(formatter)
 
-	if os.Geteuid() != 0 && opts.SingleUserPassword == "" {
+	logFile, err := setLogOutput(log.StandardLogger(), opts.LogOutput, int64(opts.LogMaxSize)*1024*1024)
+	if err != nil {
+		log.WithError(err).WithField("log_output", opts.LogOutput).Fatal("Invalid log output")
+	}
+
+	switch err := checkUserMode(opts); {
+	case errors.Is(err, ErrSingleUserAsRoot):
+		log.Error("ShellHub agent cannot run as root when single-user mode is enabled.")
+		log.Error("To disable single-user mode unset SHELLHUB_SINGLE_USER_PASSWORD env.")
+		log.Error("To run the sessions as root in single-user mode anyway, set SHELLHUB_ALLOW_ROOT_SINGLE_USER=true.")
+		os.Exit(1)
+	case errors.Is(err, ErrMultiUserAsNonRoot):
 		log.Error("When running as non-root user you need to set password for single-user mode by SHELLHUB_SINGLE_USER_PASSWORD environment variable.")
-		log.Error("You can use openssl passwd utility to generate password hash. The following algorithms are supported: bsd1, apr1, sha256, sha512.")
+		log.Error("You can use openssl passwd utility to generate password hash. The following algorithms are supported: bsd1, apr1, sha256, sha512, bcrypt, yescrypt.")
 		log.Error("Example: SHELLHUB_SINGLE_USER_PASSWORD=$(openssl passwd -6)")
+		log.Error("Bcrypt hashes can be generated with htpasswd -nB, and yescrypt hashes with mkpasswd -m yescrypt.")
 		log.Error("See man openssl-passwd for more information.")
 		os.Exit(1)
 	}
 
-	
+	if os.Geteuid() == 0 && opts.SingleUserPassword != "" {
+		log.Warn("Single-user mode is enabled while running as root: every session runs as root, with the single-user password as its only protection.")
+	}
+
+	if err := checkExtraPassword(opts); err != nil {
+		log.WithError(err).Fatal("Invalid extra password")
+	}
+
+	if err := server.CheckSessionLimits(sessionLimits(opts)); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"session_nice":       opts.SessionNice,
+			"session_cgroup":     opts.SessionCgroup,
+			"session_cpu_max":    opts.SessionCPUMax,
+			"session_memory_max": opts.SessionMemoryMax,
+		}).Fatal("Invalid session limits")
+	}
+
+	if err := checkPasswordLockout(opts); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"password_lockout_window":   opts.PasswordLockoutWindow,
+			"password_lockout_cooldown": opts.PasswordLockoutCooldown,
+		}).Fatal("Invalid password lockout configuration")
+	}
+
+	if err := checkUpdate(opts); err != nil {
+		log.WithError(err).WithField("update_url", opts.UpdateURL).Fatal("Invalid update configuration")
+	}
+
+	if err := checkKeepAliveJitter(opts); err != nil {
+		log.WithError(err).WithField("keepalive_jitter", opts.KeepAliveJitter).Fatal("Invalid keep alive jitter")
+	}
+
+	if err := checkSingleUserShell(opts); err != nil {
+		log.WithError(err).WithField("single_user_shell", opts.SingleUserShell).Fatal("Invalid single-user shell")
+	}
+
+	if err := checkSessionHooks(opts); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"on_session_start": opts.OnSessionStart,
+			"on_session_end":   opts.OnSessionEnd,
+		}).Fatal("Invalid session hook")
+	}
+
+	if err := checkConnectionHooks(opts); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"on_connect":    opts.OnConnect,
+			"on_disconnect": opts.OnDisconnect,
+		}).Fatal("Invalid connection hook")
+	}
+
+	if err := checkWatchdog(opts); err != nil {
+		log.WithError(err).WithField("watchdog_interval", opts.WatchdogInterval).Fatal("Invalid watchdog configuration")
+	}
+
+	if err := checkHeartbeat(opts); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"heartbeat_timeout":      opts.HeartbeatTimeout,
+			"heartbeat_max_failures": opts.HeartbeatMaxFailures,
+		}).Fatal("Invalid heartbeat configuration")
+	}
+
+	if err := checkTCPKeepAlive(opts); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"tcp_keepalive_idle":     opts.TCPKeepAliveIdle,
+			"tcp_keepalive_interval": opts.TCPKeepAliveInterval,
+			"tcp_keepalive_count":    opts.TCPKeepAliveCount,
+		}).Fatal("Invalid TCP keep-alive configuration")
+	}
+
+	if err := checkSFTPRoot(opts); err != nil {
+		log.WithError(err).WithField("sftp_root", opts.SFTPRoot).Fatal("Invalid SFTP root directory")
+	}
+
+	if err := server.CheckAlgorithms(sshAlgorithms(opts)); err != nil {
+		log.WithError(err).Fatal("Invalid SSH algorithms")
+	}
+
+	if err := checkSFTPUser(opts); err != nil {
+		log.WithError(err).WithField("sftp_user", opts.SFTPUser).Fatal("Invalid SFTP user")
+	}
+
+	if err := sftp.CheckTuning(opts.SFTPMaxInFlight, opts.SFTPPacketSize); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"sftp_max_in_flight": opts.SFTPMaxInFlight,
+			"sftp_packet_size":   opts.SFTPPacketSize,
+		}).Fatal("Invalid SFTP tuning")
+	}
+
+	if !updater.ValidChannel(opts.UpdateChannel) {
+		log.WithField("update_channel", opts.UpdateChannel).Warn("Unknown update channel, falling back to stable")
+
+		opts.UpdateChannel = updater.ChannelStable
+	}
+
 	log.WithFields(log.Fields{
-		"version": AgentVersion,
+		"version":        AgentVersion,
+		"update_channel": opts.UpdateChannel,
 		"mode": func() string {
 			if opts.SingleUserPassword != "" {
 				return "single-user"
@@ -109,19 +803,211 @@ func NewAgentServer() *Agent { // nolint:gocyclo
 		}(),
 	}).Info("Starting ShellHub")
 
-	agent, err := NewAgent(&opts)
+	agent, err := NewAgent(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := agent.initialize(); err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("Failed to initialize agent")
+	agent.logFile = logFile
+
+	if opts.EventWebhookURL != "" {
+		agent.events = webhook.NewSender(opts.EventWebhookURL, opts.EventWebhookSecret, opts.EventWebhookQueueSize)
+	}
+
+	if opts.HealthAddress != "" {
+		go func() {
+			if err := http.ListenAndServe(opts.HealthAddress, agent.health.handler()); err != nil { // nolint:gosec
+				log.WithError(err).WithField("address", opts.HealthAddress).Error("Failed to serve the health check endpoints")
+			}
+		}()
+	}
+
+	if w, interval := newWatchdog(opts, agent.health.ready); w != nil {
+		go w.run(interval)
 	}
 
-	serv := server.NewServer(agent.cli, agent.authData, opts.PrivateKey, opts.KeepAliveInterval, opts.SingleUserPassword)
+	if update, err := updater.PendingUpdate(); err != nil {
+		log.WithError(err).Warn("Failed to read the pending agent update")
+	} else if update != nil {
+		go agent.confirmUpdate(update)
+	}
+
+	b := backoff.New(
+		time.Duration(opts.InitRetryInterval)*time.Second,
+		time.Duration(opts.ReconnectBackoffMax)*time.Second,
+	)
+
+	initialize := func() error {
+		err := agent.initialize()
+
+		// A rejected authorization is not worth retrying.
+		if err != nil && !retryable(err) {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}
+
+	if err := backoff.Retry(opts.InitRetries, b, initialize, func(err error, delay time.Duration) {
+		log.WithError(err).WithField("retry_in", delay).Warn("Failed to initialize agent")
+	}); err != nil {
+		entry := log.WithFields(log.Fields{"err": err})
+		if hint := initErrorHint(err); hint != "" {
+			entry = entry.WithField("hint", hint)
+		}
+
+		entry.Fatal("Failed to initialize agent")
+	}
+
+	if opts.ConnWriteTimeout == 0 {
+		opts.ConnWriteTimeout = 3 * opts.KeepAliveInterval
+	}
+
+	clients, err := newClientFilter(opts.AllowedClientCIDRs, opts.DeniedClientCIDRs)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid client CIDRs")
+	}
+
+	limiter := bandwidthLimiter(opts)
+
+	forwardRules, err := server.ParseForwardRules(opts.PortForwardingAllowList)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid port forwarding allow list")
+	}
+
+	passphrase, err := privateKeyPassphrase(opts)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to read the private key passphrase")
+	}
+
+	serverOpts := []server.Opt{
+		server.WithMaxSessions(opts.MaxSessions),
+		server.WithPrivateKeyPassphrase(passphrase),
+		server.WithSessionRate(opts.MaxSessionsPerMinute),
+		server.WithIdleTimeout(time.Duration(opts.IdleTimeout) * time.Second),
+		server.WithMaxSessionDuration(time.Duration(opts.MaxSessionDuration) * time.Second),
+		server.WithDeadlines(
+			time.Duration(opts.ConnReadTimeout)*time.Second,
+			time.Duration(opts.ConnWriteTimeout)*time.Second,
+		),
+		server.WithSFTPRoot(opts.SFTPRoot),
+		server.WithSFTPDisabled(opts.DisableSFTP),
+		server.WithSFTPReadOnly(opts.SFTPReadOnly),
+		server.WithSFTPTuning(opts.SFTPMaxInFlight, opts.SFTPPacketSize),
+		server.WithBandwidthLimiter(limiter),
+		server.WithForwardRules(forwardRules),
+		server.WithRemoteForwarding(opts.AllowRemoteForwarding),
+		server.WithAcceptEnv(acceptEnvPatterns(opts.AcceptEnv)),
+		server.WithExtraPassword(opts.ExtraPassword),
+		server.WithPasswordLockout(
+			opts.PasswordLockoutThreshold,
+			time.Duration(opts.PasswordLockoutWindow)*time.Second,
+			time.Duration(opts.PasswordLockoutCooldown)*time.Second,
+		),
+		server.WithKeepAliveJitter(opts.KeepAliveJitter),
+		server.WithAllowedCommands(opts.AllowedCommands),
+		server.WithSessionLimits(sessionLimits(opts)),
+		server.WithExecTimeout(time.Duration(opts.ExecTimeout)*time.Second),
+		server.WithTenantID(opts.TenantID),
+		server.WithAlgorithms(sshAlgorithms(opts)),
+		server.WithSessionHooks(
+			opts.OnSessionStart,
+			opts.OnSessionEnd,
+			time.Duration(opts.SessionHookTimeout)*time.Second,
+		),
+	}
+
+	banner, err := loadLoginBanner(opts)
+	if err != nil {
+		log.WithError(err).WithField("login_banner", opts.LoginBanner).Fatal("Failed to read the login banner")
+	}
+
+	if banner != "" {
+		serverOpts = append(serverOpts, server.WithLoginBanner(func() string {
+			return agent.loginBanner(banner)
+		}))
+	}
+
+	if agent.events != nil {
+		serverOpts = append(serverOpts, server.WithSessionEvents(func(event server.SessionEvent, info server.SessionInfo) {
+			e := webhook.Event{
+				Type:          webhook.SessionOpened,
+				SessionID:     info.ID,
+				RemoteAddr:    info.RemoteAddr,
+				BytesReceived: info.BytesReceived,
+				BytesSent:     info.BytesSent,
+			}
+
+			if event == server.SessionEnd {
+				e.Type = webhook.SessionClosed
+			}
+
+			agent.event(e)
+		}))
+	}
+
+	if opts.SFTPUser != "" {
+		credential, err := server.LookupCredential(opts.SFTPUser)
+		if err != nil {
+			log.WithError(err).WithField("sftp_user", opts.SFTPUser).Fatal("Invalid SFTP user")
+		}
+
+		serverOpts = append(serverOpts, server.WithSFTPCredential(credential))
+	}
+
+	if opts.SingleUserPassword != "" && opts.SingleUserShell != "" {
+		shell, _ := parseSingleUserShell(opts.SingleUserShell)
+		serverOpts = append(serverOpts, server.WithSingleUserShell(shell))
+	}
+
+	if opts.SessionRecording {
+		serverOpts = append(serverOpts, server.WithSessionRecording(
+			opts.SessionRecordingDir,
+			int64(opts.SessionRecordingMaxSize)*1024*1024,
+			time.Duration(opts.SessionRecordingRetention)*24*time.Hour,
+		))
+	}
+
+	serv := server.NewServer(agent.cli, agent.authData, opts.PrivateKey, opts.KeepAliveInterval, opts.SingleUserPassword, serverOpts...)
 
 	tun := tunnel.NewTunnel()
+	proxy, err := newHTTPProxy(opts, limiter)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid HTTP tunnel configuration")
+	}
+
+	agent.health.setStatus(func() *agentStatus {
+		return newAgentStatus(agent, serv, proxy)
+	})
+
+	if len(opts.ExtraHeaders) > 0 {
+		log.WithField("headers", redactedHeaders(opts.ExtraHeaders)).Info("Sending extra headers to the server")
+	}
+
+	if opts.DisableSFTP {
+		log.Info("SFTP is disabled, refusing the SFTP sessions")
+	}
+
+	if opts.DisableHTTPTunnel {
+		log.Info("HTTP tunnel is disabled, refusing the requests to the device HTTP services")
+	}
+
+	tun.HTTPHandler = httpTunnelHandler(opts, proxy)
 	tun.ConnHandler = func(w http.ResponseWriter, r *http.Request) {
+		if clients.enabled() {
+			if err := clients.check(clientIP(r)); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"id":        mux.Vars(r)["id"],
+					"client_ip": clientIP(r),
+					"version":   AgentVersion,
+				}).Warning("Refusing new session")
+
+				http.Error(w, "client is not allowed to open sessions on this device", http.StatusForbidden)
+
+				return
+			}
+		}
+
 		hj, ok := w.(http.Hijacker)
 		if !ok {
 			http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
@@ -145,89 +1031,525 @@ func NewAgentServer() *Agent { // nolint:gocyclo
 			return
 		}
 
-		serv.Sessions[vars["id"]] = conn
-		serv.HandleConn(conn)
+		if ip := clientIP(r); ip != nil {
+			conn = &server.ClientConn{Conn: conn, Client: ip.String()}
+		}
 
-		conn.Close()
+		if err := serv.ServeSession(vars["id"], conn); errors.Is(err, server.ErrMaxSessionsReached) || errors.Is(err, server.ErrSessionRateExceeded) || errors.Is(err, server.ErrMaintenanceMode) {
+			log.WithError(err).WithFields(log.Fields{
+				"id":      vars["id"],
+				"version": AgentVersion,
+			}).Warning("Refusing new session")
+		}
 	}
 
 	serv.SetDeviceName(agent.authData.Name)
 
-	go func() {
-		for {
-			listener, err := agent.newReverseListener()
-			if err != nil {
-				time.Sleep(time.Second * 10)
+	if opts.ControlSocket != "" {
+		listener, err := serveControl(opts.ControlSocket, newControlServer(agent, serv, proxy, configFile))
+		if err != nil {
+			log.WithError(err).WithField("path", opts.ControlSocket).Error("Failed to listen on the control socket")
+		} else {
+			defer listener.Close()
+		}
+	}
 
-				continue
-			}
+	agent.sessions = serv.ListSessionIDs
+	agent.closeSession = serv.CloseSession
 
-			namespace := agent.authData.Namespace
-			tenantName := agent.authData.Name
-			sshEndpoint := agent.serverInfo.Endpoints.SSH
+	agent.onServerChange = func() {
+		serv.SetAPI(agent.currentClient(), agent.currentAuth())
+		serv.SetDeviceName(agent.currentAuth().Name)
+	}
 
-			sshid := strings.NewReplacer(
-				"{namespace}", namespace,
-				"{tenantName}", tenantName,
-				"{sshEndpoint}", strings.Split(sshEndpoint, ":")[0],
-			).Replace("{namespace}.{tenantName}@{sshEndpoint}")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-			log.WithFields(log.Fields{
-				"namespace":      namespace,
-				"hostname":       tenantName,
-				"server_address": opts.ServerAddress,
-				"ssh_server":     sshEndpoint,
-				"sshid":          sshid,
-			}).Info("Server connection established")
+	go agent.reloadOnSignal(configFile, serv)
+	go toggleMaintenanceOnSignal(serv)
 
-			if err := tun.Listen(listener); err != nil {
-				continue
-			}
+	if agent.events != nil {
+		go agent.events.Run(ctx)
+	}
+
+	go agent.listen(ctx, tun)
+	go agent.notifyReady(ctx, sdNotify)
+
+	if opts.RTTReportInterval > 0 && opts.HeartbeatInterval > 0 {
+		go agent.reportRTT(ctx, time.Duration(opts.RTTReportInterval)*time.Second)
+	}
+
+	// The public key was checked at startup.
+	publicKey, _ := updatePublicKey(opts)
+	u := updater.NewUpdater(AgentVersion, opts.UpdateURL, opts.UpdateChannel, publicKey)
+	u.UserAgent = userAgent(opts)
+
+	if agent.tlsConfig != nil && agent.tlsConfig.RootCAs != nil {
+		u.SetRootCAs(agent.tlsConfig.RootCAs)
+	}
+
+	if u.Enabled() {
+		go agent.updateLoop(ctx, u, func() int {
+			return len(serv.ListSessionIDs())
+		})
+	} else {
+		log.WithField("version", AgentVersion).Debug("Auto-updating mechanism is disabled")
+	}
+
+	for {
+		// Refresh the authorization before the current token expires.
+		select {
+		case <-ctx.Done():
+			shutdown(serv, tun, time.Duration(opts.ShutdownTimeout)*time.Second)
+
+			return agent
+		case <-time.After(agent.authorizationInterval()):
 		}
-	}()
 
-	// This hard coded interval will be removed in a follow up change to make use of JWT token expire time.
-	ticker := time.NewTicker(10 * time.Minute)
+		if agent.refreshAuthorization() {
+			serv.SetAPI(agent.currentClient(), agent.currentAuth())
+			serv.SetDeviceName(agent.currentAuth().Name)
+		}
+	}
+}
+
+// acceptEnvPatterns splits the accepted environment variable patterns also on
+// spaces, as they are usually written in the sshd AcceptEnv form.
+func acceptEnvPatterns(values []string) []string {
+	var patterns []string
+	for _, value := range values {
+		patterns = append(patterns, strings.Fields(value)...)
+	}
 
-	for range ticker.C {
-		sessions := make([]string, 0, len(serv.Sessions))
-		for key := range serv.Sessions {
-			sessions = append(sessions, key)
+	return patterns
+}
+
+// bandwidthLimiter returns the function creating the bandwidth limiter of each
+// session, which returns nil when the bandwidth is unlimited and the same
+// limiter for every session when it is shared.
+func bandwidthLimiter(opts *ConfigOptions) func() *ratelimit.Limiter {
+	bytesPerSecond := opts.MaxBandwidthKbps * 1000 / 8
+
+	if bytesPerSecond <= 0 {
+		return func() *ratelimit.Limiter {
+			return nil
 		}
+	}
 
-		agent.sessions = sessions
+	if opts.MaxBandwidthShared {
+		shared := ratelimit.New(bytesPerSecond)
 
-		if err := agent.authorize(); err != nil {
-			serv.SetDeviceName(agent.authData.Name)
+		return func() *ratelimit.Limiter {
+			return shared
 		}
 	}
 
-	return agent
+	return func() *ratelimit.Limiter {
+		return ratelimit.New(bytesPerSecond)
+	}
+}
+
+// shutdown closes the tunnel and the active sessions, waiting up to timeout
+// for the cleanup to complete.
+func shutdown(serv *server.Server, tun *tunnel.Tunnel, timeout time.Duration) {
+	log.Info("Shutting down ShellHub agent")
+
+	if err := sdNotify(sdState{"STOPPING": "1"}.String()); err != nil {
+		log.WithError(err).Debug("Failed to notify systemd that the agent is stopping")
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if err := tun.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close the tunnel")
+		}
+
+		for _, id := range serv.ListSessionIDs() {
+			serv.CloseSession(id)
+		}
+	}()
+
+	select {
+	case <-done:
+		log.Info("ShellHub agent stopped")
+	case <-time.After(timeout):
+		log.WithField("timeout", timeout).Warn("Timeout waiting for the ShellHub agent to stop")
+	}
 }
 
 func main() {
+	var configFile string
+
 	// Default command.
 	rootCmd := &cobra.Command{ // nolint: exhaustruct
 		Use: "agent",
 		Run: func(cmd *cobra.Command, args []string) {
 			loglevel.SetLogLevel()
 
-			NewAgentServer()
+			NewAgentServer(configFile)
 		},
 	}
 
-	rootCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Path to a YAML file with the agent configuration")
+
+	var infoJSON bool
+
+	infoCmd := &cobra.Command{ // nolint: exhaustruct
 		Use:   "info",
 		Short: "Show information about the agent",
 		Run: func(cmd *cobra.Command, args []string) {
 			loglevel.SetLogLevel()
 
-			if err := NewAgentServer().probeServerInfo(); err != nil {
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if opts.ServerAddress, err = normalizeServerAddresses(opts.ServerAddress); err != nil {
+				log.Fatal(err)
+			}
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := agent.probeServerInfo(); err != nil {
+				if hint := initErrorHint(err); hint != "" {
+					log.WithError(err).WithField("hint", hint).Fatal("Failed to probe the server information")
+				}
+
+				log.Fatal(err)
+			}
+
+			info := newAgentInfo(opts, agent.serverInfo)
+
+			if opts.ControlSocket != "" {
+				if info.Runtime, err = runningAgentStatus(opts.ControlSocket); err != nil {
+					log.WithError(err).Debug("Failed to query the running agent status")
+				}
+			}
+
+			if !infoJSON {
+				info.writeText(os.Stdout)
+
+				return
+			}
+
+			if err := info.writeJSON(os.Stdout); err != nil {
 				log.Fatal(err)
 			}
 		},
+	}
+
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "Output the information as a JSON object")
+
+	rootCmd.AddCommand(infoCmd)
+
+	rootCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+		Use:   "sshid",
+		Short: "Show the SSHID used to reach the device through the server",
+		Run: func(cmd *cobra.Command, args []string) {
+			loglevel.SetLogLevel()
+
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if opts.ServerAddress, err = normalizeServerAddresses(opts.ServerAddress); err != nil {
+				log.Fatal(err)
+			}
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := agent.initialize(); err != nil {
+				if hint := initErrorHint(err); hint != "" {
+					log.WithError(err).WithField("hint", hint).Fatal("Failed to initialize agent")
+				}
+
+				log.Fatal(err)
+			}
+
+			fmt.Println(agent.sshid())
+		},
 	})
 
+	rootCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+		Use:   "test-connection",
+		Short: "Check that the device can connect and authenticate to the server",
+		Long: `Check that the device can connect and authenticate to the server, e.g. in CI or provisioning pipelines.
+It probes the server information and authorizes the device, showing the result, then exits without listening
+for sessions. The exit status is non-zero when the connection fails.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			loglevel.SetLogLevel()
+
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if opts.ServerAddress, err = normalizeServerAddresses(opts.ServerAddress); err != nil {
+				log.Fatal(err)
+			}
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := agent.initialize(); err != nil {
+				if hint := initErrorHint(err); hint != "" {
+					log.WithError(err).WithField("hint", hint).Fatal("Failed to connect to the server")
+				}
+
+				log.Fatal(err)
+			}
+
+			writeConnectionReport(os.Stdout, agent)
+		},
+	})
+
+	var versionCheck bool
+
+	versionCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   "version",
+		Short: "Show the agent version",
+		Run: func(cmd *cobra.Command, args []string) {
+			if !versionCheck {
+				fmt.Println(AgentVersion)
+
+				return
+			}
+
+			// As for the automatic updates, the development builds are never
+			// checked.
+			if AgentVersion == "" || AgentVersion == updater.LatestVersion {
+				fmt.Printf("Agent version: %s\n", AgentVersion)
+				fmt.Println("Update checks disabled")
+
+				return
+			}
+
+			loglevel.SetLogLevel()
+
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if opts.ServerAddress, err = normalizeServerAddresses(opts.ServerAddress); err != nil {
+				log.Fatal(err)
+			}
+
+			if !updater.ValidChannel(opts.UpdateChannel) {
+				opts.UpdateChannel = updater.ChannelStable
+			}
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			latest, err := agent.latestVersion()
+			if err != nil {
+				if hint := initErrorHint(err); hint != "" {
+					log.WithError(err).WithField("hint", hint).Fatal("Failed to check for agent updates")
+				}
+
+				log.Fatal(err)
+			}
+
+			if err := writeVersionCheck(os.Stdout, AgentVersion, latest, opts.UpdateChannel); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	versionCmd.Flags().BoolVar(&versionCheck, "check", false, "Ask the server whether an agent update is available")
+
+	rootCmd.AddCommand(versionCmd)
+
+	rootCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+		Use:   "sftp",
+		Short: "Starts the SFTP server",
+		Long: `Starts the SFTP server. This command is used internally by the agent and should not be used directly.
+It is initialized by the agent when a new SFTP session is created.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			server.NewSFTPServer()
+		},
+	})
+
+	var (
+		watchedExecutable string
+		watchedPID        int
+		watchTimeout      time.Duration
+	)
+
+	watchdogCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   updater.WatchdogCommand,
+		Short: "Watches an agent update",
+		Long: `Watches an agent update, rolling it back unless it connects to the server in time. This command is used internally by the agent and should not be used directly.
+It is started from the previous agent binary before restarting into the update.`,
+		Hidden: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			update, err := updater.Watch(watchedExecutable, watchedPID, watchTimeout)
+			if update == nil && err == nil {
+				return
+			}
+
+			fields := log.Fields{"pid": watchedPID, "timeout": watchTimeout}
+			if update != nil {
+				fields["version"] = update.From
+				fields["new_version"] = update.To
+			}
+
+			switch {
+			case update == nil:
+				log.WithError(err).WithFields(fields).Fatal("Failed to roll back agent update")
+			case err != nil:
+				log.WithError(err).WithFields(fields).Error("Updated agent failed to connect, rolled back but failed to stop it")
+			default:
+				log.WithFields(fields).Error("Updated agent failed to connect, rolled back")
+			}
+		},
+	}
+
+	watchdogCmd.Flags().StringVar(&watchedExecutable, "executable", "", "Path of the updated agent executable")
+	watchdogCmd.Flags().IntVar(&watchedPID, "pid", 0, "Process ID of the updated agent")
+	watchdogCmd.Flags().DurationVar(&watchTimeout, "timeout", 0, "Time for the updated agent to connect to the server")
+
+	rootCmd.AddCommand(watchdogCmd)
+
+	var controlSocket string
+
+	sessionsCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   "sessions",
+		Short: "List the active sessions of the running agent",
+		Run: func(cmd *cobra.Command, args []string) {
+			runSessionsCommand(controlSocket, configFile)
+		},
+	}
+
+	sessionsCmd.Flags().StringVar(&controlSocket, "socket", "", "Path of the agent control socket")
+
+	rootCmd.AddCommand(sessionsCmd)
+
+	controlCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   "control <command> [args]",
+		Short: "Send a command to the running agent",
+		Long: `Send a command to the running agent through its control socket. The available commands are:
+
+  sessions     list the active sessions
+  close <id>   close the session identified by id
+  status       show the agent connection state
+  reload       reload the configuration options that can be changed at runtime
+  maintenance [on|off]
+               show, enable or disable the maintenance mode, refusing new sessions`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runControlCommand(controlSocket, configFile, args)
+		},
+	}
+
+	controlCmd.Flags().StringVar(&controlSocket, "socket", "", "Path of the agent control socket")
+
+	rootCmd.AddCommand(controlCmd)
+
+	configCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   "config",
+		Short: "Manage the agent configuration",
+	}
+
+	configCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+		Use:   "validate",
+		Short: "Validate the agent configuration without connecting to the server",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FAIL] environment: %s\n", err)
+				os.Exit(1)
+			}
+
+			if !validateConfig(os.Stdout, opts) {
+				os.Exit(1)
+			}
+		},
+	})
+
+	rootCmd.AddCommand(configCmd)
+
+	rootCmd.AddCommand(&cobra.Command{ // nolint: exhaustruct
+		Use:   "doctor",
+		Short: "Diagnose the connectivity of the agent with the server",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := loadConfigOptions(configFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FAIL] environment: %s\n", err)
+				os.Exit(1)
+			}
+
+			if opts.ServerAddress, err = normalizeServerAddresses(opts.ServerAddress); err != nil {
+				fmt.Fprintf(os.Stderr, "[FAIL] server address: %s\n", err)
+				os.Exit(1)
+			}
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FAIL] agent: %s\n", err)
+				os.Exit(1)
+			}
+
+			if !newDoctor(opts, agent).run(os.Stdout) {
+				os.Exit(1)
+			}
+		},
+	})
+
+	var (
+		keyPath           string
+		keyForce          bool
+		keyPassphraseFile string
+	)
+
+	keygenCmd := &cobra.Command{ // nolint: exhaustruct
+		Use:   "keygen",
+		Short: "Generate a device private key",
+		Long: `Generate a device private key in the format the agent expects, readable only by its owner, and print
+its public key and fingerprint. An existing key is not overwritten unless --force is set.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var passphrase []byte
+
+			if keyPassphraseFile != "" {
+				var err error
+
+				passphrase, err = privateKeyPassphrase(&ConfigOptions{PrivateKeyPassphraseFile: keyPassphraseFile})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			if err := generateKey(os.Stdout, keyPath, keyForce, passphrase); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	keygenCmd.Flags().StringVar(&keyPath, "out", "", "Path of the private key to generate")
+	keygenCmd.Flags().BoolVar(&keyForce, "force", false, "Overwrite the existing private key")
+	keygenCmd.Flags().StringVar(&keyPassphraseFile, "passphrase-file", "", "Path of a file holding the passphrase encrypting the private key")
+	keygenCmd.MarkFlagRequired("out") // nolint:errcheck
+
+	rootCmd.AddCommand(keygenCmd)
+
 	rootCmd.Version = AgentVersion
 
 	rootCmd.SetVersionTemplate(fmt.Sprintf("{{ .Name }} version: {{ .Version }}\ngo: %s\n",
@@ -1,12 +1,15 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	resty "github.com/go-resty/resty/v2"
 	"github.com/brycedjohnson/shellhub-agent/pkg/models"
@@ -23,7 +26,19 @@ var (
 	ErrUnknown          = errors.New("unknown error")
 )
 
-func NewClient(opts ...Opt) Client {
+// StatusError is returned when the server answers a request with an error
+// status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with status %s", e.Status)
+}
+
+// NewClient returns a client of the server, failing when an option is invalid.
+func NewClient(opts ...Opt) (Client, error) {
 	httpClient := resty.New()
 	httpClient.SetRetryCount(math.MaxInt32)
 	httpClient.AddRetryCondition(func(r *resty.Response, err error) bool {
@@ -39,11 +54,12 @@ func NewClient(opts ...Opt) Client {
 		port:   apiPort,
 		scheme: apiScheme,
 		http:   httpClient,
+		proxy:  http.ProxyFromEnvironment,
 	}
 
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
-			return nil
+			return nil, err
 		}
 	}
 
@@ -51,7 +67,17 @@ func NewClient(opts ...Opt) Client {
 		httpClient.SetLogger(&LeveledLogger{c.logger})
 	}
 
-	return c
+	httpClient.OnAfterResponse(func(_ *resty.Client, r *resty.Response) error {
+		if date, err := http.ParseTime(r.Header().Get("Date")); err == nil {
+			c.mu.Lock()
+			c.serverDate = date
+			c.mu.Unlock()
+		}
+
+		return nil
+	})
+
+	return c, nil
 }
 
 type commonAPI interface {
@@ -65,6 +91,19 @@ type client struct {
 	port   int
 	http   *resty.Client
 	logger *logrus.Logger
+	tls    *tls.Config
+	proxy  func(*http.Request) (*url.URL, error)
+	dialer *net.Dialer
+	hosts  HostOverrides
+
+	userAgent string
+	headers   http.Header
+
+	handshakeTimeout time.Duration
+	compression      bool
+
+	mu         sync.Mutex
+	serverDate time.Time
 }
 
 func (c *client) ListDevices() ([]models.Device, error) {
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	resty "github.com/go-resty/resty/v2"
 	"github.com/gorilla/websocket"
@@ -28,16 +30,29 @@ type Client interface {
 
 type publicAPI interface {
 	GetInfo(agentVersion string) (*models.Info, error)
+	GetInfoContext(ctx context.Context, agentVersion string) (*models.Info, error)
+	CheckUpdate(agentVersion, channel string) (*models.Info, error)
+	CheckUpdateContext(ctx context.Context, agentVersion, channel string) (*models.Info, error)
 	Endpoints() (*models.Endpoints, error)
 	AuthDevice(req *models.DeviceAuthRequest) (*models.DeviceAuthResponse, error)
+	AuthDeviceContext(ctx context.Context, req *models.DeviceAuthRequest) (*models.DeviceAuthResponse, error)
 	NewReverseListener(token string) (*revdial.Listener, error)
+	NewReverseListenerContext(ctx context.Context, token string) (*revdial.Listener, error)
 	AuthPublicKey(req *models.PublicKeyAuthRequest, token string) (*models.PublicKeyAuthResponse, error)
+	ServerDate() time.Time
 }
 
 func (c *client) GetInfo(agentVersion string) (*models.Info, error) {
+	return c.GetInfoContext(context.Background(), agentVersion)
+}
+
+// GetInfoContext gets the server information, giving up the request and its
+// retries once the context is done.
+func (c *client) GetInfoContext(ctx context.Context, agentVersion string) (*models.Info, error) {
 	var info *models.Info
 
 	_, err := c.http.R().
+		SetContext(ctx).
 		SetResult(&info).
 		Get(buildURL(c, "/info?agent_version="+agentVersion))
 	if err != nil {
@@ -47,9 +62,42 @@ func (c *client) GetInfo(agentVersion string) (*models.Info, error) {
 	return info, nil
 }
 
+// CheckUpdate gets the server information, including the agent version the
+// agent should be updated to on the given update channel.
+func (c *client) CheckUpdate(agentVersion, channel string) (*models.Info, error) {
+	return c.CheckUpdateContext(context.Background(), agentVersion, channel)
+}
+
+// CheckUpdateContext is CheckUpdate giving up the request and its retries once
+// the context is done.
+func (c *client) CheckUpdateContext(ctx context.Context, agentVersion, channel string) (*models.Info, error) {
+	var info *models.Info
+
+	query := url.Values{}
+	query.Set("agent_version", agentVersion)
+	query.Set("channel", channel)
+
+	_, err := c.http.R().
+		SetContext(ctx).
+		SetResult(&info).
+		Get(buildURL(c, "/info?"+query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
 func (c *client) AuthDevice(req *models.DeviceAuthRequest) (*models.DeviceAuthResponse, error) {
+	return c.AuthDeviceContext(context.Background(), req)
+}
+
+// AuthDeviceContext authenticates the device, giving up the request and its
+// retries once the context is done.
+func (c *client) AuthDeviceContext(ctx context.Context, req *models.DeviceAuthRequest) (*models.DeviceAuthResponse, error) {
 	var res *models.DeviceAuthResponse
-	_, err := c.http.R().
+	resp, err := c.http.R().
+		SetContext(ctx).
 		AddRetryCondition(func(r *resty.Response, err error) bool {
 			identity := func(mac, hostname string) string {
 				if mac != "" {
@@ -65,7 +113,10 @@ func (c *client) AuthDevice(req *models.DeviceAuthRequest) (*models.DeviceAuthRe
 				"status_code": r.StatusCode(),
 			}).Debug("failed to authenticate device")
 
-			return r.IsError()
+			// Besides the network and server errors, retried by the client,
+			// only the rate limited requests are worth retrying: the other
+			// errors are permanent.
+			return r.StatusCode() == http.StatusTooManyRequests
 		}).
 		SetBody(req).
 		SetResult(&res).
@@ -74,6 +125,10 @@ func (c *client) AuthDevice(req *models.DeviceAuthRequest) (*models.DeviceAuthRe
 		return nil, err
 	}
 
+	if resp.IsError() {
+		return nil, &StatusError{StatusCode: resp.StatusCode(), Status: resp.Status()}
+	}
+
 	return res, nil
 }
 
@@ -90,18 +145,24 @@ func (c *client) Endpoints() (*models.Endpoints, error) {
 }
 
 func (c *client) NewReverseListener(token string) (*revdial.Listener, error) {
-	req, _ := http.NewRequest("GET", "", nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return c.NewReverseListenerContext(context.Background(), token)
+}
+
+// NewReverseListenerContext connects the reverse listener to the server, giving
+// up the connection and its handshake once the context is done.
+func (c *client) NewReverseListenerContext(ctx context.Context, token string) (*revdial.Listener, error) {
+	header := c.websocketHeader()
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	url := regexp.MustCompile(`^http`).ReplaceAllString(buildURL(c, "/ssh/connection"), "ws")
-	conn, _, err := websocket.DefaultDialer.Dial(url, req.Header)
+	conn, _, err := c.websocketDialer().DialContext(ctx, url, header)
 	if err != nil {
 		return nil, err
 	}
 
 	listener := revdial.NewListener(wsconnadapter.New(conn),
 		func(ctx context.Context, path string) (*websocket.Conn, *http.Response, error) {
-			return tunnelDial(ctx, strings.Replace(c.scheme, "http", "ws", 1), c.host, c.port, path)
+			return c.tunnelDial(ctx, strings.Replace(c.scheme, "http", "ws", 1), c.host, c.port, path)
 		},
 	)
 
@@ -122,6 +183,51 @@ func (c *client) AuthPublicKey(req *models.PublicKeyAuthRequest, token string) (
 	return res, nil
 }
 
-func tunnelDial(ctx context.Context, protocol, address string, port int, path string) (*websocket.Conn, *http.Response, error) {
-	return websocket.DefaultDialer.DialContext(ctx, strings.Join([]string{fmt.Sprintf("%s://%s:%d", protocol, address, port), path}, ""), nil)
+// websocketDialer returns the dialer of the WebSocket connections to the server,
+// using the client TLS, proxy, local address and resolution configuration.
+func (c *client) websocketDialer() *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = c.tls
+	dialer.Proxy = c.proxy
+
+	if c.handshakeTimeout > 0 {
+		dialer.HandshakeTimeout = c.handshakeTimeout
+	}
+
+	if c.dialer != nil {
+		dialer.NetDialContext = c.dialContext
+	}
+
+	return &dialer
+}
+
+func (c *client) tunnelDial(ctx context.Context, protocol, address string, port int, path string) (*websocket.Conn, *http.Response, error) {
+	dialer := c.websocketDialer()
+	dialer.EnableCompression = c.compression
+
+	return dialer.DialContext(ctx, strings.Join([]string{fmt.Sprintf("%s://%s:%d", protocol, address, port), path}, ""), c.websocketHeader())
+}
+
+// websocketHeader returns the headers of the WebSocket handshakes with the
+// server: the extra headers and the User-Agent.
+func (c *client) websocketHeader() http.Header {
+	header := c.headers.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	if c.userAgent != "" {
+		header.Set("User-Agent", c.userAgent)
+	}
+
+	return header
+}
+
+// ServerDate returns the server time, as sent in the Date header of the last
+// response, or the zero time when no response had it.
+func (c *client) ServerDate() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.serverDate
 }
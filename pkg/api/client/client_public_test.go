@@ -0,0 +1,277 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckUpdateQuery(t *testing.T) {
+	cases := []struct {
+		version string
+		channel string
+	}{
+		{"1.0.0", "stable"},
+		{"1.1.0-rc.1", "beta"},
+	}
+
+	for _, tc := range cases {
+		var query url.Values
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&models.Info{Version: "1.2.0"}) // nolint:errcheck
+		}))
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cli, err := NewClient(WithURL(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := cli.CheckUpdate(tc.version, tc.channel)
+		srv.Close()
+
+		if err != nil {
+			t.Fatalf("CheckUpdate(%q, %q) = %v", tc.version, tc.channel, err)
+		}
+
+		if info.Version != "1.2.0" {
+			t.Errorf("CheckUpdate(%q, %q) version = %q, want %q", tc.version, tc.channel, info.Version, "1.2.0")
+		}
+
+		if got := query.Get("channel"); got != tc.channel {
+			t.Errorf("CheckUpdate(%q, %q) sent channel %q", tc.version, tc.channel, got)
+		}
+
+		if got := query.Get("agent_version"); got != tc.version {
+			t.Errorf("CheckUpdate(%q, %q) sent agent_version %q", tc.version, tc.channel, got)
+		}
+	}
+}
+
+func TestTunnelDialCompression(t *testing.T) {
+	cases := []struct {
+		compression       bool
+		serverCompression bool
+		compressed        bool
+	}{
+		{false, false, false},
+		{false, true, false},
+		{true, false, false},
+		{true, true, true},
+	}
+
+	data := bytes.Repeat([]byte("session data "), 1024)
+
+	for _, tc := range cases {
+		upgrader := websocket.Upgrader{EnableCompression: tc.serverCompression}
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			conn.WriteMessage(typ, msg) // nolint:errcheck
+		}))
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := NewClient(WithURL(u), WithCompression(tc.compression))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cli := c.(*client)
+
+		conn, resp, err := cli.tunnelDial(context.Background(), "ws", cli.host, cli.port, "/ssh/revdial")
+		if err != nil {
+			srv.Close()
+			t.Fatalf("tunnelDial() with compression %v, server compression %v = %v", tc.compression, tc.serverCompression, err)
+		}
+
+		compressed := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+		if compressed != tc.compressed {
+			t.Errorf("tunnelDial() with compression %v, server compression %v negotiated compression %v, want %v", tc.compression, tc.serverCompression, compressed, tc.compressed)
+		}
+
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+
+		_, got, err := conn.ReadMessage()
+		conn.Close()
+		srv.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("tunnelDial() with compression %v, server compression %v: echoed %d bytes, want %d", tc.compression, tc.serverCompression, len(got), len(data))
+		}
+	}
+}
+
+func TestAuthDeviceStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli, err := NewClient(WithURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cli.AuthDevice(&models.DeviceAuthRequest{
+		DeviceAuth: &models.DeviceAuth{TenantID: "tenant", Identity: &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}},
+	})
+
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("AuthDevice() = %v, want a status error with the status %d", err, http.StatusUnauthorized)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	agents := make(chan string, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+
+		if r.URL.Path == "/ssh/revdial" {
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err == nil {
+				conn.Close()
+			}
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.Info{Version: "1.2.0"}) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(WithURL(u), WithUserAgent("shellhub-agent/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.CheckUpdate("1.0.0", "stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := c.(*client)
+
+	conn, _, err := cli.tunnelDial(context.Background(), "ws", cli.host, cli.port, "/ssh/revdial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	for _, request := range []string{"API", "tunnel"} {
+		if got := <-agents; got != "shellhub-agent/test" {
+			t.Errorf("%s request User-Agent = %q, want %q", request, got, "shellhub-agent/test")
+		}
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	headers := make(chan http.Header, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+
+		if r.URL.Path == "/ssh/revdial" {
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err == nil {
+				conn.Close()
+			}
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.Info{Version: "1.2.0"}) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra := http.Header{"X-Api-Key": {"secret"}, "X-Route": {"eu", "us"}}
+
+	c, err := NewClient(WithURL(u), WithHeaders(extra), WithUserAgent("shellhub-agent/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The headers are copied, so changing them afterwards has no effect.
+	extra.Set("X-Route", "changed")
+
+	if _, err := c.CheckUpdate("1.0.0", "stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := c.(*client)
+
+	conn, _, err := cli.tunnelDial(context.Background(), "ws", cli.host, cli.port, "/ssh/revdial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	for _, request := range []string{"API", "tunnel"} {
+		header := <-headers
+
+		if header.Get("X-Api-Key") != "secret" || strings.Join(header.Values("X-Route"), ",") != "eu,us" {
+			t.Errorf("%s request headers = %v, want the extra headers", request, header)
+		}
+
+		if header.Get("User-Agent") != "shellhub-agent/test" {
+			t.Errorf("%s request User-Agent = %q, want it kept along the extra headers", request, header.Get("User-Agent"))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewClientOptionError(t *testing.T) {
+	cases := []struct {
+		keepAlive KeepAlive
+		err       error
+	}{
+		{KeepAlive{Idle: time.Minute, Interval: 10 * time.Second, Count: 6}, nil},
+		{KeepAlive{Idle: 0, Interval: 10 * time.Second, Count: 6}, ErrInvalidKeepAlive},
+		{KeepAlive{Idle: time.Minute, Interval: time.Millisecond, Count: 6}, ErrInvalidKeepAlive},
+		{KeepAlive{Idle: time.Minute, Interval: 10 * time.Second, Count: 0}, ErrInvalidKeepAlive},
+	}
+
+	for _, tc := range cases {
+		cli, err := NewClient(WithTCPKeepAlive(tc.keepAlive))
+		if err != tc.err {
+			t.Errorf("NewClient(WithTCPKeepAlive(%+v)) error = %v, want %v", tc.keepAlive, err, tc.err)
+		}
+
+		if (cli == nil) == (tc.err == nil) {
+			t.Errorf("NewClient(WithTCPKeepAlive(%+v)) = %v, with error %v", tc.keepAlive, cli, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+var ErrInvalidKeepAlive = errors.New("tcp keep-alive idle time, interval and count must be positive")
+
+// KeepAlive holds the TCP keep-alive settings of the connections to the server.
+type KeepAlive struct {
+	// Idle is the time the connection stays idle before the first probe.
+	Idle time.Duration
+	// Interval is the time between the probes.
+	Interval time.Duration
+	// Count is the number of unanswered probes before the connection is
+	// dropped.
+	Count int
+}
+
+// Check checks that the keep-alive settings are usable by the kernel.
+func (k KeepAlive) Check() error {
+	if k.Idle < time.Second || k.Interval < time.Second || k.Count <= 0 {
+		return ErrInvalidKeepAlive
+	}
+
+	return nil
+}
+
+// control applies the keep-alive settings to the socket of a connection before
+// it connects. The kernel takes the times in seconds.
+func (k KeepAlive) control(network, address string, conn syscall.RawConn) error {
+	options := []struct {
+		level int
+		name  int
+		value int
+	}{
+		{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, int(k.Idle / time.Second)},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(k.Interval / time.Second)},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, k.Count},
+	}
+
+	var err error
+
+	if cerr := conn.Control(func(fd uintptr) {
+		for _, opt := range options {
+			if err = syscall.SetsockoptInt(int(fd), opt.level, opt.name, opt.value); err != nil {
+				return
+			}
+		}
+	}); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
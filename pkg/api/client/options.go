@@ -1,8 +1,12 @@
 package client
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -58,6 +62,162 @@ func WithPort(port int) Opt {
 	}
 }
 
+// WithRetryCount sets how many times a failed request is retried, which is
+// unlimited by default.
+func WithRetryCount(count int) Opt {
+	return func(c *client) error {
+		c.http.SetRetryCount(count)
+
+		return nil
+	}
+}
+
+// WithHandshakeTimeout sets the maximum time each request to the server, and
+// the WebSocket handshake of the reverse listener, can take before failing
+// with a timeout error. Zero keeps the defaults: no timeout for the requests,
+// and the default handshake timeout of the WebSocket dialer.
+func WithHandshakeTimeout(timeout time.Duration) Opt {
+	return func(c *client) error {
+		c.handshakeTimeout = timeout
+		c.http.SetTimeout(timeout)
+
+		return nil
+	}
+}
+
+// WithCompression offers the WebSocket permessage-deflate extension to the
+// server on the connections of the sessions, compressing their data when the
+// server accepts it.
+func WithCompression(compression bool) Opt {
+	return func(c *client) error {
+		c.compression = compression
+
+		return nil
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the server, both
+// by the API requests and the reverse listener.
+func WithTLSConfig(config *tls.Config) Opt {
+	return func(c *client) error {
+		c.tls = config
+		c.http.SetTLSClientConfig(config)
+
+		return nil
+	}
+}
+
+// WithProxy sets the function selecting the proxy used to connect to the server,
+// both by the API requests and the reverse listener, instead of the one read
+// from the environment.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Opt {
+	return func(c *client) error {
+		c.proxy = proxy
+
+		if transport, ok := c.http.GetClient().Transport.(*http.Transport); ok {
+			transport.Proxy = proxy
+		}
+
+		return nil
+	}
+}
+
+// WithLocalAddr sets the local address the connections to the server are made
+// from, both by the API requests and the reverse listener.
+func WithLocalAddr(addr net.Addr) Opt {
+	return func(c *client) error {
+		c.netDialer().LocalAddr = addr
+
+		return nil
+	}
+}
+
+// WithTCPKeepAlive enables the TCP keep-alive of the connections to the server,
+// both by the API requests and the reverse listener, with the settings of
+// keepAlive instead of the Go defaults.
+func WithTCPKeepAlive(keepAlive KeepAlive) Opt {
+	return func(c *client) error {
+		if err := keepAlive.Check(); err != nil {
+			return err
+		}
+
+		dialer := c.netDialer()
+		// A negative KeepAlive stops the dialer from overriding the settings
+		// applied to the socket before it connects.
+		dialer.KeepAlive = -1
+		dialer.Control = keepAlive.control
+
+		return nil
+	}
+}
+
+// WithResolver sets the resolver of the server endpoints, both for the API
+// requests and the reverse listener, instead of the system one.
+func WithResolver(resolver *net.Resolver) Opt {
+	return func(c *client) error {
+		c.netDialer().Resolver = resolver
+
+		return nil
+	}
+}
+
+// WithHostOverrides sets the IP addresses the hosts of the server endpoints are
+// resolved to, both for the API requests and the reverse listener.
+func WithHostOverrides(hosts HostOverrides) Opt {
+	return func(c *client) error {
+		c.hosts = hosts
+		c.netDialer()
+
+		return nil
+	}
+}
+
+// netDialer returns the dialer of the connections to the server, creating it,
+// and making the API requests use it, on the first call.
+func (c *client) netDialer() *net.Dialer {
+	if c.dialer != nil {
+		return c.dialer
+	}
+
+	c.dialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if transport, ok := c.http.GetClient().Transport.(*http.Transport); ok {
+		transport.DialContext = c.dialContext
+	}
+
+	return c.dialer
+}
+
+// WithUserAgent sets the User-Agent header of the requests to the server, both
+// by the API requests and the reverse listener.
+func WithUserAgent(userAgent string) Opt {
+	return func(c *client) error {
+		c.userAgent = userAgent
+		c.http.SetHeader("User-Agent", userAgent)
+
+		return nil
+	}
+}
+
+// WithHeaders adds the headers to the requests to the server, both by the API
+// requests and the reverse listener.
+func WithHeaders(header http.Header) Opt {
+	return func(c *client) error {
+		c.headers = header.Clone()
+
+		for name, values := range header {
+			for _, value := range values {
+				c.http.Header.Add(name, value)
+			}
+		}
+
+		return nil
+	}
+}
+
 func WithLogger(logger *logrus.Logger) Opt {
 	return func(c *client) error {
 		c.logger = logger
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"net"
+)
+
+// HostOverrides maps the hosts of the server endpoints to the IP addresses they
+// are resolved to when dialing, without any DNS query.
+type HostOverrides map[string]string
+
+// Address returns address, as host:port, with its host replaced by its
+// override, if any.
+func (h HostOverrides) Address(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	if ip, ok := h[host]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+
+	return address
+}
+
+// dialContext dials the server endpoints, applying the host overrides.
+func (c *client) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return c.netDialer().DialContext(ctx, network, c.hosts.Address(address))
+}
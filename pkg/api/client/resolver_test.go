@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+func TestHostOverridesAddress(t *testing.T) {
+	hosts := HostOverrides{
+		"cloud.shellhub.io": "203.0.113.10",
+		"ssh.shellhub.io":   "2001:db8::10",
+	}
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "cloud.shellhub.io:443", want: "203.0.113.10:443"},
+		{address: "ssh.shellhub.io:22", want: "[2001:db8::10]:22"},
+		{address: "other.shellhub.io:443", want: "other.shellhub.io:443"},
+		{address: "cloud.shellhub.io", want: "cloud.shellhub.io"},
+	}
+
+	for _, tt := range tests {
+		if got := hosts.Address(tt.address); got != tt.want {
+			t.Errorf("Address(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+
+	var none HostOverrides
+	if got := none.Address("cloud.shellhub.io:443"); got != "cloud.shellhub.io:443" {
+		t.Errorf("Address() = %q without overrides, want the address unchanged", got)
+	}
+}
+
+func TestWithHostOverrides(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.Info{Version: "1.2.0"}) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The host does not resolve, so the request only reaches the server
+	// through the override.
+	u, err := url.Parse("http://shellhub.invalid:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli, err := NewClient(WithURL(u), WithHostOverrides(HostOverrides{"shellhub.invalid": "127.0.0.1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := cli.GetInfoContext(ctx, "1.0.0")
+	if err != nil || info.Version != "1.2.0" {
+		t.Errorf("GetInfo() = %+v, %v, want the info of the overridden host", info, err)
+	}
+}
@@ -0,0 +1,105 @@
+package backoff
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// permanentError is an error Retry does not retry.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so Retry returns it without retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err}
+}
+
+// Backoff computes capped exponential delays with jitter. Each call to Next
+// doubles the delay, starting at Base, until it reaches Max.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	attempt int
+}
+
+// New creates a new Backoff starting at base and capped at max.
+func New(base, max time.Duration) *Backoff {
+	return &Backoff{
+		Base: base,
+		Max:  max,
+	}
+}
+
+// Next returns the delay to wait before the next attempt. The returned value
+// is randomized between half and the full exponential delay, avoiding many
+// clients retrying at the same time.
+func (b *Backoff) Next() time.Duration {
+	delay := b.Base
+	for i := 0; i < b.attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	b.attempt++
+
+	if delay < 2 {
+		return delay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2))) // nolint:gosec
+}
+
+// Reset restarts the delays from Base.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Retry calls fn until it succeeds or has been retried the given number of
+// times, waiting the backoff delay between the attempts. An error wrapped with
+// Permanent stops the retries. When not nil, notify is called after each
+// failed attempt that is going to be retried. The error of the last attempt is
+// returned, unwrapped when permanent.
+func Retry(retries int, b *Backoff, fn func() error, notify func(err error, delay time.Duration)) error {
+	err := fn()
+	for attempt := 0; err != nil && attempt < retries; attempt++ {
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		delay := b.Next()
+
+		if notify != nil {
+			notify(err, delay)
+		}
+
+		time.Sleep(delay)
+
+		err = fn()
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return permanent.err
+	}
+
+	return err
+}
@@ -0,0 +1,164 @@
+package backoff
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	b := New(time.Second, 10*time.Second)
+
+	// The delays double from Base until they reach Max, each randomized
+	// between half and the full delay.
+	for _, want := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	} {
+		got := b.Next()
+		if got < want/2 || got > want {
+			t.Errorf("Next() = %v, want between %v and %v", got, want/2, want)
+		}
+	}
+}
+
+func TestNextTinyDelay(t *testing.T) {
+	b := New(1, 1)
+
+	for i := 0; i < 3; i++ {
+		if got := b.Next(); got != 1 {
+			t.Errorf("Next() = %v, want 1ns", got)
+		}
+	}
+}
+
+func TestNextManyAttempts(t *testing.T) {
+	b := New(time.Second, time.Minute)
+
+	// The delay stops doubling once capped, never overflowing.
+	for i := 0; i < 1000; i++ {
+		b.Next()
+	}
+
+	if got := b.Next(); got < 30*time.Second || got > time.Minute {
+		t.Errorf("Next() = %v after many attempts, want between %v and %v", got, 30*time.Second, time.Minute)
+	}
+}
+
+func TestReset(t *testing.T) {
+	b := New(time.Second, time.Hour)
+
+	for i := 0; i < 10; i++ {
+		b.Next()
+	}
+
+	b.Reset()
+
+	if got := b.Next(); got < time.Second/2 || got > time.Second {
+		t.Errorf("Next() = %v after Reset, want between %v and %v", got, time.Second/2, time.Second)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		calls    int
+		notified int
+		err      error
+	}{
+		{name: "success", retries: 3, failures: 0, calls: 1, notified: 0},
+		{name: "success after failures", retries: 3, failures: 2, calls: 3, notified: 2},
+		{name: "success on the last retry", retries: 3, failures: 3, calls: 4, notified: 3},
+		{name: "retries exhausted", retries: 3, failures: 10, calls: 4, notified: 3, err: errFailed},
+		{name: "no retries", retries: 0, failures: 10, calls: 1, notified: 0, err: errFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, notified int
+
+			err := Retry(tt.retries, New(time.Millisecond, time.Millisecond), func() error {
+				calls++
+
+				if calls <= tt.failures {
+					return errFailed
+				}
+
+				return nil
+			}, func(err error, delay time.Duration) {
+				notified++
+
+				if err != errFailed || delay > time.Millisecond {
+					t.Errorf("notified with %v, %v, want %v, at most %v", err, delay, errFailed, time.Millisecond)
+				}
+			})
+			if err != tt.err {
+				t.Errorf("Retry() error = %v, want %v", err, tt.err)
+			}
+
+			if calls != tt.calls || notified != tt.notified {
+				t.Errorf("Retry() called fn %d times and notify %d times, want %d and %d", calls, notified, tt.calls, tt.notified)
+			}
+		})
+	}
+}
+
+func TestRetryWithoutNotify(t *testing.T) {
+	calls := 0
+
+	err := Retry(2, New(time.Millisecond, time.Millisecond), func() error {
+		calls++
+
+		return errors.New("failed")
+	}, nil)
+	if err == nil || calls != 3 {
+		t.Errorf("Retry() = %v after %d calls, want an error after 3", err, calls)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	errFailed := errors.New("failed")
+	errRejected := errors.New("rejected")
+
+	tests := []struct {
+		name    string
+		retries int
+		calls   int
+	}{
+		{name: "stops the retries", retries: 3, calls: 2},
+		{name: "unwrapped on the last attempt", retries: 1, calls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+
+			err := Retry(tt.retries, New(time.Millisecond, time.Millisecond), func() error {
+				calls++
+
+				if calls == 1 {
+					return errFailed
+				}
+
+				return Permanent(errRejected)
+			}, nil)
+			if err != errRejected || calls != tt.calls {
+				t.Errorf("Retry() = %v after %d calls, want %v after %d", err, calls, errRejected, tt.calls)
+			}
+		})
+	}
+}
+
+func TestPermanentNil(t *testing.T) {
+	if err := Permanent(nil); err != nil {
+		t.Errorf("Permanent(nil) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Package control implements a local control interface over a unix socket,
+// letting the operators send commands to the running agent. Each line sent to
+// the socket is a command followed by its space-separated arguments, answered
+// with a line holding a JSON encoded Response.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrEmptyCommand   = errors.New("empty command")
+)
+
+// Handler handles a command, returning the result encoded in the response.
+type Handler func(args []string) (interface{}, error)
+
+// Response is the answer to a command.
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Server serves the commands received through a unix socket.
+type Server struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewServer creates a new Server with no commands.
+func NewServer() *Server {
+	return &Server{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler of the command name.
+func (s *Server) Handle(name string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[name] = handler
+}
+
+// Listen listens on the unix socket at path, replacing a socket left by a
+// previous run. The socket is only accessible by the user running the agent.
+func Listen(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Serve accepts the connections on listener until it is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		if err := encoder.Encode(s.Exec(scanner.Text())); err != nil {
+			log.WithError(err).Debug("Failed to write control command response")
+
+			return
+		}
+	}
+}
+
+// Exec runs the command line, returning its response.
+func (s *Server) Exec(line string) *Response {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return &Response{Error: ErrEmptyCommand.Error()}
+	}
+
+	s.mu.RLock()
+	handler, ok := s.handlers[fields[0]]
+	s.mu.RUnlock()
+
+	if !ok {
+		return &Response{Error: fmt.Sprintf("%s: %s", ErrUnknownCommand, fields[0])}
+	}
+
+	result, err := handler(fields[1:])
+	if err != nil {
+		return &Response{Error: err.Error()}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return &Response{Error: err.Error()}
+	}
+
+	return &Response{Result: data}
+}
+
+// Send sends the command line to the agent listening on the unix socket at
+// path, returning the result of the command.
+func Send(path, line string) (json.RawMessage, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return nil, err
+	}
+
+	var res Response
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+
+	return res.Result, nil
+}
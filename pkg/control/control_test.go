@@ -0,0 +1,135 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	s := NewServer()
+
+	s.Handle("echo", func(args []string) (interface{}, error) {
+		return args, nil
+	})
+
+	s.Handle("fail", func(args []string) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+
+	tests := []struct {
+		name   string
+		line   string
+		result string
+		err    string
+	}{
+		{name: "command", line: "echo", result: `[]`},
+		{name: "arguments", line: "echo a b", result: `["a","b"]`},
+		{name: "extra spaces", line: "  echo   a  ", result: `["a"]`},
+		{name: "empty", line: " ", err: "empty command"},
+		{name: "unknown", line: "missing a", err: "unknown command: missing"},
+		{name: "handler error", line: "fail", err: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.Exec(tt.line)
+
+			if string(res.Result) != tt.result || res.Error != tt.err {
+				t.Errorf("Exec(%q) = {%s %q}, want {%s %q}", tt.line, res.Result, res.Error, tt.result, tt.err)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	s := NewServer()
+	s.Handle("echo", func(args []string) (interface{}, error) {
+		return args, nil
+	})
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	go s.Serve(listener) // nolint:errcheck
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	result, err := Send(path, "echo a b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var args []string
+	if err := json.Unmarshal(result, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(args) != 2 || args[0] != "a" || args[1] != "b" {
+		t.Errorf("Send(echo a b) = %v, want [a b]", args)
+	}
+
+	if _, err := Send(path, "missing"); err == nil || err.Error() != "unknown command: missing" {
+		t.Errorf("Send(missing) error = %v, want the unknown command", err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket file is kept on close, as left by an agent that did not
+	// exit cleanly.
+	if l, ok := listener.(interface{ SetUnlinkOnClose(bool) }); ok {
+		l.SetUnlinkOnClose(false)
+	}
+
+	listener.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err = Listen(path)
+	if err != nil {
+		t.Fatalf("Listen on a stale socket: %v", err)
+	}
+
+	listener.Close()
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if listener, err := Listen(path); err == nil {
+		listener.Close()
+		t.Fatal("Listen replaced a regular file")
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("file = %q, %v, want it unchanged", data, err)
+	}
+}
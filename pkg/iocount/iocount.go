@@ -0,0 +1,54 @@
+// Package iocount counts the bytes read from and written to connections.
+package iocount
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Counters holds the number of bytes read and written. It is safe for
+// concurrent use, so the same counters can be shared to total many
+// connections.
+type Counters struct {
+	read    int64
+	written int64
+}
+
+// Read returns the number of bytes read.
+func (c *Counters) Read() int64 {
+	return atomic.LoadInt64(&c.read)
+}
+
+// Written returns the number of bytes written.
+func (c *Counters) Written() int64 {
+	return atomic.LoadInt64(&c.written)
+}
+
+type conn struct {
+	net.Conn
+	counters []*Counters
+}
+
+// NewConn wraps c, adding the bytes read from and written to it to each of the
+// counters.
+func NewConn(c net.Conn, counters ...*Counters) net.Conn {
+	return &conn{Conn: c, counters: counters}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for _, counters := range c.counters {
+		atomic.AddInt64(&counters.read, int64(n))
+	}
+
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	for _, counters := range c.counters {
+		atomic.AddInt64(&counters.written, int64(n))
+	}
+
+	return n, err
+}
@@ -5,15 +5,31 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/pkg/errors"
+	gossh "golang.org/x/crypto/ssh"
 )
 
-var ErrPemDecode = errors.New("PEM decode error")
+var (
+	ErrPemDecode          = errors.New("PEM decode error")
+	ErrPassphraseRequired = errors.New("private key is encrypted and no passphrase is set")
+	ErrWrongPassphrase    = errors.New("wrong private key passphrase")
+	ErrMalformedKey       = errors.New("malformed private key")
+	ErrNotRSAKey          = errors.New("private key is not a RSA key")
+)
 
+// GeneratePrivateKey generates a new RSA private key, writing it PEM encoded to
+// filename, readable only by its owner. An existing file is never overwritten.
 func GeneratePrivateKey(filename string) error {
+	return GenerateEncryptedPrivateKey(filename, nil)
+}
+
+// GenerateEncryptedPrivateKey generates a new RSA private key like
+// GeneratePrivateKey, encrypting it with passphrase when it is not empty.
+func GenerateEncryptedPrivateKey(filename string, passphrase []byte) error {
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return err
@@ -29,7 +45,7 @@ func GeneratePrivateKey(filename string) error {
 		return err
 	}
 
-	f, err := os.Create(filename)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 	if err != nil {
 		return err
 	}
@@ -41,6 +57,15 @@ func GeneratePrivateKey(filename string) error {
 		Bytes: x509.MarshalPKCS1PrivateKey(key),
 	}
 
+	if len(passphrase) > 0 {
+		// The legacy PEM encryption is the one of the keys encrypted by
+		// OpenSSL and by OpenSSH with the PEM format.
+		privateKey, err = x509.EncryptPEMBlock(rand.Reader, privateKey.Type, privateKey.Bytes, passphrase, x509.PEMCipherAES256) // nolint:staticcheck
+		if err != nil {
+			return err
+		}
+	}
+
 	err = pem.Encode(f, privateKey)
 	if err != nil {
 		return err
@@ -49,18 +74,51 @@ func GeneratePrivateKey(filename string) error {
 	return f.Sync()
 }
 
-func ReadPublicKey(filename string) (*rsa.PublicKey, error) {
+// ReadPrivateKey reads the PEM encoded RSA private key from filename,
+// decrypting it with passphrase when it is encrypted. An unencrypted key is
+// read as it is, whatever the passphrase.
+func ReadPrivateKey(filename string, passphrase []byte) (*rsa.PrivateKey, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(data)
-	if block == nil {
+	if block, _ := pem.Decode(data); block == nil {
 		return nil, ErrPemDecode
 	}
 
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	raw, err := gossh.ParseRawPrivateKey(data)
+
+	var missing *gossh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+
+		// The padding check only catches most of the wrong passphrases: the
+		// others decrypt to garbage, which fails to parse.
+		raw, err = gossh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+		if err != nil {
+			return nil, ErrWrongPassphrase
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedKey, err)
+	}
+
+	key, ok := raw.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotRSAKey
+	}
+
+	return key, nil
+}
+
+// ReadPublicKey reads the public key of the unencrypted PEM encoded RSA private
+// key from filename.
+func ReadPublicKey(filename string) (*rsa.PublicKey, error) {
+	key, err := ReadPrivateKey(filename, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,163 @@
+package keygen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratePrivateKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	filename := filepath.Join(dir, "shellhub.key")
+
+	if err := GeneratePrivateKey(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("private key permissions = %o, want 600", perm)
+	}
+
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Errorf("parent directory = %v, %v, want it created with the permissions 700", fi.Mode(), err)
+	}
+
+	key, err := ReadPrivateKey(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.N.BitLen() != 2048 {
+		t.Errorf("private key size = %d bits, want 2048", key.N.BitLen())
+	}
+
+	if _, err := ReadPublicKey(filename); err != nil {
+		t.Errorf("ReadPublicKey() = %v", err)
+	}
+}
+
+func TestGeneratePrivateKeyExisting(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shellhub.key")
+
+	if err := os.WriteFile(filename, []byte("existing"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GeneratePrivateKey(filename); !errors.Is(err, os.ErrExist) {
+		t.Errorf("GeneratePrivateKey() = %v over an existing file, want %v", err, os.ErrExist)
+	}
+
+	if data, _ := os.ReadFile(filename); string(data) != "existing" {
+		t.Errorf("existing file = %q, want it unchanged", data)
+	}
+}
+
+func TestReadPrivateKeyInvalid(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "not pem", data: []byte("not a key"), err: ErrPemDecode},
+		{name: "malformed", data: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")}), err: ErrMalformedKey},
+		{name: "not rsa", data: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), err: ErrNotRSAKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "shellhub.key")
+			if err := os.WriteFile(filename, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ReadPrivateKey(filename, nil); !errors.Is(err, tt.err) {
+				t.Errorf("ReadPrivateKey() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGenerateEncryptedPrivateKey(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shellhub.key")
+
+	if err := GenerateEncryptedPrivateKey(filename, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase []byte
+		err        error
+	}{
+		{name: "right passphrase", passphrase: []byte("passphrase")},
+		{name: "no passphrase", err: ErrPassphraseRequired},
+		{name: "wrong passphrase", passphrase: []byte("wrong"), err: ErrWrongPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ReadPrivateKey(filename, tt.passphrase)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ReadPrivateKey() = %v, want %v", err, tt.err)
+			}
+
+			if err == nil && key.N.BitLen() != 2048 {
+				t.Errorf("private key size = %d bits, want 2048", key.N.BitLen())
+			}
+		})
+	}
+}
+
+func TestGenerateEncryptedPrivateKeyNoPassphrase(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shellhub.key")
+
+	if err := GenerateEncryptedPrivateKey(filename, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadPrivateKey(filename, nil); err != nil {
+		t.Errorf("ReadPrivateKey() = %v, want the key not encrypted", err)
+	}
+
+	if _, err := ReadPrivateKey(filename, []byte("ignored")); err != nil {
+		t.Errorf("ReadPrivateKey() = %v with a passphrase, want it ignored for a key not encrypted", err)
+	}
+}
+
+func TestReadPrivateKeyWrongPassphrase(t *testing.T) {
+	// Some wrong passphrases pass the padding check and decrypt to garbage:
+	// they are reported as wrong passphrases too.
+	filename := filepath.Join(t.TempDir(), "shellhub.key")
+
+	if err := GenerateEncryptedPrivateKey(filename, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 512; i++ {
+		if _, err := ReadPrivateKey(filename, []byte(fmt.Sprintf("wrong-%d", i))); !errors.Is(err, ErrWrongPassphrase) {
+			t.Fatalf("ReadPrivateKey() = %v with the passphrase wrong-%d, want %v", err, i, ErrWrongPassphrase)
+		}
+	}
+}
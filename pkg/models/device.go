@@ -67,6 +67,18 @@ type DeviceInfo struct {
 	Version    string `json:"version"`
 	Arch       string `json:"arch"`
 	Platform   string `json:"platform"`
+
+	Facts *DeviceFacts `json:"facts,omitempty"`
+}
+
+// DeviceFacts are the hardware and OS facts of the device, reported for the
+// server inventory. A fact which could not be collected is omitted.
+type DeviceFacts struct {
+	KernelVersion string `json:"kernel_version,omitempty"`
+	MemTotal      uint64 `json:"mem_total,omitempty"`
+	CPUModel      string `json:"cpu_model,omitempty"`
+	CPUCount      int    `json:"cpu_count,omitempty"`
+	MAC           string `json:"mac,omitempty"`
 }
 
 type DevicePosition struct {
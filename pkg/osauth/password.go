@@ -0,0 +1,52 @@
+package osauth
+
+import (
+	"strings"
+
+	"github.com/GehirnInc/crypt"
+	_ "github.com/GehirnInc/crypt/apr1_crypt"   // Registers the apr1 ($apr1$) algorithm.
+	_ "github.com/GehirnInc/crypt/md5_crypt"    // Registers the bsd1 ($1$) algorithm.
+	_ "github.com/GehirnInc/crypt/sha256_crypt" // Registers the sha256 ($5$) algorithm.
+	_ "github.com/GehirnInc/crypt/sha512_crypt" // Registers the sha512 ($6$) algorithm.
+	"github.com/brycedjohnson/shellhub-agent/pkg/yescrypt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptPrefixes are the prefixes of the bcrypt hashes, as generated by the
+// different implementations.
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// yescryptPrefix is the prefix of the yescrypt hashes.
+const yescryptPrefix = "$y$"
+
+func isBcrypt(hash string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsPasswordHashSupported reports whether the algorithm of the password hash is
+// supported, as identified by its prefix.
+func IsPasswordHashSupported(hash string) bool {
+	return isBcrypt(hash) || strings.HasPrefix(hash, yescryptPrefix) || crypt.IsHashSupported(hash)
+}
+
+// VerifyPasswordHash reports whether the password matches the hash, in the
+// crypt(3) format generated by openssl passwd, htpasswd -B or mkpasswd. The
+// algorithm is chosen from the hash prefix.
+func VerifyPasswordHash(hash, password string) bool {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, yescryptPrefix):
+		return yescrypt.Verify(password, hash)
+	case crypt.IsHashSupported(hash):
+		return crypt.NewFromHash(hash).Verify(hash, []byte(password)) == nil
+	default:
+		return false
+	}
+}
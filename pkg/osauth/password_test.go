@@ -0,0 +1,61 @@
+package osauth
+
+import (
+	"testing"
+)
+
+func TestVerifyPasswordHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "bsd1", hash: "$1$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1"},
+		{name: "apr1", hash: "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/"},
+		{name: "sha256", hash: "$5$abcdefgh$gruCpC7VkOTspMQTTSAR8mtlO9Upms.fwqE5y16JVM."},
+		{name: "sha512", hash: "$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."},
+		{name: "bcrypt 2a", hash: "$2a$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW"},
+		{name: "bcrypt 2b", hash: "$2b$04$zyxwvutsrqponmlkjihgfeLEldsuYBkqSxhh6lAGORxWWNDcqX2Ly"},
+		{name: "bcrypt 2y", hash: "$2y$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW"},
+		{name: "yescrypt", hash: "$y$j9T$abcdefgh$IUWJt2doU4Kf6E/JP1UkAT3S5O3Q7SSA8SJirXIlEu9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsPasswordHashSupported(tt.hash) {
+				t.Fatalf("IsPasswordHashSupported(%q) = false", tt.hash)
+			}
+
+			if !VerifyPasswordHash(tt.hash, "secret") {
+				t.Errorf("VerifyPasswordHash(%q, secret) = false", tt.hash)
+			}
+
+			if VerifyPasswordHash(tt.hash, "wrong") {
+				t.Errorf("VerifyPasswordHash(%q, wrong) = true", tt.hash)
+			}
+		})
+	}
+}
+
+func TestUnsupportedPasswordHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty", hash: ""},
+		{name: "plain text", hash: "secret"},
+		{name: "unknown prefix", hash: "$9$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1"},
+		{name: "des", hash: "abJnggxhB/yWI"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if IsPasswordHashSupported(tt.hash) {
+				t.Errorf("IsPasswordHashSupported(%q) = true", tt.hash)
+			}
+
+			if VerifyPasswordHash(tt.hash, "secret") {
+				t.Errorf("VerifyPasswordHash(%q, secret) = true", tt.hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package ratelimit limits the throughput of readers, writers and connections
+// using a token bucket.
+package ratelimit
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket refilled at a fixed rate of bytes per second,
+// holding at most one second worth of tokens unless created with NewRate. It is
+// safe for concurrent use, so the same limiter can be shared to cap the
+// aggregate throughput of many streams.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// New creates a new Limiter allowing bytesPerSecond bytes per second.
+func New(bytesPerSecond int) *Limiter {
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// NewRate creates a new Limiter allowing n events per period, all of which can
+// happen at once.
+func NewRate(n int, period time.Duration) *Limiter {
+	return &Limiter{
+		rate:   float64(n) / period.Seconds(),
+		burst:  float64(n),
+		tokens: float64(n),
+		last:   time.Now(),
+	}
+}
+
+// Burst returns the maximum number of bytes, or events, that can be consumed at
+// once.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// refill adds the tokens accumulated since the last call. It must be called
+// with the lock held.
+func (l *Limiter) refill() {
+	now := time.Now()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	l.last = now
+}
+
+// Allow consumes n tokens and reports true when they are available, otherwise
+// it reports false without consuming any.
+func (l *Limiter) Allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	if l.tokens < float64(n) {
+		return false
+	}
+
+	l.tokens -= float64(n)
+
+	return true
+}
+
+// Wait blocks until n bytes can be consumed. n should not be greater than the
+// burst size, or the wait will be longer than needed.
+func (l *Limiter) Wait(n int) {
+	l.mu.Lock()
+
+	l.refill()
+
+	// The tokens are taken in advance, so the concurrent callers wait in turn.
+	l.tokens -= float64(n)
+	tokens := l.tokens
+
+	l.mu.Unlock()
+
+	if tokens < 0 {
+		time.Sleep(time.Duration(-tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// chunks calls fn with p split in chunks not greater than the burst size,
+// waiting for the limiter before each one.
+func (l *Limiter) chunks(p []byte, fn func([]byte) (int, error)) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > l.Burst() {
+			chunk = chunk[:l.Burst()]
+		}
+
+		l.Wait(len(chunk))
+
+		n, err := fn(chunk)
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+type writer struct {
+	io.Writer
+	limiter *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.limiter.chunks(p, w.Writer.Write)
+}
+
+// NewWriter limits the writes to w. When limiter is nil, w is returned.
+func NewWriter(w io.Writer, limiter *Limiter) io.Writer {
+	if limiter == nil {
+		return w
+	}
+
+	return &writer{w, limiter}
+}
+
+type conn struct {
+	net.Conn
+	limiter *Limiter
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(p) > c.limiter.Burst() {
+		p = p[:c.limiter.Burst()]
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.limiter.Wait(n)
+	}
+
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.limiter.chunks(p, c.Conn.Write)
+}
+
+// NewConn limits the aggregate throughput of reads and writes on c. When
+// limiter is nil, c is returned.
+func NewConn(c net.Conn, limiter *Limiter) net.Conn {
+	if limiter == nil {
+		return c
+	}
+
+	return &conn{c, limiter}
+}
@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewWriter(t *testing.T) {
+	const rate = 100 * 1024
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf, New(rate))
+
+	// The first second worth of bytes is written at once, the following ones
+	// at the rate.
+	start := time.Now()
+
+	n, err := w.Write(make([]byte, 2*rate))
+	if err != nil || n != 2*rate {
+		t.Fatalf("Write() = %d, %v, want %d, nil", n, err, 2*rate)
+	}
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("Write() took %v, want about 1s", elapsed)
+	}
+
+	if buf.Len() != 2*rate {
+		t.Errorf("%d bytes written, want %d", buf.Len(), 2*rate)
+	}
+}
+
+func TestNewWriterShared(t *testing.T) {
+	const rate = 100 * 1024
+
+	limiter := New(rate)
+
+	// The writers sharing a limiter are capped together.
+	start := time.Now()
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			NewWriter(io.Discard, limiter).Write(make([]byte, rate)) // nolint:errcheck
+			done <- struct{}{}
+		}()
+	}
+
+	<-done
+	<-done
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("writes took %v, want about 1s", elapsed)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+
+	if w := NewWriter(&buf, nil); w != &buf {
+		t.Error("NewWriter() wrapped the writer without a limiter")
+	}
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	if c := NewConn(conn, nil); c != conn {
+		t.Error("NewConn() wrapped the connection without a limiter")
+	}
+}
+
+func TestNewConn(t *testing.T) {
+	const rate = 100 * 1024
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	c := NewConn(conn, New(rate))
+	defer c.Close()
+
+	go func() {
+		buf := make([]byte, rate)
+		for read := 0; read < len(buf); {
+			n, err := peer.Read(buf[read:])
+			if err != nil {
+				return
+			}
+
+			read += n
+		}
+
+		peer.Write(buf) // nolint:errcheck
+	}()
+
+	// The bytes written and read share the same rate.
+	start := time.Now()
+
+	if _, err := c.Write(make([]byte, rate)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(c, make([]byte, rate)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("exchange took %v, want about 1s", elapsed)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l := NewRate(3, time.Hour)
+
+	if l.Burst() != 3 {
+		t.Errorf("Burst() = %d, want 3", l.Burst())
+	}
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow(1) {
+			t.Fatalf("Allow() = false for event %d, want true within the burst", i+1)
+		}
+	}
+
+	if l.Allow(1) {
+		t.Error("Allow() = true once the burst is consumed, want false")
+	}
+
+	if l.Allow(4) {
+		t.Error("Allow() = true for more than the burst, want false")
+	}
+}
@@ -0,0 +1,239 @@
+package revdial
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pingConn is a server connection answering the heartbeats as told by answer,
+// called with the number of the ping starting at 1.
+type pingConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	answer   func(ping int) bool
+	pings    int
+	lastPong time.Time
+}
+
+func (c *pingConn) Ping(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pings++
+	if c.answer(c.pings) {
+		c.lastPong = time.Now()
+	}
+
+	return nil
+}
+
+func (c *pingConn) LastPong() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastPong
+}
+
+func (c *pingConn) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pings
+}
+
+// newTestListener returns a Listener whose server connection answers the
+// heartbeats as told by answer.
+func newTestListener(t *testing.T, answer func(ping int) bool) (*Listener, *pingConn) {
+	t.Helper()
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	sc := &pingConn{Conn: conn, answer: answer}
+	ln := NewListener(sc, nil)
+
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	return ln, sc
+}
+
+// heartbeat runs the heartbeat of ln in background, returning its result.
+func heartbeat(ln *Listener, maxFailures int) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- ln.Heartbeat(10*time.Millisecond, 20*time.Millisecond, maxFailures)
+	}()
+
+	return done
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	ln, _ := newTestListener(t, func(int) bool { return false })
+
+	select {
+	case err := <-heartbeat(ln, 1):
+		if err != ErrHeartbeatTimeout {
+			t.Errorf("Heartbeat() = %v, want %v", err, ErrHeartbeatTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Heartbeat did not time out")
+	}
+
+	if !ln.Closed() {
+		t.Error("the listener is open after the heartbeat timed out")
+	}
+}
+
+func TestHeartbeatAnswered(t *testing.T) {
+	ln, sc := newTestListener(t, func(int) bool { return true })
+
+	done := heartbeat(ln, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sc.count() < 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("the heartbeats were not sent")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if ln.Closed() {
+		t.Fatal("the listener was closed while the server answered the heartbeats")
+	}
+
+	ln.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Heartbeat() = %v after the listener was closed, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Heartbeat did not return after the listener was closed")
+	}
+}
+
+func TestHeartbeatRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		answered bool
+	}{
+		{name: "answered", answered: true},
+		{name: "unanswered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, sc := newTestListener(t, func(int) bool { return tt.answered })
+
+			rtts := make(chan time.Duration, 16)
+			ln.OnRoundTrip(func(rtt time.Duration) {
+				select {
+				case rtts <- rtt:
+				default:
+				}
+			})
+
+			done := heartbeat(ln, 100)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for sc.count() < 3 {
+				if time.Now().After(deadline) {
+					t.Fatal("the heartbeats were not sent")
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			ln.Close()
+			<-done
+
+			if !tt.answered {
+				if len(rtts) != 0 {
+					t.Errorf("reported %d round-trip times for unanswered heartbeats, want none", len(rtts))
+				}
+
+				return
+			}
+
+			if len(rtts) < 2 {
+				t.Fatalf("reported %d round-trip times, want one per answered heartbeat", len(rtts))
+			}
+
+			for len(rtts) > 0 {
+				// The heartbeats are answered as soon as they are sent.
+				if rtt := <-rtts; rtt < 0 || rtt > 20*time.Millisecond {
+					t.Errorf("round-trip time = %v, want at most the timeout", rtt)
+				}
+			}
+		})
+	}
+}
+
+func TestHeartbeatUnsupported(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	ln := NewListener(conn, nil)
+	defer ln.Close()
+
+	if err := ln.Heartbeat(time.Millisecond, time.Millisecond, 1); err != nil {
+		t.Errorf("Heartbeat() = %v, want nil for a connection without heartbeats", err)
+	}
+
+	if ln.Closed() {
+		t.Error("the listener was closed")
+	}
+}
+
+func TestFailureCounter(t *testing.T) {
+	c := &failureCounter{threshold: 3}
+
+	steps := []struct {
+		ok   bool
+		want bool
+	}{
+		{ok: false, want: false},
+		{ok: false, want: false},
+		{ok: true, want: false},
+		{ok: false, want: false},
+		{ok: false, want: false},
+		{ok: false, want: true},
+		{ok: false, want: true},
+	}
+
+	for i, step := range steps {
+		if got := c.record(step.ok); got != step.want {
+			t.Errorf("step %d: record(%v) = %v, want %v", i, step.ok, got, step.want)
+		}
+	}
+}
+
+func TestHeartbeatMaxFailures(t *testing.T) {
+	// The server misses two heartbeats in a row, then answers one, then
+	// stops answering.
+	ln, sc := newTestListener(t, func(ping int) bool { return ping == 3 })
+
+	select {
+	case err := <-heartbeat(ln, 3):
+		if err != ErrHeartbeatTimeout {
+			t.Errorf("Heartbeat() = %v, want %v", err, ErrHeartbeatTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Heartbeat did not time out")
+	}
+
+	// The missed heartbeats before the answered one are not counted.
+	if pings := sc.count(); pings != 6 {
+		t.Errorf("Heartbeat sent %d pings, want 6", pings)
+	}
+}
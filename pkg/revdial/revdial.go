@@ -269,6 +269,7 @@ type Listener struct {
 	mu      sync.Mutex // guards below, closing connc, and writing to rw
 	readErr error
 	closed  bool
+	onRTT   func(time.Duration)
 }
 
 type controlMsg struct {
@@ -405,6 +406,116 @@ func (ln *Listener) Accept() (net.Conn, error) {
 // ErrListenerClosed is returned by Accept after Close has been called.
 var ErrListenerClosed = errors.New("revdial: Listener closed")
 
+// ErrHeartbeatTimeout is returned by Heartbeat when the server has not answered
+// a heartbeat in time.
+var ErrHeartbeatTimeout = errors.New("revdial: heartbeat timeout")
+
+// heartbeatConn is a server connection able to check that the server is still
+// answering, such as the WebSocket connection adapter.
+type heartbeatConn interface {
+	Ping(deadline time.Time) error
+	LastPong() time.Time
+}
+
+// failureCounter counts the consecutive failures, reporting when they reach
+// the threshold.
+type failureCounter struct {
+	threshold int
+	failures  int
+}
+
+// record records an attempt, returning whether the consecutive failures have
+// reached the threshold. A success resets the count.
+func (c *failureCounter) record(ok bool) bool {
+	if ok {
+		c.failures = 0
+
+		return false
+	}
+
+	c.failures++
+
+	return c.failures >= c.threshold
+}
+
+// Heartbeat pings the server every interval, closing the Listener when the
+// server does not answer within timeout maxFailures times in a row, so a
+// connection that died silently does not keep Accept blocked forever. It
+// returns when the Listener is closed, immediately if the server connection
+// does not support heartbeats.
+func (ln *Listener) Heartbeat(interval, timeout time.Duration, maxFailures int) error {
+	hc, ok := ln.sc.(heartbeatConn)
+	if !ok {
+		return nil
+	}
+
+	wait := func(d time.Duration) bool {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			return true
+		case <-ln.donec:
+			return false
+		}
+	}
+
+	failures := &failureCounter{threshold: maxFailures}
+
+	for {
+		if !wait(interval) {
+			return nil
+		}
+
+		sent := clock.Now()
+		if err := hc.Ping(sent.Add(timeout)); err != nil {
+			if failures.record(false) {
+				ln.Close()
+
+				return err
+			}
+
+			continue
+		}
+
+		if !wait(timeout) {
+			return nil
+		}
+
+		pong := hc.LastPong()
+		if !pong.Before(sent) {
+			ln.roundTrip(pong.Sub(sent))
+		}
+
+		if failures.record(!pong.Before(sent)) {
+			ln.Close()
+
+			return ErrHeartbeatTimeout
+		}
+	}
+}
+
+// OnRoundTrip sets f to be called with the round-trip time of each heartbeat
+// answered by the server.
+func (ln *Listener) OnRoundTrip(f func(rtt time.Duration)) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.onRTT = f
+}
+
+// roundTrip reports the round-trip time of a heartbeat.
+func (ln *Listener) roundTrip(rtt time.Duration) {
+	ln.mu.Lock()
+	f := ln.onRTT
+	ln.mu.Unlock()
+
+	if f != nil {
+		f(rtt)
+	}
+}
+
 // Close closes the Listener, making future Accept calls return an
 // error.
 func (ln *Listener) Close() error {
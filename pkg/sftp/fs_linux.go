@@ -0,0 +1,621 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/sys/unix"
+)
+
+// resolveRetries bounds how many times a path is resolved again when the
+// kernel reports a concurrent rename racing with the resolution.
+const resolveRetries = 16
+
+// fileSystem serves the requests on the file system under the root directory.
+//
+// Every path is resolved by the kernel, with openat2 and RESOLVE_IN_ROOT, from a
+// file descriptor of the root directory: ".." and the symbolic links, absolute
+// ones included, are resolved as if the root directory were the root of the
+// file system, even when they are changed while being resolved. The last
+// component of the paths is then operated on with the *at system calls,
+// relative to a file descriptor of its parent directory, so nothing is
+// resolved twice.
+type fileSystem struct {
+	root     int
+	confined bool
+	readOnly bool
+
+	// inFlight bounds the reads and writes of file data handled at once.
+	inFlight   chan struct{}
+	packetSize int
+}
+
+func newFileSystem(root string, readOnly bool, maxInFlight, packetSize int) (*fileSystem, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	fs := &fileSystem{
+		root:       fd,
+		confined:   true,
+		readOnly:   readOnly,
+		inFlight:   make(chan struct{}, maxInFlight),
+		packetSize: packetSize,
+	}
+
+	probe, err := unix.Openat2(fd, ".", &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT,
+	})
+	switch {
+	case err == nil:
+		unix.Close(probe)
+	case err == unix.ENOSYS && root == "/":
+		// Paths can't escape the root of the file system: they are resolved as
+		// usual on the kernels without openat2.
+		fs.confined = false
+	case err == unix.ENOSYS:
+		unix.Close(fd)
+
+		return nil, ErrConfinement
+	default:
+		unix.Close(fd)
+
+		return nil, &os.PathError{Op: "openat2", Path: root, Err: err}
+	}
+
+	return fs, nil
+}
+
+// Close closes the root directory.
+func (fs *fileSystem) Close() error {
+	return unix.Close(fs.root)
+}
+
+func (fs *fileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  fs,
+		FilePut:  fs,
+		FileCmd:  fs,
+		FileList: fs,
+	}
+}
+
+// open opens the path in the root directory. The mode is only used when the
+// file is created.
+func (fs *fileSystem) open(p string, flags int, mode uint32) (int, error) {
+	rel := relative(p)
+	flags |= unix.O_CLOEXEC
+
+	if flags&unix.O_CREAT == 0 {
+		mode = 0
+	}
+
+	if !fs.confined {
+		fd, err := unix.Openat(fs.root, rel, flags, mode)
+		if err != nil {
+			return -1, &os.PathError{Op: "open", Path: p, Err: err}
+		}
+
+		return fd, nil
+	}
+
+	how := &unix.OpenHow{
+		Flags:   uint64(flags),
+		Mode:    uint64(mode),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+
+	var err error
+	for i := 0; i < resolveRetries; i++ {
+		var fd int
+		if fd, err = unix.Openat2(fs.root, rel, how); err == nil {
+			return fd, nil
+		}
+
+		if err != unix.EAGAIN && err != unix.EINTR {
+			break
+		}
+	}
+
+	return -1, &os.PathError{Op: "open", Path: p, Err: err}
+}
+
+// openParent opens the parent directory of the path, returning it with the
+// name of the last component of the path. The root directory itself has no
+// parent and can't be operated on.
+func (fs *fileSystem) openParent(p string) (int, string, error) {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return -1, "", &os.PathError{Op: "open", Path: p, Err: unix.EPERM}
+	}
+
+	dir, err := fs.open(path.Dir(p), unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+
+	return dir, path.Base(p), nil
+}
+
+// relative returns the path relative to the root directory.
+func relative(p string) string {
+	rel := path.Clean("/" + p)[1:]
+	if rel == "" {
+		return "."
+	}
+
+	return rel
+}
+
+// Fileread opens a file to be read.
+func (fs *fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	fd, err := fs.open(r.Filepath, unix.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: os.NewFile(uintptr(fd), r.Filepath), fs: fs}, nil
+}
+
+// Filewrite opens a file to be written.
+func (fs *fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return fs.openFile(r)
+}
+
+// OpenFile opens a file to be read and written.
+func (fs *fileSystem) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return fs.openFile(r)
+}
+
+func (fs *fileSystem) openFile(r *sftp.Request) (*file, error) {
+	if fs.readOnly {
+		return nil, denyWrite("open", r.Filepath)
+	}
+
+	pflags := r.Pflags()
+
+	flags := unix.O_WRONLY
+	if pflags.Read {
+		flags = unix.O_RDWR
+	}
+
+	if pflags.Append {
+		flags |= unix.O_APPEND
+	}
+
+	if pflags.Creat {
+		flags |= unix.O_CREAT
+	}
+
+	if pflags.Trunc {
+		flags |= unix.O_TRUNC
+	}
+
+	if pflags.Excl {
+		flags |= unix.O_EXCL
+	}
+
+	mode := uint32(0o644)
+	if r.AttrFlags().Permissions {
+		mode = r.Attributes().Mode & 0o777
+	}
+
+	fd, err := fs.open(r.Filepath, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file{File: os.NewFile(uintptr(fd), r.Filepath), fs: fs}, nil
+}
+
+// Filecmd handles the requests changing the file system, but the writes.
+func (fs *fileSystem) Filecmd(r *sftp.Request) error {
+	if fs.readOnly {
+		return denyWrite(strings.ToLower(r.Method), r.Filepath)
+	}
+
+	switch r.Method {
+	case "Setstat":
+		return fs.setstat(r)
+	case "Rename":
+		return fs.rename(r.Filepath, r.Target, unix.RENAME_NOREPLACE)
+	case "Rmdir":
+		return fs.unlink(r.Filepath, unix.AT_REMOVEDIR)
+	case "Remove":
+		return fs.unlink(r.Filepath, 0)
+	case "Mkdir":
+		return fs.mkdir(r.Filepath)
+	case "Link":
+		return fs.link(r.Filepath, r.Target)
+	case "Symlink":
+		return fs.symlink(r.Filepath, r.Target)
+	}
+
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames a file, replacing the new path when it exists.
+func (fs *fileSystem) PosixRename(r *sftp.Request) error {
+	if fs.readOnly {
+		return denyWrite(strings.ToLower(r.Method), r.Filepath)
+	}
+
+	return fs.rename(r.Filepath, r.Target, 0)
+}
+
+func (fs *fileSystem) setstat(r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+
+	if flags.Size {
+		fd, err := fs.open(r.Filepath, unix.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		err = unix.Ftruncate(fd, int64(attrs.Size))
+		unix.Close(fd)
+
+		if err != nil {
+			return &os.PathError{Op: "truncate", Path: r.Filepath, Err: err}
+		}
+	}
+
+	if !flags.Permissions && !flags.UidGid && !flags.Acmodtime {
+		return nil
+	}
+
+	fd, err := fs.open(r.Filepath, unix.O_PATH, 0)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(fd)
+
+	// The file descriptor opened with O_PATH can't be passed to fchmod nor to
+	// futimens, but its link in /proc refers to the file already resolved.
+	proc := "/proc/self/fd/" + strconv.Itoa(fd)
+
+	if flags.Permissions {
+		if err := unix.Fchmodat(unix.AT_FDCWD, proc, attrs.Mode&0o7777, 0); err != nil {
+			return &os.PathError{Op: "chmod", Path: r.Filepath, Err: err}
+		}
+	}
+
+	if flags.UidGid {
+		if err := unix.Fchownat(fd, "", int(attrs.UID), int(attrs.GID), unix.AT_EMPTY_PATH); err != nil {
+			return &os.PathError{Op: "chown", Path: r.Filepath, Err: err}
+		}
+	}
+
+	if flags.Acmodtime {
+		times := []unix.Timespec{
+			unix.NsecToTimespec(time.Unix(int64(attrs.Atime), 0).UnixNano()),
+			unix.NsecToTimespec(time.Unix(int64(attrs.Mtime), 0).UnixNano()),
+		}
+
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, proc, times, 0); err != nil {
+			return &os.PathError{Op: "utimes", Path: r.Filepath, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (fs *fileSystem) rename(oldpath, newpath string, flags uint) error {
+	olddir, oldname, err := fs.openParent(oldpath)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(olddir)
+
+	newdir, newname, err := fs.openParent(newpath)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(newdir)
+
+	if err := unix.Renameat2(olddir, oldname, newdir, newname, flags); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *fileSystem) unlink(p string, flags int) error {
+	dir, name, err := fs.openParent(p)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+
+	if err := unix.Unlinkat(dir, name, flags); err != nil {
+		return &os.PathError{Op: "remove", Path: p, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *fileSystem) mkdir(p string) error {
+	dir, name, err := fs.openParent(p)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+
+	if err := unix.Mkdirat(dir, name, 0o755); err != nil {
+		return &os.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *fileSystem) link(oldpath, newpath string) error {
+	olddir, oldname, err := fs.openParent(oldpath)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(olddir)
+
+	newdir, newname, err := fs.openParent(newpath)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(newdir)
+
+	if err := unix.Linkat(olddir, oldname, newdir, newname, 0); err != nil {
+		return &os.LinkError{Op: "link", Old: oldpath, New: newpath, Err: err}
+	}
+
+	return nil
+}
+
+// symlink creates the symbolic link linkpath to target. The target, made
+// absolute by the protocol library, is stored relative to the link, so it
+// refers to the same file seen from the root directory or from the host.
+func (fs *fileSystem) symlink(target, linkpath string) error {
+	dir, name, err := fs.openParent(linkpath)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(dir)
+
+	if fs.confined {
+		rel, err := filepath.Rel(path.Dir(path.Clean("/"+linkpath)), path.Clean("/"+target))
+		if err != nil {
+			return err
+		}
+
+		target = rel
+	}
+
+	if err := unix.Symlinkat(target, dir, name); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: linkpath, Err: err}
+	}
+
+	return nil
+}
+
+// Filelist handles the requests listing directories and reading the
+// attributes of files.
+func (fs *fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		return fs.list(r.Filepath)
+	case "Stat":
+		return fs.stat(r.Filepath)
+	case "Lstat":
+		return fs.lstat(r.Filepath)
+	case "Readlink":
+		return fs.readlink(r.Filepath)
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (fs *fileSystem) list(p string) (sftp.ListerAt, error) {
+	fd, err := fs.open(p, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := os.NewFile(uintptr(fd), p)
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(listerAt, 0, len(names))
+	for _, name := range names {
+		var st unix.Stat_t
+		if err := unix.Fstatat(fd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			// The entry was removed since the directory was read.
+			continue
+		}
+
+		entries = append(entries, newFileInfo(name, &st))
+	}
+
+	return entries, nil
+}
+
+func (fs *fileSystem) stat(p string) (sftp.ListerAt, error) {
+	fd, err := fs.open(p, unix.O_PATH, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+
+	return listerAt{newFileInfo(path.Base(p), &st)}, nil
+}
+
+func (fs *fileSystem) lstat(p string) (sftp.ListerAt, error) {
+	if path.Clean("/"+p) == "/" {
+		return fs.stat(p)
+	}
+
+	dir, name, err := fs.openParent(p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unix.Close(dir)
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(dir, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: err}
+	}
+
+	return listerAt{newFileInfo(name, &st)}, nil
+}
+
+// readlink returns the target of the symbolic link as is: an absolute target is
+// resolved from the root directory too.
+func (fs *fileSystem) readlink(p string) (sftp.ListerAt, error) {
+	dir, name, err := fs.openParent(p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unix.Close(dir)
+
+	buf := make([]byte, unix.PathMax)
+
+	n, err := unix.Readlinkat(dir, name, buf)
+	if err != nil {
+		return nil, &os.PathError{Op: "readlink", Path: p, Err: err}
+	}
+
+	return listerAt{&fileInfo{name: string(buf[:n])}}, nil
+}
+
+// file is a file open for a request, bounding its reads and writes.
+type file struct {
+	*os.File
+	fs *fileSystem
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > f.fs.packetSize {
+		p = p[:f.fs.packetSize]
+	}
+
+	f.fs.inFlight <- struct{}{}
+	defer func() { <-f.fs.inFlight }()
+
+	return f.File.ReadAt(p, off)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.inFlight <- struct{}{}
+	defer func() { <-f.fs.inFlight }()
+
+	return f.File.WriteAt(p, off)
+}
+
+// listerAt lists the attributes of files.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// fileInfo describes a file from its attributes returned by the kernel. Sys
+// returns them as a *syscall.Stat_t, which the protocol library reads the owner
+// of the file from.
+type fileInfo struct {
+	name string
+	stat syscall.Stat_t
+}
+
+func newFileInfo(name string, st *unix.Stat_t) *fileInfo {
+	return &fileInfo{
+		name: name,
+		stat: syscall.Stat_t{
+			Dev:     st.Dev,
+			Ino:     st.Ino,
+			Nlink:   st.Nlink,
+			Mode:    st.Mode,
+			Uid:     st.Uid,
+			Gid:     st.Gid,
+			Rdev:    st.Rdev,
+			Size:    st.Size,
+			Blksize: st.Blksize,
+			Blocks:  st.Blocks,
+			Atim:    syscall.Timespec{Sec: st.Atim.Sec, Nsec: st.Atim.Nsec},
+			Mtim:    syscall.Timespec{Sec: st.Mtim.Sec, Nsec: st.Mtim.Nsec},
+			Ctim:    syscall.Timespec{Sec: st.Ctim.Sec, Nsec: st.Ctim.Nsec},
+		},
+	}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.stat.Size }
+func (fi *fileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return &fi.stat }
+func (fi *fileInfo) ModTime() time.Time { return time.Unix(fi.stat.Mtim.Unix()) }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	mode := os.FileMode(fi.stat.Mode & 0o777)
+
+	switch fi.stat.Mode & unix.S_IFMT {
+	case unix.S_IFBLK:
+		mode |= os.ModeDevice
+	case unix.S_IFCHR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case unix.S_IFDIR:
+		mode |= os.ModeDir
+	case unix.S_IFIFO:
+		mode |= os.ModeNamedPipe
+	case unix.S_IFLNK:
+		mode |= os.ModeSymlink
+	case unix.S_IFSOCK:
+		mode |= os.ModeSocket
+	}
+
+	if fi.stat.Mode&unix.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if fi.stat.Mode&unix.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if fi.stat.Mode&unix.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package sftp
+
+import (
+	"github.com/pkg/sftp"
+)
+
+// fileSystem can't confine the paths to the root directory on this system.
+type fileSystem struct{}
+
+func newFileSystem(root string, readOnly bool, maxInFlight, packetSize int) (*fileSystem, error) {
+	return nil, ErrUnsupportedSystem
+}
+
+func (fs *fileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{}
+}
+
+// Close does nothing.
+func (fs *fileSystem) Close() error {
+	return nil
+}
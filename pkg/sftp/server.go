@@ -0,0 +1,194 @@
+// Package sftp serves the local file system over the SSH File Transfer
+// Protocol, confining the clients to a root directory.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Bounds of the tuning of the server. The protocol library handles at most
+// sftp.SftpServerWorkerCount requests at once and reads at most 32 KiB for a
+// single request.
+const (
+	DefaultMaxInFlight = 1
+	MaxInFlightLimit   = sftp.SftpServerWorkerCount
+
+	DefaultPacketSize = 32 * 1024
+	MinPacketSize     = 4 * 1024
+	MaxPacketSize     = 32 * 1024
+)
+
+var (
+	ErrUnsupportedSystem = errors.New("SFTP server is not supported on this system")
+	ErrConfinement       = errors.New("confining paths to the root requires Linux 5.6 or newer")
+
+	ErrInvalidMaxInFlight = fmt.Errorf("max in-flight requests must be between 1 and %d", MaxInFlightLimit)
+	ErrInvalidPacketSize  = fmt.Errorf("packet size must be between %d and %d bytes", MinPacketSize, MaxPacketSize)
+)
+
+// CheckTuning checks that the maximum number of in-flight requests and the
+// packet size are within their bounds.
+func CheckTuning(maxInFlight, packetSize int) error {
+	if maxInFlight < 1 || maxInFlight > MaxInFlightLimit {
+		return ErrInvalidMaxInFlight
+	}
+
+	if packetSize < MinPacketSize || packetSize > MaxPacketSize {
+		return ErrInvalidPacketSize
+	}
+
+	return nil
+}
+
+// Opt configures optional behavior of the Server.
+type Opt func(*Server) error
+
+// WithRoot confines the clients to the root directory, which becomes the root
+// of the file system seen by them.
+func WithRoot(root string) Opt {
+	return func(s *Server) error {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+
+		s.root = root
+		s.workdir = "/"
+
+		return nil
+	}
+}
+
+// WithReadOnly rejects the requests modifying the file system, allowing only to
+// read files and list directories.
+func WithReadOnly(readOnly bool) Opt {
+	return func(s *Server) error {
+		s.readOnly = readOnly
+
+		return nil
+	}
+}
+
+// WithMaxInFlight sets how many reads and writes of file data are handled
+// concurrently, which speeds up the pipelined transfers of the clients.
+func WithMaxInFlight(n int) Opt {
+	return func(s *Server) error {
+		if n < 1 || n > MaxInFlightLimit {
+			return ErrInvalidMaxInFlight
+		}
+
+		s.maxInFlight = n
+
+		return nil
+	}
+}
+
+// WithPacketSize sets the maximum size of the data read by a single request.
+func WithPacketSize(size int) Opt {
+	return func(s *Server) error {
+		if size < MinPacketSize || size > MaxPacketSize {
+			return ErrInvalidPacketSize
+		}
+
+		s.packetSize = size
+
+		return nil
+	}
+}
+
+// WithWorkDir sets the directory relative paths are resolved from.
+func WithWorkDir(workdir string) Opt {
+	return func(s *Server) error {
+		s.workdir = filepath.ToSlash(workdir)
+
+		return nil
+	}
+}
+
+// Server serves the SFTP protocol over a stream, usually the stdin and stdout of
+// the SSH subsystem.
+type Server struct {
+	in  io.Reader
+	out io.Writer
+
+	root     string
+	workdir  string
+	readOnly bool
+
+	maxInFlight int
+	packetSize  int
+}
+
+// NewServer creates a new SFTP server reading the requests from in and writing
+// the responses to out.
+func NewServer(in io.Reader, out io.Writer, opts ...Opt) (*Server, error) {
+	s := &Server{
+		in:          in,
+		out:         out,
+		root:        "/",
+		workdir:     "/",
+		maxInFlight: DefaultMaxInFlight,
+		packetSize:  DefaultPacketSize,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Serve handles the requests until the client disconnects, returning io.EOF, or
+// an error occurs.
+func (s *Server) Serve() error {
+	fs, err := newFileSystem(s.root, s.readOnly, s.maxInFlight, s.packetSize)
+	if err != nil {
+		return err
+	}
+
+	defer fs.Close()
+
+	server := sftp.NewRequestServer(&stream{s.in, s.out}, fs.handlers(), sftp.WithStartDirectory(s.workdir))
+
+	defer server.Close()
+
+	return server.Serve()
+}
+
+// stream joins the reader and the writer the requests are served over.
+type stream struct {
+	io.Reader
+	io.Writer
+}
+
+// Close closes the reader and the writer, when they can be closed.
+func (s *stream) Close() error {
+	if c, ok := s.Reader.(io.Closer); ok {
+		c.Close()
+	}
+
+	if c, ok := s.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// denyWrite logs a request rejected by the read-only mode, returning the error
+// reported to the client.
+func denyWrite(op, p string) error {
+	log.WithFields(log.Fields{
+		"op":   op,
+		"path": p,
+	}).Warn("Rejected SFTP write request in read-only mode")
+
+	return sftp.ErrSSHFxPermissionDenied
+}
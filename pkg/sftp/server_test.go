@@ -0,0 +1,384 @@
+//go:build linux
+// +build linux
+
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestClient serves the SFTP protocol with the options, returning a client
+// connected to the server.
+func newTestClient(t *testing.T, opts ...Opt) *sftp.Client {
+	t.Helper()
+
+	requests, requestsWriter := io.Pipe()
+	responsesReader, responses := io.Pipe()
+
+	server, err := NewServer(requests, responses, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve()
+	}()
+
+	client, err := sftp.NewClientPipe(responsesReader, requestsWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+
+		if err := <-done; err != nil && !errors.Is(err, io.EOF) {
+			t.Errorf("Serve() = %v", err)
+		}
+	})
+
+	return client
+}
+
+// newTestRoot creates a root directory, with a file, next to a directory
+// outside of it holding a secret.
+func newTestRoot(t *testing.T) (root, outside string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	root = filepath.Join(dir, "root")
+	outside = filepath.Join(dir, "outside")
+
+	for _, d := range []string{root, outside, filepath.Join(root, "inside")} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "inside", "secret"), []byte("inside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return root, outside
+}
+
+func readFile(client *sftp.Client, p string) (string, error) {
+	f, err := client.Open(p)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+
+	return string(data), err
+}
+
+func TestServerConfinesPaths(t *testing.T) {
+	root, outside := newTestRoot(t)
+
+	if err := os.Symlink("../outside", filepath.Join(root, "relative")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "absolute")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "passwd")); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, WithRoot(root))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+		err  error
+	}{
+		{name: "inside", path: "/inside/secret", want: "inside"},
+		{name: "relative inside", path: "inside/../inside/secret", want: "inside"},
+		{name: "dot-dot", path: "../../etc/passwd", err: os.ErrNotExist},
+		{name: "dot-dot to outside", path: "../outside/secret", err: os.ErrNotExist},
+		{name: "dot-dot in the middle", path: "/inside/../../outside/secret", err: os.ErrNotExist},
+		{name: "relative symlink", path: "/relative/secret", err: os.ErrNotExist},
+		{name: "absolute symlink", path: "/absolute/secret", err: os.ErrNotExist},
+		{name: "symlink to passwd", path: "/passwd", err: os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFile(client, tt.path)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("reading %q: error = %v, want %v", tt.path, err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("reading %q = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerConfinesWrites(t *testing.T) {
+	root, outside := newTestRoot(t)
+
+	if err := os.Symlink(outside, filepath.Join(root, "absolute")); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, WithRoot(root))
+
+	if f, err := client.Create("/absolute/created"); err == nil {
+		f.Close()
+	}
+
+	if err := client.Mkdir("../outside/dir"); err == nil {
+		t.Error("Mkdir(../outside/dir) succeeded")
+	}
+
+	// The link is created with a target in the root directory.
+	if err := client.Symlink("../outside/secret", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := readFile(client, "/link"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reading /link = %q, %v, want %v", got, err, os.ErrNotExist)
+	}
+
+	if err := client.Mkdir("/outside"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Rename("/inside/secret", "../outside/renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"created", "dir", "renamed"} {
+		if _, err := os.Lstat(filepath.Join(outside, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was created outside of the root: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "outside", "renamed")); err != nil {
+		t.Error("renamed file is not in the root")
+	}
+}
+
+func TestServerConfinesSwappedSymlink(t *testing.T) {
+	root, outside := newTestRoot(t)
+
+	link := filepath.Join(root, "dir")
+	if err := os.Symlink("inside", link); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, WithRoot(root))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	// Swap the link between the directory inside the root and the one outside
+	// of it, while the client reads through it.
+	go func() {
+		defer close(done)
+
+		tmp := filepath.Join(root, "swap")
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			target := "inside"
+			if i%2 == 1 {
+				target = outside
+			}
+
+			os.Remove(tmp)
+
+			if err := os.Symlink(target, tmp); err != nil {
+				t.Error(err)
+
+				return
+			}
+
+			if err := os.Rename(tmp, link); err != nil {
+				t.Error(err)
+
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		got, err := readFile(client, "/dir/secret")
+		if err == nil && got != "inside" {
+			t.Errorf("reading /dir/secret = %q, want %q", got, "inside")
+
+			break
+		}
+	}
+
+	close(stop)
+	<-done
+}
+
+func TestServerReadOnly(t *testing.T) {
+	root, _ := newTestRoot(t)
+
+	client := newTestClient(t, WithRoot(root), WithReadOnly(true))
+
+	if got, err := readFile(client, "/inside/secret"); err != nil || got != "inside" {
+		t.Fatalf("reading /inside/secret = %q, %v", got, err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{name: "create", op: func() error {
+			f, err := client.Create("/created")
+			if err == nil {
+				f.Close()
+			}
+
+			return err
+		}},
+		{name: "mkdir", op: func() error { return client.Mkdir("/dir") }},
+		{name: "remove", op: func() error { return client.Remove("/inside/secret") }},
+		{name: "rename", op: func() error { return client.Rename("/inside/secret", "/renamed") }},
+		{name: "chmod", op: func() error { return client.Chmod("/inside/secret", 0o600) }},
+		{name: "symlink", op: func() error { return client.Symlink("/inside/secret", "/link") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, os.ErrPermission) {
+				t.Errorf("error = %v, want %v", err, os.ErrPermission)
+			}
+		})
+	}
+}
+
+func TestServerFileOperations(t *testing.T) {
+	root, _ := newTestRoot(t)
+
+	client := newTestClient(t, WithRoot(root), WithMaxInFlight(4), WithPacketSize(MinPacketSize))
+
+	data := make([]byte, 3*MinPacketSize+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	f, err := client.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	if got, err := readFile(client, "/file"); err != nil || got != string(data) {
+		t.Fatalf("reading /file: %d bytes, %v, want %d bytes", len(got), err, len(data))
+	}
+
+	if err := client.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Rename("/file", "/dir/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Symlink("/dir/file", "/dir/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := client.ReadLink("/dir/link"); err != nil || target != "file" {
+		t.Errorf("ReadLink(/dir/link) = %q, %v, want %q", target, err, "file")
+	}
+
+	entries, err := client.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modes := map[string]os.FileMode{}
+	for _, e := range entries {
+		modes[e.Name()] = e.Mode().Type()
+	}
+
+	if len(modes) != 2 || modes["file"] != 0 || modes["link"] != os.ModeSymlink {
+		t.Errorf("ReadDir(/dir) = %v", modes)
+	}
+
+	if fi, err := client.Stat("/dir/link"); err != nil || fi.Size() != int64(len(data)) {
+		t.Errorf("Stat(/dir/link) = %v, %v", fi, err)
+	}
+
+	if err := client.Chmod("/dir/file", 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(root, "dir", "file")); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode of /dir/file = %v, %v, want %v", fi.Mode(), err, os.FileMode(0o600))
+	}
+
+	if err := client.Remove("/dir/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Remove("/dir/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RemoveDirectory("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Remove("/"); err == nil {
+		t.Error("Remove(/) succeeded")
+	}
+}
+
+func TestCheckTuning(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInFlight int
+		packetSize  int
+		err         error
+	}{
+		{name: "defaults", maxInFlight: DefaultMaxInFlight, packetSize: DefaultPacketSize},
+		{name: "bounds", maxInFlight: MaxInFlightLimit, packetSize: MinPacketSize},
+		{name: "no request", maxInFlight: 0, packetSize: DefaultPacketSize, err: ErrInvalidMaxInFlight},
+		{name: "too many requests", maxInFlight: MaxInFlightLimit + 1, packetSize: DefaultPacketSize, err: ErrInvalidMaxInFlight},
+		{name: "packet too small", maxInFlight: 1, packetSize: MinPacketSize - 1, err: ErrInvalidPacketSize},
+		{name: "packet too large", maxInFlight: 1, packetSize: MaxPacketSize + 1, err: ErrInvalidPacketSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckTuning(tt.maxInFlight, tt.packetSize); err != tt.err {
+				t.Errorf("CheckTuning(%d, %d) = %v, want %v", tt.maxInFlight, tt.packetSize, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package sysinfo
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sources of the system facts, which are only available on Linux.
+var (
+	DefaultKernelReleaseFilename = "/proc/sys/kernel/osrelease"
+	DefaultMemInfoFilename       = "/proc/meminfo"
+	DefaultCPUInfoFilename       = "/proc/cpuinfo"
+)
+
+var ErrFactNotFound = errors.New("fact not found")
+
+// KernelVersion returns the release of the running kernel.
+func KernelVersion() (string, error) {
+	data, err := os.ReadFile(DefaultKernelReleaseFilename)
+	if err != nil {
+		return "", err
+	}
+
+	version := strings.TrimSpace(string(data))
+	if version == "" {
+		return "", ErrFactNotFound
+	}
+
+	return version, nil
+}
+
+// MemTotal returns the total usable RAM, in bytes.
+func MemTotal() (uint64, error) {
+	value, err := readKeyValue(DefaultMemInfoFilename, "MemTotal")
+	if err != nil {
+		return 0, err
+	}
+
+	// The value is in kibibytes, e.g. "16318480 kB".
+	kb, err := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return kb * 1024, nil
+}
+
+// CPUModel returns the model name of the first CPU. Some architectures, e.g.
+// ARM, only report it as the hardware or processor name.
+func CPUModel() (string, error) {
+	for _, key := range []string{"model name", "Hardware", "Processor"} {
+		value, err := readKeyValue(DefaultCPUInfoFilename, key)
+		if errors.Is(err, ErrFactNotFound) {
+			continue
+		}
+
+		return value, err
+	}
+
+	return "", ErrFactNotFound
+}
+
+// readKeyValue returns the value of the first "key: value" line of the file
+// with the key.
+func readKeyValue(filename, key string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(k) != key {
+			continue
+		}
+
+		if v = strings.TrimSpace(v); v != "" {
+			return v, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", ErrFactNotFound
+}
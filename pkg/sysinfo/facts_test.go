@@ -0,0 +1,117 @@
+package sysinfo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setFactsFile points the variable naming a facts source to a file with the
+// content, restoring it when the test ends.
+func setFactsFile(t *testing.T, filename *string, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), filepath.Base(*filename))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := *filename
+	*filename = path
+
+	t.Cleanup(func() { *filename = previous })
+}
+
+func TestKernelVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		err     error
+	}{
+		{name: "release", content: "6.1.0-13-amd64\n", want: "6.1.0-13-amd64"},
+		{name: "empty", content: "\n", err: ErrFactNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFactsFile(t, &DefaultKernelReleaseFilename, tt.content)
+
+			got, err := KernelVersion()
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("KernelVersion() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestMemTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+		err     error
+	}{
+		{name: "kibibytes", content: "MemTotal:       16318480 kB\nMemFree:         1024 kB\n", want: 16318480 * 1024},
+		{name: "missing", content: "MemFree:         1024 kB\n", err: ErrFactNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFactsFile(t, &DefaultMemInfoFilename, tt.content)
+
+			got, err := MemTotal()
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("MemTotal() = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		setFactsFile(t, &DefaultMemInfoFilename, "MemTotal: lots\n")
+
+		if _, err := MemTotal(); err == nil {
+			t.Error("MemTotal() = nil error with a malformed value")
+		}
+	})
+}
+
+func TestCPUModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		err     error
+	}{
+		{name: "x86", content: "processor\t: 0\nmodel name\t: Intel(R) Core(TM) i7\n\nprocessor\t: 1\nmodel name\t: Intel(R) Core(TM) i7\n", want: "Intel(R) Core(TM) i7"},
+		{name: "arm hardware", content: "processor\t: 0\nHardware\t: BCM2835\n", want: "BCM2835"},
+		{name: "arm processor", content: "Processor\t: ARMv7 Processor rev 4 (v7l)\n", want: "ARMv7 Processor rev 4 (v7l)"},
+		{name: "empty model", content: "model name\t:\n", err: ErrFactNotFound},
+		{name: "missing", content: "processor\t: 0\n", err: ErrFactNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFactsFile(t, &DefaultCPUInfoFilename, tt.content)
+
+			got, err := CPUModel()
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("CPUModel() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestFactsMissingSource(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	previous := DefaultCPUInfoFilename
+	DefaultCPUInfoFilename = missing
+
+	defer func() { DefaultCPUInfoFilename = previous }()
+
+	if _, err := CPUModel(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CPUModel() = %v, want %v", err, os.ErrNotExist)
+	}
+}
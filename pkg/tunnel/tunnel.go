@@ -55,3 +55,8 @@ func NewTunnel() *Tunnel {
 func (t *Tunnel) Listen(l *revdial.Listener) error {
 	return t.srv.Serve(l)
 }
+
+// Close closes the tunnel and the listener it is serving on.
+func (t *Tunnel) Close() error {
+	return t.srv.Close()
+}
@@ -0,0 +1,242 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// backupSuffix is appended to the executable path to keep the previous
+	// agent binary.
+	backupSuffix = ".bak"
+
+	// pendingSuffix is appended to the executable path to record an update
+	// not yet confirmed.
+	pendingSuffix = ".pending"
+
+	// rolledBackSuffix is appended to the executable path to record the
+	// version of the last update rolled back, never installed again.
+	rolledBackSuffix = ".rolledback"
+)
+
+// watchInterval is how often the watchdog checks whether the update is
+// confirmed.
+var watchInterval = time.Second
+
+// WatchdogCommand is the agent command watching an update, run from the
+// previous agent binary.
+const WatchdogCommand = "update-watchdog"
+
+// Update records an installed update waiting to be confirmed by the new agent
+// connecting to the server.
+type Update struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// Expired reports whether the update has not been confirmed within timeout
+// since it was installed, meaning it must be rolled back.
+func (up *Update) Expired(timeout time.Duration, now time.Time) bool {
+	return !now.Before(up.InstalledAt.Add(timeout))
+}
+
+// PendingUpdate returns the installed update waiting to be confirmed, or nil
+// when there is none.
+func PendingUpdate() (*Update, error) {
+	executable, err := executablePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return pendingUpdate(executable)
+}
+
+func pendingUpdate(executable string) (*Update, error) {
+	data, err := os.ReadFile(executable + pendingSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	update := new(Update)
+	if err := json.Unmarshal(data, update); err != nil {
+		return nil, err
+	}
+
+	return update, nil
+}
+
+// ConfirmUpdate marks the pending update as successful.
+func ConfirmUpdate() error {
+	executable, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(executable + pendingSuffix)
+}
+
+// CancelUpdate restores the agent binary backed up by the pending update, which
+// was never run.
+func CancelUpdate() error {
+	executable, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(executable+backupSuffix, executable); err != nil {
+		return err
+	}
+
+	return os.Remove(executable + pendingSuffix)
+}
+
+// rollbackUpdate restores the agent binary backed up by the pending update,
+// recording its version as rolled back so it is not installed again.
+func rollbackUpdate(executable string, update *Update) error {
+	if err := os.Rename(executable+backupSuffix, executable); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(executable+rolledBackSuffix, []byte(update.To), 0o600); err != nil {
+		return err
+	}
+
+	return os.Remove(executable + pendingSuffix)
+}
+
+// RolledBack reports whether version is the one of the last update rolled back.
+func RolledBack(version string) bool {
+	executable, err := executablePath()
+	if err != nil {
+		return false
+	}
+
+	return rolledBack(executable, version)
+}
+
+func rolledBack(executable, version string) bool {
+	data, err := os.ReadFile(executable + rolledBackSuffix)
+	if err != nil {
+		return false
+	}
+
+	return string(data) == version
+}
+
+// StartWatchdog starts the watchdog of the pending update from the previous
+// agent binary, before the agent restarts into the update. The watchdog rolls
+// the update back unless it is confirmed within timeout, so an update failing
+// to start at all is rolled back too.
+func StartWatchdog(timeout time.Duration) error {
+	executable, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable+backupSuffix, WatchdogCommand, // nolint:gosec
+		"--executable", executable,
+		"--pid", strconv.Itoa(os.Getpid()),
+		"--timeout", timeout.String(),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// Watch waits for the pending update of the agent executable, running as pid,
+// to be confirmed within timeout since it was installed. Otherwise, it rolls the
+// update back and terminates the agent, to be started again from the previous
+// binary by its supervisor. It returns the update rolled back, if any.
+func Watch(executable string, pid int, timeout time.Duration) (*Update, error) {
+	update, err := pendingUpdate(executable)
+	if err != nil || update == nil {
+		return nil, err
+	}
+
+	for time.Now().Before(update.InstalledAt.Add(timeout)) {
+		time.Sleep(watchInterval)
+
+		pending, err := pendingUpdate(executable)
+		if err != nil {
+			return nil, err
+		}
+
+		if pending == nil || !pending.InstalledAt.Equal(update.InstalledAt) {
+			return nil, nil
+		}
+	}
+
+	// The process is only the agent running the update when it runs the
+	// updated executable, its pid may have been reused after a crash.
+	running, _ := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+
+	if err := rollbackUpdate(executable, update); err != nil {
+		return nil, err
+	}
+
+	if running == executable {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return update, err
+		}
+	}
+
+	return update, nil
+}
+
+// backup copies the executable to its backup path.
+func backup(executable string) error {
+	in, err := os.Open(executable)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(executable+backupSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
+
+// savePendingUpdate records update as waiting to be confirmed.
+func savePendingUpdate(executable string, update *Update) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(executable+pendingSuffix, data, 0o600)
+}
+
+func executablePath() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(executable)
+}
@@ -0,0 +1,163 @@
+package updater
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// installTestUpdate writes an updated executable, the backup of the previous
+// one and the pending update, returning the executable path.
+func installTestUpdate(t *testing.T, updated []byte, installedAt time.Time) string {
+	t.Helper()
+
+	executable := filepath.Join(t.TempDir(), "shellhub-agent")
+
+	if err := os.WriteFile(executable, updated, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(executable+backupSuffix, []byte("previous"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := savePendingUpdate(executable, &Update{From: "1.0.0", To: "1.1.0", InstalledAt: installedAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	return executable
+}
+
+func setWatchInterval(t *testing.T, interval time.Duration) {
+	t.Helper()
+
+	previous := watchInterval
+	watchInterval = interval
+
+	t.Cleanup(func() {
+		watchInterval = previous
+	})
+}
+
+func TestWatch(t *testing.T) {
+	setWatchInterval(t, 10*time.Millisecond)
+
+	cases := []struct {
+		name       string
+		confirm    bool
+		rolledBack bool
+	}{
+		{"confirmed", true, false},
+		{"not confirmed", false, true},
+	}
+
+	for _, tc := range cases {
+		executable := installTestUpdate(t, []byte("updated"), time.Now())
+
+		if tc.confirm {
+			time.AfterFunc(50*time.Millisecond, func() {
+				os.Remove(executable + pendingSuffix)
+			})
+		}
+
+		update, err := Watch(executable, os.Getpid(), 200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("%s: Watch() = %v", tc.name, err)
+		}
+
+		if (update != nil) != tc.rolledBack {
+			t.Errorf("%s: Watch() = %v, want rolled back %v", tc.name, update, tc.rolledBack)
+		}
+
+		want := "updated"
+		if tc.rolledBack {
+			want = "previous"
+		}
+
+		if data, err := os.ReadFile(executable); err != nil || string(data) != want {
+			t.Errorf("%s: executable = %q, %v, want %q", tc.name, data, err, want)
+		}
+
+		if got := rolledBack(executable, "1.1.0"); got != tc.rolledBack {
+			t.Errorf("%s: rolledBack(1.1.0) = %v, want %v", tc.name, got, tc.rolledBack)
+		}
+
+		if rolledBack(executable, "1.2.0") {
+			t.Errorf("%s: rolledBack(1.2.0) = true", tc.name)
+		}
+
+		if _, err := os.Stat(executable + pendingSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s: pending update was not removed: %v", tc.name, err)
+		}
+	}
+}
+
+func TestWatchWithoutPendingUpdate(t *testing.T) {
+	executable := filepath.Join(t.TempDir(), "shellhub-agent")
+
+	if update, err := Watch(executable, os.Getpid(), time.Minute); update != nil || err != nil {
+		t.Errorf("Watch() = %v, %v, want nothing to watch", update, err)
+	}
+}
+
+func TestWatchStopsUpdatedAgent(t *testing.T) {
+	setWatchInterval(t, 10*time.Millisecond)
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	binary, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The updated agent is a copy of sleep, stopped by the watchdog.
+	executable := installTestUpdate(t, binary, time.Now())
+
+	agent := exec.Command(executable, "60")
+	if err := agent.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer agent.Process.Kill() // nolint:errcheck
+
+	// A process running another executable is left alone.
+	other := exec.Command(sleep, "60")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.Process.Kill() // nolint:errcheck
+
+	for _, pid := range []int{agent.Process.Pid, other.Process.Pid} {
+		if err := savePendingUpdate(executable, &Update{From: "1.0.0", To: "1.1.0", InstalledAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(executable+backupSuffix, binary, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if update, err := Watch(executable, pid, 50*time.Millisecond); update == nil || err != nil {
+			t.Fatalf("Watch(%d) = %v, %v", pid, update, err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- agent.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updated agent was not stopped")
+	}
+
+	if err := other.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("process running another executable was stopped: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LatestVersion is the agent version used during development, which disables
+// the updates.
+const LatestVersion = "latest"
+
+// Update channels. The stable channel only receives released versions, while
+// the beta channel also receives pre-release versions.
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+// maxDownloadSize is the maximum size of a downloaded file, beyond which the
+// download is aborted.
+var maxDownloadSize = 512 << 20
+
+var (
+	ErrUpdatesDisabled  = errors.New("updates are disabled")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrDownloadFailed   = errors.New("download failed")
+	ErrDownloadTooLarge = errors.New("download is too large")
+	ErrInsecureURL      = errors.New("update url must use https")
+	ErrInvalidPublicKey = errors.New("invalid update public key")
+	ErrMissingPublicKey = errors.New("update public key is required to verify the updates")
+)
+
+// Updater checks for and installs new versions of the agent binary.
+type Updater struct {
+	// CurrentVersion is the version of the running agent.
+	CurrentVersion string
+
+	// URL is the template of the URL to download the agent binary from. The
+	// {version}, {os} and {arch} placeholders are replaced accordingly. The
+	// detached signature of the binary is downloaded from the same URL with
+	// the .sig suffix.
+	URL string
+
+	// PublicKey is the key the binaries are signed with. The updates are
+	// disabled without it.
+	PublicKey ed25519.PublicKey
+
+	// Channel is the update channel the agent is subscribed to.
+	Channel string
+
+	// UserAgent is the User-Agent header of the download requests. Empty
+	// means the default one of the HTTP client.
+	UserAgent string
+
+	http *http.Client
+}
+
+// NewUpdater creates a new Updater for the running agent version on the given
+// update channel, installing the binaries signed with publicKey.
+func NewUpdater(currentVersion, url, channel string, publicKey ed25519.PublicKey) *Updater {
+	return &Updater{
+		CurrentVersion: currentVersion,
+		URL:            url,
+		Channel:        channel,
+		PublicKey:      publicKey,
+		http:           &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// ParsePublicKey parses a base64 encoded Ed25519 public key.
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return ed25519.PublicKey(data), nil
+}
+
+// CheckURL checks that the download URL uses HTTPS, as the binaries are run
+// with the agent privileges.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return ErrInsecureURL
+	}
+
+	return nil
+}
+
+// Verify checks that signature, the base64 encoded Ed25519 signature of the
+// binary, is valid for publicKey.
+func Verify(publicKey ed25519.PublicKey, binary, signature []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return ErrMissingPublicKey
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+
+	if !ed25519.Verify(publicKey, binary, sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// SetRootCAs sets the CA certificates trusted to sign the certificate of the
+// download server, instead of the system ones.
+func (u *Updater) SetRootCAs(pool *x509.CertPool) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	u.http.Transport = transport
+}
+
+// ValidChannel reports whether channel is a known update channel.
+func ValidChannel(channel string) bool {
+	return channel == ChannelStable || channel == ChannelBeta
+}
+
+// Enabled reports whether the updates are enabled, which requires an HTTPS
+// download URL, a public key to verify the binaries and a released current
+// version.
+func (u *Updater) Enabled() bool {
+	return u.URL != "" && CheckURL(u.URL) == nil && len(u.PublicKey) == ed25519.PublicKeySize &&
+		u.CurrentVersion != "" && u.CurrentVersion != LatestVersion
+}
+
+// NeedsUpdate reports whether version is newer than the current one. On the
+// stable channel, pre-release versions are never installed.
+func (u *Updater) NeedsUpdate(version string) (bool, error) {
+	if !u.Enabled() {
+		return false, ErrUpdatesDisabled
+	}
+
+	return Newer(version, u.CurrentVersion, u.Channel)
+}
+
+// Newer reports whether version is newer than current and can be installed on
+// the update channel, the pre-release versions being only installed on the
+// beta channel.
+func Newer(version, current, channel string) (bool, error) {
+	if channel != ChannelBeta {
+		_, prerelease, err := parseVersion(version)
+		if err != nil {
+			return false, err
+		}
+
+		if prerelease != "" {
+			return false, nil
+		}
+	}
+
+	cmp, err := CompareVersions(version, current)
+	if err != nil {
+		return false, err
+	}
+
+	return cmp > 0, nil
+}
+
+// Install downloads the binary of version, verifies its signature and replaces
+// the running executable with it. The previous binary is kept as a backup to be
+// restored by the watchdog, see StartWatchdog, until the update is confirmed by
+// ConfirmUpdate.
+func (u *Updater) Install(version string) error {
+	if !u.Enabled() {
+		return ErrUpdatesDisabled
+	}
+
+	executable, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	url := strings.NewReplacer(
+		"{version}", version,
+		"{os}", runtime.GOOS,
+		"{arch}", runtime.GOARCH,
+	).Replace(u.URL)
+
+	signature, err := u.download(url + ".sig")
+	if err != nil {
+		return err
+	}
+
+	binary, err := u.download(url)
+	if err != nil {
+		return err
+	}
+
+	if err := Verify(u.PublicKey, binary, signature); err != nil {
+		return err
+	}
+
+	// The new binary is written next to the executable, so the rename
+	// replacing it is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(executable), filepath.Base(executable)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Chmod(0o755); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := backup(executable); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), executable); err != nil {
+		return err
+	}
+
+	return savePendingUpdate(executable, &Update{
+		From:        u.CurrentVersion,
+		To:          version,
+		InstalledAt: time.Now(),
+	})
+}
+
+func (u *Updater) download(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil) // nolint:noctx
+	if err != nil {
+		return nil, err
+	}
+
+	if u.UserAgent != "" {
+		req.Header.Set("User-Agent", u.UserAgent)
+	}
+
+	res, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: %s", ErrDownloadFailed, url, res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, int64(maxDownloadSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("%w: %s", ErrDownloadTooLarge, url)
+	}
+
+	return data, nil
+}
+
+// Restart replaces the running process with a new execution of the agent
+// executable, keeping the same arguments and environment.
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(executable, os.Args, os.Environ()) // nolint:gosec
+}
@@ -0,0 +1,180 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	cases := []struct {
+		url string
+		err error
+	}{
+		{"https://example.com/agent-{version}-{os}-{arch}", nil},
+		{"http://example.com/agent", ErrInsecureURL},
+		{"ftp://example.com/agent", ErrInsecureURL},
+		{"example.com/agent", ErrInsecureURL},
+		{"https:///agent", ErrInsecureURL},
+	}
+
+	for _, tc := range cases {
+		if err := CheckURL(tc.url); !errors.Is(err, tc.err) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tc.url, err, tc.err)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub) + "\n")
+	if err != nil {
+		t.Fatalf("ParsePublicKey() = %v", err)
+	}
+
+	if !key.Equal(pub) {
+		t.Errorf("ParsePublicKey() = %x, want %x", key, pub)
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(pub[:16])} {
+		if _, err := ParsePublicKey(invalid); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("ParsePublicKey(%q) = %v, want %v", invalid, err, ErrInvalidPublicKey)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := []byte("agent binary")
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, binary)) + "\n")
+
+	cases := []struct {
+		name      string
+		key       ed25519.PublicKey
+		binary    []byte
+		signature []byte
+		err       error
+	}{
+		{"valid", pub, binary, signature, nil},
+		{"tampered binary", pub, []byte("evil binary"), signature, ErrInvalidSignature},
+		{"other key", other, binary, signature, ErrInvalidSignature},
+		{"no key", nil, binary, signature, ErrMissingPublicKey},
+		{"garbage signature", pub, binary, []byte("garbage"), ErrInvalidSignature},
+		{"empty signature", pub, binary, nil, ErrInvalidSignature},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Verify(tc.key, tc.binary, tc.signature); !errors.Is(err, tc.err) {
+				t.Errorf("Verify() = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		updater *Updater
+		enabled bool
+	}{
+		{"enabled", NewUpdater("1.0.0", "https://example.com/agent", ChannelStable, pub), true},
+		{"no url", NewUpdater("1.0.0", "", ChannelStable, pub), false},
+		{"plain http", NewUpdater("1.0.0", "http://example.com/agent", ChannelStable, pub), false},
+		{"no public key", NewUpdater("1.0.0", "https://example.com/agent", ChannelStable, nil), false},
+		{"development version", NewUpdater(LatestVersion, "https://example.com/agent", ChannelStable, pub), false},
+	}
+
+	for _, tc := range cases {
+		if enabled := tc.updater.Enabled(); enabled != tc.enabled {
+			t.Errorf("%s: Enabled() = %v, want %v", tc.name, enabled, tc.enabled)
+		}
+	}
+}
+
+func TestDownloadTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1024))) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	u := NewUpdater("1.0.0", srv.URL, ChannelStable, nil)
+
+	data, err := u.download(srv.URL)
+	if err != nil || len(data) != 1024 {
+		t.Fatalf("download() = %d bytes, %v", len(data), err)
+	}
+
+	defer func(size int) { maxDownloadSize = size }(maxDownloadSize)
+	maxDownloadSize = 512
+
+	if _, err := u.download(srv.URL); !errors.Is(err, ErrDownloadTooLarge) {
+		t.Fatalf("download() = %v, want %v", err, ErrDownloadTooLarge)
+	}
+}
+
+func TestDownloadUserAgent(t *testing.T) {
+	var got string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	u := NewUpdater("1.0.0", srv.URL, ChannelStable, nil)
+	u.UserAgent = "shellhub-agent/test"
+
+	if _, err := u.download(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != u.UserAgent {
+		t.Errorf("download request User-Agent = %q, want %q", got, u.UserAgent)
+	}
+}
+
+func TestSetRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("update")) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	u := NewUpdater("1.0.0", srv.URL, ChannelStable, nil)
+
+	if _, err := u.download(srv.URL); err == nil {
+		t.Fatal("download() = nil error from a server signed by an untrusted CA")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	u.SetRootCAs(pool)
+
+	if data, err := u.download(srv.URL); err != nil || string(data) != "update" {
+		t.Errorf("download() = %q, %v, want the update from the server signed by the trusted CA", data, err)
+	}
+}
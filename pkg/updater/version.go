@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidVersion = errors.New("invalid version")
+
+// CompareVersions compares two semantic versions, optionally prefixed by "v",
+// returning -1, 0 or 1 when a is older, equal or newer than b. A pre-release
+// is older than the same version without it, and pre-releases are ordered as
+// defined by the section 11 of the Semantic Versioning specification.
+func CompareVersions(a, b string) (int, error) {
+	va, pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+
+	vb, pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range va {
+		switch {
+		case va[i] < vb[i]:
+			return -1, nil
+		case va[i] > vb[i]:
+			return 1, nil
+		}
+	}
+
+	switch {
+	case pa == pb:
+		return 0, nil
+	case pa == "":
+		return 1, nil
+	case pb == "":
+		return -1, nil
+	default:
+		return comparePrereleases(pa, pb), nil
+	}
+}
+
+// comparePrereleases compares two pre-releases identifier by identifier: the
+// numeric identifiers are compared numerically and are older than the
+// alphanumeric ones, compared in ASCII order. When all the identifiers of the
+// shorter pre-release are equal, it is the older one.
+func comparePrereleases(a, b string) int {
+	ia, ib := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		na, errA := strconv.ParseUint(ia[i], 10, 64)
+		nb, errB := strconv.ParseUint(ib[i], 10, 64)
+
+		switch {
+		case errA == nil && errB == nil:
+			switch {
+			case na < nb:
+				return -1
+			case na > nb:
+				return 1
+			}
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		case ia[i] != ib[i]:
+			return strings.Compare(ia[i], ib[i])
+		}
+	}
+
+	switch {
+	case len(ia) < len(ib):
+		return -1
+	case len(ia) > len(ib):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseVersion splits a version into its major, minor and patch numbers and
+// its pre-release identifier. Build metadata is ignored.
+func parseVersion(version string) ([3]int, string, error) {
+	var numbers [3]int
+
+	version = strings.TrimPrefix(version, "v")
+	version, _, _ = strings.Cut(version, "+")
+	version, prerelease, _ := strings.Cut(version, "-")
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return numbers, "", ErrInvalidVersion
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return numbers, "", ErrInvalidVersion
+		}
+
+		numbers[i] = n
+	}
+
+	return numbers, prerelease, nil
+}
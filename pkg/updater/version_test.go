@@ -0,0 +1,158 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.1", "1.0.0", 1},
+		{"1.1.0", "1.0.9", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0", "1.0.1", -1},
+		{"0.9.0", "v0.10.0", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+	}
+
+	for _, tc := range cases {
+		got, err := CompareVersions(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("CompareVersions(%q, %q) = %v", tc.a, tc.b, err)
+
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestCompareInvalidVersions(t *testing.T) {
+	for _, invalid := range []string{"", "latest", "1", "1.0", "1.0.0.0", "1.x.0", "1.-1.0"} {
+		if _, err := CompareVersions(invalid, "1.0.0"); !errors.Is(err, ErrInvalidVersion) {
+			t.Errorf("CompareVersions(%q, 1.0.0) = %v, want %v", invalid, err, ErrInvalidVersion)
+		}
+
+		if _, err := CompareVersions("1.0.0", invalid); !errors.Is(err, ErrInvalidVersion) {
+			t.Errorf("CompareVersions(1.0.0, %q) = %v, want %v", invalid, err, ErrInvalidVersion)
+		}
+	}
+}
+
+func TestNeedsUpdate(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		current string
+		version string
+		want    bool
+		err     error
+	}{
+		{"newer", "1.0.0", "1.1.0", true, nil},
+		{"same", "1.0.0", "1.0.0", false, nil},
+		{"older", "1.1.0", "1.0.0", false, nil},
+		{"invalid", "1.0.0", "latest", false, ErrInvalidVersion},
+		{"development version", LatestVersion, "1.1.0", false, ErrUpdatesDisabled},
+	}
+
+	for _, tc := range cases {
+		u := NewUpdater(tc.current, "https://example.com/agent", ChannelStable, pub)
+
+		got, err := u.NeedsUpdate(tc.version)
+		if !errors.Is(err, tc.err) || got != tc.want {
+			t.Errorf("%s: NeedsUpdate(%q) = %v, %v, want %v, %v", tc.name, tc.version, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestComparePrereleases(t *testing.T) {
+	// The precedence example of the Semantic Versioning specification, from
+	// the oldest to the newest.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+
+			got, err := CompareVersions(ordered[i], ordered[j])
+			if err != nil || got != want {
+				t.Errorf("CompareVersions(%q, %q) = %d, %v, want %d", ordered[i], ordered[j], got, err, want)
+			}
+		}
+	}
+
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0-2", "1.0.0-10", -1},
+		{"1.0.0-10", "1.0.0-a", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-rc.1.1", "1.0.0-rc.1", 1},
+		{"1.0.0-RC.1", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1+build.1", "1.0.0-rc.1", 0},
+	}
+
+	for _, tc := range cases {
+		got, err := CompareVersions(tc.a, tc.b)
+		if err != nil || got != tc.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, %v, want %d", tc.a, tc.b, got, err, tc.want)
+		}
+	}
+}
+
+func TestNewer(t *testing.T) {
+	cases := []struct {
+		version string
+		current string
+		channel string
+		want    bool
+	}{
+		{"1.1.0", "1.0.0", ChannelStable, true},
+		{"1.1.0", "1.0.0", ChannelBeta, true},
+		{"1.1.0-rc.1", "1.0.0", ChannelStable, false},
+		{"1.1.0-rc.1", "1.0.0", ChannelBeta, true},
+		{"1.1.0-rc.10", "1.1.0-rc.2", ChannelBeta, true},
+		{"1.1.0-rc.2", "1.1.0-rc.10", ChannelBeta, false},
+		{"1.1.0", "1.1.0-rc.2", ChannelStable, true},
+		{"1.0.0", "1.0.0", ChannelBeta, false},
+	}
+
+	for _, tc := range cases {
+		got, err := Newer(tc.version, tc.current, tc.channel)
+		if err != nil || got != tc.want {
+			t.Errorf("Newer(%q, %q, %q) = %v, %v, want %v", tc.version, tc.current, tc.channel, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package webhook delivers the agent lifecycle events, as JSON, to an external
+// HTTP endpoint, such as a SIEM or an automation service.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/backoff"
+	log "github.com/sirupsen/logrus"
+)
+
+// SignatureHeader is the header holding the HMAC-SHA256 signature of the event,
+// computed over the request body with the webhook secret.
+const SignatureHeader = "X-ShellHub-Signature"
+
+// Types of the events.
+const (
+	SessionOpened = "session_opened"
+	SessionClosed = "session_closed"
+	Connected     = "connected"
+	Disconnected  = "disconnected"
+	AuthFailed    = "auth_failed"
+)
+
+// maxAttempts is the number of times the delivery of an event is attempted
+// before it is dropped.
+const maxAttempts = 5
+
+// Event is an agent lifecycle event.
+type Event struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	TenantID      string    `json:"tenant_id,omitempty"`
+	Device        string    `json:"device,omitempty"`
+	Namespace     string    `json:"namespace,omitempty"`
+	SessionID     string    `json:"session_id,omitempty"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	BytesReceived int64     `json:"bytes_received,omitempty"`
+	BytesSent     int64     `json:"bytes_sent,omitempty"`
+	Server        string    `json:"server,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Sender queues the events and posts them to the webhook URL in background,
+// retrying the failed deliveries with backoff. When the queue is full, the
+// oldest event is dropped, so queueing an event never blocks.
+type Sender struct {
+	url     string
+	secret  []byte
+	http    *http.Client
+	backoff *backoff.Backoff
+
+	mu      sync.Mutex
+	queue   []Event
+	size    int
+	dropped int
+	ready   chan struct{}
+}
+
+// NewSender creates a Sender posting the events to url, signed with secret when
+// it is not empty, holding up to size events pending delivery.
+func NewSender(url, secret string, size int) *Sender {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Sender{
+		url:     url,
+		secret:  []byte(secret),
+		http:    &http.Client{Timeout: 10 * time.Second},
+		backoff: backoff.New(time.Second, 30*time.Second),
+		size:    size,
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Send queues the event for delivery, dropping the oldest queued event when
+// the queue is full. It is a no-op on a nil Sender.
+func (s *Sender) Send(event Event) {
+	if s == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.mu.Lock()
+
+	if len(s.queue) >= s.size {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+
+	s.queue = append(s.queue, event)
+
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (s *Sender) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// next removes and returns the oldest queued event.
+func (s *Sender) next() (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return Event{}, false
+	}
+
+	event := s.queue[0]
+	s.queue = s.queue[1:]
+
+	return event, true
+}
+
+// Run delivers the queued events until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		event, ok := s.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.ready:
+				continue
+			}
+		}
+
+		s.deliver(ctx, event)
+	}
+}
+
+// deliver posts the event, retrying with backoff until it is accepted, the
+// attempts are exhausted or ctx is done.
+func (s *Sender) deliver(ctx context.Context, event Event) {
+	defer s.backoff.Reset()
+
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, event)
+		if err == nil {
+			return
+		}
+
+		logger := log.WithError(err).WithFields(log.Fields{
+			"event":   event.Type,
+			"attempt": attempt,
+		})
+
+		if attempt >= maxAttempts {
+			logger.Warn("Failed to deliver the event to the webhook, dropping it")
+
+			return
+		}
+
+		delay := s.backoff.Next()
+
+		logger.WithField("retry_in", delay).Debug("Failed to deliver the event to the webhook")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// post sends the event to the webhook URL.
+func (s *Sender) post(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(s.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(s.secret, body))
+	}
+
+	res, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", res.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/backoff"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog"))
+	if want := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+}
+
+func TestSendDropsOldest(t *testing.T) {
+	s := NewSender("http://localhost", "", 2)
+
+	for _, typ := range []string{SessionOpened, SessionClosed, Disconnected} {
+		s.Send(Event{Type: typ})
+	}
+
+	if dropped := s.Dropped(); dropped != 1 {
+		t.Errorf("Dropped() = %d, want 1", dropped)
+	}
+
+	for _, want := range []string{SessionClosed, Disconnected} {
+		event, ok := s.next()
+		if !ok || event.Type != want {
+			t.Fatalf("next() = %+v, %v, want the %s event", event, ok, want)
+		}
+
+		if event.Time.IsZero() {
+			t.Errorf("%s event time is not set", event.Type)
+		}
+	}
+
+	if _, ok := s.next(); ok {
+		t.Error("next() returned an event from an empty queue")
+	}
+}
+
+func TestSendNil(t *testing.T) {
+	var s *Sender
+
+	s.Send(Event{Type: Connected})
+}
+
+func TestRun(t *testing.T) {
+	const secret = "secret"
+
+	type request struct {
+		event     Event
+		signature string
+		valid     bool
+	}
+
+	requests := make(chan request, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var event Event
+		json.Unmarshal(body, &event) // nolint:errcheck
+
+		requests <- request{
+			event:     event,
+			signature: r.Header.Get(SignatureHeader),
+			valid:     r.Header.Get(SignatureHeader) == "sha256="+Sign([]byte(secret), body),
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSender(srv.URL, secret, 8)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.Run(ctx)
+
+	s.Send(Event{Type: Connected, Server: "cloud.shellhub.io"})
+
+	select {
+	case req := <-requests:
+		if req.event.Type != Connected || req.event.Server != "cloud.shellhub.io" {
+			t.Errorf("delivered event = %+v, want the sent one", req.event)
+		}
+
+		if !req.valid {
+			t.Errorf("signature = %q, want the HMAC-SHA256 of the body", req.signature)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		calls    int32
+	}{
+		{name: "accepted", calls: 1},
+		{name: "retried", failures: 2, calls: 3},
+		{name: "dropped after the attempts", failures: 100, calls: maxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			s := NewSender(srv.URL, "", 1)
+			s.backoff = backoff.New(time.Millisecond, time.Millisecond)
+
+			s.deliver(context.Background(), Event{Type: AuthFailed})
+
+			if got := atomic.LoadInt32(&calls); got != tt.calls {
+				t.Errorf("deliver() posted %d times, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDeliverStopsOnCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	s := NewSender(srv.URL, "", 1)
+	s.backoff = backoff.New(time.Hour, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	go func() {
+		s.deliver(ctx, Event{Type: Disconnected})
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver() did not stop once the context was done")
+	}
+}
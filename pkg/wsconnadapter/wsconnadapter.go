@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,12 +21,35 @@ type Adapter struct {
 	readMutex  sync.Mutex
 	writeMutex sync.Mutex
 	reader     io.Reader
+	lastPong   int64
 }
 
 func New(conn *websocket.Conn) *Adapter {
-	return &Adapter{
-		conn: conn,
+	a := &Adapter{
+		conn:     conn,
+		lastPong: time.Now().UnixNano(),
 	}
+
+	// Pongs are handled while reading, so they are only seen as long as the
+	// connection is being read from.
+	conn.SetPongHandler(func(string) error {
+		atomic.StoreInt64(&a.lastPong, time.Now().UnixNano())
+
+		return nil
+	})
+
+	return a
+}
+
+// Ping sends a WebSocket ping to the peer, which answers it with a pong.
+func (a *Adapter) Ping(deadline time.Time) error {
+	return a.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// LastPong returns when the last pong was received from the peer, or when the
+// adapter was created if none was received yet.
+func (a *Adapter) LastPong() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.lastPong))
 }
 
 func (a *Adapter) Read(b []byte) (int, error) {
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+var ErrUnsupportedProxy = errors.New("unsupported proxy scheme")
+
+// proxyFunc returns the function selecting the proxy of the requests to the
+// server. The proxy set in the configuration is used for every request,
+// otherwise it is read from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// environment variables, ALL_PROXY being used when the former ones are unset.
+// Both HTTP and SOCKS5 proxies are supported, with the credentials, if any, in
+// the URL user info.
+func proxyFunc(opts *ConfigOptions) (func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+
+	all := os.Getenv("ALL_PROXY")
+	if all == "" {
+		all = os.Getenv("all_proxy")
+	}
+
+	if opts.Proxy != "" {
+		all = opts.Proxy
+		config.HTTPProxy = ""
+		config.HTTPSProxy = ""
+	}
+
+	if config.HTTPProxy == "" {
+		config.HTTPProxy = all
+	}
+
+	if config.HTTPSProxy == "" {
+		config.HTTPSProxy = all
+	}
+
+	for _, proxy := range []string{config.HTTPProxy, config.HTTPSProxy} {
+		if err := checkProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	proxy := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// checkProxy checks that the proxy, when set, is an URL of a supported scheme.
+// A proxy without scheme is taken as an HTTP one.
+func checkProxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// Retry as an HTTP proxy, like httpproxy does.
+		if u, err = url.Parse("http://" + proxy); err != nil {
+			return fmt.Errorf("invalid proxy %q: %w", proxy, err)
+		}
+	}
+
+	switch u.Scheme {
+	case "http", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedProxy, u.Scheme)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+	"github.com/brycedjohnson/shellhub-agent/server"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadableOptions are the configuration options, by field name, applied by
+// reload. A change to any other option requires restarting the agent.
+var reloadableOptions = map[string]bool{
+	"LogLevel":           true,
+	"LogFormat":          true,
+	"LogTimestampFormat": true,
+	"KeepAliveInterval":  true,
+}
+
+// reload loads the configuration again, applying the options that can be
+// changed while the agent is running. Changes to the other options are ignored
+// with a warning.
+func (a *Agent) reload(configFile string, serv *server.Server) error {
+	opts, err := loadConfigOptions(configFile)
+	if err != nil {
+		return err
+	}
+
+	// Compare against the options as adjusted at startup.
+	if serverAddress, err := normalizeServerAddresses(opts.ServerAddress); err == nil {
+		opts.ServerAddress = serverAddress
+	}
+
+	if !updater.ValidChannel(opts.UpdateChannel) {
+		opts.UpdateChannel = updater.ChannelStable
+	}
+
+	if opts.ConnWriteTimeout == 0 {
+		opts.ConnWriteTimeout = a.opts.ConnWriteTimeout
+	}
+
+	level, err := log.ParseLevel(opts.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	formatter, err := newLogFormatter(opts.LogFormat, opts.LogTimestampFormat)
+	if err != nil {
+		return err
+	}
+
+	current := reflect.ValueOf(a.opts).Elem()
+	reloaded := reflect.ValueOf(opts).Elem()
+
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+
+		if reloadableOptions[field.Name] || reflect.DeepEqual(current.Field(i).Interface(), reloaded.Field(i).Interface()) {
+			continue
+		}
+
+		log.WithField("option", field.Tag.Get("envconfig")).Warn("Ignoring the change of an option that requires restarting the agent")
+	}
+
+	log.SetLevel(level)
+	log.SetFormatter(formatter)
+
+	if serv != nil {
+		serv.SetKeepAliveInterval(opts.KeepAliveInterval)
+	}
+
+	a.opts.LogLevel = opts.LogLevel
+	a.opts.LogFormat = opts.LogFormat
+	a.opts.LogTimestampFormat = opts.LogTimestampFormat
+	a.opts.KeepAliveInterval = opts.KeepAliveInterval
+
+	log.WithFields(log.Fields{
+		"log_level":          level,
+		"keepalive_interval": opts.KeepAliveInterval,
+	}).Info("Configuration reloaded")
+
+	return nil
+}
+
+// reloadOnSignal reloads the configuration whenever the agent receives SIGHUP.
+func (a *Agent) reloadOnSignal(configFile string, serv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if a.logFile != nil {
+			if err := a.logFile.Reopen(); err != nil {
+				log.WithError(err).Error("Failed to reopen the log file")
+			}
+		}
+
+		log.Info("Received SIGHUP, reloading the configuration")
+
+		if err := a.reload(configFile, serv); err != nil {
+			log.WithError(err).Error("Failed to reload the configuration")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/server"
+	log "github.com/sirupsen/logrus"
+)
+
+// captureLog redirects the standard logger to the returned buffer, restoring
+// its settings when the test finishes.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	logger := log.StandardLogger()
+	level, formatter, out := logger.GetLevel(), logger.Formatter, logger.Out
+
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		log.SetFormatter(formatter)
+		log.SetOutput(out)
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+
+	return &buf
+}
+
+func TestReload(t *testing.T) {
+	buf := captureLog(t)
+
+	opts := newTestConfigOptions(t)
+
+	agent, err := NewAgent(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTestConfigFile(t, `
+log_level: debug
+log_format: json
+keepalive_interval: 45
+`)
+
+	t.Setenv("SHELLHUB_TENANT_ID", "other-tenant")
+
+	if err := agent.reload(path, server.NewServer(nil, nil, "", 30, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if agent.opts.LogLevel != "debug" || agent.opts.LogFormat != "json" || agent.opts.KeepAliveInterval != 45 {
+		t.Errorf("reloaded options = %q, %q, %d, want debug, json, 45", agent.opts.LogLevel, agent.opts.LogFormat, agent.opts.KeepAliveInterval)
+	}
+
+	if log.GetLevel() != log.DebugLevel {
+		t.Errorf("log level = %s, want debug", log.GetLevel())
+	}
+
+	if _, ok := log.StandardLogger().Formatter.(*log.JSONFormatter); !ok {
+		t.Errorf("log formatter = %T, want JSON", log.StandardLogger().Formatter)
+	}
+
+	// The tenant can only be changed by restarting the agent.
+	if agent.opts.TenantID != "tenant" {
+		t.Errorf("tenant id = %q, want it unchanged", agent.opts.TenantID)
+	}
+
+	if !strings.Contains(buf.String(), "option=tenant_id") {
+		t.Errorf("log = %q, want a warning about the tenant id", buf.String())
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	captureLog(t)
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "log level", config: "log_level: verbose"},
+		{name: "log format", config: "log_format: xml"},
+		{name: "config file", config: "log_level: [debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := newTestConfigOptions(t)
+
+			agent, err := NewAgent(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			level := agent.opts.LogLevel
+
+			if err := agent.reload(writeTestConfigFile(t, tt.config), nil); err == nil {
+				t.Fatal("reload() error = nil")
+			}
+
+			if agent.opts.LogLevel != level {
+				t.Errorf("log level = %q after a failed reload, want %q", agent.opts.LogLevel, level)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+)
+
+var (
+	ErrInvalidDNSServer    = errors.New("invalid dns server address")
+	ErrInvalidHostOverride = errors.New("invalid host override, expected host:ip")
+)
+
+// dnsPort is the port of the DNS server when its address has none.
+const dnsPort = "53"
+
+// dnsResolver returns the resolver querying the DNS server set in the
+// configuration, or nil when none is set.
+func dnsResolver(opts *ConfigOptions) (*net.Resolver, error) {
+	if opts.DNSServer == "" {
+		return nil, nil
+	}
+
+	address := opts.DNSServer
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), dnsPort)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDNSServer, opts.DNSServer)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, network, address)
+		},
+	}, nil
+}
+
+// hostOverrides returns the host overrides set in the configuration, as
+// host:ip pairs, where the ip may be an IPv6 address.
+func hostOverrides(opts *ConfigOptions) (client.HostOverrides, error) {
+	if len(opts.HostOverrides) == 0 {
+		return nil, nil
+	}
+
+	hosts := make(client.HostOverrides)
+
+	for _, override := range opts.HostOverrides {
+		host, ip, ok := strings.Cut(strings.TrimSpace(override), ":")
+		if !ok || host == "" || net.ParseIP(strings.Trim(ip, "[]")) == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHostOverride, override)
+		}
+
+		hosts[host] = strings.Trim(ip, "[]")
+	}
+
+	return hosts, nil
+}
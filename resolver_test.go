@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+)
+
+func TestDNSResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		resolver bool
+		err      error
+	}{
+		{name: "none"},
+		{name: "ipv4", server: "192.0.2.53", resolver: true},
+		{name: "ipv4 with port", server: "192.0.2.53:5353", resolver: true},
+		{name: "ipv6", server: "2001:db8::53", resolver: true},
+		{name: "bracketed ipv6", server: "[2001:db8::53]", resolver: true},
+		{name: "ipv6 with port", server: "[2001:db8::53]:5353", resolver: true},
+		{name: "host name", server: "dns.example.com", err: ErrInvalidDNSServer},
+		{name: "host name with port", server: "dns.example.com:53", err: ErrInvalidDNSServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := dnsResolver(&ConfigOptions{DNSServer: tt.server})
+			if !errors.Is(err, tt.err) || (resolver != nil) != tt.resolver {
+				t.Errorf("dnsResolver() = %v, %v, want a resolver %v, %v", resolver, err, tt.resolver, tt.err)
+			}
+		})
+	}
+}
+
+func TestDNSResolverDial(t *testing.T) {
+	dns, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dns.Close()
+
+	resolver, err := dnsResolver(&ConfigOptions{DNSServer: dns.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The resolver queries the configured server, whatever the system one.
+	conn, err := resolver.Dial(context.Background(), "udp", "198.51.100.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+
+	dns.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint:errcheck
+
+	buf := make([]byte, 16)
+
+	n, _, err := dns.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "query" {
+		t.Errorf("DNS server received %q, %v, want the query", buf[:n], err)
+	}
+}
+
+func TestHostOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []string
+		want      client.HostOverrides
+		err       error
+	}{
+		{name: "none"},
+		{
+			name:      "ipv4 and ipv6",
+			overrides: []string{"cloud.shellhub.io:203.0.113.10", " ssh.shellhub.io:2001:db8::10 ", "api.shellhub.io:[2001:db8::11]"},
+			want: client.HostOverrides{
+				"cloud.shellhub.io": "203.0.113.10",
+				"ssh.shellhub.io":   "2001:db8::10",
+				"api.shellhub.io":   "2001:db8::11",
+			},
+		},
+		{name: "missing ip", overrides: []string{"cloud.shellhub.io"}, err: ErrInvalidHostOverride},
+		{name: "missing host", overrides: []string{":203.0.113.10"}, err: ErrInvalidHostOverride},
+		{name: "invalid ip", overrides: []string{"cloud.shellhub.io:shellhub.io"}, err: ErrInvalidHostOverride},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hostOverrides(&ConfigOptions{HostOverrides: tt.overrides})
+			if !errors.Is(err, tt.err) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hostOverrides() = %v, %v, want %v, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rttSummary summarizes the round-trip times to the server measured over a
+// window, in milliseconds.
+type rttSummary struct {
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min_ms"`
+	Avg     float64 `json:"avg_ms"`
+	Max     float64 `json:"max_ms"`
+}
+
+// summarizeRTT returns the summary of the round-trip times.
+func summarizeRTT(samples []time.Duration) rttSummary {
+	if len(samples) == 0 {
+		return rttSummary{}
+	}
+
+	min, max, sum := samples[0], samples[0], time.Duration(0)
+	for _, rtt := range samples {
+		if rtt < min {
+			min = rtt
+		}
+
+		if rtt > max {
+			max = rtt
+		}
+
+		sum += rtt
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	return rttSummary{
+		Samples: len(samples),
+		Min:     ms(min),
+		Avg:     ms(sum / time.Duration(len(samples))),
+		Max:     ms(max),
+	}
+}
+
+// rttStats collects the round-trip times to the server, measured by the
+// heartbeats, over the current window.
+type rttStats struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	last    rttSummary
+}
+
+// record adds a round-trip time to the current window.
+func (s *rttStats) record(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = append(s.samples, rtt)
+}
+
+// flush returns the summary of the current window and starts a new one.
+func (s *rttStats) flush() rttSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = summarizeRTT(s.samples)
+	s.samples = s.samples[:0]
+
+	return s.last
+}
+
+// summary returns the summary of the current window or, when nothing was
+// measured in it yet, of the previous one.
+func (s *rttStats) summary() rttSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.samples) == 0 {
+		return s.last
+	}
+
+	return summarizeRTT(s.samples)
+}
+
+// reportRTT logs the summary of the round-trip times to the server every
+// interval, until ctx is done.
+func (a *Agent) reportRTT(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		summary := a.rtt.flush()
+		if summary.Samples == 0 {
+			continue
+		}
+
+		a.logger().WithFields(log.Fields{
+			"server_address": a.currentAddress().String(),
+			"rtt_min_ms":     summary.Min,
+			"rtt_avg_ms":     summary.Avg,
+			"rtt_max_ms":     summary.Max,
+			"samples":        summary.Samples,
+		}).Info("Server connection round-trip time")
+	}
+}
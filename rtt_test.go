@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeRTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    rttSummary
+	}{
+		{name: "empty"},
+		{name: "single", samples: []time.Duration{25 * time.Millisecond}, want: rttSummary{Samples: 1, Min: 25, Avg: 25, Max: 25}},
+		{
+			name:    "several",
+			samples: []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, 30 * time.Millisecond},
+			want:    rttSummary{Samples: 4, Min: 10, Avg: 30, Max: 50},
+		},
+		{
+			name:    "sub-millisecond",
+			samples: []time.Duration{500 * time.Microsecond, 1500 * time.Microsecond},
+			want:    rttSummary{Samples: 2, Min: 0.5, Avg: 1, Max: 1.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeRTT(tt.samples); got != tt.want {
+				t.Errorf("summarizeRTT() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRTTStats(t *testing.T) {
+	var s rttStats
+
+	if got := s.summary(); got.Samples != 0 {
+		t.Fatalf("summary() = %+v, want none before any measure", got)
+	}
+
+	s.record(10 * time.Millisecond)
+	s.record(30 * time.Millisecond)
+
+	want := rttSummary{Samples: 2, Min: 10, Avg: 20, Max: 30}
+	if got := s.summary(); got != want {
+		t.Errorf("summary() = %+v, want %+v", got, want)
+	}
+
+	if got := s.flush(); got != want {
+		t.Errorf("flush() = %+v, want %+v", got, want)
+	}
+
+	// The previous window is reported until the new one is measured.
+	if got := s.summary(); got != want {
+		t.Errorf("summary() = %+v after the flush, want the previous window %+v", got, want)
+	}
+
+	s.record(40 * time.Millisecond)
+
+	want = rttSummary{Samples: 1, Min: 40, Avg: 40, Max: 40}
+	if got := s.summary(); got != want {
+		t.Errorf("summary() = %+v, want the new window %+v", got, want)
+	}
+
+	s.flush()
+
+	if got := s.flush(); got.Samples != 0 {
+		t.Errorf("flush() = %+v, want an empty window", got)
+	}
+}
+
+func TestReportRTT(t *testing.T) {
+	buf := captureLogSync(t)
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.rtt.record(10 * time.Millisecond)
+	agent.rtt.record(30 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		agent.reportRTT(ctx, 10*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), "Server connection round-trip time") {
+		if time.Now().After(deadline) {
+			t.Fatal("the round-trip time was not reported")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The empty windows are not reported.
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	logs := buf.String()
+	if got := strings.Count(logs, "Server connection round-trip time"); got != 1 {
+		t.Errorf("reported the round-trip time %d times, want once:\n%s", got, logs)
+	}
+
+	for _, want := range []string{"rtt_min_ms=10", "rtt_avg_ms=20", "rtt_max_ms=30", "samples=2", "server_address=\"http://localhost\""} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("report misses %q:\n%s", want, logs)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// Algorithms are the key exchange algorithms, ciphers and MACs negotiated by
+// the SSH server, in order of preference. An empty list means the defaults of
+// the SSH library.
+type Algorithms struct {
+	KeyExchanges []string
+	Ciphers      []string
+	MACs         []string
+}
+
+// Algorithms supported by the server half of the SSH library.
+var (
+	supportedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	}
+
+	supportedCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+
+	supportedMACs = []string{
+		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-256-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	}
+)
+
+// CheckAlgorithms checks that the algorithms are supported by the SSH server.
+func CheckAlgorithms(algorithms Algorithms) error {
+	for _, list := range []struct {
+		kind      string
+		names     []string
+		supported []string
+	}{
+		{"key exchange", algorithms.KeyExchanges, supportedKeyExchanges},
+		{"cipher", algorithms.Ciphers, supportedCiphers},
+		{"MAC", algorithms.MACs, supportedMACs},
+	} {
+		for _, name := range list.names {
+			if !contains(list.supported, name) {
+				return fmt.Errorf("%w: %s %q, supported are %s", ErrUnsupportedAlgorithm, list.kind, name, strings.Join(list.supported, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// serverConfig returns the SSH configuration negotiating the algorithms.
+func (a Algorithms) serverConfig() *gossh.ServerConfig {
+	config := &gossh.ServerConfig{}
+
+	config.KeyExchanges = nonEmpty(a.KeyExchanges)
+	config.Ciphers = nonEmpty(a.Ciphers)
+	config.MACs = nonEmpty(a.MACs)
+
+	return config
+}
+
+// nonEmpty returns the list, or nil when it is empty so the SSH library uses its
+// defaults.
+func nonEmpty(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
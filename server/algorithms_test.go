@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCheckAlgorithms(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithms Algorithms
+		err        error
+	}{
+		{name: "defaults", algorithms: Algorithms{}},
+		{
+			name: "supported",
+			algorithms: Algorithms{
+				KeyExchanges: []string{"curve25519-sha256", "ecdh-sha2-nistp256"},
+				Ciphers:      []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"},
+				MACs:         []string{"hmac-sha2-256-etm@openssh.com"},
+			},
+		},
+		{name: "unsupported key exchange", algorithms: Algorithms{KeyExchanges: []string{"sntrup761x25519-sha512@openssh.com"}}, err: ErrUnsupportedAlgorithm},
+		{name: "unsupported cipher", algorithms: Algorithms{Ciphers: []string{"aes128-ctr", "blowfish-cbc"}}, err: ErrUnsupportedAlgorithm},
+		{name: "unsupported mac", algorithms: Algorithms{MACs: []string{"hmac-md5"}}, err: ErrUnsupportedAlgorithm},
+		{name: "mac as cipher", algorithms: Algorithms{Ciphers: []string{"hmac-sha2-256"}}, err: ErrUnsupportedAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckAlgorithms(tt.algorithms); !errors.Is(err, tt.err) {
+				t.Errorf("CheckAlgorithms() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAlgorithmsNegotiation(t *testing.T) {
+	s := NewServer(nil, nil, "", 30, testPasswordHash, WithAlgorithms(Algorithms{
+		Ciphers: []string{"aes256-ctr"},
+		MACs:    []string{"hmac-sha2-256"},
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.sshd.Serve(listener) // nolint:errcheck
+	defer s.sshd.Close()
+
+	tests := []struct {
+		name    string
+		ciphers []string
+		ok      bool
+	}{
+		{name: "allowed cipher", ciphers: []string{"aes128-ctr", "aes256-ctr"}, ok: true},
+		{name: "other cipher", ciphers: []string{"aes128-ctr"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &gossh.ClientConfig{
+				User:            "root",
+				Auth:            []gossh.AuthMethod{gossh.Password(testPassword)},
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(), // nolint:gosec
+			}
+			config.Ciphers = tt.ciphers
+
+			client, err := gossh.Dial("tcp", listener.Addr().String(), config)
+			if (err == nil) != tt.ok {
+				t.Fatalf("handshake error = %v, want success %v", err, tt.ok)
+			}
+
+			if err != nil {
+				return
+			}
+
+			defer client.Close()
+
+			// A command is run before closing the client, as closing it
+			// right after the handshake races with the connection setup.
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := session.Run("true"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
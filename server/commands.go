@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// compileCommandPattern compiles a pattern of the allowed commands, matched
+// against the whole command line, where "*" matches any sequence of characters,
+// spaces included, and "?" any single character.
+func compileCommandPattern(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(strings.Join(strings.Fields(pattern), " "))
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// commandAllowed reports whether the command, as split into its arguments, can
+// be executed. Any command is allowed when there are no allowed commands set.
+func (s *Server) commandAllowed(command []string) bool {
+	if len(s.allowedCommands) == 0 {
+		return true
+	}
+
+	line := strings.Join(command, " ")
+
+	for _, pattern := range s.allowedCommands {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkExecRequest rejects the exec requests of commands not allowed, telling
+// the client why.
+func (s *Server) checkExecRequest(session gliderssh.Session) bool {
+	if s.commandAllowed(session.Command()) {
+		return true
+	}
+
+	s.logger().WithFields(log.Fields{
+		"user":        session.User(),
+		"remoteaddr":  session.RemoteAddr(),
+		"Raw command": session.RawCommand(),
+	}).Warn("Rejected command not allowed")
+
+	fmt.Fprintf(session.Stderr(), "command not allowed: %s\n", session.RawCommand())
+
+	return false
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommandAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		command  []string
+		allowed  bool
+	}{
+		{name: "no patterns", command: []string{"rm", "-rf", "/"}, allowed: true},
+		{name: "blank patterns", patterns: []string{" ", ""}, command: []string{"reboot"}, allowed: true},
+		{name: "exact", patterns: []string{"uptime"}, command: []string{"uptime"}, allowed: true},
+		{name: "not listed", patterns: []string{"uptime"}, command: []string{"reboot"}},
+		{name: "extra arguments", patterns: []string{"uptime"}, command: []string{"uptime", "-p"}},
+		{name: "chained command", patterns: []string{"uptime"}, command: []string{"uptime;", "reboot"}},
+		{name: "prefix", patterns: []string{"up"}, command: []string{"uptime"}},
+		{name: "star", patterns: []string{"systemctl status *"}, command: []string{"systemctl", "status", "sshd"}, allowed: true},
+		{name: "star matches spaces", patterns: []string{"ls *"}, command: []string{"ls", "-l", "/tmp"}, allowed: true},
+		{name: "star needs the space", patterns: []string{"ls *"}, command: []string{"ls"}},
+		{name: "star is anchored", patterns: []string{"cat /var/log/*"}, command: []string{"cat", "/etc/shadow"}},
+		{name: "question mark", patterns: []string{"cat /tmp/?"}, command: []string{"cat", "/tmp/a"}, allowed: true},
+		{name: "question mark is one character", patterns: []string{"cat /tmp/?"}, command: []string{"cat", "/tmp/ab"}},
+		{name: "dot is literal", patterns: []string{"cat a.txt"}, command: []string{"cat", "aXtxt"}},
+		{name: "brackets are literal", patterns: []string{"cat [ab]"}, command: []string{"cat", "a"}},
+		{name: "brackets match themselves", patterns: []string{"cat [ab]"}, command: []string{"cat", "[ab]"}, allowed: true},
+		{name: "plus is literal", patterns: []string{"echo a+"}, command: []string{"echo", "aa"}},
+		{name: "alternation is literal", patterns: []string{"echo a|reboot"}, command: []string{"reboot"}},
+		{name: "anchors are literal", patterns: []string{"^echo$"}, command: []string{"echo"}},
+		{name: "pattern spaces are collapsed", patterns: []string{"  ls   -l  "}, command: []string{"ls", "-l"}, allowed: true},
+		{name: "argument with spaces", patterns: []string{"echo a b"}, command: []string{"echo", "a b"}, allowed: true},
+		{name: "second pattern", patterns: []string{"uptime", "df -h"}, command: []string{"df", "-h"}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			WithAllowedCommands(tt.patterns)(s)
+
+			if allowed := s.commandAllowed(tt.command); allowed != tt.allowed {
+				t.Errorf("commandAllowed(%q) with the patterns %q = %v, want %v", tt.command, tt.patterns, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestExecRequestNotAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		allowed bool
+	}{
+		{name: "allowed", command: "echo allowed", allowed: true},
+		{name: "not allowed", command: "id"},
+		{name: "extra arguments", command: "echo allowed; id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestSSHClient(t, WithAllowedCommands([]string{"echo allowed"}))
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer session.Close()
+
+			var stdout bytes.Buffer
+
+			session.Stdout = &stdout
+
+			err = session.Run(tt.command)
+
+			// The exec request itself is refused, so the command never
+			// starts.
+			if !tt.allowed {
+				if err == nil || !strings.Contains(err.Error(), "failed") {
+					t.Fatalf("Run(%q) = %v, want the request refused", tt.command, err)
+				}
+
+				if stdout.Len() != 0 {
+					t.Errorf("Run(%q) output = %q, want none", tt.command, stdout.String())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Run(%q) = %v", tt.command, err)
+			}
+
+			if got := stdout.String(); got != "allowed\n" {
+				t.Errorf("Run(%q) output = %q, want %q", tt.command, got, "allowed\n")
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"os/user"
+	"strings"
+)
+
+var (
+	ErrEmptyUser  = errors.New("user is empty")
+	ErrEmptyGroup = errors.New("group is empty")
+)
+
+// Credential is the user and group ids a process started by the agent runs as.
+type Credential struct {
+	UID string
+	GID string
+}
+
+// LookupCredential resolves a "user[:group]" specification, where the user and
+// the group are either names or numeric ids, to the ids of an existing user
+// and group. Without a group, the primary group of the user is used.
+func LookupCredential(spec string) (*Credential, error) {
+	name, group, hasGroup := strings.Cut(strings.TrimSpace(spec), ":")
+	if name == "" {
+		return nil, ErrEmptyUser
+	}
+
+	u, err := lookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &Credential{UID: u.Uid, GID: u.Gid}
+
+	if hasGroup {
+		g, err := lookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+
+		credential.GID = g.Gid
+	}
+
+	return credential, nil
+}
+
+// lookupUser looks up a user by its name, then by its id.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+
+	return nil, err
+}
+
+// lookupGroup looks up a group by its name, then by its id.
+func lookupGroup(name string) (*user.Group, error) {
+	if name == "" {
+		return nil, ErrEmptyGroup
+	}
+
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g, nil
+	}
+
+	if g, err := user.LookupGroupId(name); err == nil {
+		return g, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+)
+
+func TestLookupCredential(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("the nobody user is missing")
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		want Credential
+		err  error
+		fail bool
+	}{
+		{name: "name", spec: "root", want: Credential{UID: "0", GID: "0"}},
+		{name: "id", spec: "0", want: Credential{UID: "0", GID: "0"}},
+		{name: "primary group", spec: "nobody", want: Credential{UID: nobody.Uid, GID: nobody.Gid}},
+		{name: "group name", spec: "nobody:root", want: Credential{UID: nobody.Uid, GID: "0"}},
+		{name: "group id", spec: "root:" + nobody.Gid, want: Credential{UID: "0", GID: nobody.Gid}},
+		{name: "spaces", spec: " root:0 ", want: Credential{UID: "0", GID: "0"}},
+		{name: "empty", spec: "", err: ErrEmptyUser},
+		{name: "missing user", spec: ":root", err: ErrEmptyUser},
+		{name: "missing group", spec: "root:", err: ErrEmptyGroup},
+		{name: "unknown user", spec: "shellhub-missing-user", fail: true},
+		{name: "unknown group", spec: "root:shellhub-missing-group", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential, err := LookupCredential(tt.spec)
+
+			switch {
+			case tt.err != nil || tt.fail:
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("LookupCredential(%q) error = %v, want %v", tt.spec, err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("LookupCredential(%q) error = %v", tt.spec, err)
+			case *credential != tt.want:
+				t.Errorf("LookupCredential(%q) = %+v, want %+v", tt.spec, *credential, tt.want)
+			}
+		})
+	}
+}
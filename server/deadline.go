@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// deadlineConn wraps a connection, refreshing its read and write deadlines
+// before each operation, so a read or write blocked for longer than the
+// timeout fails and the session is closed. A zero timeout disables it.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func newDeadlineConn(conn net.Conn, readTimeout, writeTimeout time.Duration) *deadlineConn {
+	return &deadlineConn{
+		Conn:         conn,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+	}
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Write(b)
+}
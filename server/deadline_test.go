@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDeadlineConn(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name         string
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+		use          func(c *deadlineConn, peer net.Conn) error
+		timedOut     bool
+	}{
+		{
+			name:        "read timed out",
+			readTimeout: timeout,
+			use: func(c *deadlineConn, peer net.Conn) error {
+				_, err := c.Read(make([]byte, 1))
+
+				return err
+			},
+			timedOut: true,
+		},
+		{
+			name:         "write timed out",
+			writeTimeout: timeout,
+			use: func(c *deadlineConn, peer net.Conn) error {
+				_, err := c.Write([]byte("data"))
+
+				return err
+			},
+			timedOut: true,
+		},
+		{
+			name:        "deadline refreshed",
+			readTimeout: timeout,
+			use: func(c *deadlineConn, peer net.Conn) error {
+				// Each read is within the timeout, while all of them take
+				// longer.
+				go func() {
+					for i := 0; i < 4; i++ {
+						time.Sleep(timeout / 2)
+						peer.Write([]byte("x")) // nolint:errcheck
+					}
+				}()
+
+				for i := 0; i < 4; i++ {
+					if _, err := c.Read(make([]byte, 1)); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "disabled",
+			use: func(c *deadlineConn, peer net.Conn) error {
+				go func() {
+					time.Sleep(3 * timeout)
+					peer.Write([]byte("x")) // nolint:errcheck
+				}()
+
+				_, err := c.Read(make([]byte, 1))
+
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, peer := net.Pipe()
+			defer peer.Close()
+
+			c := newDeadlineConn(conn, tt.readTimeout, tt.writeTimeout)
+			defer c.Close()
+
+			err := tt.use(c, peer)
+			if timedOut := errors.Is(err, os.ErrDeadlineExceeded); timedOut != tt.timedOut {
+				t.Fatalf("error = %v, want timed out %v", err, tt.timedOut)
+			}
+
+			if !tt.timedOut && err != nil {
+				t.Errorf("error = %v, want none", err)
+			}
+		})
+	}
+}
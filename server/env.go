@@ -0,0 +1,40 @@
+package server
+
+import (
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// acceptedEnv returns the environment variables, in the key=value form, set by
+// the client whose names match the accepted patterns.
+func (s *Server) acceptedEnv(environ []string) []string {
+	accepted := make([]string, 0, len(environ))
+
+	for _, env := range environ {
+		name := strings.SplitN(env, "=", 2)[0]
+
+		if !s.acceptsEnv(name) {
+			log.WithField("name", name).Debug("Ignoring environment variable not accepted")
+
+			continue
+		}
+
+		accepted = append(accepted, env)
+	}
+
+	return accepted
+}
+
+// acceptsEnv reports whether the environment variable name matches any of the
+// accepted patterns.
+func (s *Server) acceptsEnv(name string) bool {
+	for _, pattern := range s.acceptEnv {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		environ  []string
+		want     []string
+	}{
+		{
+			name:    "no patterns",
+			environ: []string{"LANG=C", "LD_PRELOAD=/tmp/evil.so"},
+			want:    []string{},
+		},
+		{
+			name:     "exact",
+			patterns: []string{"LANG"},
+			environ:  []string{"LANG=C.UTF-8", "LANGUAGE=en", "LD_PRELOAD=/tmp/evil.so"},
+			want:     []string{"LANG=C.UTF-8"},
+		},
+		{
+			name:     "wildcard",
+			patterns: []string{"LC_*"},
+			environ:  []string{"LC_ALL=C", "LC_TIME=C", "LD_PRELOAD=/tmp/evil.so", "XLC_ALL=C"},
+			want:     []string{"LC_ALL=C", "LC_TIME=C"},
+		},
+		{
+			name:     "single character",
+			patterns: []string{"GIT_?"},
+			environ:  []string{"GIT_A=1", "GIT_AB=1"},
+			want:     []string{"GIT_A=1"},
+		},
+		{
+			name:     "character class",
+			patterns: []string{"[LT]ANG"},
+			environ:  []string{"LANG=C", "TANG=C", "PANG=C"},
+			want:     []string{"LANG=C", "TANG=C"},
+		},
+		{
+			name:     "case sensitive",
+			patterns: []string{"LANG"},
+			environ:  []string{"lang=C"},
+			want:     []string{},
+		},
+		{
+			name:     "value with equal signs",
+			patterns: []string{"OPTS"},
+			environ:  []string{"OPTS=a=b"},
+			want:     []string{"OPTS=a=b"},
+		},
+		{
+			name:     "malformed pattern",
+			patterns: []string{"LC_["},
+			environ:  []string{"LC_[", "LC_[=C", "LC_ALL=C"},
+			want:     []string{},
+		},
+		{
+			name:     "malformed escape",
+			patterns: []string{"LANG\\"},
+			environ:  []string{"LANG=C", "LANG\\=C"},
+			want:     []string{},
+		},
+		{
+			name:     "malformed pattern next to a valid one",
+			patterns: []string{"[", "LANG"},
+			environ:  []string{"LANG=C", "[=C", "LD_PRELOAD=/tmp/evil.so"},
+			want:     []string{"LANG=C"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			WithAcceptEnv(tt.patterns)(s)
+
+			if got := s.acceptedEnv(tt.environ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceptedEnv(%q) with the patterns %q = %q, want %q", tt.environ, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionEnv(t *testing.T) {
+	client := newTestSSHClient(t, WithAcceptEnv([]string{"LC_*"}))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+
+	for _, env := range [][2]string{{"LC_TEST", "accepted"}, {"LD_PRELOAD", "/tmp/evil.so"}} {
+		if err := session.Setenv(env[0], env[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output, err := session.Output("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environ := strings.Split(string(output), "\n")
+
+	if !contains(environ, "LC_TEST=accepted") {
+		t.Errorf("session environment %q misses LC_TEST", environ)
+	}
+
+	if contains(environ, "LD_PRELOAD=/tmp/evil.so") {
+		t.Errorf("session environment %q has LD_PRELOAD", environ)
+	}
+}
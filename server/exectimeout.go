@@ -0,0 +1,67 @@
+package server
+
+import (
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecTimeoutExitStatus is the exit status of the commands killed after the
+// exec timeout, as the one of the timeout utility.
+const ExecTimeoutExitStatus = 124
+
+// execTimer kills the process group of a command when the exec timeout
+// expires.
+type execTimer struct {
+	timer   *time.Timer
+	expired int32
+}
+
+// setProcessGroup runs the command in its own process group, so the processes
+// it spawns can be killed along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// startExecTimer starts the timer killing the process group of the started
+// command after the exec timeout. It returns nil when there is no timeout.
+func (s *Server) startExecTimer(session gliderssh.Session, cmd *exec.Cmd) *execTimer {
+	if s.execTimeout <= 0 || cmd.Process == nil {
+		return nil
+	}
+
+	t := &execTimer{}
+	t.timer = time.AfterFunc(s.execTimeout, func() {
+		atomic.StoreInt32(&t.expired, 1)
+
+		s.logger().WithFields(log.Fields{
+			"user":        session.User(),
+			"remoteaddr":  session.RemoteAddr(),
+			"Raw command": session.RawCommand(),
+			"timeout":     s.execTimeout,
+		}).Warn("Command timed out, killing it")
+
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) // nolint:errcheck
+	})
+
+	return t
+}
+
+// stop stops the timer, reporting whether it expired.
+func (t *execTimer) stop() bool {
+	if t == nil {
+		return false
+	}
+
+	t.timer.Stop()
+
+	return atomic.LoadInt32(&t.expired) == 1
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		command string
+		status  int
+	}{
+		{name: "finished in time", timeout: 5 * time.Second, command: `sh -c "exit 3"`, status: 3},
+		{name: "timed out", timeout: 200 * time.Millisecond, command: "sleep 30", status: ExecTimeoutExitStatus},
+		{name: "no timeout", command: `sh -c "sleep 0.3; exit 2"`, status: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestSSHClient(t, WithExecTimeout(tt.timeout))
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer session.Close()
+
+			start := time.Now()
+
+			if status := exitStatus(t, session.Run(tt.command)); status != tt.status {
+				t.Errorf("exit status = %d, want %d", status, tt.status)
+			}
+
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("command ran for %v, want it killed after the timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestExecTimeoutKillsProcessGroup(t *testing.T) {
+	client := newTestSSHClient(t, WithExecTimeout(300*time.Millisecond))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	// The command spawns a process in background, which must be killed along
+	// with it.
+	status := exitStatus(t, session.Run(`sh -c "sleep 30 & echo $! > `+pidFile+`; wait"`))
+	if status != ExecTimeoutExitStatus {
+		t.Fatalf("exit status = %d, want %d", status, ExecTimeoutExitStatus)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("background process %d still running after the timeout", pid)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
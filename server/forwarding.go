@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrInvalidForwardRule = errors.New("invalid port forwarding rule")
+
+// ForwardRule is a destination permitted for the local port forwarding.
+type ForwardRule struct {
+	// host is a hostname pattern, as accepted by path.Match, used when
+	// network is nil.
+	host    string
+	network *net.IPNet
+
+	// port is zero when any port is permitted.
+	port uint32
+}
+
+// ParseForwardRules parses the destinations permitted for the local port
+// forwarding, in the host:port form. The host can be a hostname pattern with
+// wildcards, an IP address or a CIDR, and the port can be "*" to permit any
+// port.
+func ParseForwardRules(patterns []string) ([]ForwardRule, error) {
+	rules := make([]ForwardRule, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+
+		i := strings.LastIndex(pattern, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidForwardRule, pattern)
+		}
+
+		host, port := strings.Trim(pattern[:i], "[]"), pattern[i+1:]
+
+		var rule ForwardRule
+
+		if port != "*" {
+			n, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidForwardRule, pattern)
+			}
+
+			rule.port = uint32(n)
+		}
+
+		switch {
+		case strings.Contains(host, "/"):
+			_, network, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidForwardRule, pattern)
+			}
+
+			rule.network = network
+		case net.ParseIP(host) != nil:
+			ip := net.ParseIP(host)
+			rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		default:
+			if _, err := path.Match(host, ""); err != nil || host == "" {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidForwardRule, pattern)
+			}
+
+			rule.host = strings.ToLower(host)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// permits reports whether the rule permits forwarding to the host and port.
+func (r ForwardRule) permits(host string, port uint32) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+
+	if r.network != nil {
+		ip := net.ParseIP(host)
+
+		return ip != nil && r.network.Contains(ip)
+	}
+
+	matched, _ := path.Match(r.host, strings.ToLower(host))
+
+	return matched
+}
+
+// requestHandlers returns the global request handlers, which handle the remote
+// port forwarding requests besides the default ones. The forwarded listeners are
+// closed when the connection requesting them is closed.
+func requestHandlers() map[string]gliderssh.RequestHandler {
+	handlers := make(map[string]gliderssh.RequestHandler)
+	for name, handler := range gliderssh.DefaultRequestHandlers {
+		handlers[name] = handler
+	}
+
+	forwardHandler := &gliderssh.ForwardedTCPHandler{}
+
+	handlers["tcpip-forward"] = forwardHandler.HandleSSHRequest
+	handlers["cancel-tcpip-forward"] = forwardHandler.HandleSSHRequest
+
+	return handlers
+}
+
+// reversePortForwardingCallback permits the remote port forwarding only when it
+// is enabled.
+func (s *Server) reversePortForwardingCallback(ctx gliderssh.Context, host string, port uint32) bool {
+	if !s.remoteForwarding {
+		log.WithFields(log.Fields{
+			"user": ctx.User(),
+			"host": host,
+			"port": port,
+		}).Warn("Remote port forwarding denied as it is disabled")
+
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"user": ctx.User(),
+		"host": host,
+		"port": port,
+	}).Info("Remote port forwarding requested")
+
+	return true
+}
+
+// localPortForwardingCallback permits the local port forwarding to the
+// destinations in the allow list, or to any destination when it is empty.
+func (s *Server) localPortForwardingCallback(ctx gliderssh.Context, host string, port uint32) bool {
+	if len(s.forwardRules) == 0 {
+		return true
+	}
+
+	for _, rule := range s.forwardRules {
+		if rule.permits(host, port) {
+			return true
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"user": ctx.User(),
+		"host": host,
+		"port": port,
+	}).Warn("Port forwarding to a destination not in the allow list denied")
+
+	return false
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParseForwardRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		err     error
+	}{
+		{name: "hostname", pattern: "example.com:80"},
+		{name: "hostname wildcard", pattern: "*.example.com:443"},
+		{name: "any port", pattern: "localhost:*"},
+		{name: "ip", pattern: "192.168.0.1:22"},
+		{name: "ipv6", pattern: "[::1]:22"},
+		{name: "cidr", pattern: "10.0.0.0/8:*"},
+		{name: "surrounding spaces", pattern: "  localhost:80  "},
+		{name: "missing port", pattern: "localhost", err: ErrInvalidForwardRule},
+		{name: "empty port", pattern: "localhost:", err: ErrInvalidForwardRule},
+		{name: "zero port", pattern: "localhost:0", err: ErrInvalidForwardRule},
+		{name: "port out of range", pattern: "localhost:65536", err: ErrInvalidForwardRule},
+		{name: "port range", pattern: "localhost:80-90", err: ErrInvalidForwardRule},
+		{name: "empty host", pattern: ":80", err: ErrInvalidForwardRule},
+		{name: "bad pattern", pattern: "a[b:80", err: ErrInvalidForwardRule},
+		{name: "bad escape", pattern: "a\\:80", err: ErrInvalidForwardRule},
+		{name: "bad cidr", pattern: "10.0.0.0/33:80", err: ErrInvalidForwardRule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseForwardRules([]string{tt.pattern})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseForwardRules(%q) error = %v, want %v", tt.pattern, err, tt.err)
+			}
+
+			if err == nil && len(rules) != 1 {
+				t.Errorf("ParseForwardRules(%q) = %d rules, want 1", tt.pattern, len(rules))
+			}
+		})
+	}
+}
+
+func TestLocalPortForwardingCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		host     string
+		port     uint32
+		allowed  bool
+	}{
+		{name: "no rules", host: "example.com", port: 80, allowed: true},
+		{name: "exact", patterns: []string{"example.com:80"}, host: "example.com", port: 80, allowed: true},
+		{name: "case insensitive", patterns: []string{"Example.com:80"}, host: "EXAMPLE.COM", port: 80, allowed: true},
+		{name: "other port", patterns: []string{"example.com:80"}, host: "example.com", port: 81},
+		{name: "other host", patterns: []string{"example.com:80"}, host: "example.org", port: 80},
+		{name: "any port", patterns: []string{"example.com:*"}, host: "example.com", port: 8080, allowed: true},
+		{name: "host wildcard", patterns: []string{"*.example.com:443"}, host: "api.example.com", port: 443, allowed: true},
+		{name: "host wildcard needs a subdomain", patterns: []string{"*.example.com:443"}, host: "example.com", port: 443},
+		{name: "host wildcard is one label", patterns: []string{"*.example.com:443"}, host: "evil.com/.example.com", port: 443},
+		{name: "suffix", patterns: []string{"*.example.com:443"}, host: "api.example.com.evil.com", port: 443},
+		{name: "ip", patterns: []string{"192.168.0.1:22"}, host: "192.168.0.1", port: 22, allowed: true},
+		{name: "other ip", patterns: []string{"192.168.0.1:22"}, host: "192.168.0.2", port: 22},
+		{name: "ipv6", patterns: []string{"[::1]:22"}, host: "::1", port: 22, allowed: true},
+		{name: "cidr", patterns: []string{"10.0.0.0/8:*"}, host: "10.1.2.3", port: 5432, allowed: true},
+		{name: "outside cidr", patterns: []string{"10.0.0.0/8:*"}, host: "11.0.0.1", port: 5432},
+		{name: "hostname in cidr rule", patterns: []string{"10.0.0.0/8:*"}, host: "localhost", port: 5432},
+		{name: "second rule", patterns: []string{"example.com:80", "localhost:*"}, host: "localhost", port: 22, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseForwardRules(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &Server{forwardRules: rules}
+
+			if allowed := s.localPortForwardingCallback(&testContext{}, tt.host, tt.port); allowed != tt.allowed {
+				t.Errorf("forwarding to %s:%d with the rules %q = %v, want %v", tt.host, tt.port, tt.patterns, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestReversePortForwardingCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", enabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{remoteForwarding: tt.enabled}
+
+			if allowed := s.reversePortForwardingCallback(&testContext{}, "127.0.0.1", 8080); allowed != tt.enabled {
+				t.Errorf("remote forwarding = %v, want %v", allowed, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestLocalPortForwarding(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer backend.Close()
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+
+			io.WriteString(conn, "hello") // nolint:errcheck
+			conn.Close()
+		}
+	}()
+
+	port := backend.Addr().(*net.TCPAddr).Port
+
+	rules, err := ParseForwardRules([]string{"127.0.0.1:" + strconv.Itoa(port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestSSHClient(t, WithForwardRules(rules))
+
+	tests := []struct {
+		name    string
+		address string
+		allowed bool
+	}{
+		{name: "allowed", address: backend.Addr().String(), allowed: true},
+		{name: "other port", address: net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1))},
+		{name: "other host", address: net.JoinHostPort("localhost", strconv.Itoa(port))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := client.Dial("tcp", tt.address)
+			if !tt.allowed {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("forwarding to %s succeeded", tt.address)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("forwarding to %s = %v", tt.address, err)
+			}
+
+			defer conn.Close()
+
+			data, err := io.ReadAll(conn)
+			if err != nil || string(data) != "hello" {
+				t.Errorf("read %q, %v, want %q", data, err, "hello")
+			}
+		})
+	}
+}
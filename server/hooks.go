@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionEvent is an event of the session lifecycle, notified to the session
+// events function and passed to the hooks.
+type SessionEvent string
+
+// Events of the session lifecycle.
+const (
+	SessionStart SessionEvent = "start"
+	SessionEnd   SessionEvent = "end"
+)
+
+// sessionHooks are the executables run when a session starts and ends.
+type sessionHooks struct {
+	onStart string
+	onEnd   string
+	timeout time.Duration
+}
+
+// hookEnv returns the environment passing the session metadata to the hooks.
+func (s *Server) hookEnv(info SessionInfo) []string {
+	_, authData, deviceName := s.device()
+
+	env := []string{
+		"SHELLHUB_SESSION_ID=" + info.ID,
+		"SHELLHUB_SESSION_REMOTE_ADDR=" + info.RemoteAddr,
+		"SHELLHUB_SESSION_STARTED_AT=" + info.StartedAt.UTC().Format(time.RFC3339),
+		"SHELLHUB_SESSION_BYTES_RECEIVED=" + strconv.FormatInt(info.BytesReceived, 10),
+		"SHELLHUB_SESSION_BYTES_SENT=" + strconv.FormatInt(info.BytesSent, 10),
+		"SHELLHUB_TENANT_ID=" + s.tenantID,
+		"SHELLHUB_DEVICE_NAME=" + deviceName,
+	}
+
+	if authData != nil {
+		env = append(env, "SHELLHUB_NAMESPACE="+authData.Namespace)
+	}
+
+	return env
+}
+
+// runHook runs the hook executable, when set, with the session metadata in its
+// environment, killing it after the hook timeout. A failing hook is only
+// logged, never affecting the session.
+func (s *Server) runHook(event SessionEvent, path string, info SessionInfo) {
+	if path == "" {
+		return
+	}
+
+	ctx := context.Background()
+	if s.hooks.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.hooks.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, path) // nolint:gosec
+	cmd.Env = append(os.Environ(), "SHELLHUB_SESSION_EVENT="+string(event))
+	cmd.Env = append(cmd.Env, s.hookEnv(info)...)
+
+	logger := s.logger().WithFields(log.Fields{
+		"id":    info.ID,
+		"event": event,
+		"hook":  path,
+	})
+
+	// The output is discarded, as waiting for it would block on the children
+	// the hook may leave running in background.
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		logger.WithError(err).Warn("Session hook failed")
+
+		return
+	}
+
+	logger.Debug("Session hook run")
+}
+
+// sessionEvent notifies the session event, when a function is set.
+func (s *Server) sessionEvent(event SessionEvent, info SessionInfo) {
+	if s.onSessionEvent != nil {
+		s.onSessionEvent(event, info)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// serveTestSession serves the session identified by id, in the single-user
+// mode, with the options, returning a client connected to it and a channel
+// closed once the session is over.
+func serveTestSession(t *testing.T, id string, opts ...Opt) (*gossh.Client, <-chan struct{}) {
+	t.Helper()
+
+	privateKey := filepath.Join(t.TempDir(), "shellhub.key")
+	if err := keygen.GeneratePrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(nil, nil, privateKey, 30, testPasswordHash, opts...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		peer, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.ServeSession(id, peer) // nolint:errcheck
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, chans, reqs, err := gossh.NewClientConn(conn, listener.Addr().String(), &gossh.ClientConfig{
+		User:            "root",
+		Auth:            []gossh.AuthMethod{gossh.Password(testPassword)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), // nolint:gosec
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := gossh.NewClient(c, chans, reqs)
+
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	return client, done
+}
+
+func TestSessionEvents(t *testing.T) {
+	type notified struct {
+		event SessionEvent
+		info  SessionInfo
+	}
+
+	events := make(chan notified, 2)
+
+	client, done := serveTestSession(t, "session", WithSessionEvents(func(event SessionEvent, info SessionInfo) {
+		events <- notified{event, info}
+	}))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+	<-done
+	close(events)
+
+	var got []notified
+	for e := range events {
+		got = append(got, e)
+	}
+
+	if len(got) != 2 || got[0].event != SessionStart || got[1].event != SessionEnd {
+		t.Fatalf("events = %v, want %q then %q", got, SessionStart, SessionEnd)
+	}
+
+	for _, e := range got {
+		if e.info.ID != "session" {
+			t.Errorf("%s event session id = %q, want %q", e.event, e.info.ID, "session")
+		}
+	}
+
+	if end := got[1].info; end.BytesReceived == 0 || end.BytesSent == 0 {
+		t.Errorf("end event bytes = %d received, %d sent, want both counted", end.BytesReceived, end.BytesSent)
+	}
+}
+
+// writeTestHook writes an executable shell script running the script, returning
+// its path.
+func writeTestHook(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hook")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o700); err != nil { // nolint:gosec
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRunHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	hook := writeTestHook(t, "env > "+out+"\n")
+
+	s := NewServer(nil, nil, "", 0, "", WithTenantID("tenant"), WithSessionHooks(hook, hook, time.Second))
+
+	startedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	s.runHook(SessionEnd, hook, SessionInfo{
+		ID:            "session",
+		StartedAt:     startedAt,
+		RemoteAddr:    "192.0.2.1:4242",
+		BytesReceived: 10,
+		BytesSent:     20,
+	})
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := strings.Split(string(data), "\n")
+
+	for _, want := range []string{
+		"SHELLHUB_SESSION_EVENT=end",
+		"SHELLHUB_SESSION_ID=session",
+		"SHELLHUB_SESSION_REMOTE_ADDR=192.0.2.1:4242",
+		"SHELLHUB_SESSION_STARTED_AT=2023-01-02T03:04:05Z",
+		"SHELLHUB_SESSION_BYTES_RECEIVED=10",
+		"SHELLHUB_SESSION_BYTES_SENT=20",
+		"SHELLHUB_TENANT_ID=tenant",
+	} {
+		if !contains(env, want) {
+			t.Errorf("hook environment misses %q", want)
+		}
+	}
+}
+
+func TestRunHookFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{name: "failing", script: "exit 1\n"},
+		{name: "timed out", script: "sleep 10\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := writeTestHook(t, tt.script)
+
+			s := NewServer(nil, nil, "", 0, "", WithSessionHooks(hook, hook, 100*time.Millisecond))
+
+			start := time.Now()
+			s.runHook(SessionStart, hook, SessionInfo{ID: "session"})
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("runHook() returned after %v, want the hook killed after its timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestSessionHooks(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeTestHook(t, "echo $SHELLHUB_SESSION_ID >> "+filepath.Join(dir, "$SHELLHUB_SESSION_EVENT")+"\n")
+
+	client, done := serveTestSession(t, "session", WithSessionHooks(hook, hook, time.Second))
+
+	// The session starts once the start hook ran.
+	if data, err := os.ReadFile(filepath.Join(dir, "start")); err != nil || string(data) != "session\n" {
+		t.Errorf("start hook output = %q, %v, want %q", data, err, "session\n")
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+	<-done
+
+	if data, err := os.ReadFile(filepath.Join(dir, "end")); err != nil || string(data) != "session\n" {
+		t.Errorf("end hook output = %q, %v, want %q", data, err, "session\n")
+	}
+}
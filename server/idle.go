@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// idleConn wraps a connection, calling onIdle when no bytes are read or written
+// for the timeout duration.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+
+	// done is set once the connection went idle or was closed, after which
+	// the timer is neither reset nor calls onIdle.
+	done int32
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration, onIdle func()) *idleConn {
+	c := &idleConn{
+		Conn:    conn,
+		timeout: timeout,
+	}
+
+	c.timer = time.AfterFunc(timeout, func() {
+		if atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+			onIdle()
+		}
+	})
+
+	return c
+}
+
+// active resets the timer, unless it already fired or was stopped, since
+// resetting it would call onIdle again.
+func (c *idleConn) active() {
+	if atomic.LoadInt32(&c.done) == 0 && c.timer.Stop() {
+		c.timer.Reset(c.timeout)
+	}
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.active()
+	}
+
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.active()
+	}
+
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	atomic.StoreInt32(&c.done, 1)
+	c.timer.Stop()
+
+	return c.Conn.Close()
+}
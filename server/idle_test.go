@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleConn(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		use   func(c *idleConn)
+		calls int32
+	}{
+		{
+			name:  "idle",
+			use:   func(c *idleConn) { time.Sleep(3 * timeout) },
+			calls: 1,
+		},
+		{
+			name: "active",
+			use: func(c *idleConn) {
+				for i := 0; i < 8; i++ {
+					time.Sleep(timeout / 4)
+					c.Write([]byte("data")) // nolint:errcheck
+				}
+			},
+			calls: 0,
+		},
+		{
+			name: "active after idle",
+			use: func(c *idleConn) {
+				time.Sleep(2 * timeout)
+				c.Write([]byte("data")) // nolint:errcheck
+				time.Sleep(2 * timeout)
+			},
+			calls: 1,
+		},
+		{
+			name: "closed",
+			use: func(c *idleConn) {
+				c.Close()
+				time.Sleep(2 * timeout)
+			},
+			calls: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, peer := net.Pipe()
+			defer peer.Close()
+
+			go io.Copy(io.Discard, peer) // nolint:errcheck
+
+			var calls int32
+
+			c := newIdleConn(conn, timeout, func() {
+				atomic.AddInt32(&calls, 1)
+			})
+			defer c.Close()
+
+			tt.use(c)
+
+			if got := atomic.LoadInt32(&calls); got != tt.calls {
+				t.Errorf("onIdle called %d times, want %d", got, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+// keepAliveDelay returns the delay until the next keep alive message, the
+// interval randomized by up to the jitter fraction in either direction so the
+// keep alive messages of many devices are spread over time. A jitter of zero
+// keeps the exact interval.
+func keepAliveDelay(interval time.Duration, jitter float64, rng *rand.Rand) time.Duration {
+	if jitter <= 0 || interval <= 0 {
+		return interval
+	}
+
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	// A value in the [-jitter, jitter) range.
+	offset := (rng.Float64()*2 - 1) * jitter
+
+	return interval + time.Duration(offset*float64(interval))
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveDelay(t *testing.T) {
+	const interval = 30 * time.Second
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		jitter   float64
+		min, max time.Duration
+	}{
+		{name: "no jitter", interval: interval, jitter: 0, min: interval, max: interval},
+		{name: "negative jitter", interval: interval, jitter: -0.5, min: interval, max: interval},
+		{name: "no interval", interval: 0, jitter: 0.5, min: 0, max: 0},
+		{name: "jitter", interval: interval, jitter: 0.1, min: 27 * time.Second, max: 33 * time.Second},
+		{name: "full jitter", interval: interval, jitter: 1, min: 0, max: 2 * interval},
+		{name: "jitter above one", interval: interval, jitter: 5, min: 0, max: 2 * interval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1)) // nolint:gosec
+
+			for i := 0; i < 1000; i++ {
+				if delay := keepAliveDelay(tt.interval, tt.jitter, rng); delay < tt.min || delay > tt.max {
+					t.Fatalf("keepAliveDelay(%s, %v) = %s, want between %s and %s", tt.interval, tt.jitter, delay, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepAliveDelaySpread(t *testing.T) {
+	const interval = 30 * time.Second
+
+	rng := rand.New(rand.NewSource(1)) // nolint:gosec
+
+	var shorter, longer int
+
+	for i := 0; i < 1000; i++ {
+		switch delay := keepAliveDelay(interval, 0.5, rng); {
+		case delay < interval:
+			shorter++
+		case delay > interval:
+			longer++
+		}
+	}
+
+	// The delays are spread in either direction of the interval.
+	if shorter < 400 || longer < 400 {
+		t.Errorf("keepAliveDelay spread = %d shorter and %d longer delays, want about half each", shorter, longer)
+	}
+}
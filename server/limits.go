@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidNice         = errors.New("session nice value must be between -20 and 19")
+	ErrInvalidSessionLimit = errors.New("session cpu and memory limits must not be negative")
+	ErrSessionCgroupUnset  = errors.New("session cpu and memory limits require the session cgroup")
+)
+
+// SessionLimits are the limits applied to the shells and commands spawned for
+// the sessions, so they cannot starve the device critical processes.
+type SessionLimits struct {
+	// Nice is the niceness of the processes, zero leaving it unchanged.
+	Nice int
+	// Cgroup is the path of the cgroup v2 the processes are started in,
+	// empty leaving them in the agent one.
+	Cgroup string
+	// CPUMax is the CPU time the processes of all the sessions can use, in
+	// percent of a CPU. Zero means unlimited.
+	CPUMax int
+	// MemoryMax is the memory the processes of all the sessions can use, in
+	// bytes. Zero means unlimited.
+	MemoryMax int64
+}
+
+// CheckSessionLimits checks that the session limits are valid.
+func CheckSessionLimits(limits SessionLimits) error {
+	if limits.Nice < -20 || limits.Nice > 19 {
+		return ErrInvalidNice
+	}
+
+	if limits.CPUMax < 0 || limits.MemoryMax < 0 {
+		return ErrInvalidSessionLimit
+	}
+
+	if (limits.CPUMax > 0 || limits.MemoryMax > 0) && limits.Cgroup == "" {
+		return ErrSessionCgroupUnset
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// cgroupCPUPeriod is the period, in microseconds, of the session cgroup CPU
+// limit.
+const cgroupCPUPeriod = 100000
+
+// setupSessionCgroup creates the session cgroup, when missing, and sets its CPU
+// and memory limits.
+func setupSessionCgroup(limits SessionLimits) error {
+	if limits.Cgroup == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(limits.Cgroup, 0o755); err != nil {
+		return err
+	}
+
+	cpuMax := "max " + strconv.Itoa(cgroupCPUPeriod)
+	if limits.CPUMax > 0 {
+		cpuMax = fmt.Sprintf("%d %d", limits.CPUMax*cgroupCPUPeriod/100, cgroupCPUPeriod)
+	}
+
+	memoryMax := "max"
+	if limits.MemoryMax > 0 {
+		memoryMax = strconv.FormatInt(limits.MemoryMax, 10)
+	}
+
+	if err := os.WriteFile(filepath.Join(limits.Cgroup, "cpu.max"), []byte(cpuMax), 0o644); err != nil { // nolint:gosec
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(limits.Cgroup, "memory.max"), []byte(memoryMax), 0o644) // nolint:gosec
+}
+
+// startWithLimits calls start to start cmd, with the session limits applied to
+// the process before it executes anything: it is cloned straight into the
+// session cgroup, from a thread with the session niceness, which it inherits.
+// The limits failing to be set up are only logged, the session going on
+// without them.
+func (s *Server) startWithLimits(cmd *exec.Cmd, start func() error) error {
+	if s.limits.Cgroup != "" {
+		cgroup, err := os.Open(s.limits.Cgroup)
+		if err != nil {
+			s.logger().WithError(err).WithField("cgroup", s.limits.Cgroup).Warn("Failed to open the session cgroup")
+		} else {
+			defer cgroup.Close()
+
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(cgroup.Fd())
+		}
+	}
+
+	if s.limits.Nice == 0 {
+		return start()
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		// The thread is never unlocked, so that it exits with the goroutine
+		// instead of running other goroutines with the session niceness.
+		runtime.LockOSThread()
+
+		// The niceness is a thread attribute on Linux, the one of the calling
+		// thread being set with the zero ID.
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, s.limits.Nice); err != nil {
+			s.logger().WithError(err).WithField("nice", s.limits.Nice).Warn("Failed to set the session process niceness")
+		}
+
+		done <- start()
+	}()
+
+	return <-done
+}
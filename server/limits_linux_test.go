@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// processNice returns the niceness of the process pid, the 19th field of its
+// stat file.
+func processNice(t *testing.T, pid int) int {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fields following the command name, which may hold spaces, start
+	// with the third one.
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+
+	nice, err := strconv.Atoi(fields[16])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return nice
+}
+
+// processCgroup returns the cgroup v2 path of the process pid.
+func processCgroup(t *testing.T, pid int) string {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// testCgroup creates a cgroup in the cgroup v2 hierarchy, skipping the test
+// when there is none the test can create it in.
+func testCgroup(t *testing.T) (dir, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[2] != "cgroup2" {
+			continue
+		}
+
+		path = "/shellhub-test-" + strconv.Itoa(os.Getpid())
+		dir = filepath.Join(fields[1], path)
+
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Skipf("cannot create a cgroup: %v", err)
+		}
+
+		t.Cleanup(func() {
+			os.Remove(dir)
+		})
+
+		return dir, path
+	}
+
+	t.Skip("no cgroup v2 hierarchy")
+
+	return "", ""
+}
+
+// startSleep starts a sleeping process with the session limits of s.
+func startSleep(t *testing.T, s *Server) *exec.Cmd {
+	t.Helper()
+
+	cmd := exec.Command("sleep", "10")
+	if err := s.startWithLimits(cmd, cmd.Start); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill() // nolint:errcheck
+		cmd.Wait()         // nolint:errcheck
+	})
+
+	return cmd
+}
+
+func TestStartWithLimits(t *testing.T) {
+	// The niceness the processes are started with when it is not changed.
+	agentNice := processNice(t, startSleep(t, &Server{}).Process.Pid)
+
+	tests := []struct {
+		name   string
+		nice   int
+		cgroup bool
+	}{
+		{name: "nice", nice: 15},
+		{name: "lowest priority", nice: 19},
+		{name: "cgroup", cgroup: true},
+		{name: "nice and cgroup", nice: 10, cgroup: true},
+		// The niceness of the previous sessions is not inherited.
+		{name: "no limits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{limits: SessionLimits{Nice: tt.nice}}
+
+			var path string
+			if tt.cgroup {
+				s.limits.Cgroup, path = testCgroup(t)
+			}
+
+			pid := startSleep(t, s).Process.Pid
+
+			want := tt.nice
+			if want == 0 {
+				want = agentNice
+			}
+
+			if nice := processNice(t, pid); nice != want {
+				t.Errorf("process niceness = %d, want %d", nice, want)
+			}
+
+			if tt.cgroup {
+				if got := processCgroup(t, pid); got != path {
+					t.Errorf("process cgroup = %q, want %q", got, path)
+				}
+			}
+		})
+	}
+}
+
+func TestStartWithLimitsInvalidCgroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cgroup  string
+		started bool
+	}{
+		// The cgroup is not opened, so the process starts without it.
+		{name: "missing", cgroup: filepath.Join(t.TempDir(), "missing"), started: true},
+		// The cgroup is opened, but the kernel refuses to clone into it.
+		{name: "not a cgroup", cgroup: t.TempDir()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{limits: SessionLimits{Cgroup: tt.cgroup}}
+
+			cmd := exec.Command("true")
+
+			err := s.startWithLimits(cmd, cmd.Start)
+			if started := err == nil; started != tt.started {
+				t.Fatalf("startWithLimits() = %v, want started %v", err, tt.started)
+			}
+
+			if tt.started {
+				cmd.Wait() // nolint:errcheck
+			}
+		})
+	}
+}
+
+func TestSessionInvalidCgroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(session *gossh.Session) error
+	}{
+		{name: "pty", start: func(session *gossh.Session) error {
+			if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+				return err
+			}
+
+			return session.Shell()
+		}},
+		{name: "shell", start: func(session *gossh.Session) error { return session.Shell() }},
+		{name: "exec", start: func(session *gossh.Session) error { return session.Start("true") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestSSHClient(t, WithSessionLimits(SessionLimits{Cgroup: t.TempDir()}))
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer session.Close()
+
+			if err := tt.start(session); err != nil {
+				t.Fatal(err)
+			}
+
+			if status := exitStatus(t, session.Wait()); status != 1 {
+				t.Errorf("exit status = %d, want 1", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var ErrSessionLimitsUnsupported = errors.New("session limits are only supported on Linux")
+
+func setupSessionCgroup(limits SessionLimits) error {
+	if limits.Cgroup == "" {
+		return nil
+	}
+
+	return ErrSessionLimitsUnsupported
+}
+
+func (s *Server) startWithLimits(cmd *exec.Cmd, start func() error) error {
+	if s.limits.Nice != 0 || s.limits.Cgroup != "" {
+		s.logger().Debug(ErrSessionLimitsUnsupported)
+	}
+
+	return start()
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	gliderssh "github.com/gliderlabs/ssh"
+)
+
+// maxLockoutCooldown is the longest time a client is locked out for, however
+// many times it has been locked out before.
+const maxLockoutCooldown = time.Hour
+
+// contextKeyClient is the context key of the address of the client the session
+// is opened on behalf of, when known.
+const contextKeyClient = "client"
+
+// ClientConn is the connection of a session opened on behalf of the client at
+// Client, as reported by the ShellHub server, used to tell the clients apart
+// as all the sessions are opened by the server.
+type ClientConn struct {
+	net.Conn
+	Client string
+}
+
+// lockout locks out the clients failing the password authentication too many
+// times within a window, for a cooldown doubling on each new lockout.
+type lockout struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*lockoutClient
+}
+
+// lockoutClient is the password authentication record of a client.
+type lockoutClient struct {
+	failures    []time.Time
+	lockouts    int
+	lockedUntil time.Time
+}
+
+func newLockout(threshold int, window, cooldown time.Duration) *lockout {
+	return &lockout{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		clients:   make(map[string]*lockoutClient),
+	}
+}
+
+// locked reports whether the client is locked out, and until when.
+func (l *lockout) locked(client string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[client]
+	if !ok || !clock.Now().Before(c.lockedUntil) {
+		return time.Time{}, false
+	}
+
+	return c.lockedUntil, true
+}
+
+// failure records a failed password authentication of the client, returning
+// the time it is locked out for when it reaches the threshold, zero otherwise.
+func (l *lockout) failure(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := clock.Now()
+
+	l.prune(now)
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &lockoutClient{}
+		l.clients[client] = c
+	}
+
+	failures := c.failures[:0]
+	for _, t := range c.failures {
+		if now.Sub(t) < l.window {
+			failures = append(failures, t)
+		}
+	}
+
+	c.failures = append(failures, now)
+
+	if len(c.failures) < l.threshold {
+		return 0
+	}
+
+	cooldown := l.cooldown << c.lockouts
+	if cooldown > maxLockoutCooldown || cooldown <= 0 {
+		cooldown = maxLockoutCooldown
+	}
+
+	c.failures = nil
+	c.lockouts++
+	c.lockedUntil = now.Add(cooldown)
+
+	return cooldown
+}
+
+// success forgets the failures and lockouts of the client.
+func (l *lockout) success(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.clients, client)
+}
+
+// prune forgets the clients neither locked out nor failing for the longest
+// cooldown, so they start over.
+func (l *lockout) prune(now time.Time) {
+	for client, c := range l.clients {
+		last := c.lockedUntil
+		if n := len(c.failures); n > 0 && c.failures[n-1].After(last) {
+			last = c.failures[n-1]
+		}
+
+		if now.Sub(last) > maxLockoutCooldown {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// passwordClient returns the address of the client authenticating with a
+// password, as reported by the server, and whether it is known. The remote
+// address of the connection is the one of the tunnel, shared by all the
+// clients, so it never identifies a client.
+func passwordClient(ctx gliderssh.Context) (string, bool) {
+	client, ok := ctx.Value(contextKeyClient).(string)
+
+	return client, ok && client != ""
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	gliderssh "github.com/gliderlabs/ssh"
+)
+
+// fakeClock is a clock set by the tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func setFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+
+	fake := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	backend := clock.DefaultBackend
+	clock.DefaultBackend = fake
+
+	t.Cleanup(func() {
+		clock.DefaultBackend = backend
+	})
+
+	return fake
+}
+
+func TestLockout(t *testing.T) {
+	const (
+		threshold = 3
+		window    = time.Minute
+		cooldown  = 10 * time.Second
+	)
+
+	// A step either records a failure after advancing the clock, checking the
+	// cooldown it locks the client out for, or checks whether the client is
+	// locked out.
+	type step struct {
+		advance  time.Duration
+		failure  bool
+		cooldown time.Duration
+		locked   bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "below threshold",
+			steps: []step{
+				{failure: true},
+				{failure: true},
+				{locked: false},
+			},
+		},
+		{
+			name: "threshold within window",
+			steps: []step{
+				{failure: true},
+				{advance: 20 * time.Second, failure: true},
+				{advance: 20 * time.Second, failure: true, cooldown: cooldown},
+				{locked: true},
+			},
+		},
+		{
+			name: "failures outside of window",
+			steps: []step{
+				{failure: true},
+				{advance: 40 * time.Second, failure: true},
+				{advance: 40 * time.Second, failure: true},
+				{locked: false},
+			},
+		},
+		{
+			name: "lock expires",
+			steps: []step{
+				{failure: true},
+				{failure: true},
+				{failure: true, cooldown: cooldown},
+				{advance: cooldown - time.Second, locked: true},
+				{advance: time.Second, locked: false},
+			},
+		},
+		{
+			name: "cooldown doubles",
+			steps: []step{
+				{failure: true},
+				{failure: true},
+				{failure: true, cooldown: cooldown},
+				{advance: cooldown, failure: true},
+				{failure: true},
+				{failure: true, cooldown: 2 * cooldown},
+				{advance: 2 * cooldown, failure: true},
+				{failure: true},
+				{failure: true, cooldown: 4 * cooldown},
+				{advance: 4*cooldown - time.Second, locked: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := setFakeClock(t)
+			l := newLockout(threshold, window, cooldown)
+
+			for i, s := range tt.steps {
+				fake.now = fake.now.Add(s.advance)
+
+				if s.failure {
+					if got := l.failure("client"); got != s.cooldown {
+						t.Fatalf("step %d: failure() = %v, want %v", i, got, s.cooldown)
+					}
+
+					continue
+				}
+
+				if _, locked := l.locked("client"); locked != s.locked {
+					t.Fatalf("step %d: locked() = %v, want %v", i, locked, s.locked)
+				}
+			}
+		})
+	}
+}
+
+func TestLockoutMaxCooldown(t *testing.T) {
+	fake := setFakeClock(t)
+	l := newLockout(1, time.Minute, 40*time.Minute)
+
+	if got := l.failure("client"); got != 40*time.Minute {
+		t.Fatalf("failure() = %v, want %v", got, 40*time.Minute)
+	}
+
+	fake.now = fake.now.Add(40 * time.Minute)
+
+	if got := l.failure("client"); got != maxLockoutCooldown {
+		t.Errorf("failure() = %v, want %v", got, maxLockoutCooldown)
+	}
+}
+
+func TestLockoutSuccess(t *testing.T) {
+	setFakeClock(t)
+	l := newLockout(2, time.Minute, time.Minute)
+
+	l.failure("client")
+	l.success("client")
+
+	if got := l.failure("client"); got != 0 {
+		t.Errorf("failure() after success = %v, want 0", got)
+	}
+
+	// The clients are locked out independently.
+	if got := l.failure("other"); got != 0 {
+		t.Errorf("failure() of another client = %v, want 0", got)
+	}
+
+	if _, locked := l.locked("other"); locked {
+		t.Error("another client is locked out")
+	}
+}
+
+// testContext is the context of a password authentication on behalf of the
+// client, empty when it is unknown.
+type testContext struct {
+	gliderssh.Context
+	client string
+}
+
+func (c *testContext) User() string {
+	return "root"
+}
+
+func (c *testContext) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}
+}
+
+func (c *testContext) Value(key interface{}) interface{} {
+	if key == contextKeyClient && c.client != "" {
+		return c.client
+	}
+
+	return nil
+}
+
+func TestPasswordHandlerLockout(t *testing.T) {
+	// The hash of "secret".
+	const hash = "$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."
+
+	tests := []struct {
+		name   string
+		client string
+		locked bool
+	}{
+		{name: "known client", client: "203.0.113.1", locked: true},
+		{name: "unknown client", client: "", locked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFakeClock(t)
+
+			s := NewServer(nil, nil, "", 0, hash, WithPasswordLockout(2, time.Minute, time.Minute))
+			ctx := &testContext{client: tt.client}
+
+			for i := 0; i < 2; i++ {
+				if s.passwordHandler(ctx, "wrong") {
+					t.Fatal("wrong password accepted")
+				}
+			}
+
+			if got := s.passwordHandler(ctx, "secret"); got == tt.locked {
+				t.Errorf("password accepted after the failures = %v, want %v", got, !tt.locked)
+			}
+
+			// Another client is never locked out by the failures of the
+			// first one, nor by the ones of the unknown clients.
+			other := &testContext{client: "203.0.113.2"}
+			if !s.passwordHandler(other, "secret") {
+				t.Error("password of another client refused")
+			}
+		})
+	}
+}
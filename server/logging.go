@@ -0,0 +1,30 @@
+package server
+
+import (
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// LogFields returns the fields identifying the tenant and namespace of the
+// device, added to the logs of the connection and session lifecycle so they
+// can be told apart on multi-tenant servers. The namespace is only known once
+// the device is authorized.
+func LogFields(tenantID string, authData *models.DeviceAuthResponse) log.Fields {
+	fields := log.Fields{
+		"tenant_id": tenantID,
+	}
+
+	if authData != nil {
+		fields["namespace"] = authData.Namespace
+	}
+
+	return fields
+}
+
+// logger returns the logger of the session lifecycle, carrying the tenant and
+// namespace fields.
+func (s *Server) logger() *log.Entry {
+	_, authData, _ := s.device()
+
+	return log.WithFields(LogFields(s.tenantID, authData))
+}
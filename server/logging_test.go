@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+func TestLogFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		authData  *models.DeviceAuthResponse
+		namespace interface{}
+	}{
+		{name: "unauthorized", authData: nil, namespace: nil},
+		{name: "authorized", authData: &models.DeviceAuthResponse{Namespace: "namespace"}, namespace: "namespace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := LogFields("tenant", tt.authData)
+
+			if fields["tenant_id"] != "tenant" || fields["namespace"] != tt.namespace {
+				t.Errorf("LogFields() = %v, want the tenant id tenant and the namespace %v", fields, tt.namespace)
+			}
+		})
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	s := NewServer(nil, nil, "", 0, "", WithTenantID("tenant"))
+
+	if data := s.logger().Data; data["tenant_id"] != "tenant" || data["namespace"] != nil {
+		t.Errorf("logger() fields = %v before the authorization, want only the tenant id", data)
+	}
+
+	// The namespace is known once the device is authorized.
+	s.SetAPI(nil, &models.DeviceAuthResponse{Namespace: "namespace"})
+
+	if data := s.logger().Data; data["tenant_id"] != "tenant" || data["namespace"] != "namespace" {
+		t.Errorf("logger() fields = %v after the authorization, want the tenant id and the namespace", data)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/ratelimit"
+)
+
+// Opt configures optional behavior of the Server.
+type Opt func(*Server)
+
+// WithMaxSessions limits the number of concurrent sessions. Zero means unlimited.
+func WithMaxSessions(max int) Opt {
+	return func(s *Server) {
+		s.maxSessions = max
+	}
+}
+
+// WithIdleTimeout closes the sessions with no bytes transferred for the timeout
+// duration. Zero disables it.
+func WithIdleTimeout(timeout time.Duration) Opt {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithMaxSessionDuration closes the sessions lasting longer than max, whether
+// they are idle or not. Zero disables it.
+func WithMaxSessionDuration(max time.Duration) Opt {
+	return func(s *Server) {
+		s.maxSessionDuration = max
+	}
+}
+
+// WithDeadlines sets the maximum time a read or a write on a session
+// connection can block before the session is closed. Zero disables it.
+func WithDeadlines(readTimeout, writeTimeout time.Duration) Opt {
+	return func(s *Server) {
+		s.readTimeout = readTimeout
+		s.writeTimeout = writeTimeout
+	}
+}
+
+// WithSFTPRoot confines the SFTP sessions to the root directory. Empty means
+// the whole file system is served.
+func WithSFTPRoot(root string) Opt {
+	return func(s *Server) {
+		s.sftpRoot = root
+	}
+}
+
+// WithLoginBanner sets the function returning the banner written to the
+// interactive sessions before the shell starts.
+func WithLoginBanner(banner func() string) Opt {
+	return func(s *Server) {
+		s.loginBanner = banner
+	}
+}
+
+// WithPasswordLockout locks out, for the cooldown, the clients failing the
+// password authentication threshold times within the window, the cooldown
+// doubling on each new lockout of the client, up to an hour. A zero threshold
+// disables it.
+func WithPasswordLockout(threshold int, window, cooldown time.Duration) Opt {
+	return func(s *Server) {
+		if threshold > 0 {
+			s.lockout = newLockout(threshold, window, cooldown)
+		}
+	}
+}
+
+// WithSessionLimits sets the limits applied to the shells and commands spawned
+// for the sessions.
+func WithSessionLimits(limits SessionLimits) Opt {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithExecTimeout kills the commands executed without a shell still running
+// after the timeout. Zero disables the timeout.
+func WithExecTimeout(timeout time.Duration) Opt {
+	return func(s *Server) {
+		s.execTimeout = timeout
+	}
+}
+
+// WithSFTPDisabled rejects the SFTP subsystem requests, so no SFTP server
+// process is ever started, leaving the other sessions unaffected.
+func WithSFTPDisabled(disabled bool) Opt {
+	return func(s *Server) {
+		s.sftpDisabled = disabled
+	}
+}
+
+// WithSFTPReadOnly rejects the SFTP requests modifying the file system.
+func WithSFTPReadOnly(readOnly bool) Opt {
+	return func(s *Server) {
+		s.sftpReadOnly = readOnly
+	}
+}
+
+// WithSFTPTuning sets how many SFTP requests are handled concurrently and the
+// maximum size of the data of a request, to speed up the transfers over high
+// latency links. Zero keeps the defaults of the SFTP server.
+func WithSFTPTuning(maxInFlight, packetSize int) Opt {
+	return func(s *Server) {
+		s.sftpMaxInFlight = maxInFlight
+		s.sftpPacketSize = packetSize
+	}
+}
+
+// WithSFTPCredential runs the SFTP server process as the user and group of the
+// credential instead of the ones of the session user. Nil means the session
+// user.
+func WithSFTPCredential(credential *Credential) Opt {
+	return func(s *Server) {
+		s.sftpCredential = credential
+	}
+}
+
+// WithSessionRate limits the number of sessions started per minute, allowing
+// them all at once. Zero means unlimited.
+func WithSessionRate(perMinute int) Opt {
+	return func(s *Server) {
+		s.sessionRate = nil
+
+		if perMinute > 0 {
+			s.sessionRate = ratelimit.NewRate(perMinute, time.Minute)
+		}
+	}
+}
+
+// WithBandwidthLimiter limits the throughput of each session with the limiter
+// returned by limiter, which may be shared between the sessions.
+func WithBandwidthLimiter(limiter func() *ratelimit.Limiter) Opt {
+	return func(s *Server) {
+		s.limiter = limiter
+	}
+}
+
+// WithForwardRules limits the destinations of the local port forwarding to the
+// ones permitted by the rules. An empty list permits any destination.
+func WithForwardRules(rules []ForwardRule) Opt {
+	return func(s *Server) {
+		s.forwardRules = rules
+	}
+}
+
+// WithRemoteForwarding enables the remote port forwarding, letting the users
+// listen on a port of the device tunneled back to them.
+func WithRemoteForwarding(enabled bool) Opt {
+	return func(s *Server) {
+		s.remoteForwarding = enabled
+	}
+}
+
+// WithSessionRecording records the PTY sessions in dir, keeping each recording
+// under maxSize bytes and removing the ones older than retention. Zero disables
+// the respective limit.
+func WithSessionRecording(dir string, maxSize int64, retention time.Duration) Opt {
+	return func(s *Server) {
+		s.recordingDir = dir
+		s.recordingMaxSize = maxSize
+		s.recordingRetention = retention
+	}
+}
+
+// WithAcceptEnv sets the patterns, as accepted by path.Match, of the
+// environment variables the clients can set for their sessions. The other
+// variables are ignored.
+func WithAcceptEnv(patterns []string) Opt {
+	return func(s *Server) {
+		s.acceptEnv = patterns
+	}
+}
+
+// WithExtraPassword allows the users to authenticate with the password hashed
+// as hash, besides the other authentication methods.
+func WithExtraPassword(hash string) Opt {
+	return func(s *Server) {
+		s.extraPassword = hash
+	}
+}
+
+// WithKeepAliveJitter randomizes the interval between the keep alive messages
+// by up to the jitter fraction of it, in either direction. Zero keeps the exact
+// interval.
+func WithKeepAliveJitter(jitter float64) Opt {
+	return func(s *Server) {
+		s.keepAliveJitter = jitter
+	}
+}
+
+// WithAllowedCommands restricts the commands the clients can execute without
+// a shell to the ones matching the patterns, where "*" matches any sequence of
+// characters and "?" any single one. The interactive sessions are not
+// affected. No patterns allow any command.
+func WithAllowedCommands(patterns []string) Opt {
+	return func(s *Server) {
+		s.allowedCommands = nil
+
+		for _, pattern := range patterns {
+			if strings.TrimSpace(pattern) == "" {
+				continue
+			}
+
+			s.allowedCommands = append(s.allowedCommands, compileCommandPattern(pattern))
+		}
+	}
+}
+
+// WithSingleUserShell sets the program, as its path followed by its arguments,
+// started for the interactive sessions in single-user mode instead of the user
+// shell.
+func WithSingleUserShell(shell []string) Opt {
+	return func(s *Server) {
+		s.singleUserShell = shell
+	}
+}
+
+// WithTenantID sets the tenant id added to the logs of the session lifecycle.
+func WithTenantID(tenantID string) Opt {
+	return func(s *Server) {
+		s.tenantID = tenantID
+	}
+}
+
+// WithAlgorithms restricts the key exchange algorithms, ciphers and MACs
+// negotiated with the clients to the ones of algorithms.
+func WithAlgorithms(algorithms Algorithms) Opt {
+	return func(s *Server) {
+		s.algorithms = algorithms
+	}
+}
+
+// WithSessionHooks sets the executables run when a session starts and ends,
+// with the session metadata in their environment, killing them after the
+// timeout. Empty paths disable the hooks and zero the timeout.
+func WithSessionHooks(onStart, onEnd string, timeout time.Duration) Opt {
+	return func(s *Server) {
+		s.hooks = sessionHooks{onStart: onStart, onEnd: onEnd, timeout: timeout}
+	}
+}
+
+// WithPrivateKeyPassphrase sets the passphrase decrypting the private key used
+// as host key. Empty means the key is not encrypted.
+func WithPrivateKeyPassphrase(passphrase []byte) Opt {
+	return func(s *Server) {
+		s.privateKeyPassphrase = passphrase
+	}
+}
+
+// WithSessionEvents sets the function notified when a session starts and ends,
+// with the SessionStart and SessionEnd events. It must not block.
+func WithSessionEvents(notify func(event SessionEvent, info SessionInfo)) Opt {
+	return func(s *Server) {
+		s.onSessionEvent = notify
+	}
+}
@@ -44,17 +44,34 @@ func openPty(c *exec.Cmd) (*os.File, *os.File, error) {
 	return ptmx, tty, err
 }
 
-func startPty(c *exec.Cmd, out io.ReadWriter, winCh <-chan ssh.Window) (*os.File, error) {
+// resizePty applies the window size changes received from winCh to the pty
+// master f, through the TIOCSWINSZ ioctl, until winCh is closed. The first
+// value received is the initial size requested with the pty. resized, when not
+// nil, is called after each change.
+func resizePty(f *os.File, winCh <-chan ssh.Window, resized func(ssh.Window)) {
+	for win := range winCh {
+		if err := pty.Setsize(f, &pty.Winsize{Rows: uint16(win.Height), Cols: uint16(win.Width)}); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"rows": win.Height,
+				"cols": win.Width,
+			}).Warn("Failed to resize pty")
+
+			continue
+		}
+
+		if resized != nil {
+			resized(win)
+		}
+	}
+}
+
+func startPty(c *exec.Cmd, out io.ReadWriter, winCh <-chan ssh.Window, resized func(ssh.Window)) (*os.File, error) {
 	f, tty, err := openPty(c)
 	if err != nil {
 		return nil, err
 	}
 
-	go func() {
-		for win := range winCh {
-			_ = pty.Setsize(f, &pty.Winsize{uint16(win.Height), uint16(win.Width), 0, 0})
-		}
-	}()
+	go resizePty(f, winCh, resized)
 
 	go func() {
 		_, err := io.Copy(out, f)
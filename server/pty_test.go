@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestResizePty(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+
+	defer ptmx.Close()
+	defer tty.Close()
+
+	winCh := make(chan ssh.Window, 2)
+	winCh <- ssh.Window{Width: 80, Height: 24}
+	winCh <- ssh.Window{Width: 132, Height: 43}
+	close(winCh)
+
+	var resized []ssh.Window
+
+	resizePty(ptmx, winCh, func(win ssh.Window) {
+		resized = append(resized, win)
+	})
+
+	rows, cols, err := pty.Getsize(tty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows != 43 || cols != 132 {
+		t.Errorf("pty size = %dx%d, want 132x43", cols, rows)
+	}
+
+	if len(resized) != 2 || resized[0].Width != 80 || resized[1].Width != 132 {
+		t.Errorf("resized with %v, want each window size in turn", resized)
+	}
+}
+
+func TestResizePtyWithoutCallback(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+
+	defer ptmx.Close()
+	defer tty.Close()
+
+	winCh := make(chan ssh.Window, 1)
+	winCh <- ssh.Window{Width: 100, Height: 30}
+	close(winCh)
+
+	resizePty(ptmx, winCh, nil)
+
+	if rows, cols, err := pty.Getsize(tty); err != nil || rows != 30 || cols != 100 {
+		t.Errorf("pty size = %dx%d, %v, want 100x30", cols, rows, err)
+	}
+}
+
+func TestSessionWindowChange(t *testing.T) {
+	client := newTestSSHClient(t)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	session.Stdout = &out
+
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.WindowChange(43, 132); err != nil {
+		t.Fatal(err)
+	}
+
+	// The window change is applied in background, before the shell reads
+	// the command.
+	time.Sleep(200 * time.Millisecond)
+
+	io.WriteString(stdin, "stty size; exit\n") // nolint:errcheck
+
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "43 132") {
+		t.Errorf("output = %q, want the changed size 43 132", out.String())
+	}
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/iocount"
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// recordingExt is the extension of the session recordings, which are
+	// written in the asciinema v2 format.
+	recordingExt = ".cast"
+
+	// contextKeyTunnelSessionID is the context key of the id the session has
+	// in the server sessions.
+	contextKeyTunnelSessionID = "tunnel_session_id"
+)
+
+// sessionConn is the connection of a session, carrying its id and client
+// address to the SSH connection context, and counting the bytes transferred
+// over it.
+type sessionConn struct {
+	net.Conn
+	id       string
+	counters *iocount.Counters
+	client   string
+}
+
+// sessionCounters returns the byte counters of a session connection, or nil
+// when conn is not one.
+func sessionCounters(conn net.Conn) *iocount.Counters {
+	if c, ok := conn.(*sessionConn); ok {
+		return c.counters
+	}
+
+	return nil
+}
+
+// recordingHeader is the first line of an asciinema v2 recording.
+type recordingHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder records the input and output of a PTY session.
+type recorder struct {
+	id      string
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	size    int64
+	maxSize int64
+	closed  bool
+}
+
+// newRecorder creates the recording of the session identified by id in dir,
+// numbering the file when the session has many recordings. Events are dropped
+// once the file reaches maxSize bytes, unless it is zero.
+func newRecorder(dir, id string, width, height int, term string, maxSize int64) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(dir, id+recordingExt)
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	for i := 1; errors.Is(err, os.ErrExist); i++ {
+		name = filepath.Join(dir, fmt.Sprintf("%s.%d%s", id, i, recordingExt))
+		file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recorder{
+		id:      id,
+		file:    file,
+		w:       bufio.NewWriter(file),
+		start:   time.Now(),
+		maxSize: maxSize,
+	}
+
+	if err := r.writeLine(recordingHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Env:       map[string]string{"TERM": term},
+	}); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// writeLine writes v as a JSON line, unless the recording would exceed the
+// maximum size. The header is always written.
+func (r *recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		return nil
+	}
+
+	n, err := r.w.Write(line)
+	r.size += int64(n)
+
+	return err
+}
+
+// record writes an event of kind, "i" for input, "o" for output and "r" for a
+// resize, with data.
+func (r *recorder) record(kind string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+
+	if err := r.writeLine([]interface{}{elapsed, kind, string(data)}); err != nil {
+		log.WithError(err).WithField("file", r.file.Name()).Warn("Failed to write session recording")
+	}
+}
+
+// resize records a window size change as a resize event. It does nothing on a
+// nil recorder.
+func (r *recorder) resize(win gliderssh.Window) {
+	if r == nil {
+		return
+	}
+
+	r.record("r", []byte(fmt.Sprintf("%dx%d", win.Width, win.Height)))
+}
+
+// Close flushes and closes the recording. It can be called many times, and on
+// a nil recorder.
+func (r *recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+
+		return err
+	}
+
+	return r.file.Close()
+}
+
+// recordedStream records the data read from and written to a session.
+type recordedStream struct {
+	io.ReadWriter
+	recorder *recorder
+}
+
+func (s *recordedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriter.Read(p)
+	if n > 0 {
+		s.recorder.record("i", p[:n])
+	}
+
+	return n, err
+}
+
+func (s *recordedStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if n > 0 {
+		s.recorder.record("o", p[:n])
+	}
+
+	return n, err
+}
+
+// stream returns rw recording its data, or rw itself on a nil recorder.
+func (r *recorder) stream(rw io.ReadWriter) io.ReadWriter {
+	if r == nil {
+		return rw
+	}
+
+	return &recordedStream{rw, r}
+}
+
+// startRecording starts recording the PTY session, when the session recording
+// is enabled. It returns nil when it is disabled or fails.
+func (s *Server) startRecording(session gliderssh.Session, sspty gliderssh.Pty) *recorder {
+	if s.recordingDir == "" {
+		return nil
+	}
+
+	id, ok := session.Context().Value(contextKeyTunnelSessionID).(string)
+	if !ok {
+		id, _ = session.Context().Value(gliderssh.ContextKeySessionID).(string)
+	}
+
+	s.pruneRecordings()
+
+	rec, err := newRecorder(s.recordingDir, id, sspty.Window.Width, sspty.Window.Height, sspty.Term, s.recordingMaxSize)
+	if err != nil {
+		log.WithError(err).WithField("id", id).Warn("Failed to start session recording")
+
+		return nil
+	}
+
+	s.mu.Lock()
+	s.recorders[id] = append(s.recorders[id], rec)
+	s.mu.Unlock()
+
+	return rec
+}
+
+// stopRecording closes a recording started by startRecording.
+func (s *Server) stopRecording(rec *recorder) {
+	if rec == nil {
+		return
+	}
+
+	id := rec.id
+
+	s.mu.Lock()
+
+	recorders := s.recorders[id][:0]
+	for _, r := range s.recorders[id] {
+		if r != rec {
+			recorders = append(recorders, r)
+		}
+	}
+
+	if len(recorders) == 0 {
+		delete(s.recorders, id)
+	} else {
+		s.recorders[id] = recorders
+	}
+
+	s.mu.Unlock()
+
+	if err := rec.Close(); err != nil {
+		log.WithError(err).WithField("id", id).Warn("Failed to close session recording")
+	}
+}
+
+// closeRecordings closes the recordings of the session identified by id.
+func (s *Server) closeRecordings(id string) {
+	s.mu.Lock()
+	recorders := s.recorders[id]
+	delete(s.recorders, id)
+	s.mu.Unlock()
+
+	for _, rec := range recorders {
+		if err := rec.Close(); err != nil {
+			log.WithError(err).WithField("id", id).Warn("Failed to close session recording")
+		}
+	}
+}
+
+// pruneRecordings removes the recordings older than the retention period.
+func (s *Server) pruneRecordings() {
+	if s.recordingRetention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(s.recordingDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordingExt) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < s.recordingRetention {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.recordingDir, entry.Name())); err != nil {
+			log.WithError(err).WithField("file", entry.Name()).Warn("Failed to remove expired session recording")
+		}
+	}
+}
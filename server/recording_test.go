@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// readRecording returns the header and the events of a recording.
+func readRecording(t *testing.T, path string) (recordingHeader, [][]interface{}) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var header recordingHeader
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil {
+		t.Fatalf("recording %s has no header", path)
+	}
+
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("recording %s has an invalid event %s", path, scanner.Bytes())
+		}
+
+		events = append(events, event)
+	}
+
+	return header, events
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+
+	rec, err := newRecorder(dir, "session", 80, 24, "xterm", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rw bytes.Buffer
+	rw.WriteString("ls\n")
+
+	stream := rec.stream(&rw)
+	stream.Read(make([]byte, 3))     // nolint:errcheck
+	stream.Write([]byte("file\r\n")) // nolint:errcheck
+	rec.resize(gliderssh.Window{Width: 100, Height: 40})
+
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The events after the recording is closed are dropped.
+	rec.record("o", []byte("late"))
+
+	if err := rec.Close(); err != nil {
+		t.Errorf("second Close() error = %v, want none", err)
+	}
+
+	header, events := readRecording(t, filepath.Join(dir, "session"+recordingExt))
+
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Env["TERM"] != "xterm" {
+		t.Errorf("header = %+v, want version 2, 80x24 and TERM xterm", header)
+	}
+
+	want := [][2]string{{"i", "ls\n"}, {"o", "file\r\n"}, {"r", "100x40"}}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+
+	for i, event := range events {
+		if event[1] != want[i][0] || event[2] != want[i][1] {
+			t.Errorf("event %d = %v, want %v", i, event, want[i])
+		}
+	}
+}
+
+func TestRecorderNumbering(t *testing.T) {
+	dir := t.TempDir()
+
+	for i := 0; i < 3; i++ {
+		rec, err := newRecorder(dir, "session", 80, 24, "xterm", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec.Close()
+	}
+
+	for _, name := range []string{"session.cast", "session.1.cast", "session.2.cast"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("recording %s: %v", name, err)
+		}
+	}
+}
+
+func TestRecorderMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	rec, err := newRecorder(dir, "session", 80, 24, "xterm", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		rec.record("o", []byte("output"))
+	}
+
+	rec.Close()
+
+	path := filepath.Join(dir, "session"+recordingExt)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size() > 200 {
+		t.Errorf("recording size = %d, want at most 200", fi.Size())
+	}
+
+	if _, events := readRecording(t, path); len(events) == 0 || len(events) == 100 {
+		t.Errorf("recording has %d events, want some dropped", len(events))
+	}
+}
+
+func TestPruneRecordings(t *testing.T) {
+	dir := t.TempDir()
+
+	old := time.Now().Add(-48 * time.Hour)
+
+	files := map[string]bool{
+		"expired.cast": false,
+		"recent.cast":  true,
+		"expired.txt":  true,
+	}
+
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.HasPrefix(name, "expired") {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	s := NewServer(nil, nil, "", 0, "", WithSessionRecording(dir, 0, 24*time.Hour))
+	s.pruneRecordings()
+
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestSessionRecording(t *testing.T) {
+	dir := t.TempDir()
+
+	client := newTestSSHClient(t, WithSessionRecording(dir, 0, 0))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A PTY session runs the shell, fed with the commands.
+	session.Stdin = strings.NewReader("echo recorded\nexit\n")
+
+	var out bytes.Buffer
+	session.Stdout = &out
+
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "recorded") {
+		t.Fatalf("output = %q, want the command output", out.String())
+	}
+
+	recordings, err := filepath.Glob(filepath.Join(dir, "*"+recordingExt))
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("recordings = %v, %v, want one", recordings, err)
+	}
+
+	_, events := readRecording(t, recordings[0])
+
+	var output strings.Builder
+	for _, event := range events {
+		if event[1] == "o" {
+			output.WriteString(event[2].(string))
+		}
+	}
+
+	if !strings.Contains(output.String(), "recorded") {
+		t.Errorf("recorded output = %q, want the command output", output.String())
+	}
+}
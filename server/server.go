@@ -6,21 +6,29 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
 	"os/user"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 
-	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/iocount"
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
 	"github.com/brycedjohnson/shellhub-agent/pkg/osauth"
+	"github.com/brycedjohnson/shellhub-agent/pkg/ratelimit"
 	"github.com/brycedjohnson/shellhub-agent/server/command"
 	"github.com/brycedjohnson/shellhub-agent/server/utmp"
-	"github.com/brycedjohnson/shellhub-agent/pkg/api/client"
-	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	gliderssh "github.com/gliderlabs/ssh"
 	log "github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -31,6 +39,12 @@ const (
 	SFTPSubsystemName = "sftp"
 )
 
+var (
+	ErrMaxSessionsReached  = errors.New("maximum number of sessions reached")
+	ErrSessionRateExceeded = errors.New("too many new sessions, try again later")
+	ErrMaintenanceMode     = errors.New("device is under maintenance, try again later")
+)
+
 type sshConn struct {
 	net.Conn
 	closeCallback func(string)
@@ -47,32 +61,81 @@ func (c *sshConn) Close() error {
 
 type Server struct {
 	sshd               *gliderssh.Server
+	deviceMu           sync.RWMutex // guards api, authData and deviceName
 	api                client.Client
 	authData           *models.DeviceAuthResponse
+	tenantID           string
 	cmds               map[string]*exec.Cmd
-	Sessions           map[string]net.Conn
+	sessions           map[string]*session
+	sessionsMu         sync.RWMutex
+	maxSessions        int
+	sessionRate        *ratelimit.Limiter
+	maintenance        bool
+	transferred        iocount.Counters
+	idleTimeout        time.Duration
+	maxSessionDuration time.Duration
+	execTimeout        time.Duration
+	loginBanner        func() string
+	lockout            *lockout
+	limits             SessionLimits
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	sftpRoot           string
+	sftpDisabled       bool
+	sftpReadOnly       bool
+	sftpMaxInFlight    int
+	sftpPacketSize     int
+	sftpCredential     *Credential
+	limiter            func() *ratelimit.Limiter
+	forwardRules       []ForwardRule
+	remoteForwarding   bool
+	recordingDir       string
+	recordingMaxSize   int64
+	recordingRetention time.Duration
+	recorders          map[string][]*recorder
+	acceptEnv          []string
+	allowedCommands    []*regexp.Regexp
 	deviceName         string
 	mu                 sync.Mutex
 	keepAliveInterval  int
+	keepAliveJitter    float64
 	singleUserPassword string
+	singleUserShell    []string
+	extraPassword      string
+	algorithms         Algorithms
+	hooks              sessionHooks
+	onSessionEvent     func(event SessionEvent, info SessionInfo)
+
+	privateKeyPassphrase []byte
 }
 
 // NewServer creates a new server SSH agent server.
-func NewServer(api client.Client, authData *models.DeviceAuthResponse, privateKey string, keepAliveInterval int, singleUserPassword string) *Server {
+func NewServer(api client.Client, authData *models.DeviceAuthResponse, privateKey string, keepAliveInterval int, singleUserPassword string, opts ...Opt) *Server {
 	server := &Server{
-		api:               api,
-		authData:          authData,
-		cmds:              make(map[string]*exec.Cmd),
-		Sessions:          make(map[string]net.Conn),
-		keepAliveInterval: keepAliveInterval,
+		api:                api,
+		authData:           authData,
+		cmds:               make(map[string]*exec.Cmd),
+		sessions:           make(map[string]*session),
+		recorders:          make(map[string][]*recorder),
+		keepAliveInterval:  keepAliveInterval,
+		singleUserPassword: singleUserPassword,
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	if err := setupSessionCgroup(server.limits); err != nil {
+		log.WithError(err).WithField("cgroup", server.limits.Cgroup).Warn("Failed to set up the session cgroup")
 	}
 
 	server.sshd = &gliderssh.Server{
 		PublicKeyHandler:       server.publicKeyHandler,
 		Handler:                server.sessionHandler,
 		SessionRequestCallback: server.sessionRequestCallback,
-		RequestHandlers:        gliderssh.DefaultRequestHandlers,
+		RequestHandlers:        requestHandlers(),
 		SubsystemHandlers: map[string]gliderssh.SubsystemHandler{
+			SFTPSubsystemName: server.sftpSubsystemHandler,
 		},
 		ConnCallback: func(ctx gliderssh.Context, conn net.Conn) net.Conn {
 			closeCallback := func(id string) {
@@ -85,14 +148,18 @@ func NewServer(api client.Client, authData *models.DeviceAuthResponse, privateKe
 				}
 			}
 
+			if c, ok := conn.(*sessionConn); ok {
+				ctx.SetValue(contextKeyTunnelSessionID, c.id)
+				ctx.SetValue(contextKeyClient, c.client)
+			}
+
 			return &sshConn{conn, closeCallback, ctx}
 		},
-		LocalPortForwardingCallback: func(ctx gliderssh.Context, destinationHost string, destinationPort uint32) bool {
-			return true
-		},
-		ReversePortForwardingCallback: func(ctx gliderssh.Context, destinationHost string, destinationPort uint32) bool {
-			return false
+		ServerConfigCallback: func(ctx gliderssh.Context) *gossh.ServerConfig {
+			return server.algorithms.serverConfig()
 		},
+		LocalPortForwardingCallback:   server.localPortForwardingCallback,
+		ReversePortForwardingCallback: server.reversePortForwardingCallback,
 		ChannelHandlers: map[string]gliderssh.ChannelHandler{
 			"session":       gliderssh.DefaultSessionHandler,
 			"direct-tcpip":  gliderssh.DirectTCPIPHandler,
@@ -100,37 +167,71 @@ func NewServer(api client.Client, authData *models.DeviceAuthResponse, privateKe
 		},
 	}
 
-	err := server.sshd.SetOption(gliderssh.HostKeyFile(privateKey))
-	if err != nil {
+	// The password authentication is only offered when there is a password to
+	// check against.
+	if server.singleUserPassword != "" || server.extraPassword != "" {
+		server.sshd.PasswordHandler = server.passwordHandler
+	}
+
+	if err := server.setHostKey(privateKey); err != nil {
 		log.Warn(err)
 	}
 
 	return server
 }
 
-// startKeepAlive sends a keep alive message to the server every in keepAliveInterval seconds.
+// setHostKey sets the private key as host key, decrypting it with the private
+// key passphrase when set.
+func (s *Server) setHostKey(privateKey string) error {
+	if len(s.privateKeyPassphrase) == 0 {
+		return s.sshd.SetOption(gliderssh.HostKeyFile(privateKey))
+	}
+
+	key, err := keygen.ReadPrivateKey(privateKey, s.privateKeyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.sshd.AddHostKey(signer)
+
+	return nil
+}
+
+// startKeepAlive sends a keep alive message to the server every in keepAliveInterval seconds,
+// randomized by the keep alive jitter.
 func (s *Server) startKeepAliveLoop(session gliderssh.Session) {
+	s.mu.Lock()
 	interval := time.Duration(s.keepAliveInterval) * time.Second
+	s.mu.Unlock()
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano())) // nolint:gosec
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(keepAliveDelay(interval, s.keepAliveJitter, rng))
+	defer timer.Stop()
 
 	log.WithFields(log.Fields{
 		"interval": interval,
+		"jitter":   s.keepAliveJitter,
 	}).Debug("Starting keep alive loop")
 
 loop:
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			if conn, ok := session.Context().Value(gliderssh.ContextKeyConn).(gossh.Conn); ok {
 				if _, _, err := conn.SendRequest("keepalive", false, nil); err != nil {
 					log.Error(err)
 				}
 			}
+
+			timer.Reset(keepAliveDelay(interval, s.keepAliveJitter, rng))
 		case <-session.Context().Done():
 			log.Debug("Stopping keep alive loop after session closed")
-			ticker.Stop()
 
 			break loop
 		}
@@ -140,7 +241,7 @@ loop:
 func (s *Server) sessionHandler(session gliderssh.Session) {
 	sspty, winCh, isPty := session.Pty()
 
-	log.Info("New session request")
+	s.logger().Info("New session request")
 
 	go s.startKeepAliveLoop(session)
 	requestType := session.Context().Value("request_type").(string) //nolint:forcetypeassert
@@ -148,10 +249,30 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 	switch {
 	case isPty:
 		scmd := newShellCmd(s, session.User(), sspty.Term)
+		scmd.Env = append(scmd.Env, s.acceptedEnv(session.Environ())...)
+
+		if s.loginBanner != nil {
+			if _, err := io.WriteString(session, s.loginBanner()); err != nil {
+				log.Warn(err)
+			}
+		}
+
+		rec := s.startRecording(session, sspty)
+		defer s.stopRecording(rec)
+
+		var pts *os.File
 
-		pts, err := startPty(scmd, session, winCh)
+		err := s.startWithLimits(scmd, func() (err error) {
+			pts, err = startPty(scmd, rec.stream(session), winCh, rec.resize)
+
+			return err
+		})
 		if err != nil {
-			log.Warn(err)
+			log.WithError(err).WithField("user", session.User()).Warn("Failed to start the session shell")
+
+			_ = session.Exit(1)
+
+			return
 		}
 
 		u := osauth.LookupUser(session.User())
@@ -163,7 +284,7 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 
 		remoteAddr := session.RemoteAddr()
 
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":       session.User(),
 			"pty":        pts.Name(),
 			"ispty":      isPty,
@@ -185,7 +306,7 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			log.Warn(err)
 		}
 
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":       session.User(),
 			"pty":        pts.Name(),
 			"remoteaddr": remoteAddr,
@@ -195,6 +316,7 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 		utmp.UtmpEndSession(ut)
 	case !isPty && requestType == "shell":
 		cmd := newShellCmd(s, session.User(), "")
+		cmd.Env = append(cmd.Env, s.acceptedEnv(session.Environ())...)
 
 		stdout, _ := cmd.StdoutPipe()
 		stdin, _ := cmd.StdinPipe()
@@ -205,12 +327,7 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			return
 		}
 
-		go func() {
-			serverConn.Wait()  // nolint:errcheck
-			cmd.Process.Kill() // nolint:errcheck
-		}()
-
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":        session.User(),
 			"ispty":       isPty,
 			"remoteaddr":  session.RemoteAddr(),
@@ -218,11 +335,19 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			"Raw command": session.RawCommand(),
 		}).Info("Command started")
 
-		err := cmd.Start()
-		if err != nil {
-			log.Warn(err)
+		if err := s.startWithLimits(cmd, cmd.Start); err != nil {
+			log.WithError(err).WithField("user", session.User()).Warn("Failed to start the session shell")
+
+			_ = session.Exit(1)
+
+			return
 		}
 
+		go func() {
+			serverConn.Wait()  // nolint:errcheck
+			cmd.Process.Kill() // nolint:errcheck
+		}()
+
 		go func() {
 			if _, err := io.Copy(stdin, session); err != nil {
 				fmt.Println(err) //nolint:forbidigo
@@ -238,14 +363,13 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			}
 		}()
 
-		err = cmd.Wait()
-		if err != nil {
+		if err := cmd.Wait(); err != nil {
 			log.Warn(err)
 		}
 
 		session.Exit(cmd.ProcessState.ExitCode()) //nolint:errcheck
 
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":        session.User(),
 			"remoteaddr":  session.RemoteAddr(),
 			"localaddr":   session.LocalAddr(),
@@ -259,13 +383,14 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 				"localaddr": session.LocalAddr(),
 			}).Error("None command was received")
 
-			log.Info("Session ended")
+			s.logger().Info("Session ended")
 			_ = session.Exit(1)
 
 			return
 		}
 
-		cmd := command.NewCmd(u, "", "", s.deviceName, session.Command()...)
+		cmd := command.NewCmd(u, "", "", s.currentDeviceName(), session.Command()...)
+		cmd.Env = append(cmd.Env, s.acceptedEnv(session.Environ())...)
 
 		stdout, _ := cmd.StdoutPipe()
 		stdin, _ := cmd.StdinPipe()
@@ -276,16 +401,23 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			return
 		}
 
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":        session.User(),
 			"remoteaddr":  session.RemoteAddr(),
 			"localaddr":   session.LocalAddr(),
 			"Raw command": session.RawCommand(),
 		}).Info("Command started")
 
-		err := cmd.Start()
-		if err != nil {
-			log.Warn(err)
+		if s.execTimeout > 0 {
+			setProcessGroup(cmd)
+		}
+
+		if err := s.startWithLimits(cmd, cmd.Start); err != nil {
+			log.WithError(err).WithField("user", session.User()).Warn("Failed to start the session command")
+
+			_ = session.Exit(1)
+
+			return
 		}
 
 		go func() {
@@ -293,6 +425,8 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 			cmd.Process.Kill() // nolint:errcheck
 		}()
 
+		timer := s.startExecTimer(session, cmd)
+
 		go func() {
 			if _, err := io.Copy(stdin, session); err != nil {
 				fmt.Println(err) //nolint:forbidigo
@@ -315,14 +449,17 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 
 		wg.Wait()
 
-		err = cmd.Wait()
-		if err != nil {
+		if err := cmd.Wait(); err != nil {
 			log.Warn(err)
 		}
 
-		session.Exit(cmd.ProcessState.ExitCode()) //nolint:errcheck
+		if timer.stop() {
+			session.Exit(ExecTimeoutExitStatus) //nolint:errcheck
+		} else {
+			session.Exit(cmd.ProcessState.ExitCode()) //nolint:errcheck
+		}
 
-		log.WithFields(log.Fields{
+		s.logger().WithFields(log.Fields{
 			"user":        session.User(),
 			"remoteaddr":  session.RemoteAddr(),
 			"localaddr":   session.LocalAddr(),
@@ -331,6 +468,72 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 	}
 }
 
+// passwordHandler authenticates the users with the single-user password, in the
+// single-user mode, or with the extra password, when it is set.
+//
+// With the password lockout enabled, the clients failing too many times are
+// refused without checking the password until their lockout expires. The
+// clients whose address is not reported by the server are never locked out, as
+// they can't be told apart.
+func (s *Server) passwordHandler(ctx gliderssh.Context, password string) bool {
+	client, known := passwordClient(ctx)
+
+	lockout := s.lockout
+	if !known {
+		lockout = nil
+	}
+
+	if lockout != nil {
+		if until, locked := lockout.locked(client); locked {
+			log.WithFields(log.Fields{
+				"user":         ctx.User(),
+				"client":       client,
+				"locked_until": until,
+			}).Warn("Refused password authentication from a locked out client")
+
+			return false
+		}
+	}
+
+	if s.singleUserPassword != "" && osauth.VerifyPasswordHash(s.singleUserPassword, password) {
+		if lockout != nil {
+			lockout.success(client)
+		}
+
+		return true
+	}
+
+	if s.extraPassword != "" && osauth.VerifyPasswordHash(s.extraPassword, password) {
+		log.WithFields(log.Fields{
+			"user":       ctx.User(),
+			"remoteaddr": ctx.RemoteAddr(),
+		}).Info("User authenticated with the extra password")
+
+		if lockout != nil {
+			lockout.success(client)
+		}
+
+		return true
+	}
+
+	log.WithFields(log.Fields{
+		"user":       ctx.User(),
+		"remoteaddr": ctx.RemoteAddr(),
+		"client":     client,
+	}).Warn("Failed password authentication")
+
+	if lockout != nil {
+		if cooldown := lockout.failure(client); cooldown > 0 {
+			log.WithFields(log.Fields{
+				"client":   client,
+				"cooldown": cooldown,
+			}).Warn("Client locked out after too many failed password authentications")
+		}
+	}
+
+	return false
+}
+
 func (s *Server) publicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
 	if osauth.LookupUser(ctx.User()) == nil {
 		return false
@@ -341,9 +544,14 @@ func (s *Server) publicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey
 		Namespace string
 	}
 
+	api, authData, deviceName := s.device()
+	if api == nil || authData == nil {
+		return false
+	}
+
 	sig := &Signature{
 		Username:  ctx.User(),
-		Namespace: s.deviceName,
+		Namespace: deviceName,
 	}
 
 	sigBytes, err := json.Marshal(sig)
@@ -353,10 +561,10 @@ func (s *Server) publicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey
 
 	sigHash := sha256.Sum256(sigBytes)
 
-	res, err := s.api.AuthPublicKey(&models.PublicKeyAuthRequest{
+	res, err := api.AuthPublicKey(&models.PublicKeyAuthRequest{
 		Fingerprint: gossh.FingerprintLegacyMD5(key),
 		Data:        string(sigBytes),
-	}, s.authData.Token)
+	}, authData.Token)
 	if err != nil {
 		return false
 	}
@@ -386,6 +594,19 @@ func (s *Server) publicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey
 }
 
 func (s *Server) sessionRequestCallback(session gliderssh.Session, requestType string) bool {
+	if requestType == "exec" && !s.checkExecRequest(session) {
+		return false
+	}
+
+	if requestType == "subsystem" && session.Subsystem() == SFTPSubsystemName && s.sftpDisabled {
+		s.logger().WithFields(log.Fields{
+			"user":       session.User(),
+			"remoteaddr": session.RemoteAddr(),
+		}).Warn("Rejected SFTP session as SFTP is disabled")
+
+		return false
+	}
+
 	session.Context().SetValue("request_type", requestType)
 
 	return true
@@ -393,7 +614,7 @@ func (s *Server) sessionRequestCallback(session gliderssh.Session, requestType s
 
 // sftpSubsystemHandler handles the SFTP subsystem session.
 func (s *Server) sftpSubsystemHandler(session gliderssh.Session) {
-	log.WithFields(log.Fields{
+	s.logger().WithFields(log.Fields{
 		"user": session.Context().User(),
 	}).Info("SFTP session started")
 	defer session.Close()
@@ -409,14 +630,35 @@ func (s *Server) sftpSubsystemHandler(session gliderssh.Session) {
 		return
 	}
 
+	credential := &Credential{UID: looked.Uid, GID: looked.Gid}
+	if s.sftpCredential != nil {
+		credential = s.sftpCredential
+	}
+
 	home := fmt.Sprintf("HOME=%s", looked.HomeDir)
-	gid := fmt.Sprintf("GID=%s", looked.Gid)
-	uid := fmt.Sprintf("UID=%s", looked.Uid)
+	gid := fmt.Sprintf("GID=%s", credential.GID)
+	uid := fmt.Sprintf("UID=%s", credential.UID)
 
 	cmd.Env = append(cmd.Env, home)
 	cmd.Env = append(cmd.Env, gid)
 	cmd.Env = append(cmd.Env, uid)
 
+	if s.sftpRoot != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SFTP_ROOT=%s", s.sftpRoot))
+	}
+
+	if s.sftpReadOnly {
+		cmd.Env = append(cmd.Env, "SFTP_READ_ONLY=true")
+	}
+
+	if s.sftpMaxInFlight > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SFTP_MAX_IN_FLIGHT=%d", s.sftpMaxInFlight))
+	}
+
+	if s.sftpPacketSize > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("SFTP_PACKET_SIZE=%d", s.sftpPacketSize))
+	}
+
 	input, err := cmd.StdinPipe()
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
@@ -493,19 +735,15 @@ func (s *Server) sftpSubsystemHandler(session gliderssh.Session) {
 	go func() {
 		log.WithFields(log.Fields{
 			"user": session.Context().User(),
-		}).Trace("copying error to session")
+		}).Trace("logging error from command")
 
-		if _, err := io.Copy(session, erro); err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"user": session.Context().User(),
-			}).Error("Failed to copy stderr to session")
-
-			return
-		}
+		// The SFTP server logs to its standard error, which cannot be sent to
+		// the session without corrupting the protocol stream.
+		logSFTPServer(session.Context().User(), erro)
 
 		log.WithFields(log.Fields{
 			"user": session.Context().User(),
-		}).Trace("closing error to session ends")
+		}).Trace("logging error from command ends")
 	}()
 
 	go s.startKeepAliveLoop(session)
@@ -518,7 +756,7 @@ func (s *Server) sftpSubsystemHandler(session gliderssh.Session) {
 		return
 	}
 
-	log.WithFields(log.Fields{
+	s.logger().WithFields(log.Fields{
 		"user": session.Context().User(),
 	}).Info("SFTP session closed")
 }
@@ -527,14 +765,280 @@ func (s *Server) HandleConn(conn net.Conn) {
 	s.sshd.HandleConn(conn)
 }
 
+// SetKeepAliveInterval sets the interval, in seconds, of the keep alive
+// messages sent to the sessions started afterwards.
+func (s *Server) SetKeepAliveInterval(interval int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keepAliveInterval = interval
+}
+
 func (s *Server) SetDeviceName(name string) {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
 	s.deviceName = name
 }
 
+// SetAPI sets the client and the device authorization used to authenticate the
+// users, as they change when the agent switches to another server.
+func (s *Server) SetAPI(api client.Client, authData *models.DeviceAuthResponse) {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	s.api = api
+	s.authData = authData
+}
+
+// device returns the client, the device authorization and the device name
+// currently in use.
+func (s *Server) device() (client.Client, *models.DeviceAuthResponse, string) {
+	s.deviceMu.RLock()
+	defer s.deviceMu.RUnlock()
+
+	return s.api, s.authData, s.deviceName
+}
+
+// currentDeviceName returns the device name currently in use.
+func (s *Server) currentDeviceName() string {
+	_, _, name := s.device()
+
+	return name
+}
+
+// SessionInfo describes an active session.
+type SessionInfo struct {
+	ID            string    `json:"id"`
+	StartedAt     time.Time `json:"started_at"`
+	RemoteAddr    string    `json:"remote_addr"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+}
+
+// session is a registered session connection.
+type session struct {
+	conn     net.Conn
+	info     SessionInfo
+	counters *iocount.Counters
+}
+
+// AddSession registers the connection of a session identified by id. It fails
+// with ErrMaintenanceMode in maintenance mode, with ErrSessionRateExceeded when
+// too many sessions were started in the last minute, and with
+// ErrMaxSessionsReached when the maximum number of sessions is reached.
+func (s *Server) AddSession(id string, conn net.Conn) error {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if s.maintenance {
+		return ErrMaintenanceMode
+	}
+
+	if s.maxSessions > 0 && len(s.sessions) >= s.maxSessions {
+		return ErrMaxSessionsReached
+	}
+
+	// The rate is checked last, so that the sessions refused for another
+	// reason don't take a token.
+	if s.sessionRate != nil && !s.sessionRate.Allow(1) {
+		return ErrSessionRateExceeded
+	}
+
+	info := SessionInfo{
+		ID:        id,
+		StartedAt: clock.Now(),
+	}
+
+	if addr := conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+
+	s.sessions[id] = &session{conn: conn, info: info, counters: sessionCounters(conn)}
+
+	return nil
+}
+
+// sessionInfo returns the description of the session identified by id, as it
+// was registered.
+func (s *Server) sessionInfo(id string) SessionInfo {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	if session, ok := s.sessions[id]; ok {
+		return session.info
+	}
+
+	return SessionInfo{ID: id}
+}
+
+// SetMaintenance enables or disables the maintenance mode, in which the new
+// sessions are refused while the active ones go on.
+func (s *Server) SetMaintenance(enabled bool) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	s.maintenance = enabled
+}
+
+// Maintenance reports whether the maintenance mode is enabled.
+func (s *Server) Maintenance() bool {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	return s.maintenance
+}
+
+// GetSession returns the connection of the session identified by id.
+func (s *Server) GetSession(id string) (net.Conn, bool) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+
+	return session.conn, true
+}
+
+// ListSessions returns the description of the registered sessions, sorted by
+// their start time.
+func (s *Server) ListSessions() []SessionInfo {
+	s.sessionsMu.RLock()
+
+	sessions := make([]SessionInfo, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		info := session.info
+		if session.counters != nil {
+			info.BytesReceived = session.counters.Read()
+			info.BytesSent = session.counters.Written()
+		}
+
+		sessions = append(sessions, info)
+	}
+
+	s.sessionsMu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+
+	return sessions
+}
+
+// DeleteSession unregisters the session identified by id without closing its connection.
+func (s *Server) DeleteSession(id string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	delete(s.sessions, id)
+}
+
+// ListSessionIDs returns a snapshot of the ids of the registered sessions.
+func (s *Server) ListSessionIDs() []string {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// ServeSession registers conn as the session identified by id and handles it
+// until the connection is closed. When the session cannot be registered, the
+// reason is written to the connection before closing it.
+func (s *Server) ServeSession(id string, conn net.Conn) error {
+	var client string
+	if c, ok := conn.(*ClientConn); ok {
+		client = c.Client
+	}
+
+	if s.limiter != nil {
+		conn = ratelimit.NewConn(conn, s.limiter())
+	}
+
+	if s.readTimeout > 0 || s.writeTimeout > 0 {
+		conn = newDeadlineConn(conn, s.readTimeout, s.writeTimeout)
+	}
+
+	if s.idleTimeout > 0 {
+		conn = newIdleConn(conn, s.idleTimeout, func() {
+			log.WithFields(log.Fields{
+				"id":      id,
+				"timeout": s.idleTimeout,
+			}).Info("Closing idle session")
+
+			s.CloseSession(id)
+		})
+	}
+
+	counters := new(iocount.Counters)
+	conn = &sessionConn{iocount.NewConn(conn, counters, &s.transferred), id, counters, client}
+
+	if err := s.AddSession(id, conn); err != nil {
+		conn.Write([]byte(err.Error() + "\r\n")) // nolint:errcheck
+		conn.Close()
+
+		return err
+	}
+
+	defer s.DeleteSession(id)
+
+	if s.maxSessionDuration > 0 {
+		timer := time.AfterFunc(s.maxSessionDuration, func() {
+			log.WithFields(log.Fields{
+				"id":           id,
+				"max_duration": s.maxSessionDuration,
+			}).Info("Closing session, maximum duration reached")
+
+			s.CloseSession(id)
+		})
+
+		defer timer.Stop()
+	}
+
+	info := s.sessionInfo(id)
+
+	// The session waits for the start hook, which may prepare the device for
+	// it, e.g. mounting a file system.
+	s.sessionEvent(SessionStart, info)
+	s.runHook(SessionStart, s.hooks.onStart, info)
+
+	s.HandleConn(conn)
+
+	conn.Close()
+
+	s.logger().WithFields(log.Fields{
+		"id":             id,
+		"bytes_received": counters.Read(),
+		"bytes_sent":     counters.Written(),
+	}).Info("Session connection closed")
+
+	info.BytesReceived = counters.Read()
+	info.BytesSent = counters.Written()
+
+	s.sessionEvent(SessionEnd, info)
+	s.runHook(SessionEnd, s.hooks.onEnd, info)
+
+	return nil
+}
+
+// TransferredBytes returns the total number of bytes received from and sent to
+// the clients over all the sessions.
+func (s *Server) TransferredBytes() (received, sent int64) {
+	return s.transferred.Read(), s.transferred.Written()
+}
+
 func (s *Server) CloseSession(id string) {
-	if session, ok := s.Sessions[id]; ok {
+	s.closeRecordings(id)
+
+	if session, ok := s.GetSession(id); ok {
 		session.Close()
-		delete(s.Sessions, id)
+		s.DeleteSession(id)
 	}
 }
 
@@ -555,7 +1059,19 @@ func newShellCmd(s *Server, username, term string) *exec.Cmd {
 		term = "xterm"
 	}
 
-	cmd := command.NewCmd(user, shell, term, s.deviceName, shell, "--login")
+	args := s.shellCommand(shell)
+	cmd := command.NewCmd(user, shell, term, s.currentDeviceName(), args...)
 
 	return cmd
 }
+
+// shellCommand returns the command, as its path followed by its arguments,
+// started for the interactive sessions: the single-user shell, when set in the
+// single-user mode, otherwise the login shell.
+func (s *Server) shellCommand(shell string) []string {
+	if s.singleUserPassword != "" && len(s.singleUserShell) > 0 {
+		return s.singleUserShell
+	}
+
+	return []string{shell, "--login"}
+}
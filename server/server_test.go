@@ -0,0 +1,551 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/keygen"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testPassword is the single-user password of the test servers, whose hash is
+// testPasswordHash.
+const (
+	testPassword     = "secret"
+	testPasswordHash = "$2a$04$abcdefghijklmnopqrstuu2r9OfJnfCsdneAXAGHnS4UpFFP8WIrW"
+)
+
+// newTestSSHClient serves SSH, in the single-user mode, with the options,
+// returning a client connected to the server.
+func newTestSSHClient(t *testing.T, opts ...Opt) *gossh.Client {
+	t.Helper()
+
+	s := NewServer(nil, nil, "", 30, testPasswordHash, opts...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.sshd.Serve(listener) // nolint:errcheck
+
+	client, err := gossh.Dial("tcp", listener.Addr().String(), &gossh.ClientConfig{
+		User:            "root",
+		Auth:            []gossh.AuthMethod{gossh.Password(testPassword)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), // nolint:gosec
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		s.sshd.Close()
+	})
+
+	return client
+}
+
+// exitStatus returns the exit status of a finished session, failing the test
+// when the session did not exit with a status.
+func exitStatus(t *testing.T, err error) int {
+	t.Helper()
+
+	if err == nil {
+		return 0
+	}
+
+	exitErr, ok := err.(*gossh.ExitError)
+	if !ok {
+		t.Fatalf("session error = %v, want an exit status", err)
+	}
+
+	return exitErr.ExitStatus()
+}
+
+func TestSessionRegistryConcurrentAccess(t *testing.T) {
+	s := NewServer(nil, nil, "", 0, "")
+
+	const workers = 16
+	const iterations = 100
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+
+				conn, peer := net.Pipe()
+
+				if err := s.AddSession(id, conn); err != nil {
+					t.Errorf("AddSession(%q) = %v", id, err)
+
+					return
+				}
+
+				if _, ok := s.GetSession(id); !ok {
+					t.Errorf("GetSession(%q) did not find the session", id)
+				}
+
+				s.ListSessionIDs()
+				s.ListSessions()
+
+				s.CloseSession(id)
+				peer.Close()
+
+				if _, ok := s.GetSession(id); ok {
+					t.Errorf("GetSession(%q) found a closed session", id)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	if ids := s.ListSessionIDs(); len(ids) != 0 {
+		t.Errorf("ListSessionIDs() = %v, want none", ids)
+	}
+}
+
+func TestAddSessionMaxSessions(t *testing.T) {
+	s := NewServer(nil, nil, "", 0, "", WithMaxSessions(1))
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	if err := s.AddSession("first", conn); err != nil {
+		t.Fatalf("AddSession(first) = %v", err)
+	}
+
+	if err := s.AddSession("second", conn); err != ErrMaxSessionsReached {
+		t.Fatalf("AddSession(second) = %v, want %v", err, ErrMaxSessionsReached)
+	}
+}
+
+func TestAddSessionRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSessions int
+		ids         []string
+		errs        []error
+	}{
+		{
+			name: "rate exceeded",
+			ids:  []string{"first", "second", "third"},
+			errs: []error{nil, nil, ErrSessionRateExceeded},
+		},
+		{
+			name:        "refused sessions take no token",
+			maxSessions: 1,
+			ids:         []string{"first", "second", "third"},
+			errs:        []error{nil, ErrMaxSessionsReached, ErrMaxSessionsReached},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, nil, "", 0, "", WithSessionRate(2), WithMaxSessions(tt.maxSessions))
+
+			conn, peer := net.Pipe()
+			defer conn.Close()
+			defer peer.Close()
+
+			for i, id := range tt.ids {
+				if err := s.AddSession(id, conn); err != tt.errs[i] {
+					t.Fatalf("AddSession(%s) = %v, want %v", id, err, tt.errs[i])
+				}
+			}
+
+			if tt.maxSessions == 0 {
+				return
+			}
+
+			// Once a session ends, the next one still gets the token left.
+			s.CloseSession(tt.ids[0])
+
+			if err := s.AddSession("last", conn); err != nil {
+				t.Errorf("AddSession(last) = %v, want %v", err, nil)
+			}
+		})
+	}
+}
+
+func TestExtraPassword(t *testing.T) {
+	// extraPasswordHash is the hash of the extra password "extra".
+	const extraPasswordHash = "$1$abcdefgh$U2v8qTg0Rl8c/aYPg4Wmr."
+
+	tests := []struct {
+		name               string
+		singleUserPassword string
+		extraPassword      string
+		password           string
+		ok                 bool
+	}{
+		{name: "single-user password", singleUserPassword: testPasswordHash, extraPassword: extraPasswordHash, password: testPassword, ok: true},
+		{name: "extra password in single-user mode", singleUserPassword: testPasswordHash, extraPassword: extraPasswordHash, password: "extra", ok: true},
+		{name: "extra password in multi-user mode", extraPassword: extraPasswordHash, password: "extra", ok: true},
+		{name: "wrong password", singleUserPassword: testPasswordHash, extraPassword: extraPasswordHash, password: "wrong"},
+		{name: "extra password unset", singleUserPassword: testPasswordHash, password: "extra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, nil, "", 30, tt.singleUserPassword, WithExtraPassword(tt.extraPassword))
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go s.sshd.Serve(listener) // nolint:errcheck
+			defer s.sshd.Close()
+
+			client, err := gossh.Dial("tcp", listener.Addr().String(), &gossh.ClientConfig{
+				User:            "root",
+				Auth:            []gossh.AuthMethod{gossh.Password(tt.password)},
+				HostKeyCallback: gossh.InsecureIgnoreHostKey(), // nolint:gosec
+			})
+			if (err == nil) != tt.ok {
+				t.Fatalf("password authentication error = %v, want success %v", err, tt.ok)
+			}
+
+			if err != nil {
+				return
+			}
+
+			defer client.Close()
+
+			// A command is run before closing the client, as closing it
+			// right after the handshake races with the connection setup.
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := session.Run("true"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	s := NewServer(nil, nil, "", 0, "")
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	if err := s.AddSession("before", conn); err != nil {
+		t.Fatalf("AddSession(before) = %v", err)
+	}
+
+	s.SetMaintenance(true)
+
+	if !s.Maintenance() {
+		t.Fatal("Maintenance() = false after enabling it")
+	}
+
+	if err := s.AddSession("during", conn); err != ErrMaintenanceMode {
+		t.Fatalf("AddSession(during) = %v, want %v", err, ErrMaintenanceMode)
+	}
+
+	// The sessions started before are kept.
+	if _, ok := s.GetSession("before"); !ok {
+		t.Error("the session started before the maintenance was removed")
+	}
+
+	s.SetMaintenance(false)
+
+	if err := s.AddSession("after", conn); err != nil {
+		t.Fatalf("AddSession(after) = %v", err)
+	}
+}
+
+func TestServeSessionRefused(t *testing.T) {
+	s := NewServer(nil, nil, "", 0, "")
+	s.SetMaintenance(true)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeSession("id", conn)
+	}()
+
+	// The client is told why the session was refused.
+	reason, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reason) != ErrMaintenanceMode.Error()+"\r\n" {
+		t.Errorf("refused session read %q, want the maintenance reason", reason)
+	}
+
+	if err := <-done; err != ErrMaintenanceMode {
+		t.Errorf("ServeSession() = %v, want %v", err, ErrMaintenanceMode)
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	menu := []string{"/usr/bin/menu", "--restricted"}
+
+	tests := []struct {
+		name               string
+		singleUserPassword string
+		singleUserShell    []string
+		want               []string
+	}{
+		{name: "login shell", want: []string{"/bin/sh", "--login"}},
+		{name: "single-user login shell", singleUserPassword: testPasswordHash, want: []string{"/bin/sh", "--login"}},
+		{name: "single-user shell", singleUserPassword: testPasswordHash, singleUserShell: menu, want: menu},
+		{name: "multi-user mode", singleUserShell: menu, want: []string{"/bin/sh", "--login"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, nil, "", 0, tt.singleUserPassword, WithSingleUserShell(tt.singleUserShell))
+
+			if got := s.shellCommand("/bin/sh"); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("shellCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleUserShellSession(t *testing.T) {
+	// The program waits for a line, so its output is read before it exits.
+	client := newTestSSHClient(t, WithSingleUserShell([]string{"/bin/sh", "-c", "echo device menu; read line"}))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(line) != "device menu" {
+		t.Errorf("interactive session output = %q, want the single-user shell output", line)
+	}
+
+	io.WriteString(stdin, "\n") // nolint:errcheck
+
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetHostKey(t *testing.T) {
+	dir := t.TempDir()
+
+	plain := filepath.Join(dir, "plain.key")
+	if err := keygen.GeneratePrivateKey(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := filepath.Join(dir, "encrypted.key")
+	if err := keygen.GenerateEncryptedPrivateKey(encrypted, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		err        error
+	}{
+		{name: "plain", key: plain},
+		{name: "plain with passphrase", key: plain, passphrase: "ignored"},
+		{name: "encrypted", key: encrypted, passphrase: "passphrase"},
+		{name: "encrypted with wrong passphrase", key: encrypted, passphrase: "wrong", err: keygen.ErrWrongPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, nil, "", 0, "", WithPrivateKeyPassphrase([]byte(tt.passphrase)))
+
+			if err := s.setHostKey(tt.key); !errors.Is(err, tt.err) {
+				t.Fatalf("setHostKey() = %v, want %v", err, tt.err)
+			}
+
+			if want := tt.err == nil; (len(s.sshd.HostSigners) == 1) != want {
+				t.Errorf("host signers = %d, want the host key set: %v", len(s.sshd.HostSigners), want)
+			}
+		})
+	}
+}
+
+func TestSFTPDisabled(t *testing.T) {
+	client := newTestSSHClient(t, WithSFTPDisabled(true))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer session.Close()
+
+	if err := session.RequestSubsystem(SFTPSubsystemName); err == nil {
+		t.Error("RequestSubsystem() = nil error, want the SFTP subsystem rejected")
+	}
+
+	// The other sessions are not affected.
+	other, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.Close()
+
+	if out, err := other.Output("echo ok"); err != nil || string(out) != "ok\n" {
+		t.Errorf("Output() = %q, %v, want the command run", out, err)
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    time.Duration
+		closed bool
+	}{
+		{name: "reached", max: 300 * time.Millisecond, closed: true},
+		{name: "unlimited"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, done := serveTestSession(t, "id", WithMaxSessionDuration(tt.max))
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer session.Close()
+
+			// The session is active, which does not keep it open past the
+			// maximum duration.
+			if err := session.Start("sleep 30"); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case <-done:
+				if !tt.closed {
+					t.Error("session closed without a maximum duration")
+				}
+			case <-time.After(2 * time.Second):
+				if tt.closed {
+					t.Error("session still open after its maximum duration")
+				}
+			}
+		})
+	}
+}
+
+func TestLoginBanner(t *testing.T) {
+	client := newTestSSHClient(t,
+		WithSingleUserShell([]string{"/bin/sh", "-c", "echo device menu; read line"}),
+		WithLoginBanner(func() string { return "Authorized use only\r\n" }),
+	)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The banner is written before the shell starts.
+	r := bufio.NewReader(stdout)
+	for _, want := range []string{"Authorized use only", "device menu"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.TrimSpace(line) != want {
+			t.Errorf("interactive session output = %q, want %q", line, want)
+		}
+	}
+
+	io.WriteString(stdin, "\n") // nolint:errcheck
+
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginBannerExec(t *testing.T) {
+	client := newTestSSHClient(t, WithLoginBanner(func() string { return "Authorized use only\r\n" }))
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commands executed without a terminal are left as they are, so their
+	// output can be parsed.
+	out, err := session.Output("echo output")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "output\n" {
+		t.Errorf("command output = %q, want it without the banner", out)
+	}
+}
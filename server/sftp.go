@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewSFTPServer serves the SFTP protocol over the standard input and output. It
+// runs in the child process started by the SFTP subsystem, which sets the user
+// to run as, its home directory and the SFTP root through the environment.
+func NewSFTPServer() {
+	// The agent reads the log entries from the standard error, see logSFTPServer.
+	log.SetFormatter(&log.JSONFormatter{})
+
+	if err := dropPrivileges(os.Getenv("UID"), os.Getenv("GID")); err != nil {
+		log.WithError(err).Error("Failed to drop privileges")
+
+		return
+	}
+
+	opts := []sftp.Opt{
+		sftp.WithReadOnly(os.Getenv("SFTP_READ_ONLY") == "true"),
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("SFTP_MAX_IN_FLIGHT")); err == nil {
+		opts = append(opts, sftp.WithMaxInFlight(n))
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("SFTP_PACKET_SIZE")); err == nil {
+		opts = append(opts, sftp.WithPacketSize(size))
+	}
+
+	if root := os.Getenv("SFTP_ROOT"); root != "" {
+		opts = append(opts, sftp.WithRoot(root))
+	} else if home := os.Getenv("HOME"); home != "" {
+		opts = append(opts, sftp.WithWorkDir(home))
+	}
+
+	server, err := sftp.NewServer(os.Stdin, os.Stdout, opts...)
+	if err != nil {
+		log.WithError(err).Error("Failed to create SFTP server")
+
+		return
+	}
+
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		log.WithError(err).Error("SFTP server stopped")
+	}
+}
+
+// dropPrivileges switches the process to the user and group, when running as
+// root and they are set.
+func dropPrivileges(uid, gid string) error {
+	if os.Geteuid() != 0 || uid == "" || gid == "" {
+		return nil
+	}
+
+	g, err := strconv.Atoi(gid)
+	if err != nil {
+		return err
+	}
+
+	u, err := strconv.Atoi(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Setgroups([]int{g}); err != nil {
+		return err
+	}
+
+	if err := syscall.Setgid(g); err != nil {
+		return err
+	}
+
+	return syscall.Setuid(u)
+}
+
+// logSFTPServer logs the entries written by the SFTP server to r in the agent
+// log, until r is closed.
+func logSFTPServer(user string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := log.Fields{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			log.WithField("user", user).Warn(scanner.Text())
+
+			continue
+		}
+
+		level, err := log.ParseLevel(fmt.Sprint(fields[log.FieldKeyLevel]))
+		if err != nil {
+			level = log.WarnLevel
+		}
+
+		msg := fmt.Sprint(fields[log.FieldKeyMsg])
+
+		delete(fields, log.FieldKeyLevel)
+		delete(fields, log.FieldKeyMsg)
+		delete(fields, log.FieldKeyTime)
+
+		fields["user"] = user
+
+		log.WithFields(fields).Log(level, msg)
+	}
+}
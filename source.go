@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	ErrInvalidSourceAddress  = errors.New("invalid source address")
+	ErrSourceAddressNotFound = errors.New("source address is not assigned to the device")
+	ErrNoInterfaceAddress    = errors.New("network interface has no address")
+)
+
+// sourceAddr returns the local address the connections to the server are made
+// from, either the source address or the first address of the source
+// interface, preferring IPv4. When both are set, the address must belong to
+// the interface. It returns nil when none is set.
+func sourceAddr(opts *ConfigOptions) (*net.TCPAddr, error) {
+	if opts.SourceAddress == "" && opts.SourceInterface == "" {
+		return nil, nil
+	}
+
+	addrs, err := localAddrs(opts.SourceInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SourceAddress == "" {
+		ip := preferredIP(addrs)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoInterfaceAddress, opts.SourceInterface)
+		}
+
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	ip := net.ParseIP(opts.SourceAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSourceAddress, opts.SourceAddress)
+	}
+
+	for _, addr := range addrs {
+		if addr.Equal(ip) {
+			return &net.TCPAddr{IP: ip}, nil
+		}
+	}
+
+	if opts.SourceInterface != "" {
+		return nil, fmt.Errorf("%w: %s on %s", ErrSourceAddressNotFound, opts.SourceAddress, opts.SourceInterface)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrSourceAddressNotFound, opts.SourceAddress)
+}
+
+// localAddrs returns the IP addresses of the network interface, or of all the
+// interfaces when name is empty.
+func localAddrs(name string) ([]net.IP, error) {
+	var (
+		addrs []net.Addr
+		err   error
+	)
+
+	if name == "" {
+		addrs, err = net.InterfaceAddrs()
+	} else {
+		var iface *net.Interface
+
+		iface, err = net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("network interface %s: %w", name, err)
+		}
+
+		addrs, err = iface.Addrs()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+
+	return ips, nil
+}
+
+// preferredIP returns the first IPv4 address, or the first IPv6 one when there
+// is none, skipping the link-local addresses which cannot be used without a
+// zone.
+func preferredIP(ips []net.IP) net.IP {
+	var fallback net.IP
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip
+		}
+
+		if fallback == nil && !ip.IsLinkLocalUnicast() {
+			fallback = ip
+		}
+	}
+
+	return fallback
+}
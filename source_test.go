@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPreferredIP(t *testing.T) {
+	ipv4 := net.ParseIP("192.0.2.1")
+	ipv6 := net.ParseIP("2001:db8::1")
+	linkLocal := net.ParseIP("fe80::1")
+
+	tests := []struct {
+		name string
+		ips  []net.IP
+		want net.IP
+	}{
+		{name: "none", ips: nil, want: nil},
+		{name: "ipv4", ips: []net.IP{ipv4}, want: ipv4},
+		{name: "ipv4 before ipv6", ips: []net.IP{ipv6, ipv4}, want: ipv4},
+		{name: "ipv6", ips: []net.IP{linkLocal, ipv6}, want: ipv6},
+		{name: "link-local only", ips: []net.IP{linkLocal}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preferredIP(tt.ips); !got.Equal(tt.want) {
+				t.Errorf("preferredIP(%v) = %v, want %v", tt.ips, got, tt.want)
+			}
+		})
+	}
+}
+
+// loopbackInterface returns the name of the loopback network interface.
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+
+	t.Skip("no loopback interface")
+
+	return ""
+}
+
+func TestSourceAddr(t *testing.T) {
+	lo := loopbackInterface(t)
+
+	tests := []struct {
+		name    string
+		address string
+		iface   string
+		want    string
+		err     error
+		fail    bool
+	}{
+		{name: "unset"},
+		{name: "address", address: "127.0.0.1", want: "127.0.0.1"},
+		{name: "interface", iface: lo, want: "127.0.0.1"},
+		{name: "address on interface", address: "127.0.0.1", iface: lo, want: "127.0.0.1"},
+		{name: "invalid address", address: "localhost", err: ErrInvalidSourceAddress},
+		{name: "address not assigned", address: "198.51.100.1", err: ErrSourceAddressNotFound},
+		{name: "address on another interface", address: "198.51.100.1", iface: lo, err: ErrSourceAddressNotFound},
+		{name: "unknown interface", iface: "shellhub-missing0", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := sourceAddr(&ConfigOptions{SourceAddress: tt.address, SourceInterface: tt.iface})
+
+			switch {
+			case tt.err != nil || tt.fail:
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("sourceAddr() error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("sourceAddr() error = %v", err)
+			case tt.want == "" && addr != nil:
+				t.Errorf("sourceAddr() = %v, want nil", addr)
+			case tt.want != "" && (addr == nil || addr.IP.String() != tt.want):
+				t.Errorf("sourceAddr() = %v, want %s", addr, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	log "github.com/sirupsen/logrus"
+)
+
+// agentState is the information the agent keeps across restarts.
+type agentState struct {
+	// ServerAddress is the address of the last server the agent connected to.
+	ServerAddress string `json:"server_address"`
+	// ConnectedAt is when the agent last connected to it.
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// readState reads the agent state from the file at path.
+func readState(path string) (*agentState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(agentState)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// writeState writes the agent state to the file at path, replacing it
+// atomically so a crash never leaves it partially written.
+func writeState(path string, state *agentState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// preferredServer returns the index of the server whose address is address,
+// or zero, the first server, when none is.
+func preferredServer(servers []*url.URL, address string) int {
+	for i, server := range servers {
+		if server.String() == address {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// lastServer returns the index of the server the agent last connected to, as
+// recorded in the state file, defaulting to the first server when the state
+// file is missing or invalid.
+func (a *Agent) lastServer() int {
+	if a.opts.StateFile == "" || len(a.servers) < 2 {
+		return 0
+	}
+
+	state, err := readState(a.opts.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("state_file", a.opts.StateFile).Warn("Failed to read the agent state, ignoring it")
+		}
+
+		return 0
+	}
+
+	return preferredServer(a.servers, state.ServerAddress)
+}
+
+// saveState records the server the agent is connected to in the state file,
+// to try it first on the next start.
+func (a *Agent) saveState() {
+	if a.opts.StateFile == "" || len(a.servers) < 2 {
+		return
+	}
+
+	state := &agentState{
+		ServerAddress: a.currentAddress().String(),
+		ConnectedAt:   clock.Now(),
+	}
+
+	if err := writeState(a.opts.StateFile, state); err != nil {
+		log.WithError(err).WithField("state_file", a.opts.StateFile).Warn("Failed to write the agent state")
+	}
+}
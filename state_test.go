@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "agent.json")
+
+	state := &agentState{
+		ServerAddress: "https://cloud.shellhub.io",
+		ConnectedAt:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if err := writeState(path, state); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("state file permissions = %o, want 600", perm)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary state file was left behind: %v", err)
+	}
+
+	got, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ServerAddress != state.ServerAddress || !got.ConnectedAt.Equal(state.ConnectedAt) {
+		t.Errorf("readState() = %+v, want %+v", got, state)
+	}
+}
+
+func TestPreferredServer(t *testing.T) {
+	var servers []*url.URL
+	for _, address := range []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"} {
+		u, err := url.Parse(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		servers = append(servers, u)
+	}
+
+	tests := []struct {
+		address string
+		want    int
+	}{
+		{address: "https://a.example.com", want: 0},
+		{address: "https://c.example.com", want: 2},
+		{address: "https://removed.example.com", want: 0},
+		{address: "", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := preferredServer(servers, tt.address); got != tt.want {
+				t.Errorf("preferredServer(%q) = %d, want %d", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastServer(t *testing.T) {
+	const servers = "https://a.example.com,https://b.example.com"
+
+	tests := []struct {
+		name    string
+		servers string
+		state   string
+		want    string
+	}{
+		{name: "no state", servers: servers, want: "https://a.example.com"},
+		{name: "last server", servers: servers, state: `{"server_address":"https://b.example.com"}`, want: "https://b.example.com"},
+		{name: "removed server", servers: servers, state: `{"server_address":"https://c.example.com"}`, want: "https://a.example.com"},
+		{name: "invalid state", servers: servers, state: `{`, want: "https://a.example.com"},
+		{name: "single server", servers: "https://a.example.com", state: `{"server_address":"https://b.example.com"}`, want: "https://a.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+
+			if tt.state != "" {
+				if err := os.WriteFile(path, []byte(tt.state), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			agent, err := NewAgent(&ConfigOptions{ServerAddress: tt.servers, TenantID: "tenant", StateFile: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := agent.currentAddress().String(); got != tt.want {
+				t.Errorf("server = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "https://a.example.com,https://b.example.com", TenantID: "tenant", StateFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := agent.useServer(1); err != nil {
+		t.Fatal(err)
+	}
+
+	agent.saveState()
+
+	// The agent starts with the saved server afterwards.
+	agent, err = NewAgent(&ConfigOptions{ServerAddress: "https://a.example.com,https://b.example.com", TenantID: "tenant", StateFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := agent.currentAddress().String(); got != "https://b.example.com" {
+		t.Errorf("server = %s after a restart, want https://b.example.com", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdState is a state notified to systemd, as variable assignments such as
+// READY=1, sent as newline-separated KEY=VALUE lines.
+type sdState map[string]string
+
+func (s sdState) String() string {
+	lines := make([]string, 0, len(s))
+	for key, value := range s {
+		lines = append(lines, key+"="+value)
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// systemdWatchdogInterval returns the interval to notify the systemd watchdog
+// at, half of its timeout, or zero when the watchdog is not enabled for the
+// agent process.
+func systemdWatchdogInterval() time.Duration {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdNotify sends the state to the systemd notification socket. It is a no-op
+// when the agent is not run by systemd with a notification socket.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// A leading @ means the socket is in the abstract namespace.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// notifyReady notifies systemd that the agent is ready once it connects to
+// the server for the first time, after the device has been authorized, so a
+// Type=notify service is only considered started when it is reachable. It
+// gives up when ctx is done.
+func (a *Agent) notifyReady(ctx context.Context, notify func(state string) error) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-a.connected:
+	}
+
+	state := sdState{
+		"READY":  "1",
+		"STATUS": "Connected to " + a.currentAddress().String(),
+	}
+
+	if err := notify(state.String()); err != nil {
+		a.logger().WithError(err).Warn("Failed to notify systemd that the agent is ready")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdStateString(t *testing.T) {
+	tests := []struct {
+		name  string
+		state sdState
+		want  string
+	}{
+		{name: "empty", state: sdState{}, want: ""},
+		{name: "single", state: sdState{"WATCHDOG": "1"}, want: "WATCHDOG=1"},
+		{name: "sorted", state: sdState{"STATUS": "Connected", "READY": "1"}, want: "READY=1\nSTATUS=Connected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemdWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name   string
+		socket string
+		pid    string
+		usec   string
+		want   time.Duration
+	}{
+		{name: "no socket", usec: "30000000"},
+		{name: "enabled", socket: "/run/systemd/notify", usec: "30000000", want: 15 * time.Second},
+		{name: "own process", socket: "/run/systemd/notify", pid: strconv.Itoa(os.Getpid()), usec: "30000000", want: 15 * time.Second},
+		{name: "other process", socket: "/run/systemd/notify", pid: "1", usec: "30000000"},
+		{name: "no timeout", socket: "/run/systemd/notify"},
+		{name: "zero timeout", socket: "/run/systemd/notify", usec: "0"},
+		{name: "invalid timeout", socket: "/run/systemd/notify", usec: "30s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NOTIFY_SOCKET", tt.socket)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+
+			if got := systemdWatchdogInterval(); got != tt.want {
+				t.Errorf("systemdWatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// listenTestNotifySocket listens on a notification socket at path, returning
+// the states received on it.
+func listenTestNotifySocket(t *testing.T, path string) <-chan string {
+	t.Helper()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	states := make(chan string, 1)
+
+	go func() {
+		buf := make([]byte, 1024)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		states <- string(buf[:n])
+	}()
+
+	return states
+}
+
+func TestSdNotify(t *testing.T) {
+	// A temporary directory of its own keeps the socket path short enough.
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	tests := []struct {
+		name   string
+		listen string
+		socket string
+	}{
+		{name: "path", listen: filepath.Join(dir, "notify"), socket: filepath.Join(dir, "notify")},
+		{name: "abstract", listen: "\x00shellhub-agent-test-" + strconv.Itoa(os.Getpid()), socket: "@shellhub-agent-test-" + strconv.Itoa(os.Getpid())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			states := listenTestNotifySocket(t, tt.listen)
+
+			t.Setenv("NOTIFY_SOCKET", tt.socket)
+
+			if err := sdNotify("READY=1"); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case state := <-states:
+				if state != "READY=1" {
+					t.Errorf("notified %q, want %q", state, "READY=1")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the notification")
+			}
+		})
+	}
+}
+
+func TestSdNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify() = %v, want nil without a notification socket", err)
+	}
+}
+
+func TestSdNotifyUnreachable(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "notify"))
+
+	if err := sdNotify("READY=1"); err == nil {
+		t.Error("sdNotify() = nil, want an error for a missing socket")
+	}
+}
+
+func TestNotifyReady(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(chan string, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		agent.notifyReady(context.Background(), func(state string) error {
+			states <- state
+
+			return errors.New("connection refused")
+		})
+	}()
+
+	select {
+	case state := <-states:
+		t.Fatalf("notified %q before connecting", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(agent.connected)
+
+	select {
+	case state := <-states:
+		if want := "READY=1\nSTATUS=Connected to http://localhost"; state != want {
+			t.Errorf("notified %q, want %q", state, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+
+	// A failure to notify is only logged.
+	<-done
+}
+
+func TestNotifyReadyCancelled(t *testing.T) {
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: "http://localhost", TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var notified bool
+
+	agent.notifyReady(ctx, func(state string) error {
+		notified = true
+
+		return nil
+	})
+
+	if notified {
+		t.Error("notifyReady() notified systemd without connecting")
+	}
+}
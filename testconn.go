@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeConnectionReport writes to w the device and server information obtained
+// by the initialized agent, as shown by the test-connection command.
+func writeConnectionReport(w io.Writer, a *Agent) {
+	fmt.Fprintf(w, "Server address: %s\n", a.serverAddress)
+	fmt.Fprintf(w, "Server version: %s\n", a.serverInfo.Version)
+	fmt.Fprintf(w, "API endpoint: %s\n", a.serverInfo.Endpoints.API)
+	fmt.Fprintf(w, "SSH endpoint: %s\n", a.serverInfo.Endpoints.SSH)
+	fmt.Fprintf(w, "Device UID: %s\n", a.authData.UID)
+	fmt.Fprintf(w, "Device name: %s\n", a.authData.Name)
+	fmt.Fprintf(w, "Namespace: %s\n", a.authData.Namespace)
+	fmt.Fprintf(w, "SSHID: %s\n", a.sshid())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+)
+
+func TestWriteConnectionReport(t *testing.T) {
+	srv := newFakeServer(t, "first")
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.pubKey = &key.PublicKey
+	agent.Identity = &models.DeviceIdentity{MAC: "00:00:00:00:00:01"}
+
+	if err := agent.connectServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	writeConnectionReport(&out, agent)
+
+	want := strings.Join([]string{
+		"Server address: " + srv.URL,
+		"Server version: 1.0.0",
+		"API endpoint: first:80",
+		"SSH endpoint: first:22",
+		"Device UID: uid",
+		"Device name: device",
+		"Namespace: first",
+		"SSHID: first.device@first",
+	}, "\n") + "\n"
+
+	if out.String() != want {
+		t.Errorf("writeConnectionReport() =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrIncompleteClientCert = errors.New("client certificate and key must be set together")
+	ErrInvalidCACert        = errors.New("no certificate found in the CA certificate file")
+	ErrEmptyCACertDir       = errors.New("no CA certificate file found in the directory")
+	ErrInvalidCertPin       = errors.New("invalid server certificate pin")
+	ErrCertPinMismatch      = errors.New("server certificate does not match any pin")
+	ErrEmptyCertPins        = errors.New("no server certificate pin in the list")
+)
+
+// newTLSConfig returns the TLS configuration used to connect to the server,
+// presenting the client certificate and trusting the CA certificate when they
+// are set, and checking the server certificate against the pins. It returns nil
+// when the default configuration is enough.
+func newTLSConfig(opts *ConfigOptions) (*tls.Config, error) {
+	if opts.ClientCertFile == "" && opts.ClientKeyFile == "" && opts.CACertFile == "" && opts.CACertDir == "" && opts.ServerCertPins == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, ErrIncompleteClientCert
+		}
+
+		// The key pair is checked to match while loading.
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CACertFile != "" || opts.CACertDir != "" {
+		pool, err := caCertPool(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = pool
+	}
+
+	if opts.ServerCertPins != "" {
+		pins, err := parseCertPins(opts.ServerCertPins)
+		if err != nil {
+			return nil, err
+		}
+
+		config.VerifyPeerCertificate = verifyCertPins(pins)
+	}
+
+	return config, nil
+}
+
+// caCertExtensions are the extensions of the CA certificate files loaded from
+// the CA certificate directory.
+var caCertExtensions = map[string]bool{
+	".pem": true,
+	".crt": true,
+	".cer": true,
+}
+
+// caCertPool returns the pool of the CA certificates trusted to sign the server
+// certificate: the ones of the CA certificate file and of the CA certificate
+// directory, added to the system ones when CACertAppend is set. Each file must
+// hold at least one PEM encoded certificate.
+func caCertPool(opts *ConfigOptions) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	if opts.CACertAppend {
+		if system, err := x509.SystemCertPool(); err == nil {
+			pool = system
+		}
+	}
+
+	var files []string
+
+	if opts.CACertFile != "" {
+		files = append(files, opts.CACertFile)
+	}
+
+	if opts.CACertDir != "" {
+		entries, err := os.ReadDir(opts.CACertDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the CA certificates: %w", err)
+		}
+
+		found := false
+
+		for _, entry := range entries {
+			if entry.IsDir() || !caCertExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+				continue
+			}
+
+			files = append(files, filepath.Join(opts.CACertDir, entry.Name()))
+			found = true
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyCACertDir, opts.CACertDir)
+		}
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the CA certificate: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCACert, file)
+		}
+	}
+
+	return pool, nil
+}
+
+// parseCertPins parses a comma-separated list of base64 encoded SHA-256 hashes,
+// failing when it holds none, as the pinning would reject every server.
+func parseCertPins(list string) ([][]byte, error) {
+	var pins [][]byte
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pin, err := base64.StdEncoding.DecodeString(item)
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCertPin, item)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	if len(pins) == 0 {
+		return nil, ErrEmptyCertPins
+	}
+
+	return pins, nil
+}
+
+// verifyCertPins returns a function, to be used as the VerifyPeerCertificate
+// of a TLS configuration, accepting the connection only when the SHA-256 hash
+// of one of the certificates presented by the server, or of its public key,
+// matches a pin. It runs after the usual chain verification.
+func verifyCertPins(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+
+			certHash := sha256.Sum256(cert.Raw)
+			keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+			for _, pin := range pins {
+				if string(pin) == string(certHash[:]) || string(pin) == string(keyHash[:]) {
+					return nil
+				}
+			}
+		}
+
+		return ErrCertPinMismatch
+	}
+}
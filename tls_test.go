@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCertPins(t *testing.T) {
+	pin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	other := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", sha256.Size)))
+
+	tests := []struct {
+		name  string
+		list  string
+		count int
+		err   error
+	}{
+		{name: "single", list: pin, count: 1},
+		{name: "many", list: pin + "," + other, count: 2},
+		{name: "spaces and empty items", list: " " + pin + " ,, " + other + ",", count: 2},
+		{name: "comma", list: ",", err: ErrEmptyCertPins},
+		{name: "spaces", list: " , ", err: ErrEmptyCertPins},
+		{name: "not base64", list: "not a pin", err: ErrInvalidCertPin},
+		{name: "not sha256", list: base64.StdEncoding.EncodeToString([]byte("short")), err: ErrInvalidCertPin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := parseCertPins(tt.list)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseCertPins(%q) error = %v, want %v", tt.list, err, tt.err)
+			}
+
+			if len(pins) != tt.count {
+				t.Errorf("parseCertPins(%q) = %d pins, want %d", tt.list, len(pins), tt.count)
+			}
+
+			// The config validation and the agent startup reject the list too.
+			if _, err := newTLSConfig(&ConfigOptions{ServerCertPins: tt.list}); !errors.Is(err, tt.err) {
+				t.Errorf("newTLSConfig() with the pins %q error = %v, want %v", tt.list, err, tt.err)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost, usable as a CA,
+// and its key to dir, returning their paths and the certificate.
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile, cert
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, _ := writeTestCert(t, dir, "client")
+	_, otherKeyFile, _ := writeTestCert(t, dir, "other")
+
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalidFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  ConfigOptions
+		certs int
+		ca    bool
+		err   error
+		fail  bool
+	}{
+		{name: "default"},
+		{name: "client certificate", opts: ConfigOptions{ClientCertFile: certFile, ClientKeyFile: keyFile}, certs: 1},
+		{name: "ca certificate", opts: ConfigOptions{CACertFile: certFile}, ca: true},
+		{name: "client and ca certificates", opts: ConfigOptions{ClientCertFile: certFile, ClientKeyFile: keyFile, CACertFile: certFile}, certs: 1, ca: true},
+		{name: "certificate without key", opts: ConfigOptions{ClientCertFile: certFile}, err: ErrIncompleteClientCert},
+		{name: "key without certificate", opts: ConfigOptions{ClientKeyFile: keyFile}, err: ErrIncompleteClientCert},
+		{name: "mismatched key", opts: ConfigOptions{ClientCertFile: certFile, ClientKeyFile: otherKeyFile}, fail: true},
+		{name: "missing client certificate", opts: ConfigOptions{ClientCertFile: filepath.Join(dir, "missing.crt"), ClientKeyFile: keyFile}, fail: true},
+		{name: "missing ca certificate", opts: ConfigOptions{CACertFile: filepath.Join(dir, "missing.crt")}, fail: true},
+		{name: "invalid ca certificate", opts: ConfigOptions{CACertFile: invalidFile}, err: ErrInvalidCACert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := newTLSConfig(&tt.opts)
+			if tt.err != nil || tt.fail {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("newTLSConfig() error = %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.certs == 0 && !tt.ca {
+				if config != nil {
+					t.Errorf("newTLSConfig() = %v, want nil for the default configuration", config)
+				}
+
+				return
+			}
+
+			if len(config.Certificates) != tt.certs || (config.RootCAs != nil) != tt.ca {
+				t.Errorf("newTLSConfig() = %d certificates and CA pool %v, want %d and %v", len(config.Certificates), config.RootCAs != nil, tt.certs, tt.ca)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigHandshake(t *testing.T) {
+	dir := t.TempDir()
+
+	serverCert, serverKey, _ := writeTestCert(t, dir, "server")
+	clientCert, clientKey, client := writeTestCert(t, dir, "client")
+
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName) // nolint:errcheck
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		opts ConfigOptions
+		ok   bool
+	}{
+		{name: "client certificate and private ca", opts: ConfigOptions{ClientCertFile: clientCert, ClientKeyFile: clientKey, CACertFile: serverCert}, ok: true},
+		{name: "no client certificate", opts: ConfigOptions{CACertFile: serverCert}},
+		{name: "untrusted server", opts: ConfigOptions{ClientCertFile: clientCert, ClientKeyFile: clientKey, CACertFile: clientCert}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := newTLSConfig(&tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+			res, err := c.Get(server.URL)
+			if (err == nil) != tt.ok {
+				t.Fatalf("GET error = %v, want success %v", err, tt.ok)
+			}
+
+			if err != nil {
+				return
+			}
+
+			defer res.Body.Close()
+
+			body, _ := io.ReadAll(res.Body)
+			if string(body) != "client" {
+				t.Errorf("client certificate seen by the server = %q, want client", body)
+			}
+		})
+	}
+}
+
+func TestCACertPool(t *testing.T) {
+	dir := t.TempDir()
+
+	fileCA, _, fileCert := writeTestCert(t, dir, "file")
+
+	cas := filepath.Join(dir, "cas")
+	if err := os.MkdirAll(filepath.Join(cas, "subdir.crt"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, dirCert := writeTestCert(t, cas, "dir")
+
+	// The files without a certificate extension are ignored.
+	if err := os.WriteFile(filepath.Join(cas, "README"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(cas, "dir.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.MkdirAll(empty, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := filepath.Join(dir, "invalid")
+	if err := os.MkdirAll(invalid, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(invalid, "ca.PEM"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    ConfigOptions
+		trusted []*x509.Certificate
+		err     error
+	}{
+		{name: "directory", opts: ConfigOptions{CACertDir: cas}, trusted: []*x509.Certificate{dirCert}},
+		{name: "file and directory", opts: ConfigOptions{CACertFile: fileCA, CACertDir: cas}, trusted: []*x509.Certificate{fileCert, dirCert}},
+		{name: "directory without certificates", opts: ConfigOptions{CACertDir: empty}, err: ErrEmptyCACertDir},
+		{name: "missing directory", opts: ConfigOptions{CACertDir: filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+		{name: "invalid certificate in the directory", opts: ConfigOptions{CACertDir: invalid}, err: ErrInvalidCACert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := caCertPool(&tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("caCertPool() = %v, want %v", err, tt.err)
+			}
+
+			for _, cert := range tt.trusted {
+				if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err != nil {
+					t.Errorf("certificate %s not trusted: %v", cert.Subject.CommonName, err)
+				}
+			}
+
+			if tt.err == nil && len(tt.trusted) == 1 {
+				if _, err := fileCert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err == nil {
+					t.Errorf("certificate %s trusted, want only the configured ones", fileCert.Subject.CommonName)
+				}
+			}
+		})
+	}
+}
+
+func TestCACertPoolAppend(t *testing.T) {
+	system, err := x509.SystemCertPool()
+	if err != nil || len(system.Subjects()) == 0 { // nolint:staticcheck
+		t.Skip("no system CA certificates")
+	}
+
+	caFile, _, _ := writeTestCert(t, t.TempDir(), "ca")
+
+	tests := []struct {
+		name   string
+		append bool
+		system bool
+	}{
+		{name: "replacing the system ones"},
+		{name: "appended to the system ones", append: true, system: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := caCertPool(&ConfigOptions{CACertFile: caFile, CACertAppend: tt.append})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subjects := len(pool.Subjects()) // nolint:staticcheck
+			if got := subjects > 1; got != tt.system {
+				t.Errorf("pool holds %d certificates, want the system ones %v", subjects, tt.system)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/clock"
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+	log "github.com/sirupsen/logrus"
+)
+
+// drainPollInterval is the interval between the checks of the active sessions
+// while waiting for them to finish before updating.
+const drainPollInterval = time.Second
+
+// drainAction is what to do with a pending update given the active sessions.
+type drainAction int
+
+const (
+	// drainProceed updates the agent now.
+	drainProceed drainAction = iota
+	// drainWait waits for the active sessions to finish.
+	drainWait
+	// drainPostpone gives up on the update until the next check.
+	drainPostpone
+)
+
+// drainDecision decides whether to update the agent with active sessions,
+// after having waited for them to finish for waited, up to timeout. When the
+// timeout expires, the update is forced or postponed.
+func drainDecision(active int, waited, timeout time.Duration, force bool) drainAction {
+	switch {
+	case active == 0:
+		return drainProceed
+	case waited < timeout:
+		return drainWait
+	case force:
+		return drainProceed
+	default:
+		return drainPostpone
+	}
+}
+
+// drain waits for the active sessions to finish before an update, returning
+// whether the update can proceed.
+func (a *Agent) drain(ctx context.Context, activeSessions func() int) bool {
+	timeout := time.Duration(a.opts.UpdateDrainTimeout) * time.Second
+	initial := activeSessions()
+	start := clock.Now()
+
+	if initial > 0 {
+		log.WithFields(log.Fields{
+			"sessions": initial,
+			"timeout":  timeout,
+		}).Info("Waiting for the active sessions to finish before updating")
+	}
+
+	for {
+		active := activeSessions()
+
+		switch drainDecision(active, clock.Now().Sub(start), timeout, a.opts.UpdateForce) {
+		case drainProceed:
+			if initial > 0 {
+				log.WithFields(log.Fields{
+					"sessions":        initial,
+					"active_sessions": active,
+					"waited":          clock.Now().Sub(start).Round(time.Second),
+				}).Info("Done waiting for the active sessions")
+			}
+
+			return true
+		case drainPostpone:
+			log.WithFields(log.Fields{
+				"sessions":        initial,
+				"active_sessions": active,
+				"timeout":         timeout,
+			}).Warn("Postponing the agent update as sessions are still active")
+
+			return false
+		case drainWait:
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// updateLoop periodically checks the server version and, when it is newer than
+// the agent, updates the agent to it and restarts, once the active sessions, as
+// counted by activeSessions, have finished.
+func (a *Agent) updateLoop(ctx context.Context, u *updater.Updater, activeSessions func() int) {
+	interval := time.Duration(a.opts.UpdateCheckInterval) * time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		info, err := a.currentClient().CheckUpdate(AgentVersion, u.Channel)
+		if err != nil {
+			log.WithError(err).Warn("Failed to check for agent updates")
+
+			continue
+		}
+
+		ok, err := u.NeedsUpdate(info.Version)
+		if err != nil {
+			log.WithError(err).WithField("version", info.Version).Warn("Failed to compare agent versions")
+
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if updater.RolledBack(info.Version) {
+			log.WithField("new_version", info.Version).Debug("Skipping agent update rolled back before")
+
+			continue
+		}
+
+		if !a.drain(ctx, activeSessions) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"version":     AgentVersion,
+			"new_version": info.Version,
+		}).Info("Updating ShellHub agent")
+
+		if err := u.Install(info.Version); err != nil {
+			log.WithError(err).WithField("new_version", info.Version).Error("Failed to install agent update")
+
+			continue
+		}
+
+		if err := updater.StartWatchdog(time.Duration(a.opts.UpdateRollbackTimeout) * time.Second); err != nil {
+			log.WithError(err).WithField("new_version", info.Version).Error("Failed to start the agent update watchdog")
+
+			if err := updater.CancelUpdate(); err != nil {
+				log.WithError(err).WithField("new_version", info.Version).Error("Failed to cancel agent update")
+			}
+
+			continue
+		}
+
+		if err := updater.Restart(); err != nil {
+			log.WithError(err).Error("Failed to restart the updated agent")
+		}
+	}
+}
+
+// confirmUpdate confirms a pending agent update once the agent connects to the
+// server. Unconfirmed updates are rolled back by the watchdog started from the
+// previous agent before restarting into the update.
+func (a *Agent) confirmUpdate(update *updater.Update) {
+	<-a.connected
+
+	fields := log.Fields{
+		"version":     update.From,
+		"new_version": update.To,
+	}
+
+	if err := updater.ConfirmUpdate(); err != nil {
+		log.WithError(err).WithFields(fields).Warn("Failed to confirm agent update")
+
+		return
+	}
+
+	log.WithFields(fields).Info("Agent update confirmed")
+}
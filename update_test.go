@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainDecision(t *testing.T) {
+	tests := []struct {
+		name   string
+		active int
+		waited time.Duration
+		force  bool
+		want   drainAction
+	}{
+		{name: "no sessions", active: 0, waited: 0, want: drainProceed},
+		{name: "no sessions after waiting", active: 0, waited: time.Hour, want: drainProceed},
+		{name: "sessions", active: 2, waited: time.Minute, want: drainWait},
+		{name: "sessions when forced", active: 2, waited: time.Minute, force: true, want: drainWait},
+		{name: "timeout", active: 1, waited: 10 * time.Minute, want: drainPostpone},
+		{name: "timeout when forced", active: 1, waited: 10 * time.Minute, force: true, want: drainProceed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := drainDecision(tt.active, tt.waited, 10*time.Minute, tt.force); got != tt.want {
+				t.Errorf("drainDecision(%d, %s) = %d, want %d", tt.active, tt.waited, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	// The sessions are counted once before waiting, then at each check.
+	tests := []struct {
+		name     string
+		sessions []int
+		timeout  int
+		force    bool
+		want     bool
+	}{
+		{name: "no sessions", sessions: []int{0, 0}, timeout: 60, want: true},
+		{name: "sessions finishing", sessions: []int{1, 1, 0}, timeout: 60, want: true},
+		{name: "timeout", sessions: []int{1, 1}, timeout: 0, want: false},
+		{name: "timeout when forced", sessions: []int{1, 1}, timeout: 0, force: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &Agent{opts: &ConfigOptions{UpdateDrainTimeout: tt.timeout, UpdateForce: tt.force}}
+
+			calls := 0
+			activeSessions := func() int {
+				active := tt.sessions[calls]
+				calls++
+
+				return active
+			}
+
+			if got := agent.drain(context.Background(), activeSessions); got != tt.want {
+				t.Errorf("drain() = %v, want %v", got, tt.want)
+			}
+
+			if calls != len(tt.sessions) {
+				t.Errorf("drain() counted the sessions %d times, want %d", calls, len(tt.sessions))
+			}
+		})
+	}
+}
+
+func TestDrainCanceled(t *testing.T) {
+	agent := &Agent{opts: &ConfigOptions{UpdateDrainTimeout: 60}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool)
+	go func() {
+		done <- agent.drain(ctx, func() int { return 1 })
+	}()
+
+	cancel()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("drain() = true after the context was canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return after the context was canceled")
+	}
+}
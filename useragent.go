@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// userAgent returns the User-Agent of the requests to the server, identifying
+// the agent version and platform, followed by the configured suffix, if any.
+func userAgent(opts *ConfigOptions) string {
+	ua := fmt.Sprintf("shellhub-agent/%s (%s; %s; %s)", AgentVersion, AgentPlatform, runtime.GOOS, runtime.GOARCH)
+
+	if suffix := strings.TrimSpace(opts.UserAgentSuffix); suffix != "" {
+		ua += " " + suffix
+	}
+
+	return ua
+}
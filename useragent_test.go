@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	base := "shellhub-agent/" + AgentVersion + " (" + AgentPlatform + "; " + runtime.GOOS + "; " + runtime.GOARCH + ")"
+
+	tests := []struct {
+		name   string
+		suffix string
+		want   string
+	}{
+		{name: "no suffix", want: base},
+		{name: "blank suffix", suffix: "  ", want: base},
+		{name: "suffix", suffix: " fleet/2 ", want: base + " fleet/2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userAgent(&ConfigOptions{UserAgentSuffix: tt.suffix}); got != tt.want {
+				t.Errorf("userAgent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctorUserAgent(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		agents []string
+	)
+
+	d := newTestDoctor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+
+		serverInfoHandler(w, r)
+	}), "127.0.0.1:1")
+
+	doctorReport(d)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(agents) == 0 {
+		t.Fatal("doctor did not request the server")
+	}
+
+	for _, got := range agents {
+		if want := userAgent(d.opts); got != want {
+			t.Errorf("doctor request User-Agent = %q, want %q", got, want)
+		}
+	}
+}
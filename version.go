@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+)
+
+// latestVersion asks the server for the latest agent version on the update
+// channel.
+func (a *Agent) latestVersion() (string, error) {
+	ctx, cancel := a.probeContext()
+	defer cancel()
+
+	info, err := a.currentClient().CheckUpdateContext(ctx, AgentVersion, a.opts.UpdateChannel)
+	if err != nil {
+		return "", a.probeError(ctx, err)
+	}
+
+	return info.Version, nil
+}
+
+// writeVersionCheck writes to w whether an update of the current agent version
+// to the latest one is available on the update channel.
+func writeVersionCheck(w io.Writer, current, latest, channel string) error {
+	newer, err := updater.Newer(latest, current, channel)
+	if err != nil {
+		return fmt.Errorf("latest version %q: %w", latest, err)
+	}
+
+	fmt.Fprintf(w, "Agent version: %s\n", current)
+	fmt.Fprintf(w, "Latest version: %s (%s channel)\n", latest, channel)
+
+	if newer {
+		fmt.Fprintln(w, "An update is available")
+	} else {
+		fmt.Fprintln(w, "The agent is up to date")
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brycedjohnson/shellhub-agent/pkg/models"
+	"github.com/brycedjohnson/shellhub-agent/pkg/updater"
+)
+
+func TestWriteVersionCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		latest  string
+		channel string
+		want    string
+		err     error
+	}{
+		{
+			name:    "update available",
+			current: "1.0.0",
+			latest:  "1.1.0",
+			channel: updater.ChannelStable,
+			want:    "Agent version: 1.0.0\nLatest version: 1.1.0 (stable channel)\nAn update is available\n",
+		},
+		{
+			name:    "up to date",
+			current: "1.1.0",
+			latest:  "1.1.0",
+			channel: updater.ChannelStable,
+			want:    "Agent version: 1.1.0\nLatest version: 1.1.0 (stable channel)\nThe agent is up to date\n",
+		},
+		{
+			name:    "pre-release on the stable channel",
+			current: "1.0.0",
+			latest:  "1.1.0-rc.1",
+			channel: updater.ChannelStable,
+			want:    "Agent version: 1.0.0\nLatest version: 1.1.0-rc.1 (stable channel)\nThe agent is up to date\n",
+		},
+		{
+			name:    "pre-release on the beta channel",
+			current: "1.0.0",
+			latest:  "1.1.0-rc.1",
+			channel: updater.ChannelBeta,
+			want:    "Agent version: 1.0.0\nLatest version: 1.1.0-rc.1 (beta channel)\nAn update is available\n",
+		},
+		{
+			name:    "invalid latest version",
+			current: "1.0.0",
+			latest:  "latest",
+			channel: updater.ChannelStable,
+			err:     updater.ErrInvalidVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := writeVersionCheck(&out, tt.current, tt.latest, tt.channel)
+			if !errors.Is(err, tt.err) || out.String() != tt.want {
+				t.Errorf("writeVersionCheck() = %v, wrote:\n%s\nwant %v, and:\n%s", err, out.String(), tt.err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	var channel string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel = r.URL.Query().Get("channel")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.Info{Version: "1.2.0"}) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant", UpdateChannel: updater.ChannelBeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := agent.latestVersion()
+	if err != nil || latest != "1.2.0" {
+		t.Errorf("latestVersion() = %q, %v, want 1.2.0", latest, err)
+	}
+
+	if channel != updater.ChannelBeta {
+		t.Errorf("update check channel = %q, want %q", channel, updater.ChannelBeta)
+	}
+}
+
+func TestLatestVersionTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	agent, err := NewAgent(&ConfigOptions{ServerAddress: srv.URL, TenantID: "tenant", ProbeTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := agent.latestVersion(); !errors.Is(err, ErrProbeTimeout) {
+		t.Errorf("latestVersion() = %v, want %v", err, ErrProbeTimeout)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrInvalidWatchdogInterval = errors.New("watchdog interval must be positive when the watchdog file is set")
+
+// checkWatchdog checks that the watchdog file, when set, is touched at a
+// positive interval.
+func checkWatchdog(opts *ConfigOptions) error {
+	if opts.WatchdogFile != "" && opts.WatchdogInterval <= 0 {
+		return ErrInvalidWatchdogInterval
+	}
+
+	return nil
+}
+
+// touchFile sets the modification time of the file at path to now, creating it
+// when it does not exist.
+func touchFile(path string, now time.Time) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, now, now)
+}
+
+// watchdog signals the external supervisors that the agent is healthy, that
+// is connected to the server, by touching the watchdog file and notifying the
+// systemd watchdog. Both stop while the agent is unhealthy, so the supervisor
+// can restart it.
+type watchdog struct {
+	file     string
+	systemd  bool
+	healthy  func() bool
+	touch    func(path string, now time.Time) error
+	notify   func(state string) error
+	failures bool
+}
+
+// newWatchdog creates the watchdog of the agent, returning the interval it must
+// run at, the shortest of the watchdog file and the systemd watchdog ones. It
+// returns nil when neither is enabled.
+func newWatchdog(opts *ConfigOptions, healthy func() bool) (*watchdog, time.Duration) {
+	interval := time.Duration(opts.WatchdogInterval) * time.Second
+
+	systemd := systemdWatchdogInterval()
+	if systemd > 0 && (opts.WatchdogFile == "" || systemd < interval) {
+		interval = systemd
+	}
+
+	if opts.WatchdogFile == "" && systemd == 0 {
+		return nil, 0
+	}
+
+	return &watchdog{
+		file:    opts.WatchdogFile,
+		systemd: systemd > 0,
+		healthy: healthy,
+		touch:   touchFile,
+		notify:  sdNotify,
+	}, interval
+}
+
+// beat signals that the agent is healthy, when it is.
+func (w *watchdog) beat(now time.Time) {
+	if !w.healthy() {
+		return
+	}
+
+	if w.file != "" {
+		if err := w.touch(w.file, now); err != nil {
+			if !w.failures {
+				log.WithError(err).WithField("file", w.file).Warn("Failed to touch the watchdog file")
+			}
+
+			w.failures = true
+		} else {
+			w.failures = false
+		}
+	}
+
+	if !w.systemd {
+		return
+	}
+
+	if err := w.notify(sdState{"WATCHDOG": "1"}.String()); err != nil {
+		log.WithError(err).Debug("Failed to notify the systemd watchdog")
+	}
+}
+
+// run signals that the agent is healthy every interval, forever.
+func (w *watchdog) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		w.beat(now)
+	}
+}
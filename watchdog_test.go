@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckWatchdog(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ConfigOptions
+		err  error
+	}{
+		{name: "disabled"},
+		{name: "disabled without interval", opts: ConfigOptions{WatchdogInterval: 0}},
+		{name: "enabled", opts: ConfigOptions{WatchdogFile: "/run/shellhub/watchdog", WatchdogInterval: 10}},
+		{name: "enabled without interval", opts: ConfigOptions{WatchdogFile: "/run/shellhub/watchdog"}, err: ErrInvalidWatchdogInterval},
+		{name: "negative interval", opts: ConfigOptions{WatchdogFile: "/run/shellhub/watchdog", WatchdogInterval: -1}, err: ErrInvalidWatchdogInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkWatchdog(&tt.opts); !errors.Is(err, tt.err) {
+				t.Errorf("checkWatchdog() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTouchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchdog")
+
+	for _, now := range []time.Time{
+		time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 1, 1, 0, 0, 10, 0, time.UTC),
+	} {
+		if err := touchFile(path, now); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !fi.ModTime().Equal(now) {
+			t.Errorf("modification time = %v, want %v", fi.ModTime(), now)
+		}
+	}
+}
+
+func TestNewWatchdog(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		usec     string
+		interval time.Duration
+		systemd  bool
+	}{
+		{name: "disabled"},
+		{name: "file", file: "/run/shellhub/watchdog", interval: 10 * time.Second},
+		{name: "systemd", usec: "30000000", interval: 15 * time.Second, systemd: true},
+		{name: "file more often than systemd", file: "/run/shellhub/watchdog", usec: "30000000", interval: 10 * time.Second, systemd: true},
+		{name: "systemd more often than file", file: "/run/shellhub/watchdog", usec: "4000000", interval: 2 * time.Second, systemd: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NOTIFY_SOCKET", "")
+			t.Setenv("WATCHDOG_PID", "")
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+
+			if tt.usec != "" {
+				t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+			}
+
+			w, interval := newWatchdog(&ConfigOptions{WatchdogFile: tt.file, WatchdogInterval: 10}, func() bool { return true })
+			if tt.file == "" && !tt.systemd {
+				if w != nil {
+					t.Errorf("newWatchdog() = %+v, want nil when disabled", w)
+				}
+
+				return
+			}
+
+			if w == nil || interval != tt.interval || w.file != tt.file || w.systemd != tt.systemd {
+				t.Errorf("newWatchdog() = %+v, %v, want the file %q, systemd %v and the interval %v", w, interval, tt.file, tt.systemd, tt.interval)
+			}
+		})
+	}
+}
+
+func TestWatchdogBeat(t *testing.T) {
+	tests := []struct {
+		name    string
+		healthy bool
+		systemd bool
+		touched int
+		states  []string
+	}{
+		{name: "healthy", healthy: true, systemd: true, touched: 1, states: []string{"WATCHDOG=1"}},
+		{name: "healthy without systemd", healthy: true, touched: 1},
+		{name: "unhealthy", systemd: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				touched int
+				states  []string
+			)
+
+			w := &watchdog{
+				file:    "/run/shellhub/watchdog",
+				systemd: tt.systemd,
+				healthy: func() bool { return tt.healthy },
+				touch: func(path string, now time.Time) error {
+					touched++
+
+					return nil
+				},
+				notify: func(state string) error {
+					states = append(states, state)
+
+					return nil
+				},
+			}
+
+			w.beat(time.Now())
+
+			if touched != tt.touched || strings.Join(states, "|") != strings.Join(tt.states, "|") {
+				t.Errorf("beat() touched the file %d times and notified %q, want %d and %q", touched, states, tt.touched, tt.states)
+			}
+		})
+	}
+}
+
+func TestWatchdogBeatTouchFailure(t *testing.T) {
+	buf := captureLog(t)
+
+	errTouch := errors.New("read-only file system")
+
+	var fail bool
+
+	w := &watchdog{
+		file:    "/run/shellhub/watchdog",
+		healthy: func() bool { return true },
+		touch: func(path string, now time.Time) error {
+			if fail {
+				return errTouch
+			}
+
+			return nil
+		},
+	}
+
+	// The failures are only logged when they start, not on every beat.
+	for _, failing := range []bool{true, true, false, true} {
+		fail = failing
+		w.beat(time.Now())
+	}
+
+	if got := strings.Count(buf.String(), "Failed to touch the watchdog file"); got != 2 {
+		t.Errorf("logged %d touch failures, want 2:\n%s", got, buf.String())
+	}
+}